@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/db"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/services"
+)
+
+// Grants a role to an existing user, e.g. to bootstrap the first admin:
+//
+//	go run ./cmd/admin -email owner@example.com -role admin
+func main() {
+	var email, role string
+	flag.StringVar(&email, "email", "", "Email of the user to update")
+	flag.StringVar(&role, "role", string(models.RoleAdmin), "Role to grant (user, moderator, admin)")
+	flag.Parse()
+
+	if email == "" {
+		log.Fatal("-email is required")
+	}
+	if !models.Role(role).IsValid() {
+		log.Fatalf("Unknown role %q", role)
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+
+	// Initialize database
+	database, err := db.InitPostgres(cfg.Database)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+
+	// Initialize Redis, needed to revoke the user's sessions
+	redisClient, err := db.InitRedis(cfg.Redis)
+	if err != nil {
+		log.Fatal("Failed to connect to Redis:", err)
+	}
+	defer redisClient.Close()
+
+	var user models.User
+	if err := database.Where("email = ?", email).First(&user).Error; err != nil {
+		log.Fatalf("Failed to find user %s: %v", email, err)
+	}
+
+	userService := services.NewUserService(database, redisClient, *cfg)
+	if _, err := userService.UpdateRole(user.ID.String(), models.Role(role)); err != nil {
+		log.Fatal("Failed to update role:", err)
+	}
+
+	log.Printf("Granted role %s to %s", role, email)
+}
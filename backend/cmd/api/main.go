@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/db"
 	"github.com/doggyclub/backend/pkg/handlers"
+	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/services"
 	"github.com/labstack/echo/v4"
 	echomiddleware "github.com/labstack/echo/v4/middleware"
 )
@@ -30,6 +33,13 @@ func main() {
 		log.Fatal("Failed to connect to Redis:", err)
 	}
 
+	// Background jobs
+	if err := services.NewPresenceService(database, redisClient, *cfg).Rebuild(); err != nil {
+		log.Printf("Failed to rebuild presence index: %v", err)
+	}
+	services.NewLocationHistoryService(database, *cfg).StartRetentionJob(context.Background())
+	services.NewHashtagService(database, redisClient, *cfg).StartTrendingJob(context.Background())
+
 	// Create Echo instance
 	e := echo.New()
 
@@ -44,7 +54,7 @@ func main() {
 	// Cache middleware disabled for simplified schema
 	// e.Use(cacheMiddleware.ConditionalCache())
 	// e.Use(cacheMiddleware.ETagMiddleware())
-	// e.Use(cacheMiddleware.RateLimitMiddleware(100, 15*time.Minute)) // 100 requests per 15 minutes
+	e.Use(middleware.RateLimitMiddleware(redisClient, cfg.RateLimit, cfg.RateLimit.Global))
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
@@ -67,15 +77,30 @@ func main() {
 	encounterHandler := handlers.NewEncounterHandler(database, redisClient, *cfg)
 	encounterHandler.RegisterRoutes(e)
 
+	placeHandler := handlers.NewPlaceHandler(database, redisClient, *cfg)
+	placeHandler.RegisterRoutes(e)
+
+	walkHandler := handlers.NewWalkHandler(database, redisClient, *cfg)
+	walkHandler.RegisterRoutes(e)
+
 	postHandler := handlers.NewPostHandler(database, redisClient, *cfg)
 	postHandler.RegisterRoutes(e)
 
+	hashtagHandler := handlers.NewHashtagHandler(database, redisClient, *cfg)
+	hashtagHandler.RegisterRoutes(e)
+
+	searchHandler := handlers.NewSearchHandler(database, redisClient, *cfg)
+	searchHandler.RegisterRoutes(e)
+
 	giftHandler := handlers.NewGiftHandler(database, redisClient, *cfg)
 	giftHandler.RegisterRoutes(e)
 
 	notificationHandler := handlers.NewNotificationHandler(database, redisClient, *cfg)
 	notificationHandler.RegisterRoutes(e)
 
+	realtimeHandler := handlers.NewRealtimeHandler(database, redisClient, *cfg)
+	realtimeHandler.RegisterRoutes(e)
+
 	subscriptionHandler := handlers.NewSubscriptionHandler(database, redisClient, *cfg)
 	subscriptionHandler.RegisterRoutes(e)
 
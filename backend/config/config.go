@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"log"
 	"os"
 	"strconv"
@@ -10,13 +11,22 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
-	Firebase FirebaseConfig
-	External ExternalConfig
-	Features FeatureConfig
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Redis     RedisConfig
+	JWT       JWTConfig
+	Auth      AuthConfig
+	Mail      MailConfig
+	RateLimit RateLimitConfig
+	Location  LocationConfig
+	Encounter EncounterConfig
+	Beacon    BeaconConfig
+	Realtime  RealtimeConfig
+	Hashtag   HashtagConfig
+	Timeline  TimelineConfig
+	Firebase  FirebaseConfig
+	External  ExternalConfig
+	Features  FeatureConfig
 }
 
 type ServerConfig struct {
@@ -46,6 +56,90 @@ type JWTConfig struct {
 	RefreshExpireHours  time.Duration
 }
 
+type AuthConfig struct {
+	PasswordResetTTL    time.Duration
+	PasswordResetURL    string
+	PasswordResetLimit  int
+	PasswordResetWindow time.Duration
+}
+
+// RateLimitPolicy allows Limit requests per client within a sliding Window
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+type RateLimitConfig struct {
+	Enabled         bool
+	Global          RateLimitPolicy
+	Login           RateLimitPolicy
+	Register        RateLimitPolicy
+	EncounterDetect RateLimitPolicy
+	GiftSend        RateLimitPolicy
+}
+
+// LocationConfig controls how long location history is kept and how
+// older points are thinned out
+type LocationConfig struct {
+	HistoryRetention   time.Duration
+	DownsampleAfter    time.Duration
+	DownsampleInterval time.Duration
+	PurgeInterval      time.Duration
+	PresenceTTL        time.Duration // how long a dog stays in the live presence index
+	SnapGridMeters     float64       // grid other users' positions are snapped to
+	BatchMaxAge        time.Duration // oldest point accepted in an offline upload
+	ClockSkew          time.Duration // how far ahead of the server a client clock may be
+}
+
+// EncounterConfig controls when co-located dogs count as having met
+type EncounterConfig struct {
+	DwellTime         time.Duration // how long dogs must stay in range
+	SeparationTimeout time.Duration // how long apart before a proximity session ends
+	WaveWindow        time.Duration // how long a wave waits to be waved back
+}
+
+// BeaconConfig controls the rotating Bluetooth identifiers dogs broadcast
+type BeaconConfig struct {
+	Secret    string
+	Epoch     time.Duration // how long each identifier is broadcast
+	BatchSize int           // identifiers issued per request
+	Tolerance int           // past epochs whose identifiers are still accepted
+}
+
+// RealtimeConfig controls the WebSocket and SSE event streams
+type RealtimeConfig struct {
+	HeartbeatInterval time.Duration // how often idle connections are pinged
+	ReplayLimit       int           // events kept per user for resuming
+	ReplayTTL         time.Duration // how long a user's missed events are kept
+}
+
+// HashtagConfig controls how trending hashtags are ranked and refreshed
+type HashtagConfig struct {
+	TrendingInterval time.Duration // how often the trending list is recomputed
+	TrendingWindow   time.Duration // how far back uses are counted
+	TrendingHalfLife time.Duration // how quickly a use stops counting
+}
+
+// TimelineConfig controls the timelines kept in Redis for each user
+type TimelineConfig struct {
+	MaxPosts           int           // newest posts kept per timeline
+	FanoutMaxFollowers int           // followers above which a dog's posts are read on demand
+	FanoutMinFollowers int           // followers at or below which they are written to timelines again
+	TTL                time.Duration // how long an unread timeline is kept
+}
+
+type MailConfig struct {
+	Driver    string // smtp or log
+	Host      string
+	Port      string
+	Username  string
+	Password  string
+	FromName  string
+	FromEmail string
+	LogPath   string // log driver output file, stdout when empty
+}
+
 type FirebaseConfig struct {
 	CredentialsPath string
 	ProjectID       string
@@ -79,8 +173,35 @@ func Load() (*Config, error) {
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	jwtExpire, _ := strconv.Atoi(getEnv("JWT_EXPIRE_HOURS", "24"))
 	jwtRefreshExpire, _ := strconv.Atoi(getEnv("JWT_REFRESH_EXPIRE_HOURS", "168"))
+	resetTTL, _ := strconv.Atoi(getEnv("PASSWORD_RESET_TTL_MINUTES", "60"))
+	resetLimit, _ := strconv.Atoi(getEnv("PASSWORD_RESET_LIMIT", "3"))
+	resetWindow, _ := strconv.Atoi(getEnv("PASSWORD_RESET_WINDOW_MINUTES", "60"))
+	locationRetention, _ := strconv.Atoi(getEnv("LOCATION_HISTORY_RETENTION_DAYS", "30"))
+	downsampleAfter, _ := strconv.Atoi(getEnv("LOCATION_DOWNSAMPLE_AFTER_HOURS", "24"))
+	downsampleInterval, _ := strconv.Atoi(getEnv("LOCATION_DOWNSAMPLE_INTERVAL_SECONDS", "300"))
+	locationPurge, _ := strconv.Atoi(getEnv("LOCATION_PURGE_INTERVAL_MINUTES", "60"))
+	presenceTTL, _ := strconv.Atoi(getEnv("LOCATION_PRESENCE_TTL_MINUTES", "60"))
+	snapGrid, _ := strconv.ParseFloat(getEnv("LOCATION_SNAP_GRID_METERS", "250"), 64)
+	batchMaxAge, _ := strconv.Atoi(getEnv("LOCATION_BATCH_MAX_AGE_HOURS", "72"))
+	clockSkew, _ := strconv.Atoi(getEnv("LOCATION_CLOCK_SKEW_SECONDS", "120"))
+	encounterDwell, _ := strconv.Atoi(getEnv("ENCOUNTER_DWELL_SECONDS", "120"))
+	encounterSeparation, _ := strconv.Atoi(getEnv("ENCOUNTER_SEPARATION_SECONDS", "300"))
+	encounterWaveWindow, _ := strconv.Atoi(getEnv("ENCOUNTER_WAVE_WINDOW_HOURS", "48"))
+	beaconEpoch, _ := strconv.Atoi(getEnv("BEACON_EPOCH_MINUTES", "15"))
+	beaconBatch, _ := strconv.Atoi(getEnv("BEACON_BATCH_SIZE", "8"))
+	beaconTolerance, _ := strconv.Atoi(getEnv("BEACON_TOLERANCE_EPOCHS", "1"))
+	realtimeHeartbeat, _ := strconv.Atoi(getEnv("REALTIME_HEARTBEAT_SECONDS", "25"))
+	realtimeReplayLimit, _ := strconv.Atoi(getEnv("REALTIME_REPLAY_LIMIT", "200"))
+	realtimeReplayTTL, _ := strconv.Atoi(getEnv("REALTIME_REPLAY_TTL_HOURS", "24"))
+	trendingInterval, _ := strconv.Atoi(getEnv("HASHTAG_TRENDING_INTERVAL_MINUTES", "10"))
+	trendingWindow, _ := strconv.Atoi(getEnv("HASHTAG_TRENDING_WINDOW_HOURS", "72"))
+	trendingHalfLife, _ := strconv.Atoi(getEnv("HASHTAG_TRENDING_HALF_LIFE_HOURS", "6"))
+	timelineMaxPosts, _ := strconv.Atoi(getEnv("TIMELINE_MAX_POSTS", "800"))
+	timelineFanout, _ := strconv.Atoi(getEnv("TIMELINE_FANOUT_MAX_FOLLOWERS", "10000"))
+	timelineFanoutMin, _ := strconv.Atoi(getEnv("TIMELINE_FANOUT_MIN_FOLLOWERS", "9000"))
+	timelineTTL, _ := strconv.Atoi(getEnv("TIMELINE_TTL_HOURS", "72"))
 
-	return &Config{
+	cfg := &Config{
 		Server: ServerConfig{
 			Port:        getEnv("PORT", "9090"),
 			Environment: getEnv("ENV", "development"),
@@ -104,6 +225,67 @@ func Load() (*Config, error) {
 			ExpireHours:        time.Duration(jwtExpire) * time.Hour,
 			RefreshExpireHours: time.Duration(jwtRefreshExpire) * time.Hour,
 		},
+		Auth: AuthConfig{
+			PasswordResetTTL:    time.Duration(resetTTL) * time.Minute,
+			PasswordResetURL:    getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+			PasswordResetLimit:  resetLimit,
+			PasswordResetWindow: time.Duration(resetWindow) * time.Minute,
+		},
+		Mail: MailConfig{
+			Driver:    getEnv("MAIL_DRIVER", "log"),
+			Host:      getEnv("SMTP_HOST", ""),
+			Port:      getEnv("SMTP_PORT", "587"),
+			Username:  getEnv("SMTP_USERNAME", ""),
+			Password:  getEnv("SMTP_PASSWORD", ""),
+			FromName:  getEnv("SMTP_FROM_NAME", "DoggyClub"),
+			FromEmail: getEnv("SMTP_FROM_EMAIL", "noreply@doggyclub.app"),
+			LogPath:   getEnv("MAIL_LOG_PATH", ""),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:         getEnvBool("RATE_LIMIT_ENABLED", true),
+			Global:          getEnvRateLimit("RATE_LIMIT_GLOBAL", "global", 300, time.Minute),
+			Login:           getEnvRateLimit("RATE_LIMIT_LOGIN", "login", 5, time.Minute),
+			Register:        getEnvRateLimit("RATE_LIMIT_REGISTER", "register", 10, time.Hour),
+			EncounterDetect: getEnvRateLimit("RATE_LIMIT_ENCOUNTER_DETECT", "encounter_detect", 60, time.Minute),
+			GiftSend:        getEnvRateLimit("RATE_LIMIT_GIFT_SEND", "gift_send", 30, time.Hour),
+		},
+		Location: LocationConfig{
+			HistoryRetention:   time.Duration(locationRetention) * 24 * time.Hour,
+			DownsampleAfter:    time.Duration(downsampleAfter) * time.Hour,
+			DownsampleInterval: time.Duration(downsampleInterval) * time.Second,
+			PurgeInterval:      time.Duration(locationPurge) * time.Minute,
+			PresenceTTL:        time.Duration(presenceTTL) * time.Minute,
+			SnapGridMeters:     snapGrid,
+			BatchMaxAge:        time.Duration(batchMaxAge) * time.Hour,
+			ClockSkew:          time.Duration(clockSkew) * time.Second,
+		},
+		Encounter: EncounterConfig{
+			DwellTime:         time.Duration(encounterDwell) * time.Second,
+			SeparationTimeout: time.Duration(encounterSeparation) * time.Second,
+			WaveWindow:        time.Duration(encounterWaveWindow) * time.Hour,
+		},
+		Beacon: BeaconConfig{
+			Secret:    getEnv("BEACON_SECRET", ""),
+			Epoch:     time.Duration(beaconEpoch) * time.Minute,
+			BatchSize: beaconBatch,
+			Tolerance: beaconTolerance,
+		},
+		Realtime: RealtimeConfig{
+			HeartbeatInterval: time.Duration(realtimeHeartbeat) * time.Second,
+			ReplayLimit:       realtimeReplayLimit,
+			ReplayTTL:         time.Duration(realtimeReplayTTL) * time.Hour,
+		},
+		Hashtag: HashtagConfig{
+			TrendingInterval: time.Duration(trendingInterval) * time.Minute,
+			TrendingWindow:   time.Duration(trendingWindow) * time.Hour,
+			TrendingHalfLife: time.Duration(trendingHalfLife) * time.Hour,
+		},
+		Timeline: TimelineConfig{
+			MaxPosts:           timelineMaxPosts,
+			FanoutMaxFollowers: timelineFanout,
+			FanoutMinFollowers: timelineFanoutMin,
+			TTL:                time.Duration(timelineTTL) * time.Hour,
+		},
 		Firebase: FirebaseConfig{
 			CredentialsPath: getEnv("FIREBASE_CREDENTIALS_PATH", ""),
 			ProjectID:       getEnv("FIREBASE_PROJECT_ID", ""),
@@ -125,7 +307,23 @@ func Load() (*Config, error) {
 			EnablePushNotifications:  getEnvBool("ENABLE_PUSH_NOTIFICATIONS", true),
 			EnablePremiumFeatures:    getEnvBool("ENABLE_PREMIUM_FEATURES", true),
 		},
-	}, nil
+	}
+
+	// Beacon identifiers are derived from their own key so that it can be
+	// rotated without signing everyone out, and vice versa
+	if cfg.Beacon.Secret == "" && cfg.Server.Environment != "development" {
+		return nil, errors.New("BEACON_SECRET must be set")
+	}
+
+	// The log driver writes working password reset links to the logs
+	if cfg.Mail.Driver == "log" && cfg.Server.Environment != "development" {
+		return nil, errors.New("MAIL_DRIVER=log is only allowed in development")
+	}
+	if cfg.Mail.Driver == "smtp" && cfg.Mail.Host == "" {
+		return nil, errors.New("SMTP_HOST must be set for the smtp mail driver")
+	}
+
+	return cfg, nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -143,4 +341,16 @@ func getEnvBool(key string, defaultValue bool) bool {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// getEnvRateLimit reads <prefix>_LIMIT and <prefix>_WINDOW_SECONDS
+func getEnvRateLimit(prefix, name string, defaultLimit int, defaultWindow time.Duration) RateLimitPolicy {
+	policy := RateLimitPolicy{Name: name, Limit: defaultLimit, Window: defaultWindow}
+	if limit, err := strconv.Atoi(os.Getenv(prefix + "_LIMIT")); err == nil && limit > 0 {
+		policy.Limit = limit
+	}
+	if seconds, err := strconv.Atoi(os.Getenv(prefix + "_WINDOW_SECONDS")); err == nil && seconds > 0 {
+		policy.Window = time.Duration(seconds) * time.Second
+	}
+	return policy
+}
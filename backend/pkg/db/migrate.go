@@ -18,26 +18,63 @@ func AutoMigrate(db *gorm.DB) error {
 	err := db.AutoMigrate(
 		// Core models
 		&models.User{},
+		&models.Session{},
 		&models.RefreshToken{},
+		&models.PasswordResetToken{},
 		&models.Dog{},
 		&models.Encounter{},
 		&models.DeviceLocation{},
+		&models.ProximitySession{},
+		&models.LocationBatch{},
+		&models.EncounterSettings{},
+		&models.EncounterWave{},
+		&models.Place{},
+		&models.PlaceVisit{},
+		&models.Walk{},
+		&models.WalkPoint{},
 		&models.Gift{},
 		&models.Post{},
+		&models.PostMedia{},
 		&models.Like{},
 		&models.Comment{},
+		&models.CommentLike{},
 		&models.Hashtag{},
+		&models.PostHashtag{},
 		&models.Follower{},
+		&models.FollowRequest{},
 		&models.SubscriptionPlan{},
 		&models.UserSubscription{},
 		&models.DeviceToken{},
 		&models.Notification{},
+		// Moderation models
+		&models.Report{},
+		&models.BlockedUser{},
+		&models.UserSuspension{},
+		&models.ContentFilter{},
+		&models.ModerationAction{},
+		&models.SafetySettings{},
 	)
 	
 	if err != nil {
 		return err
 	}
 
+	if err := createLocationHistoryTable(db); err != nil {
+		return err
+	}
+
+	if err := createEncounterPairIndex(db); err != nil {
+		return err
+	}
+
+	if err := migratePostImages(db); err != nil {
+		return err
+	}
+
+	if err := createSearchIndexes(db); err != nil {
+		return err
+	}
+
 	// Create indexes for better performance
 	createIndexes(db)
 
@@ -45,6 +82,99 @@ func AutoMigrate(db *gorm.DB) error {
 	return nil
 }
 
+// createLocationHistoryTable creates the day-partitioned location history
+// table. Daily partitions are added ahead of time by the location retention
+// job; the default partition only catches points outside them.
+func createLocationHistoryTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS location_history (
+			id uuid NOT NULL DEFAULT gen_random_uuid(),
+			dog_id uuid NOT NULL REFERENCES dogs(id) ON DELETE CASCADE,
+			location geography(POINT) NOT NULL,
+			recorded_at timestamptz NOT NULL,
+			PRIMARY KEY (id, recorded_at)
+		) PARTITION BY RANGE (recorded_at)`,
+		`CREATE TABLE IF NOT EXISTS location_history_default PARTITION OF location_history DEFAULT`,
+		`CREATE INDEX IF NOT EXISTS idx_location_history_dog_recorded ON location_history (dog_id, recorded_at DESC)`,
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createEncounterPairIndex allows at most one open encounter per dog pair.
+// Encounters recorded before encounters were kept open are closed first, once,
+// when the index does not exist yet.
+func createEncounterPairIndex(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&models.Encounter{}, "idx_encounters_open_pair") {
+		return nil
+	}
+
+	statements := []string{
+		`UPDATE encounters SET ended_at = timestamp, last_seen_at = timestamp
+			WHERE ended_at IS NULL AND last_seen_at IS NULL`,
+		`UPDATE encounters SET detection_methods = jsonb_build_array(detection_method), detection_count = 1
+			WHERE detection_methods IS NULL AND detection_method <> ''`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_encounters_open_pair ON encounters (dog1_id, dog2_id)
+			WHERE ended_at IS NULL`,
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePostImages moves the single image of posts created before posts
+// had media lists into post_media
+func migratePostImages(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Post{}, "image_url") {
+		return nil
+	}
+
+	statements := []string{
+		`INSERT INTO post_media (id, post_id, position, type, url)
+			SELECT gen_random_uuid(), id, 0, 'photo', image_url FROM posts
+			WHERE image_url <> '' AND NOT EXISTS (SELECT 1 FROM post_media WHERE post_media.post_id = posts.id)`,
+		`ALTER TABLE posts DROP COLUMN image_url`,
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createSearchIndexes indexes post content for full-text search and dog
+// names, breeds, usernames and hashtags for fuzzy and prefix matching
+func createSearchIndexes(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE INDEX IF NOT EXISTS idx_posts_content_fulltext ON posts USING GIN (to_tsvector('english', content))`,
+		`CREATE INDEX IF NOT EXISTS idx_dogs_name_trgm ON dogs USING GIN (name gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_dogs_breed_trgm ON dogs USING GIN (breed gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING GIN (username gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_hashtags_tag_prefix ON hashtags (tag varchar_pattern_ops)`,
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createIndexes(db *gorm.DB) {
 	// Modern GORM uses Migrator to create indexes
 	migrator := db.Migrator()
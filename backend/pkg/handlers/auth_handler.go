@@ -13,15 +13,24 @@ import (
 	"gorm.io/gorm"
 )
 
+// maxUserAgentLength matches the width of sessions.user_agent
+const maxUserAgentLength = 500
+
 type AuthHandler struct {
-	authService *services.AuthService
-	cfg         config.Config
+	authService          *services.AuthService
+	passwordResetService *services.PasswordResetService
+	sessionService       *services.SessionService
+	cfg                  config.Config
+	redis                *redis.Client
 }
 
 func NewAuthHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *AuthHandler {
 	return &AuthHandler{
-		authService: services.NewAuthService(db, cfg.JWT.Secret),
-		cfg:         cfg,
+		authService:          services.NewAuthService(db, cfg.JWT),
+		passwordResetService: services.NewPasswordResetService(db, redis, cfg),
+		sessionService:       services.NewSessionService(db, redis, cfg),
+		cfg:                  cfg,
+		redis:                redis,
 	}
 }
 
@@ -31,6 +40,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
+	req.ClientInfo = clientInfo(c, req.DeviceName)
 
 	resp, err := h.authService.Register(req)
 	if err != nil {
@@ -47,6 +57,7 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
+	req.ClientInfo = clientInfo(c, req.DeviceName)
 
 	resp, err := h.authService.Login(req)
 	if err != nil {
@@ -61,30 +72,113 @@ func (h *AuthHandler) Login(c echo.Context) error {
 func (h *AuthHandler) RefreshToken(c echo.Context) error {
 	var req struct {
 		RefreshToken string `json:"refresh_token" validate:"required"`
+		DeviceName   string `json:"device_name" validate:"omitempty,max=100"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
-	// RefreshToken method not implemented in simplified service
-	return c.JSON(http.StatusNotImplemented, map[string]string{"error": "RefreshToken not implemented"})
+	if err := utils.ValidateStruct(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": utils.NewValidationError(utils.FormatValidationErrors(err)),
+		})
+	}
+
+	resp, err := h.authService.RefreshToken(req.RefreshToken, clientInfo(c, req.DeviceName))
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, resp)
 }
 
-// Logout handles user logout
+// Logout revokes the current session and its access token
 func (h *AuthHandler) Logout(c echo.Context) error {
-	// userID := middleware.GetUserID(c) // Not used in simplified implementation
-	
+	userID := middleware.GetUserID(c)
+
 	var req struct {
 		RefreshToken string `json:"refresh_token"`
 	}
-	c.Bind(&req)
+	// The body is optional; the session comes from the access token
+	_ = c.Bind(&req)
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
 
-	// Logout method not implemented in simplified service
-	// In a real implementation, would invalidate refresh tokens
+	if err := h.sessionService.Logout(userUUID, middleware.GetClaims(c), req.RefreshToken); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out successfully"})
 }
 
+// LogoutAll revokes every session of the current user
+func (h *AuthHandler) LogoutAll(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	if err := h.sessionService.RevokeAllSessions(userUUID); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	// The presented token may be older than the one recorded on its session
+	if err := h.sessionService.Logout(userUUID, middleware.GetClaims(c), ""); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out of all sessions"})
+}
+
+// GetSessions lists the current user's active sessions
+func (h *AuthHandler) GetSessions(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	sessions, err := h.sessionService.ListSessions(userUUID, middleware.GetSessionID(c))
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// RevokeSession signs the current user out of one of their sessions
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid session ID"})
+	}
+
+	if err := h.sessionService.RevokeSession(userUUID, sessionID); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
 // ChangePassword handles password change
 func (h *AuthHandler) ChangePassword(c echo.Context) error {
 	userID := middleware.GetUserID(c)
@@ -131,8 +225,10 @@ func (h *AuthHandler) ForgotPassword(c echo.Context) error {
 		})
 	}
 
-	// ForgotPassword method not implemented in simplified service
-	// In a real implementation, would send password reset email
+	if err := h.passwordResetService.RequestReset(req.Email); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "If the email exists, a password reset link has been sent",
@@ -155,16 +251,12 @@ func (h *AuthHandler) ResetPassword(c echo.Context) error {
 		})
 	}
 
-	// ResetPassword method not implemented in simplified service
-	return c.JSON(http.StatusNotImplemented, map[string]string{"error": "ResetPassword not implemented"})
-	
-	// In a real implementation, would handle password reset here:
-	// 
-	// if err := h.authService.ResetPassword(req.Token, req.NewPassword); err != nil {
-	//	status, apiErr := utils.HTTPError(err)
-	//	return c.JSON(status, map[string]interface{}{"error": apiErr})
-	// }
-	// return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successfully"})
+	if err := h.passwordResetService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successfully"})
 }
 
 // GetMe returns current user info
@@ -179,19 +271,37 @@ func (h *AuthHandler) GetMe(c echo.Context) error {
 	})
 }
 
+// clientInfo describes the device behind the request
+func clientInfo(c echo.Context, deviceName string) services.ClientInfo {
+	userAgent := c.Request().UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	return services.ClientInfo{
+		DeviceName: deviceName,
+		IPAddress:  c.RealIP(),
+		UserAgent:  userAgent,
+	}
+}
+
 // RegisterRoutes registers auth routes
 func (h *AuthHandler) RegisterRoutes(e *echo.Echo) {
 	auth := e.Group("/api/auth")
 	
 	// Public routes
-	auth.POST("/register", h.Register)
-	auth.POST("/login", h.Login)
+	auth.POST("/register", h.Register, middleware.RateLimitMiddleware(h.redis, h.cfg.RateLimit, h.cfg.RateLimit.Register))
+	auth.POST("/login", h.Login, middleware.RateLimitMiddleware(h.redis, h.cfg.RateLimit, h.cfg.RateLimit.Login))
 	auth.POST("/refresh", h.RefreshToken)
 	auth.POST("/forgot-password", h.ForgotPassword)
 	auth.POST("/reset-password", h.ResetPassword)
 	
 	// Protected routes
-	auth.POST("/logout", h.Logout, middleware.AuthMiddleware(h.cfg.JWT))
-	auth.POST("/change-password", h.ChangePassword, middleware.AuthMiddleware(h.cfg.JWT))
-	auth.GET("/me", h.GetMe, middleware.AuthMiddleware(h.cfg.JWT))
+	auth.POST("/logout", h.Logout, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+	auth.POST("/logout-all", h.LogoutAll, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+	auth.GET("/sessions", h.GetSessions, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+	auth.DELETE("/sessions", h.LogoutAll, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+	auth.DELETE("/sessions/:id", h.RevokeSession, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+	auth.POST("/change-password", h.ChangePassword, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+	auth.GET("/me", h.GetMe, middleware.AuthMiddleware(h.cfg.JWT, h.redis))
 }
\ No newline at end of file
@@ -10,6 +10,7 @@ import (
 
 	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
@@ -265,14 +266,14 @@ func TestAuthHandler_RefreshToken(t *testing.T) {
 	user := testutils.CreateTestUser(t, ctx.DB)
 	
 	// Create refresh token manually
+	refreshToken := "test-refresh-token-" + user.ID.String()
 	refreshTokenModel := &models.RefreshToken{
 		UserID:    user.ID,
-		Token:     "test-refresh-token-" + user.ID.String(),
+		Token:     utils.HashToken(refreshToken),
 		ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
 	}
 	err := ctx.DB.Create(refreshTokenModel).Error
 	require.NoError(t, err)
-	refreshToken := refreshTokenModel.Token
 
 	tests := []struct {
 		name           string
@@ -337,14 +338,14 @@ func TestAuthHandler_Logout(t *testing.T) {
 	user := testutils.CreateTestUser(t, ctx.DB)
 	
 	// Create refresh token manually
+	refreshToken := "test-logout-refresh-token-" + user.ID.String()
 	refreshTokenModel := &models.RefreshToken{
 		UserID:    user.ID,
-		Token:     "test-logout-refresh-token-" + user.ID.String(),
+		Token:     utils.HashToken(refreshToken),
 		ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
 	}
 	err := ctx.DB.Create(refreshTokenModel).Error
 	require.NoError(t, err)
-	refreshToken := refreshTokenModel.Token
 
 	// Test logout
 	requestBody := `{
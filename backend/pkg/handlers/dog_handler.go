@@ -15,14 +15,18 @@ import (
 )
 
 type DogHandler struct {
-	dogService *services.DogService
-	cfg        config.Config
+	dogService        *services.DogService
+	moderationService *services.ModerationService
+	cfg               config.Config
+	redis             *redis.Client
 }
 
 func NewDogHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *DogHandler {
 	return &DogHandler{
-		dogService: services.NewDogService(db),
-		cfg:        cfg,
+		dogService:        services.NewDogService(db, redis, cfg),
+		moderationService: services.NewModerationService(db, redis, cfg),
+		cfg:               cfg,
+		redis:             redis,
 	}
 }
 
@@ -232,7 +236,7 @@ func (h *DogHandler) GetPersonalityTraits(c echo.Context) error {
 
 // RegisterRoutes registers dog routes
 func (h *DogHandler) RegisterRoutes(e *echo.Echo) {
-	dogs := e.Group("/api/dogs", middleware.AuthMiddleware(h.cfg.JWT))
+	dogs := e.Group("/api/dogs", middleware.AuthMiddleware(h.cfg.JWT, h.redis), middleware.SuspensionGuard(h.moderationService))
 
 	// Dog management
 	dogs.POST("", h.CreateDog)
@@ -249,6 +253,6 @@ func (h *DogHandler) RegisterRoutes(e *echo.Echo) {
 
 	// Public routes
 	dogsPublic := e.Group("/api/dogs")
-	dogsPublic.GET("/search", h.SearchPublicDogs, middleware.OptionalAuthMiddleware(h.cfg.JWT))
+	dogsPublic.GET("/search", h.SearchPublicDogs, middleware.OptionalAuthMiddleware(h.cfg.JWT, h.redis))
 	dogsPublic.GET("/personality-traits", h.GetPersonalityTraits)
 }
\ No newline at end of file
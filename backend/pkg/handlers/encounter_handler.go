@@ -6,6 +6,7 @@ import (
 
 	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/services"
 	"github.com/doggyclub/backend/pkg/utils"
 	"github.com/google/uuid"
@@ -15,20 +16,27 @@ import (
 )
 
 type EncounterHandler struct {
-	encounterService *services.EncounterService
-	cfg              config.Config
+	encounterService  *services.EncounterService
+	moderationService *services.ModerationService
+	cfg               config.Config
+	redis             *redis.Client
 }
 
 func NewEncounterHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *EncounterHandler {
 	return &EncounterHandler{
-		encounterService: services.NewEncounterService(db),
-		cfg:              cfg,
+		encounterService:  services.NewEncounterService(db, redis, cfg),
+		moderationService: services.NewModerationService(db, redis, cfg),
+		cfg:               cfg,
+		redis:             redis,
 	}
 }
 
 // DetectEncounters handles encounter detection
 func (h *EncounterHandler) DetectEncounters(c echo.Context) error {
-	// userID := middleware.GetUserID(c) // Not used in this method
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
 
 	var req struct {
 		DogID         string  `json:"dog_id" validate:"required"`
@@ -43,7 +51,7 @@ func (h *EncounterHandler) DetectEncounters(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dog ID format"})
 	}
 
-	response, err := h.encounterService.DetectEncounters(dogUUID, req.RadiusMeters)
+	response, err := h.encounterService.DetectEncounters(userUUID, dogUUID, req.RadiusMeters)
 	if err != nil {
 		status, apiErr := utils.HTTPError(err)
 		return c.JSON(status, map[string]interface{}{"error": apiErr})
@@ -52,6 +60,159 @@ func (h *EncounterHandler) DetectEncounters(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// UpdateLocation records the current location of one of the user's dogs
+func (h *EncounterHandler) UpdateLocation(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	var req services.LocationUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	if err := h.encounterService.UpdateDeviceLocation(userUUID, req); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Location updated successfully"})
+}
+
+// UploadLocationBatch records locations a device collected while offline
+// and returns the encounters found in them
+func (h *EncounterHandler) UploadLocationBatch(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	var req services.LocationBatchRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	result, err := h.encounterService.IngestLocationBatch(userUUID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetLocationHistory returns the location history of one of the user's dogs
+func (h *EncounterHandler) GetLocationHistory(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	dogUUID, err := uuid.Parse(c.Param("dogId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dog ID format"})
+	}
+
+	hours := 24
+	if hoursStr := c.QueryParam("hours"); hoursStr != "" {
+		if hr, err := strconv.Atoi(hoursStr); err == nil && hr > 0 {
+			hours = hr
+		}
+	}
+
+	locations, err := h.encounterService.GetLocationHistory(userUUID, dogUUID, hours)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"locations": locations,
+		"hours":     hours,
+	})
+}
+
+// GetBeaconIDs returns the rotating Bluetooth identifiers one of the user's dogs should broadcast next
+func (h *EncounterHandler) GetBeaconIDs(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	dogUUID, err := uuid.Parse(c.Param("dogId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dog ID format"})
+	}
+
+	count, _ := strconv.Atoi(c.QueryParam("count"))
+
+	beacons, err := h.encounterService.GetBeaconIDs(userUUID, dogUUID, count)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"beacons": beacons})
+}
+
+// ReportBluetoothEncounter records a beacon observed by one of the user's dogs
+func (h *EncounterHandler) ReportBluetoothEncounter(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	var req services.EncounterDetectionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	req.Method = models.DetectionMethodBluetooth
+
+	encounter, err := h.encounterService.CreateBluetoothEncounter(userUUID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+	if encounter == nil {
+		// Declined by the owners' encounter preferences
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, encounter)
+}
+
+// GetNearbyDogs returns dogs near one of the user's dogs whose owners share their location with the user
+func (h *EncounterHandler) GetNearbyDogs(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	dogUUID, err := uuid.Parse(c.Param("dogId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dog ID format"})
+	}
+
+	radius := 1000.0
+	if radiusStr := c.QueryParam("radius_meters"); radiusStr != "" {
+		if r, err := strconv.ParseFloat(radiusStr, 64); err == nil && r >= 1 && r <= 10000 {
+			radius = r
+		}
+	}
+
+	dogs, err := h.encounterService.GetNearbyDogs(userUUID, dogUUID, radius)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dogs":          dogs,
+		"radius_meters": radius,
+	})
+}
+
 // GetEncounterHistory returns dog's encounter history
 func (h *EncounterHandler) GetEncounterHistory(c echo.Context) error {
 	dogID := c.Param("dogId")
@@ -92,43 +253,137 @@ func (h *EncounterHandler) GetEncounterHistory(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
-// GetEncounterDetails returns detailed encounter information
-// Disabled for simplified schema
+// GetEncounterDetails returns an encounter of one of the user's dogs
 func (h *EncounterHandler) GetEncounterDetails(c echo.Context) error {
-	return c.JSON(http.StatusNotImplemented, map[string]string{"error": "GetEncounterDetails not implemented in simplified schema"})
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	encounterUUID, err := uuid.Parse(c.Param("encounterId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid encounter ID format"})
+	}
+
+	details, err := h.encounterService.GetEncounterDetails(userUUID, encounterUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, details)
+}
+
+// WaveAtEncounter waves from the user's dog at the other dog of an encounter
+func (h *EncounterHandler) WaveAtEncounter(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	encounterUUID, err := uuid.Parse(c.Param("encounterId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid encounter ID format"})
+	}
+
+	result, err := h.encounterService.WaveAtEncounter(userUUID, encounterUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetReceivedWaves returns the pending waves at the user's dogs
+func (h *EncounterHandler) GetReceivedWaves(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	limit := 20
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	offset := 0
+	if o, err := strconv.Atoi(c.QueryParam("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+
+	waves, total, err := h.encounterService.GetReceivedWaves(userUUID, limit, offset)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"waves":  waves,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetEncounterPreferences returns user's encounter preferences
+func (h *EncounterHandler) GetEncounterPreferences(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	settings, err := h.encounterService.GetEncounterPreferences(userUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, settings)
 }
 
 // UpdateEncounterPreferences updates user's encounter preferences
-// Disabled for simplified schema
 func (h *EncounterHandler) UpdateEncounterPreferences(c echo.Context) error {
-	return c.JSON(http.StatusNotImplemented, map[string]string{"error": "UpdateEncounterPreferences not implemented in simplified schema"})
-	
-	/*
-	// Original implementation would be:
-	userID := middleware.GetUserID(c)
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
 	var req services.UpdateEncounterSettingsRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
-	if err := h.encounterService.UpdateEncounterPreferences(userID, req); err != nil {
+
+	settings, err := h.encounterService.UpdateEncounterPreferences(userUUID, req)
+	if err != nil {
 		status, apiErr := utils.HTTPError(err)
 		return c.JSON(status, map[string]interface{}{"error": apiErr})
 	}
-	return c.JSON(http.StatusOK, map[string]string{"message": "Encounter preferences updated successfully"})
-	*/
+
+	return c.JSON(http.StatusOK, settings)
 }
 
 // RegisterRoutes registers encounter routes
 func (h *EncounterHandler) RegisterRoutes(e *echo.Echo) {
-	encounters := e.Group("/api/encounters", middleware.AuthMiddleware(h.cfg.JWT))
+	encounters := e.Group("/api/encounters", middleware.AuthMiddleware(h.cfg.JWT, h.redis), middleware.SuspensionGuard(h.moderationService))
 
 	// Encounter detection
-	encounters.POST("/detect", h.DetectEncounters)
+	encounters.POST("/location", h.UpdateLocation)
+	encounters.POST("/locations/batch", h.UploadLocationBatch, middleware.RateLimitMiddleware(h.redis, h.cfg.RateLimit, h.cfg.RateLimit.EncounterDetect))
+	encounters.POST("/detect", h.DetectEncounters, middleware.RateLimitMiddleware(h.redis, h.cfg.RateLimit, h.cfg.RateLimit.EncounterDetect))
+	encounters.POST("/bluetooth", h.ReportBluetoothEncounter, middleware.RateLimitMiddleware(h.redis, h.cfg.RateLimit, h.cfg.RateLimit.EncounterDetect))
+	encounters.GET("/dogs/:dogId/beacons", h.GetBeaconIDs)
+	encounters.GET("/dogs/:dogId/nearby", h.GetNearbyDogs)
 
 	// Encounter history
 	encounters.GET("/history", h.GetEncounterHistory)
+	encounters.GET("/dogs/:dogId/locations", h.GetLocationHistory)
 	encounters.GET("/:encounterId/details", h.GetEncounterDetails)
 
+	// Waves
+	encounters.POST("/:encounterId/wave", h.WaveAtEncounter)
+	encounters.GET("/waves", h.GetReceivedWaves)
+
 	// Settings
+	encounters.GET("/preferences", h.GetEncounterPreferences)
 	encounters.PUT("/preferences", h.UpdateEncounterPreferences)
 }
\ No newline at end of file
@@ -15,14 +15,18 @@ import (
 )
 
 type GiftHandler struct {
-	giftService *services.GiftService
-	cfg         config.Config
+	giftService       *services.GiftService
+	moderationService *services.ModerationService
+	cfg               config.Config
+	redis             *redis.Client
 }
 
 func NewGiftHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *GiftHandler {
 	return &GiftHandler{
-		giftService: services.NewGiftService(db),
-		cfg:         cfg,
+		giftService:       services.NewGiftService(db, redis, cfg),
+		moderationService: services.NewModerationService(db, redis, cfg),
+		cfg:               cfg,
+		redis:             redis,
 	}
 }
 
@@ -44,14 +48,18 @@ func (h *GiftHandler) GetGiftCatalog(c echo.Context) error {
 
 // SendGift sends a gift to another dog
 func (h *GiftHandler) SendGift(c echo.Context) error {
-	// userID := middleware.GetUserID(c) // Not used in simplified schema
+	userID := middleware.GetUserID(c)
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
 
 	var req services.SendGiftRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
-	gift, err := h.giftService.SendGift(req)
+	gift, err := h.giftService.SendGift(userUUID, req)
 	if err != nil {
 		status, apiErr := utils.HTTPError(err)
 		return c.JSON(status, map[string]interface{}{"error": apiErr})
@@ -222,19 +230,19 @@ func (h *GiftHandler) GetTransactionHistory(c echo.Context) error {
 
 // RegisterRoutes registers gift routes
 func (h *GiftHandler) RegisterRoutes(e *echo.Echo) {
-	gifts := e.Group("/api/gifts", middleware.AuthMiddleware(h.cfg.JWT))
+	gifts := e.Group("/api/gifts", middleware.AuthMiddleware(h.cfg.JWT, h.redis), middleware.SuspensionGuard(h.moderationService))
 
 	// Gift catalog
 	gifts.GET("/catalog", h.GetGiftCatalog)
 
 	// Gift purchasing and management
-	gifts.POST("/send", h.SendGift)
+	gifts.POST("/send", h.SendGift, middleware.RateLimitMiddleware(h.redis, h.cfg.RateLimit, h.cfg.RateLimit.GiftSend))
 	gifts.GET("/sent", h.GetSentGifts)
 	gifts.GET("/received", h.GetReceivedGifts)
 	gifts.POST("/exchange", h.ExchangeGift)
 
 	// Currency management
-	currency := e.Group("/api/currency", middleware.AuthMiddleware(h.cfg.JWT))
+	currency := e.Group("/api/currency", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
 	currency.POST("/purchase", h.PurchaseCurrency)
 	currency.GET("/transactions", h.GetTransactionHistory)
 
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/services"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type HashtagHandler struct {
+	hashtagService *services.HashtagService
+	cfg            config.Config
+	redis          *redis.Client
+}
+
+func NewHashtagHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *HashtagHandler {
+	return &HashtagHandler{
+		hashtagService: services.NewHashtagService(db, redis, cfg),
+		cfg:            cfg,
+		redis:          redis,
+	}
+}
+
+// GetPosts returns a page of the posts using a hashtag, newest first
+func (h *HashtagHandler) GetPosts(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	tag := c.Param("tag")
+
+	limitStr := c.QueryParam("limit")
+	limit := 20
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	posts, nextCursor, err := h.hashtagService.GetPosts(userID, tag, c.QueryParam("cursor"), limit)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"posts":       posts,
+		"next_cursor": nextCursor,
+		"limit":       limit,
+	})
+}
+
+// GetTrending returns the hashtags trending right now
+func (h *HashtagHandler) GetTrending(c echo.Context) error {
+	limitStr := c.QueryParam("limit")
+	limit := 20
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 50 {
+			limit = l
+		}
+	}
+
+	hashtags, err := h.hashtagService.GetTrending(limit)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"hashtags": hashtags,
+	})
+}
+
+// RegisterRoutes registers hashtag routes
+func (h *HashtagHandler) RegisterRoutes(e *echo.Echo) {
+	hashtags := e.Group("/api/hashtags", middleware.OptionalAuthMiddleware(h.cfg.JWT, h.redis))
+
+	hashtags.GET("/trending", h.GetTrending)
+	hashtags.GET("/:tag/posts", h.GetPosts)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/services"
 	"github.com/doggyclub/backend/pkg/utils"
 	"github.com/labstack/echo/v4"
@@ -16,12 +17,14 @@ import (
 type ModerationHandler struct {
 	moderationService *services.ModerationService
 	cfg               config.Config
+	redis             *redis.Client
 }
 
 func NewModerationHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *ModerationHandler {
 	return &ModerationHandler{
 		moderationService: services.NewModerationService(db, redis, cfg),
 		cfg:               cfg,
+		redis:             redis,
 	}
 }
 
@@ -247,7 +250,7 @@ func (h *ModerationHandler) CheckUserSuspended(c echo.Context) error {
 
 // RegisterRoutes registers moderation routes
 func (h *ModerationHandler) RegisterRoutes(e *echo.Echo) {
-	moderation := e.Group("/api/moderation", middleware.AuthMiddleware(h.cfg.JWT))
+	moderation := e.Group("/api/moderation", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
 
 	// Content reporting
 	moderation.POST("/reports", h.CreateReport)
@@ -264,10 +267,13 @@ func (h *ModerationHandler) RegisterRoutes(e *echo.Echo) {
 	// Utility endpoints
 	moderation.GET("/check-blocked/:userId", h.CheckUserBlocked)
 
-	// Admin routes (would need admin middleware in real app)
-	admin := e.Group("/api/admin/moderation", middleware.AuthMiddleware(h.cfg.JWT))
-	admin.GET("/reports", h.GetReports)
-	admin.PUT("/reports/:reportId/review", h.ReviewReport)
-	admin.POST("/suspend", h.SuspendUser)
-	admin.GET("/check-suspended/:userId", h.CheckUserSuspended)
+	// Admin routes
+	admin := e.Group("/api/admin/moderation",
+		middleware.AuthMiddleware(h.cfg.JWT, h.redis),
+		middleware.RequireRole(models.RoleModerator, models.RoleAdmin),
+	)
+	admin.GET("/reports", h.GetReports, middleware.RequirePermission(models.PermissionReportsReview))
+	admin.PUT("/reports/:reportId/review", h.ReviewReport, middleware.RequirePermission(models.PermissionReportsReview))
+	admin.POST("/suspend", h.SuspendUser, middleware.RequirePermission(models.PermissionUsersSuspend))
+	admin.GET("/check-suspended/:userId", h.CheckUserSuspended, middleware.RequirePermission(models.PermissionReportsReview))
 }
\ No newline at end of file
@@ -6,6 +6,7 @@ import (
 
 	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/services"
 	"github.com/doggyclub/backend/pkg/utils"
 	"github.com/labstack/echo/v4"
@@ -16,12 +17,14 @@ import (
 type NotificationHandler struct {
 	notificationService *services.NotificationService
 	cfg                 config.Config
+	redis               *redis.Client
 }
 
 func NewNotificationHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *NotificationHandler {
 	return &NotificationHandler{
 		notificationService: services.NewNotificationService(db, cfg),
 		cfg:                 cfg,
+		redis:               redis,
 	}
 }
 
@@ -163,7 +166,7 @@ func (h *NotificationHandler) SendNotification(c echo.Context) error {
 
 // RegisterRoutes registers notification routes
 func (h *NotificationHandler) RegisterRoutes(e *echo.Echo) {
-	notifications := e.Group("/api/notifications", middleware.AuthMiddleware(h.cfg.JWT))
+	notifications := e.Group("/api/notifications", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
 
 	// Device management
 	notifications.POST("/devices", h.RegisterDevice)
@@ -179,7 +182,10 @@ func (h *NotificationHandler) RegisterRoutes(e *echo.Echo) {
 	notifications.PUT("/read-all", h.MarkAllNotificationsAsRead)
 	notifications.GET("/unread-count", h.GetUnreadCount)
 
-	// Admin routes (would need admin middleware in real app)
-	admin := e.Group("/api/admin/notifications", middleware.AuthMiddleware(h.cfg.JWT))
-	admin.POST("/send", h.SendNotification)
+	// Admin routes
+	admin := e.Group("/api/admin/notifications",
+		middleware.AuthMiddleware(h.cfg.JWT, h.redis),
+		middleware.RequireRole(models.RoleAdmin),
+	)
+	admin.POST("/send", h.SendNotification, middleware.RequirePermission(models.PermissionNotificationsSend))
 }
\ No newline at end of file
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/services"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type PlaceHandler struct {
+	placeService *services.PlaceService
+	cfg          config.Config
+	redis        *redis.Client
+}
+
+func NewPlaceHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *PlaceHandler {
+	return &PlaceHandler{
+		placeService: services.NewPlaceService(db, cfg),
+		cfg:          cfg,
+		redis:        redis,
+	}
+}
+
+// ListPlaces returns places near a position, or the newest places
+func (h *PlaceHandler) ListPlaces(c echo.Context) error {
+	var center *models.GeoPoint
+	if latStr, lngStr := c.QueryParam("latitude"), c.QueryParam("longitude"); latStr != "" || lngStr != "" {
+		lat, latErr := strconv.ParseFloat(latStr, 64)
+		lng, lngErr := strconv.ParseFloat(lngStr, 64)
+		if latErr != nil || lngErr != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid latitude or longitude"})
+		}
+		point := models.NewGeoPoint(lat, lng)
+		center = &point
+	}
+
+	radius := 5000.0
+	if radiusStr := c.QueryParam("radius_meters"); radiusStr != "" {
+		if r, err := strconv.ParseFloat(radiusStr, 64); err == nil && r >= 1 && r <= 50000 {
+			radius = r
+		}
+	}
+
+	places, err := h.placeService.ListPlaces(center, radius)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"places": places,
+	})
+}
+
+// GetPlace returns a place
+func (h *PlaceHandler) GetPlace(c echo.Context) error {
+	placeUUID, err := uuid.Parse(c.Param("placeId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid place ID format"})
+	}
+
+	place, err := h.placeService.GetPlace(placeUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, place)
+}
+
+// GetPresentDogs returns the dogs at a place right now
+func (h *PlaceHandler) GetPresentDogs(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	placeUUID, err := uuid.Parse(c.Param("placeId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid place ID format"})
+	}
+
+	dogs, err := h.placeService.GetPresentDogs(userUUID, placeUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dogs": dogs,
+	})
+}
+
+// GetLeaderboard returns the dogs that visited a place most this week
+func (h *PlaceHandler) GetLeaderboard(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	placeUUID, err := uuid.Parse(c.Param("placeId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid place ID format"})
+	}
+
+	leaderboard, err := h.placeService.GetLeaderboard(userUUID, placeUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"leaderboard": leaderboard,
+	})
+}
+
+// CreatePlace adds a place (admin only)
+func (h *PlaceHandler) CreatePlace(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	var req services.PlaceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	place, err := h.placeService.CreatePlace(userUUID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusCreated, place)
+}
+
+// UpdatePlace replaces a place's details and boundary (admin only)
+func (h *PlaceHandler) UpdatePlace(c echo.Context) error {
+	placeUUID, err := uuid.Parse(c.Param("placeId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid place ID format"})
+	}
+
+	var req services.PlaceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	place, err := h.placeService.UpdatePlace(placeUUID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, place)
+}
+
+// DeletePlace removes a place (admin only)
+func (h *PlaceHandler) DeletePlace(c echo.Context) error {
+	placeUUID, err := uuid.Parse(c.Param("placeId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid place ID format"})
+	}
+
+	if err := h.placeService.DeletePlace(placeUUID); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Place deleted successfully"})
+}
+
+// RegisterRoutes registers place routes
+func (h *PlaceHandler) RegisterRoutes(e *echo.Echo) {
+	places := e.Group("/api/places", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+
+	places.GET("", h.ListPlaces)
+	places.GET("/:placeId", h.GetPlace)
+	places.GET("/:placeId/present", h.GetPresentDogs)
+	places.GET("/:placeId/leaderboard", h.GetLeaderboard)
+
+	// Admin routes
+	admin := e.Group("/api/admin/places",
+		middleware.AuthMiddleware(h.cfg.JWT, h.redis),
+		middleware.RequireRole(models.RoleAdmin),
+	)
+	admin.POST("", h.CreatePlace, middleware.RequirePermission(models.PermissionPlacesManage))
+	admin.PUT("/:placeId", h.UpdatePlace, middleware.RequirePermission(models.PermissionPlacesManage))
+	admin.DELETE("/:placeId", h.DeletePlace, middleware.RequirePermission(models.PermissionPlacesManage))
+}
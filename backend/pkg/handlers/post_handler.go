@@ -6,22 +6,28 @@ import (
 
 	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/services"
 	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
 type PostHandler struct {
-	postService *services.PostService
-	cfg         config.Config
+	postService       *services.PostService
+	moderationService *services.ModerationService
+	cfg               config.Config
+	redis             *redis.Client
 }
 
 func NewPostHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *PostHandler {
 	return &PostHandler{
-		postService: services.NewPostService(db, redis, cfg),
-		cfg:         cfg,
+		postService:       services.NewPostService(db, redis, cfg),
+		moderationService: services.NewModerationService(db, redis, cfg),
+		cfg:               cfg,
+		redis:             redis,
 	}
 }
 
@@ -43,7 +49,8 @@ func (h *PostHandler) CreatePost(c echo.Context) error {
 	return c.JSON(http.StatusCreated, post)
 }
 
-// GetTimeline returns posts for user's timeline
+// GetTimeline returns posts for user's timeline, newest first, or the
+// ranked "For You" feed with ?mode=ranked
 func (h *PostHandler) GetTimeline(c echo.Context) error {
 	userID := middleware.GetUserID(c)
 
@@ -55,6 +62,25 @@ func (h *PostHandler) GetTimeline(c echo.Context) error {
 		}
 	}
 
+	switch services.TimelineMode(c.QueryParam("mode")) {
+	case "", services.TimelineModeChronological:
+	case services.TimelineModeRanked:
+		posts, nextCursor, err := h.postService.GetRankedFeed(userID, c.QueryParam("cursor"), limit)
+		if err != nil {
+			status, apiErr := utils.HTTPError(err)
+			return c.JSON(status, map[string]interface{}{"error": apiErr})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"posts":       posts,
+			"next_cursor": nextCursor,
+			"limit":       limit,
+			"mode":        services.TimelineModeRanked,
+		})
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid timeline mode"})
+	}
+
 	offsetStr := c.QueryParam("offset")
 	offset := 0
 	if offsetStr != "" {
@@ -166,10 +192,21 @@ func (h *PostHandler) AddComment(c echo.Context) error {
 	return c.JSON(http.StatusCreated, comment)
 }
 
-// GetComments returns comments for a post
+// GetComments returns a page of the comments on a post, or of the replies
+// to one of them when parent_id is given
 func (h *PostHandler) GetComments(c echo.Context) error {
+	userID := middleware.GetUserID(c)
 	postID := c.Param("postId")
 
+	var parentID *uuid.UUID
+	if parentStr := c.QueryParam("parent_id"); parentStr != "" {
+		id, err := uuid.Parse(parentStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid comment ID format"})
+		}
+		parentID = &id
+	}
+
 	limitStr := c.QueryParam("limit")
 	limit := 20
 	if limitStr != "" {
@@ -178,52 +215,241 @@ func (h *PostHandler) GetComments(c echo.Context) error {
 		}
 	}
 
-	offsetStr := c.QueryParam("offset")
-	offset := 0
-	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
-	}
-
-	comments, total, err := h.postService.GetComments(postID, limit, offset)
+	comments, nextCursor, err := h.postService.GetComments(postID, userID, parentID, c.QueryParam("cursor"), limit)
 	if err != nil {
 		status, apiErr := utils.HTTPError(err)
 		return c.JSON(status, map[string]interface{}{"error": apiErr})
 	}
 
 	response := map[string]interface{}{
-		"comments": comments,
-		"total":    total,
-		"limit":    limit,
-		"offset":   offset,
+		"comments":    comments,
+		"next_cursor": nextCursor,
+		"limit":       limit,
 	}
 
 	return c.JSON(http.StatusOK, response)
 }
 
-// FollowDog follows or unfollows a dog
+// UpdateComment edits one of the user's comments
+func (h *PostHandler) UpdateComment(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	commentID, err := uuid.Parse(c.Param("commentId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid comment ID format"})
+	}
+
+	var req services.UpdateCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	comment, err := h.postService.UpdateComment(commentID, userID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, comment)
+}
+
+// DeleteComment deletes a comment by its author or the post's owner
+func (h *PostHandler) DeleteComment(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	commentID, err := uuid.Parse(c.Param("commentId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid comment ID format"})
+	}
+
+	if err := h.postService.DeleteComment(commentID, userID); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Comment deleted successfully"})
+}
+
+// LikeComment likes or unlikes a comment
+func (h *PostHandler) LikeComment(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+	commentID, err := uuid.Parse(c.Param("commentId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid comment ID format"})
+	}
+
+	liked, err := h.postService.LikeComment(commentID, userID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	message := "Comment unliked successfully"
+	if liked {
+		message = "Comment liked successfully"
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"liked":   liked,
+		"message": message,
+	})
+}
+
+// FollowDog follows or unfollows a dog, or requests to follow it
 func (h *PostHandler) FollowDog(c echo.Context) error {
 	userID := middleware.GetUserID(c)
 	dogID := c.Param("dogId")
 
-	following, err := h.postService.FollowDog(dogID, userID)
+	state, err := h.postService.FollowDog(dogID, userID)
 	if err != nil {
 		status, apiErr := utils.HTTPError(err)
 		return c.JSON(status, map[string]interface{}{"error": apiErr})
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"following": following,
+		"following": state == services.FollowStateFollowing,
+		"status":    state,
 		"message": func() string {
-			if following {
+			switch state {
+			case services.FollowStateFollowing:
 				return "Dog followed successfully"
+			case services.FollowStateRequested:
+				return "Follow request sent"
 			}
 			return "Dog unfollowed successfully"
 		}(),
 	})
 }
 
+// GetFollowers returns the dogs following a dog
+func (h *PostHandler) GetFollowers(c echo.Context) error {
+	return h.followList(c, h.postService.GetFollowers)
+}
+
+// GetFollowing returns the dogs a dog follows
+func (h *PostHandler) GetFollowing(c echo.Context) error {
+	return h.followList(c, h.postService.GetFollowing)
+}
+
+func (h *PostHandler) followList(c echo.Context, list func(viewerID string, dogID uuid.UUID, limit int, offset int) ([]models.Dog, int64, error)) error {
+	dogUUID, err := uuid.Parse(c.Param("dogId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dog ID format"})
+	}
+
+	limitStr := c.QueryParam("limit")
+	limit := 20
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offsetStr := c.QueryParam("offset")
+	offset := 0
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	dogs, total, err := list(middleware.GetUserID(c), dogUUID, limit, offset)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dogs":   dogs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetFollowRequests returns the pending follow requests to (?direction=incoming,
+// the default) or from (?direction=outgoing) the user's dogs
+func (h *PostHandler) GetFollowRequests(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	direction := services.FollowRequestsIncoming
+	if d := c.QueryParam("direction"); d != "" {
+		direction = services.FollowRequestDirection(d)
+	}
+
+	limitStr := c.QueryParam("limit")
+	limit := 20
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offsetStr := c.QueryParam("offset")
+	offset := 0
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	requests, total, err := h.postService.GetFollowRequests(userID, direction, limit, offset)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"requests": requests,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// ApproveFollowRequest approves a request to follow one of the user's dogs
+func (h *PostHandler) ApproveFollowRequest(c echo.Context) error {
+	requestUUID, err := uuid.Parse(c.Param("requestId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request ID format"})
+	}
+
+	follow, err := h.postService.ApproveFollowRequest(middleware.GetUserID(c), requestUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, follow)
+}
+
+// DenyFollowRequest denies a request to follow one of the user's dogs
+func (h *PostHandler) DenyFollowRequest(c echo.Context) error {
+	requestUUID, err := uuid.Parse(c.Param("requestId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request ID format"})
+	}
+
+	if err := h.postService.DenyFollowRequest(middleware.GetUserID(c), requestUUID); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Follow request denied"})
+}
+
+// CancelFollowRequest withdraws a follow request made by one of the user's dogs
+func (h *PostHandler) CancelFollowRequest(c echo.Context) error {
+	requestUUID, err := uuid.Parse(c.Param("requestId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request ID format"})
+	}
+
+	if err := h.postService.CancelFollowRequest(middleware.GetUserID(c), requestUUID); err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Follow request cancelled"})
+}
+
 // SearchPosts searches for posts
 func (h *PostHandler) SearchPosts(c echo.Context) error {
 	query := c.QueryParam("q")
@@ -265,7 +491,7 @@ func (h *PostHandler) SearchPosts(c echo.Context) error {
 
 // RegisterRoutes registers post routes
 func (h *PostHandler) RegisterRoutes(e *echo.Echo) {
-	posts := e.Group("/api/posts", middleware.AuthMiddleware(h.cfg.JWT))
+	posts := e.Group("/api/posts", middleware.AuthMiddleware(h.cfg.JWT, h.redis), middleware.SuspensionGuard(h.moderationService))
 
 	// Post management
 	posts.POST("", h.CreatePost)
@@ -278,11 +504,20 @@ func (h *PostHandler) RegisterRoutes(e *echo.Echo) {
 	posts.POST("/:postId/like", h.LikePost)
 	posts.POST("/:postId/comments", h.AddComment)
 	posts.GET("/:postId/comments", h.GetComments)
+	posts.PUT("/comments/:commentId", h.UpdateComment)
+	posts.DELETE("/comments/:commentId", h.DeleteComment)
+	posts.POST("/comments/:commentId/like", h.LikeComment)
 
 	// Following
 	posts.POST("/dogs/:dogId/follow", h.FollowDog)
+	posts.GET("/dogs/:dogId/followers", h.GetFollowers)
+	posts.GET("/dogs/:dogId/following", h.GetFollowing)
+	posts.GET("/follow-requests", h.GetFollowRequests)
+	posts.POST("/follow-requests/:requestId/approve", h.ApproveFollowRequest)
+	posts.POST("/follow-requests/:requestId/deny", h.DenyFollowRequest)
+	posts.DELETE("/follow-requests/:requestId", h.CancelFollowRequest)
 
 	// Public routes
 	postsPublic := e.Group("/api/posts")
-	postsPublic.GET("/search", h.SearchPosts, middleware.OptionalAuthMiddleware(h.cfg.JWT))
+	postsPublic.GET("/search", h.SearchPosts, middleware.OptionalAuthMiddleware(h.cfg.JWT, h.redis))
 }
\ No newline at end of file
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/services"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRealtimeHeartbeat = 25 * time.Second
+
+	// realtimeRetry is how long SSE clients wait before reconnecting
+	realtimeRetry = 3 * time.Second
+)
+
+type RealtimeHandler struct {
+	hub       *services.RealtimeHub
+	heartbeat time.Duration
+	cfg       config.Config
+	redis     *redis.Client
+}
+
+func NewRealtimeHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *RealtimeHandler {
+	heartbeat := cfg.Realtime.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = defaultRealtimeHeartbeat
+	}
+
+	return &RealtimeHandler{
+		hub:       services.NewRealtimeHub(redis, cfg),
+		heartbeat: heartbeat,
+		cfg:       cfg,
+		redis:     redis,
+	}
+}
+
+// WebSocket streams the user's events over a WebSocket. Clients change
+// their subscriptions by sending {"action": "subscribe"|"unsubscribe",
+// "types": [...]} and resume with ?last_event_id=.
+func (h *RealtimeHandler) WebSocket(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	stream, err := h.openStream(c, userUUID, c.QueryParam("last_event_id"))
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+	defer stream.Close()
+
+	expires := h.tokenExpiry(c)
+	server := websocket.Server{
+		// Connections authenticate with the access token, not cookies, so
+		// other origins cannot ride on a user's session
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			socket := &realtimeSocket{ws: ws}
+
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				socket.receive(stream)
+			}()
+
+			h.pump(closed, stream, expires, func(event *services.RealtimeEvent) error {
+				if event == nil {
+					return socket.send(map[string]string{"type": "heartbeat"})
+				}
+				return socket.send(event)
+			})
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// Events streams the user's events as server-sent events, for clients that
+// cannot use WebSockets. Subscriptions are chosen with ?types= and clients
+// resume with the Last-Event-ID header.
+func (h *RealtimeHandler) Events(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("last_event_id")
+	}
+
+	stream, err := h.openStream(c, userUUID, lastEventID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+	defer stream.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", realtimeRetry.Milliseconds())
+	res.Flush()
+
+	h.pump(c.Request().Context().Done(), stream, h.tokenExpiry(c), func(event *services.RealtimeEvent) error {
+		if event == nil {
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+		} else {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(res, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return err
+			}
+		}
+		res.Flush()
+		return nil
+	})
+	return nil
+}
+
+// openStream opens the user's event stream for the types in ?types=
+func (h *RealtimeHandler) openStream(c echo.Context, userID uuid.UUID, lastEventID string) (*services.RealtimeStream, error) {
+	types, err := services.ParseRealtimeEventTypes(c.QueryParam("types"))
+	if err != nil {
+		return nil, err
+	}
+	return h.hub.Open(userID, lastEventID, types)
+}
+
+// tokenExpiry returns when the connection's access token expires, after
+// which the client must reconnect with a fresh one
+func (h *RealtimeHandler) tokenExpiry(c echo.Context) time.Time {
+	if claims := middleware.GetClaims(c); claims != nil && claims.ExpiresAt != nil {
+		return claims.ExpiresAt.Time
+	}
+	return time.Now().Add(h.cfg.JWT.ExpireHours)
+}
+
+// pump sends the stream's events, and a heartbeat (nil) whenever the
+// connection has been idle, until the connection closes, the stream is
+// dropped or the access token expires
+func (h *RealtimeHandler) pump(closed <-chan struct{}, stream *services.RealtimeStream, expires time.Time, send func(event *services.RealtimeEvent) error) {
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	expiry := time.NewTimer(time.Until(expires))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-expiry.C:
+			return
+		case <-heartbeat.C:
+			if err := send(nil); err != nil {
+				return
+			}
+		case event, ok := <-stream.Events():
+			if !ok {
+				return
+			}
+			if err := send(&event); err != nil {
+				return
+			}
+			heartbeat.Reset(h.heartbeat)
+		}
+	}
+}
+
+// realtimeSocket serializes writes to a WebSocket shared by the event pump
+// and the subscription replies
+type realtimeSocket struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (s *realtimeSocket) send(message interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return websocket.JSON.Send(s.ws, message)
+}
+
+// receive applies the client's subscription changes until the socket closes
+func (s *realtimeSocket) receive(stream *services.RealtimeStream) {
+	for {
+		var req services.RealtimeSubscriptionRequest
+		err := websocket.JSON.Receive(s.ws, &req)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			if s.send(map[string]string{"type": "error", "error": "Invalid request body"}) != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		if err := stream.Apply(req); err != nil {
+			_, apiErr := utils.HTTPError(err)
+			if s.send(map[string]interface{}{"type": "error", "error": apiErr}) != nil {
+				return
+			}
+			continue
+		}
+		if s.send(map[string]interface{}{"type": "subscribed", "types": stream.Types()}) != nil {
+			return
+		}
+	}
+}
+
+// RegisterRoutes registers realtime routes
+func (h *RealtimeHandler) RegisterRoutes(e *echo.Echo) {
+	realtime := e.Group("/api/realtime", middleware.TokenFromQuery("access_token"), middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+
+	realtime.GET("/ws", h.WebSocket)
+	realtime.GET("/events", h.Events)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/services"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type SearchHandler struct {
+	searchService *services.SearchService
+	cfg           config.Config
+	redis         *redis.Client
+}
+
+func NewSearchHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *SearchHandler {
+	return &SearchHandler{
+		searchService: services.NewSearchService(db),
+		cfg:           cfg,
+		redis:         redis,
+	}
+}
+
+// Search searches posts, dogs and users, or the types given in ?type=
+func (h *SearchHandler) Search(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	limitStr := c.QueryParam("limit")
+	limit := 20
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offsetStr := c.QueryParam("offset")
+	offset := 0
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	results, err := h.searchService.Search(userID, c.QueryParam("q"), searchTypes(c), limit, offset)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"results": results,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// Autocomplete suggests hashtags, dog names and usernames for a partly
+// typed query
+func (h *SearchHandler) Autocomplete(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	limitStr := c.QueryParam("limit")
+	limit := 5
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 20 {
+			limit = l
+		}
+	}
+
+	suggestions, err := h.searchService.Autocomplete(userID, c.QueryParam("q"), searchTypes(c), limit)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"suggestions": suggestions,
+	})
+}
+
+// searchTypes returns the comma-separated types in ?type=
+func searchTypes(c echo.Context) []services.SearchType {
+	var types []services.SearchType
+	for _, t := range strings.Split(c.QueryParam("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, services.SearchType(t))
+		}
+	}
+	return types
+}
+
+// RegisterRoutes registers search routes
+func (h *SearchHandler) RegisterRoutes(e *echo.Echo) {
+	search := e.Group("/api/search", middleware.OptionalAuthMiddleware(h.cfg.JWT, h.redis))
+
+	search.GET("", h.Search)
+	search.GET("/autocomplete", h.Autocomplete)
+}
@@ -15,12 +15,14 @@ import (
 type SubscriptionHandler struct {
 	subscriptionService *services.SubscriptionService
 	cfg                 config.Config
+	redis               *redis.Client
 }
 
 func NewSubscriptionHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *SubscriptionHandler {
 	return &SubscriptionHandler{
 		subscriptionService: services.NewSubscriptionService(db, cfg),
 		cfg:                 cfg,
+		redis:               redis,
 	}
 }
 
@@ -154,7 +156,7 @@ func (h *SubscriptionHandler) CheckFeatureAccess(c echo.Context) error {
 
 // RegisterRoutes registers subscription routes
 func (h *SubscriptionHandler) RegisterRoutes(e *echo.Echo) {
-	subscriptions := e.Group("/api/subscriptions", middleware.AuthMiddleware(h.cfg.JWT))
+	subscriptions := e.Group("/api/subscriptions", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
 
 	// Subscription management
 	subscriptions.GET("/plans", h.GetSubscriptionPlans)
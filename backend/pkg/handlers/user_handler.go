@@ -6,6 +6,7 @@ import (
 
 	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/services"
 	"github.com/doggyclub/backend/pkg/utils"
 	"github.com/labstack/echo/v4"
@@ -16,12 +17,14 @@ import (
 type UserHandler struct {
 	userService *services.UserService
 	cfg         config.Config
+	redis       *redis.Client
 }
 
 func NewUserHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *UserHandler {
 	return &UserHandler{
 		userService: services.NewUserService(db, redis, cfg),
 		cfg:         cfg,
+		redis:       redis,
 	}
 }
 
@@ -56,6 +59,19 @@ func (h *UserHandler) UpdateProfile(c echo.Context) error {
 	return c.JSON(http.StatusOK, profile)
 }
 
+// GetPrivacySettings returns the current user's privacy settings
+func (h *UserHandler) GetPrivacySettings(c echo.Context) error {
+	userID := middleware.GetUserID(c)
+
+	settings, err := h.userService.GetPrivacySettings(userID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
 // UpdatePrivacySettings updates privacy settings
 func (h *UserHandler) UpdatePrivacySettings(c echo.Context) error {
 	userID := middleware.GetUserID(c)
@@ -122,8 +138,9 @@ func (h *UserHandler) DeleteAccount(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "Account deleted successfully"})
 }
 
-// SearchUsers searches for users (admin function)
+// SearchUsers searches the users the current user may find by username
 func (h *UserHandler) SearchUsers(c echo.Context) error {
+	userID := middleware.GetUserID(c)
 	query := c.QueryParam("q")
 	if query == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Query parameter 'q' is required"})
@@ -145,7 +162,7 @@ func (h *UserHandler) SearchUsers(c echo.Context) error {
 		}
 	}
 
-	users, total, err := h.userService.SearchUsers(query, limit, offset)
+	users, total, err := h.userService.SearchUsers(userID, query, limit, offset)
 	if err != nil {
 		status, apiErr := utils.HTTPError(err)
 		return c.JSON(status, map[string]interface{}{"error": apiErr})
@@ -161,9 +178,36 @@ func (h *UserHandler) SearchUsers(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// UpdateUserRole changes another user's role (admin only)
+func (h *UserHandler) UpdateUserRole(c echo.Context) error {
+	targetUserID := c.Param("userId")
+	if targetUserID == middleware.GetUserID(c) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Cannot change your own role"})
+	}
+
+	var req services.UpdateRoleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	if err := utils.ValidateStruct(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": utils.NewValidationError(utils.FormatValidationErrors(err)),
+		})
+	}
+
+	user, err := h.userService.UpdateRole(targetUserID, req.Role)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
 // RegisterRoutes registers user routes
 func (h *UserHandler) RegisterRoutes(e *echo.Echo) {
-	users := e.Group("/api/users", middleware.AuthMiddleware(h.cfg.JWT))
+	users := e.Group("/api/users", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
 
 	// Profile management
 	users.GET("/profile", h.GetProfile)
@@ -171,12 +215,20 @@ func (h *UserHandler) RegisterRoutes(e *echo.Echo) {
 	users.DELETE("/profile", h.DeleteAccount)
 
 	// Settings
+	users.GET("/privacy", h.GetPrivacySettings)
 	users.PUT("/privacy", h.UpdatePrivacySettings)
 	users.PUT("/notifications", h.UpdateNotificationPreferences)
 
 	// Currency
 	users.GET("/currency", h.GetUserCurrency)
 
-	// Search (for admin/public use)
+	// Search
 	users.GET("/search", h.SearchUsers)
+
+	// Admin routes
+	admin := e.Group("/api/admin/users",
+		middleware.AuthMiddleware(h.cfg.JWT, h.redis),
+		middleware.RequireRole(models.RoleAdmin),
+	)
+	admin.PUT("/:userId/role", h.UpdateUserRole, middleware.RequirePermission(models.PermissionRolesManage))
 }
\ No newline at end of file
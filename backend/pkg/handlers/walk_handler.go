@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/middleware"
+	"github.com/doggyclub/backend/pkg/services"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type WalkHandler struct {
+	walkService *services.WalkService
+	cfg         config.Config
+	redis       *redis.Client
+}
+
+func NewWalkHandler(db *gorm.DB, redis *redis.Client, cfg config.Config) *WalkHandler {
+	return &WalkHandler{
+		walkService: services.NewWalkService(db),
+		cfg:         cfg,
+		redis:       redis,
+	}
+}
+
+// StartWalk starts a walk for one of the user's dogs
+func (h *WalkHandler) StartWalk(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	var req services.StartWalkRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	walk, err := h.walkService.StartWalk(userUUID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusCreated, walk)
+}
+
+// AddPoints records a batch of GPS points for a walk in progress
+func (h *WalkHandler) AddPoints(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	walkUUID, err := uuid.Parse(c.Param("walkId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid walk ID format"})
+	}
+
+	var req services.AddWalkPointsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	walk, accepted, err := h.walkService.AddPoints(userUUID, walkUUID, req)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"walk":     walk,
+		"accepted": accepted,
+	})
+}
+
+// StopWalk ends a walk
+func (h *WalkHandler) StopWalk(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	walkUUID, err := uuid.Parse(c.Param("walkId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid walk ID format"})
+	}
+
+	walk, err := h.walkService.StopWalk(userUUID, walkUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, walk)
+}
+
+// GetWalk returns a walk with its encounters
+func (h *WalkHandler) GetWalk(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	walkUUID, err := uuid.Parse(c.Param("walkId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid walk ID format"})
+	}
+
+	walk, err := h.walkService.GetWalk(userUUID, walkUUID)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, walk)
+}
+
+// GetDogWalks returns the walks of one of the user's dogs
+func (h *WalkHandler) GetDogWalks(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	dogUUID, err := uuid.Parse(c.Param("dogId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid dog ID format"})
+	}
+
+	limit := 20
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	offset := 0
+	if offsetStr := c.QueryParam("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	walks, total, err := h.walkService.GetDogWalks(userUUID, dogUUID, limit, offset)
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"walks":  walks,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// ExportWalk downloads a walk as GPX or GeoJSON, chosen by ?format=
+func (h *WalkHandler) ExportWalk(c echo.Context) error {
+	userUUID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+	}
+
+	walkUUID, err := uuid.Parse(c.Param("walkId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid walk ID format"})
+	}
+
+	var data []byte
+	var contentType, extension string
+	switch c.QueryParam("format") {
+	case "gpx", "":
+		data, err = h.walkService.ExportGPX(userUUID, walkUUID)
+		contentType, extension = "application/gpx+xml", "gpx"
+	case "geojson":
+		data, err = h.walkService.ExportGeoJSON(userUUID, walkUUID)
+		contentType, extension = "application/geo+json", "geojson"
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported export format"})
+	}
+	if err != nil {
+		status, apiErr := utils.HTTPError(err)
+		return c.JSON(status, map[string]interface{}{"error": apiErr})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\"walk-"+walkUUID.String()+"."+extension+"\"")
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+// RegisterRoutes registers walk routes
+func (h *WalkHandler) RegisterRoutes(e *echo.Echo) {
+	walks := e.Group("/api/walks", middleware.AuthMiddleware(h.cfg.JWT, h.redis))
+
+	walks.POST("", h.StartWalk)
+	walks.POST("/:walkId/points", h.AddPoints)
+	walks.POST("/:walkId/stop", h.StopWalk)
+	walks.GET("/:walkId", h.GetWalk)
+	walks.GET("/:walkId/export", h.ExportWalk)
+	walks.GET("/dogs/:dogId", h.GetDogWalks)
+}
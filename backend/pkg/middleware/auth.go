@@ -2,15 +2,20 @@ package middleware
 
 import (
 	"context"
-	"strings"
 
 	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/services"
 	"github.com/doggyclub/backend/pkg/utils"
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 )
 
-// AuthMiddleware creates JWT authentication middleware
-func AuthMiddleware(cfg config.JWTConfig) echo.MiddlewareFunc {
+// AuthMiddleware creates JWT authentication middleware.
+// Tokens revoked through logout or session management are rejected.
+func AuthMiddleware(cfg config.JWTConfig, redis *redis.Client) echo.MiddlewareFunc {
+	denylist := services.NewTokenDenylist(redis)
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			// Get token from header
@@ -31,9 +36,17 @@ func AuthMiddleware(cfg config.JWTConfig) echo.MiddlewareFunc {
 				return c.JSON(401, map[string]string{"error": "Invalid or expired token"})
 			}
 
+			// Reject revoked tokens, failing closed if the denylist is unreachable
+			denied, err := denylist.IsRevoked(claims)
+			if err != nil {
+				return c.JSON(503, map[string]string{"error": "Authentication temporarily unavailable"})
+			}
+			if denied {
+				return c.JSON(401, map[string]string{"error": "Invalid or expired token"})
+			}
+
 			// Set user context
-			c.Set("user_id", claims.UserID)
-			c.Set("email", claims.Email)
+			setClaims(c, claims)
 
 			return next(c)
 		}
@@ -41,7 +54,9 @@ func AuthMiddleware(cfg config.JWTConfig) echo.MiddlewareFunc {
 }
 
 // OptionalAuthMiddleware for endpoints that work with or without auth
-func OptionalAuthMiddleware(cfg config.JWTConfig) echo.MiddlewareFunc {
+func OptionalAuthMiddleware(cfg config.JWTConfig, redis *redis.Client) echo.MiddlewareFunc {
+	denylist := services.NewTokenDenylist(redis)
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -50,8 +65,9 @@ func OptionalAuthMiddleware(cfg config.JWTConfig) echo.MiddlewareFunc {
 				if tokenString != "" {
 					claims, err := utils.ValidateToken(tokenString, cfg)
 					if err == nil {
-						c.Set("user_id", claims.UserID)
-						c.Set("email", claims.Email)
+						if denied, err := denylist.IsRevoked(claims); err == nil && !denied {
+							setClaims(c, claims)
+						}
 					}
 				}
 			}
@@ -60,6 +76,38 @@ func OptionalAuthMiddleware(cfg config.JWTConfig) echo.MiddlewareFunc {
 	}
 }
 
+// TokenFromQuery lets the access token be passed as a query parameter, for
+// clients such as browser WebSocket and EventSource that cannot set headers.
+// Must run before AuthMiddleware; a header token takes precedence. The
+// parameter is removed from the request URI so the token does not end up in
+// the access log.
+func TokenFromQuery(param string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			query := req.URL.Query()
+			if token := query.Get(param); token != "" {
+				if req.Header.Get("Authorization") == "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				query.Del(param)
+				req.URL.RawQuery = query.Encode()
+				req.RequestURI = req.URL.RequestURI()
+			}
+			return next(c)
+		}
+	}
+}
+
+// setClaims stores the authenticated user on the context
+func setClaims(c echo.Context, claims *utils.Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("email", claims.Email)
+	c.Set("role", claims.Role)
+	c.Set("session_id", claims.SessionID)
+	c.Set("claims", claims)
+}
+
 // GetUserID gets user ID from context
 func GetUserID(c echo.Context) string {
 	if userID, ok := c.Get("user_id").(string); ok {
@@ -76,36 +124,66 @@ func GetEmail(c echo.Context) string {
 	return ""
 }
 
-// RequireRole middleware for role-based access control
-func RequireRole(roles ...string) echo.MiddlewareFunc {
+// GetSessionID gets the session ID of the access token from context
+func GetSessionID(c echo.Context) string {
+	if sessionID, ok := c.Get("session_id").(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
+// GetClaims gets the validated access token claims from context
+func GetClaims(c echo.Context) *utils.Claims {
+	if claims, ok := c.Get("claims").(*utils.Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+// GetRole gets the user's role from context. Tokens issued before roles
+// existed carry none and are treated as regular users.
+func GetRole(c echo.Context) models.Role {
+	if role, ok := c.Get("role").(string); ok && role != "" {
+		return models.Role(role)
+	}
+	return models.RoleUser
+}
+
+// RequireRole middleware for role-based access control.
+// Must run after AuthMiddleware.
+func RequireRole(roles ...models.Role) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			// For now, we'll just check if user is authenticated
-			// Role checking can be implemented later
-			userID := GetUserID(c)
-			if userID == "" {
-				return c.JSON(403, map[string]string{"error": "Forbidden"})
+			if GetUserID(c) == "" {
+				return c.JSON(401, map[string]string{"error": "Unauthorized"})
 			}
-			return next(c)
+
+			role := GetRole(c)
+			for _, allowed := range roles {
+				if role == allowed {
+					return next(c)
+				}
+			}
+			return c.JSON(403, map[string]string{"error": "Forbidden"})
 		}
 	}
 }
 
-// RateLimitMiddleware implements rate limiting
-func RateLimitMiddleware(limit int, window int) echo.MiddlewareFunc {
-	// For now, just pass through
-	// Implement actual rate limiting with Redis later
+// RequirePermission middleware rejects users whose role lacks any of the permissions.
+// Must run after AuthMiddleware.
+func RequirePermission(permissions ...models.Permission) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			// Get client IP
-			ip := c.RealIP()
-			if ip == "" {
-				ip = strings.Split(c.Request().RemoteAddr, ":")[0]
+			if GetUserID(c) == "" {
+				return c.JSON(401, map[string]string{"error": "Unauthorized"})
+			}
+
+			role := GetRole(c)
+			for _, permission := range permissions {
+				if !role.HasPermission(permission) {
+					return c.JSON(403, map[string]string{"error": "Forbidden"})
+				}
 			}
-			
-			// For now, just pass through
-			// Implement actual rate limiting with Redis later
-			_ = ip // Use the variable to avoid unused error
 			return next(c)
 		}
 	}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// SuspensionChecker reports whether a user is currently suspended
+type SuspensionChecker interface {
+	IsUserSuspended(userID string) (bool, *models.UserSuspension, error)
+}
+
+// SuspensionGuard rejects write requests from suspended users. Reads stay
+// available so a suspended user can still see their account.
+// Must run after AuthMiddleware.
+func SuspensionGuard(checker SuspensionChecker) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+
+			userID := GetUserID(c)
+			if userID == "" {
+				return next(c)
+			}
+
+			suspended, suspension, err := checker.IsUserSuspended(userID)
+			if err != nil {
+				status, apiErr := utils.HTTPError(err)
+				return c.JSON(status, map[string]interface{}{"error": apiErr})
+			}
+			if suspended {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error": utils.NewSuspendedError(suspension.Type, suspension.ExpiresAt),
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
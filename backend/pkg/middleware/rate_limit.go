@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/services"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitMiddleware limits requests per client under the given policy.
+// Clients are keyed by user ID when authenticated and by IP otherwise, so
+// place it after AuthMiddleware to limit per user. Requests are let through
+// if Redis is unavailable.
+func RateLimitMiddleware(redis *redis.Client, cfg config.RateLimitConfig, policy config.RateLimitPolicy) echo.MiddlewareFunc {
+	limiter := services.NewRateLimiter(redis)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if !cfg.Enabled || policy.Limit <= 0 {
+			return next
+		}
+
+		return func(c echo.Context) error {
+			client := "ip:" + c.RealIP()
+			if userID := GetUserID(c); userID != "" {
+				client = "user:" + userID
+			}
+
+			result, err := limiter.Allow(policy, client)
+			if err != nil {
+				log.Printf("Rate limit check failed for %s: %v", policy.Name, err)
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.ResetAfter).Unix(), 10))
+
+			if !result.Allowed {
+				retryAfter := int(math.Ceil(result.ResetAfter.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				header.Set("Retry-After", strconv.Itoa(retryAfter))
+				status, apiErr := utils.HTTPError(utils.ErrTooManyRequests)
+				return c.JSON(status, map[string]interface{}{"error": apiErr})
+			}
+
+			return next(c)
+		}
+	}
+}
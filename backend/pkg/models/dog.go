@@ -7,12 +7,32 @@ import (
 	"gorm.io/gorm"
 )
 
+// DogSize is a dog's size class
+type DogSize string
+
+const (
+	DogSizeSmall  DogSize = "small"
+	DogSizeMedium DogSize = "medium"
+	DogSizeLarge  DogSize = "large"
+	DogSizeGiant  DogSize = "giant"
+)
+
+// IsValid reports whether the size is a known size class
+func (s DogSize) IsValid() bool {
+	switch s {
+	case DogSizeSmall, DogSizeMedium, DogSizeLarge, DogSizeGiant:
+		return true
+	}
+	return false
+}
+
 // Dog represents a dog profile in the system
 type Dog struct {
 	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	UserID   uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
 	Name     string    `gorm:"type:varchar(50);not null" json:"name" validate:"required,max=50"`
 	Breed    string    `gorm:"type:varchar(50)" json:"breed"`
+	Size     DogSize   `gorm:"type:varchar(10)" json:"size,omitempty"`
 	Age      int       `gorm:"type:integer" json:"age" validate:"min=0,max=30"`
 	PhotoURL string    `gorm:"type:varchar(255)" json:"photo_url"`
 	Bio      string    `gorm:"type:text" json:"bio"`
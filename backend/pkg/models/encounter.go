@@ -1,6 +1,8 @@
 package models
 
 import (
+	"math"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,18 +19,39 @@ const (
 	DetectionMethodBluetooth DetectionMethod = "bluetooth"
 )
 
-// Encounter represents a meeting between two dogs
+// detectionConfidence is how likely a single detection by each method is a real meeting
+var detectionConfidence = map[DetectionMethod]float64{
+	DetectionMethodGPS:       0.6,
+	DetectionMethodBluetooth: 0.7,
+}
+
+// Encounter represents a meeting between two dogs. Detections of the same
+// meeting from either dog and by any method merge into one encounter, which
+// stays open until the dogs are no longer seen together. Dog1ID is always the
+// lower of the two IDs and each pair has at most one open encounter.
 type Encounter struct {
-	ID               uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	Dog1ID           uuid.UUID       `gorm:"type:uuid;not null;index" json:"dog1_id"`
-	Dog2ID           uuid.UUID       `gorm:"type:uuid;not null;index" json:"dog2_id"`
-	Location         orb.Point       `gorm:"type:geography(POINT)" json:"location"`
-	DetectionMethod  DetectionMethod `gorm:"type:varchar(20);not null" json:"detection_method"`
-	Timestamp        time.Time       `gorm:"default:CURRENT_TIMESTAMP;index" json:"timestamp"`
+	ID                uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Dog1ID            uuid.UUID       `gorm:"type:uuid;not null;index" json:"dog1_id"`
+	Dog2ID            uuid.UUID       `gorm:"type:uuid;not null;index" json:"dog2_id"`
+	Location          GeoPoint        `gorm:"type:geography(POINT)" json:"location"`
+	DetectionMethod   DetectionMethod `gorm:"type:varchar(20);not null" json:"detection_method"`
+	Timestamp         time.Time       `gorm:"default:CURRENT_TIMESTAMP;index" json:"timestamp"`
+	StartedAt         *time.Time      `json:"started_at,omitempty"`
+	LastSeenAt        *time.Time      `json:"last_seen_at,omitempty"`
+	EndedAt           *time.Time      `json:"ended_at,omitempty"`
+	DurationSeconds   int             `gorm:"not null;default:0" json:"duration_seconds"`
+	MinDistanceMeters *float64        `json:"min_distance_meters,omitempty"`
+	PlaceID           *uuid.UUID      `gorm:"type:uuid;index" json:"place_id,omitempty"`
+
+	// Detection evidence
+	DetectionMethods []DetectionMethod `gorm:"type:jsonb;serializer:json" json:"detection_methods"`
+	DetectionCount   int               `gorm:"not null;default:0" json:"detection_count"`
+	Confidence       float64           `gorm:"not null;default:0" json:"confidence"`
 
 	// Relationships
-	Dog1 Dog `gorm:"foreignKey:Dog1ID;constraint:OnDelete:CASCADE" json:"dog1,omitempty"`
-	Dog2 Dog `gorm:"foreignKey:Dog2ID;constraint:OnDelete:CASCADE" json:"dog2,omitempty"`
+	Dog1  Dog    `gorm:"foreignKey:Dog1ID;constraint:OnDelete:CASCADE" json:"dog1,omitempty"`
+	Dog2  Dog    `gorm:"foreignKey:Dog2ID;constraint:OnDelete:CASCADE" json:"dog2,omitempty"`
+	Place *Place `gorm:"foreignKey:PlaceID;constraint:OnDelete:SET NULL" json:"place,omitempty"`
 }
 
 // BeforeCreate sets the ID before creating the encounter
@@ -44,14 +67,47 @@ func (Encounter) TableName() string {
 	return "encounters"
 }
 
+// HasDetectionMethod reports whether the encounter was detected by method
+func (e *Encounter) HasDetectionMethod(method DetectionMethod) bool {
+	for _, m := range e.DetectionMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// AddDetection records another detection of the encounter and recomputes its
+// confidence. Distinct methods combine as independent evidence and every
+// repeated detection, such as the other dog reporting the same meeting,
+// halves the remaining doubt.
+func (e *Encounter) AddDetection(method DetectionMethod) {
+	if e.DetectionMethod == "" {
+		e.DetectionMethod = method
+	}
+	if !e.HasDetectionMethod(method) {
+		e.DetectionMethods = append(e.DetectionMethods, method)
+	}
+	e.DetectionCount++
+
+	doubt := 1.0
+	for _, m := range e.DetectionMethods {
+		doubt *= 1 - detectionConfidence[m]
+	}
+	for i := len(e.DetectionMethods); i < e.DetectionCount; i++ {
+		doubt /= 2
+	}
+	e.Confidence = math.Round((1-doubt)*100) / 100
+}
+
 // GetLocationWKT returns the location as WKT string
 func (e *Encounter) GetLocationWKT() string {
-	return wkt.MarshalString(e.Location)
+	return wkt.MarshalString(orb.Point(e.Location))
 }
 
 // SetLocationFromCoords sets the location from latitude and longitude
 func (e *Encounter) SetLocationFromCoords(lat, lng float64) {
-	e.Location = orb.Point{lng, lat}
+	e.Location = NewGeoPoint(lat, lng)
 }
 
 // GetLatLng returns the latitude and longitude
@@ -59,11 +115,58 @@ func (e *Encounter) GetLatLng() (float64, float64) {
 	return e.Location[1], e.Location[0] // lat, lng
 }
 
+// ProximitySession tracks two dogs staying within encounter range of each
+// other. It becomes an encounter once it lasts the configured dwell time and
+// ends when the dogs have not been seen together for the separation timeout.
+// Dog1ID is always the lower of the two IDs so each pair has one open session.
+type ProximitySession struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Dog1ID            uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_proximity_sessions_open_pair,where:ended_at IS NULL" json:"dog1_id"`
+	Dog2ID            uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_proximity_sessions_open_pair,where:ended_at IS NULL;index" json:"dog2_id"`
+	Location          GeoPoint   `gorm:"type:geography(POINT)" json:"location"`
+	StartedAt         time.Time  `gorm:"not null" json:"started_at"`
+	LastSeenAt        time.Time  `gorm:"not null;index" json:"last_seen_at"`
+	EndedAt           *time.Time `json:"ended_at,omitempty"`
+	MinDistanceMeters float64    `gorm:"not null" json:"min_distance_meters"`
+	EncounterID       *uuid.UUID `gorm:"type:uuid" json:"encounter_id,omitempty"`
+
+	// Relationships
+	Dog1      Dog        `gorm:"foreignKey:Dog1ID;constraint:OnDelete:CASCADE" json:"-"`
+	Dog2      Dog        `gorm:"foreignKey:Dog2ID;constraint:OnDelete:CASCADE" json:"-"`
+	Encounter *Encounter `gorm:"foreignKey:EncounterID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating the proximity session
+func (ps *ProximitySession) BeforeCreate(tx *gorm.DB) error {
+	if ps.ID == uuid.Nil {
+		ps.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the ProximitySession model
+func (ProximitySession) TableName() string {
+	return "proximity_sessions"
+}
+
+// Duration returns how long the dogs have been together
+func (ps *ProximitySession) Duration() time.Duration {
+	return ps.LastSeenAt.Sub(ps.StartedAt)
+}
+
+// OrderedDogPair returns the two dog IDs with the lower one first
+func OrderedDogPair(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
+	if a.String() > b.String() {
+		return b, a
+	}
+	return a, b
+}
+
 // DeviceLocation represents real-time location tracking for cache
 type DeviceLocation struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	DogID     uuid.UUID `gorm:"type:uuid;not null;index" json:"dog_id"`
-	Location  orb.Point `gorm:"type:geography(POINT);index:idx_location,type:gist" json:"location"`
+	Location  GeoPoint  `gorm:"type:geography(POINT);index:idx_location,type:gist" json:"location"`
 	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index" json:"updated_at"`
 
 	// Relationship
@@ -91,15 +194,209 @@ func (DeviceLocation) TableName() string {
 
 // GetLocationWKT returns the location as WKT string
 func (dl *DeviceLocation) GetLocationWKT() string {
-	return wkt.MarshalString(dl.Location)
+	return wkt.MarshalString(orb.Point(dl.Location))
 }
 
 // SetLocationFromCoords sets the location from latitude and longitude
 func (dl *DeviceLocation) SetLocationFromCoords(lat, lng float64) {
-	dl.Location = orb.Point{lng, lat}
+	dl.Location = NewGeoPoint(lat, lng)
 }
 
 // GetLatLng returns the latitude and longitude
 func (dl *DeviceLocation) GetLatLng() (float64, float64) {
 	return dl.Location[1], dl.Location[0] // lat, lng
+}
+
+// LocationBatch records an offline location upload by its client batch ID,
+// so a retried upload is answered from the first one instead of ingested twice
+type LocationBatch struct {
+	ID           uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	DogID        uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_location_batches_dog_batch,priority:1" json:"dog_id"`
+	BatchID      string      `gorm:"type:varchar(64);not null;uniqueIndex:idx_location_batches_dog_batch,priority:2" json:"batch_id"`
+	Accepted     int         `gorm:"not null;default:0" json:"accepted"`
+	Rejected     int         `gorm:"not null;default:0" json:"rejected"`
+	EncounterIDs []uuid.UUID `gorm:"type:jsonb;serializer:json" json:"encounter_ids"`
+	CreatedAt    time.Time   `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+
+	// Relationships
+	Dog Dog `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating the batch
+func (lb *LocationBatch) BeforeCreate(tx *gorm.DB) error {
+	if lb.ID == uuid.Nil {
+		lb.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the LocationBatch model
+func (LocationBatch) TableName() string {
+	return "location_batches"
+}
+
+// WaveStatus is the state of a wave at an encounter
+type WaveStatus string
+
+const (
+	WaveStatusPending WaveStatus = "pending" // waiting for the other side to wave back
+	WaveStatusMatched WaveStatus = "matched" // both sides waved and the dogs follow each other
+	WaveStatusExpired WaveStatus = "expired" // not waved back in time
+)
+
+// EncounterWave is an owner's wave from their dog at the other dog of an
+// encounter. When both sides wave before the first wave expires the dogs
+// are connected. Each dog waves at most once per encounter.
+type EncounterWave struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	EncounterID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_encounter_waves_dog" json:"encounter_id"`
+	DogID       uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_encounter_waves_dog" json:"dog_id"`
+	ToDogID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"to_dog_id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Status      WaveStatus `gorm:"type:varchar(10);not null;index:idx_encounter_waves_status_expiry" json:"status"`
+	ExpiresAt   time.Time  `gorm:"not null;index:idx_encounter_waves_status_expiry" json:"expires_at"`
+	MatchedAt   *time.Time `json:"matched_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Relationships
+	Encounter Encounter `gorm:"foreignKey:EncounterID;constraint:OnDelete:CASCADE" json:"-"`
+	Dog       Dog       `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"dog,omitempty"`
+	ToDog     Dog       `gorm:"foreignKey:ToDogID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating the wave
+func (w *EncounterWave) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the EncounterWave model
+func (EncounterWave) TableName() string {
+	return "encounter_waves"
+}
+
+// DefaultEncounterRadius is the encounter radius of users who never set one
+const DefaultEncounterRadius = 50
+
+// EncounterSettings are a user's encounter detection preferences, shared by
+// all of the user's dogs. Users without a row use DefaultEncounterSettings.
+type EncounterSettings struct {
+	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID           uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"user_id"`
+	DetectionEnabled bool      `gorm:"not null" json:"detection_enabled"`
+	QuietHoursStart  *string   `gorm:"type:varchar(5)" json:"quiet_hours_start"` // HH:MM in TimeZone
+	QuietHoursEnd    *string   `gorm:"type:varchar(5)" json:"quiet_hours_end"`
+	TimeZone         string    `gorm:"type:varchar(64);not null" json:"time_zone"`
+	RadiusMeters     int       `gorm:"not null" json:"radius_meters"`
+	FollowedDogsOnly bool      `gorm:"not null" json:"followed_dogs_only"`
+	Breeds           []string  `gorm:"type:jsonb;serializer:json" json:"breeds"`
+	Sizes            []DogSize `gorm:"type:jsonb;serializer:json" json:"sizes"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// DefaultEncounterSettings returns the settings of a user who never changed them
+func DefaultEncounterSettings(userID uuid.UUID) EncounterSettings {
+	return EncounterSettings{
+		UserID:           userID,
+		DetectionEnabled: true,
+		TimeZone:         "UTC",
+		RadiusMeters:     DefaultEncounterRadius,
+		Breeds:           []string{},
+		Sizes:            []DogSize{},
+	}
+}
+
+// BeforeCreate sets the ID before creating the settings
+func (es *EncounterSettings) BeforeCreate(tx *gorm.DB) error {
+	if es.ID == uuid.Nil {
+		es.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the EncounterSettings model
+func (EncounterSettings) TableName() string {
+	return "encounter_settings"
+}
+
+// InQuietHours reports whether t falls within the quiet hours, which may
+// span midnight
+func (es *EncounterSettings) InQuietHours(t time.Time) bool {
+	if es.QuietHoursStart == nil || es.QuietHoursEnd == nil {
+		return false
+	}
+	start, err := time.Parse("15:04", *es.QuietHoursStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", *es.QuietHoursEnd)
+	if err != nil {
+		return false
+	}
+
+	location, err := time.LoadLocation(es.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := t.In(location)
+
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	until := end.Hour()*60 + end.Minute()
+	if from <= until {
+		return minute >= from && minute < until
+	}
+	return minute >= from || minute < until
+}
+
+// Detecting reports whether the user's dogs take part in encounter detection at t
+func (es *EncounterSettings) Detecting(t time.Time) bool {
+	return es.DetectionEnabled && !es.InQuietHours(t)
+}
+
+// Accepts reports whether the dog passes the breed and size filters. Dogs
+// without a size never pass a size filter.
+func (es *EncounterSettings) Accepts(dog Dog) bool {
+	if len(es.Breeds) > 0 {
+		matched := false
+		for _, breed := range es.Breeds {
+			if strings.EqualFold(strings.TrimSpace(breed), strings.TrimSpace(dog.Breed)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(es.Sizes) > 0 {
+		for _, size := range es.Sizes {
+			if size == dog.Size {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// LocationHistory is an append-only record of the locations a dog reported.
+// The table is range partitioned by day on RecordedAt and created by the
+// migration rather than AutoMigrate.
+type LocationHistory struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DogID      uuid.UUID `gorm:"type:uuid;not null" json:"dog_id"`
+	Location   GeoPoint  `gorm:"type:geography(POINT);not null" json:"location"`
+	RecordedAt time.Time `gorm:"primaryKey;not null" json:"recorded_at"`
+}
+
+// TableName returns the table name for the LocationHistory model
+func (LocationHistory) TableName() string {
+	return "location_history"
 }
\ No newline at end of file
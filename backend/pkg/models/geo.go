@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/ewkb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/planar"
+)
+
+// GeoPoint is a WGS84 point stored in a PostGIS geography(POINT) column.
+// Like orb.Point it holds [longitude, latitude] and marshals to JSON as such.
+type GeoPoint orb.Point
+
+// NewGeoPoint creates a point from latitude and longitude
+func NewGeoPoint(lat, lng float64) GeoPoint {
+	return GeoPoint{lng, lat}
+}
+
+// Lat returns the latitude
+func (p GeoPoint) Lat() float64 {
+	return p[1]
+}
+
+// Lng returns the longitude
+func (p GeoPoint) Lng() float64 {
+	return p[0]
+}
+
+// metersPerDegreeLat is the length of one degree of latitude
+const metersPerDegreeLat = 111320.0
+
+// Snap moves the point to the centre of its cell in a grid of cells about
+// gridMeters on a side. Every point in a cell snaps to the same centre, so
+// snapped points reveal only the cell.
+func (p GeoPoint) Snap(gridMeters float64) GeoPoint {
+	if gridMeters <= 0 {
+		return p
+	}
+
+	latStep := gridMeters / metersPerDegreeLat
+	lat := (math.Floor(p.Lat()/latStep) + 0.5) * latStep
+	lat = math.Max(-90, math.Min(90, lat))
+
+	// Cells in a row share the longitude step of the row's centre
+	lngStep := gridMeters / (metersPerDegreeLat * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	lng := (math.Floor(p.Lng()/lngStep) + 0.5) * lngStep
+	if lng > 180 {
+		lng -= 360
+	}
+	return NewGeoPoint(lat, lng)
+}
+
+// Value writes the point as EWKT, which PostGIS casts to geography
+func (p GeoPoint) Value() (driver.Value, error) {
+	return "SRID=4326;POINT(" + strconv.FormatFloat(p[0], 'f', -1, 64) + " " +
+		strconv.FormatFloat(p[1], 'f', -1, 64) + ")", nil
+}
+
+// Scan reads the hex encoded EWKB PostGIS returns for geography columns
+func (p *GeoPoint) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*p = GeoPoint{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		// The scanner decodes hex in place, so leave the driver's buffer alone
+		data = append([]byte(nil), v...)
+	default:
+		return fmt.Errorf("unsupported type for GeoPoint: %T", value)
+	}
+
+	var point orb.Point
+	if err := ewkb.Scanner(&point).Scan(data); err != nil {
+		return fmt.Errorf("failed to scan GeoPoint: %w", err)
+	}
+	*p = GeoPoint(point)
+	return nil
+}
+
+// GeoPolygon is a WGS84 polygon stored in a PostGIS geography(POLYGON)
+// column. Rings hold [longitude, latitude] points, outer ring first.
+type GeoPolygon orb.Polygon
+
+// Contains reports whether the point is inside the polygon and outside its holes
+func (p GeoPolygon) Contains(point GeoPoint) bool {
+	return planar.PolygonContains(orb.Polygon(p), orb.Point(point))
+}
+
+// Centroid returns the centre of the polygon
+func (p GeoPolygon) Centroid() GeoPoint {
+	centroid, _ := planar.CentroidArea(orb.Polygon(p))
+	return GeoPoint(centroid)
+}
+
+// Value writes the polygon as EWKT, which PostGIS casts to geography
+func (p GeoPolygon) Value() (driver.Value, error) {
+	return "SRID=4326;" + wkt.MarshalString(orb.Polygon(p)), nil
+}
+
+// Scan reads the hex encoded EWKB PostGIS returns for geography columns
+func (p *GeoPolygon) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = append([]byte(nil), v...)
+	default:
+		return fmt.Errorf("unsupported type for GeoPolygon: %T", value)
+	}
+
+	var polygon orb.Polygon
+	if err := ewkb.Scanner(&polygon).Scan(data); err != nil {
+		return fmt.Errorf("failed to scan GeoPolygon: %w", err)
+	}
+	*p = GeoPolygon(polygon)
+	return nil
+}
+
+// GeoLineString is a WGS84 line stored in a PostGIS geography(LINESTRING)
+// column, as [longitude, latitude] points
+type GeoLineString orb.LineString
+
+// Value writes the line as EWKT, which PostGIS casts to geography
+func (l GeoLineString) Value() (driver.Value, error) {
+	return "SRID=4326;" + wkt.MarshalString(orb.LineString(l)), nil
+}
+
+// Scan reads the hex encoded EWKB PostGIS returns for geography columns
+func (l *GeoLineString) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = append([]byte(nil), v...)
+	default:
+		return fmt.Errorf("unsupported type for GeoLineString: %T", value)
+	}
+
+	var line orb.LineString
+	if err := ewkb.Scanner(&line).Scan(data); err != nil {
+		return fmt.Errorf("failed to scan GeoLineString: %w", err)
+	}
+	*l = GeoLineString(line)
+	return nil
+}
@@ -97,7 +97,7 @@ type SafetySettings struct {
 	HideFromSearch        bool      `json:"hide_from_search" gorm:"default:false"`
 	BlockExplicitContent  bool      `json:"block_explicit_content" gorm:"default:true"`
 	MinAge                *int      `json:"min_age,omitempty"` // Minimum age of users who can interact
-	AllowedLocations      []string  `json:"allowed_locations" gorm:"type:text[]"` // Allowed countries/regions
+	AllowedLocations      []string  `json:"allowed_locations" gorm:"type:jsonb;serializer:json"` // Allowed countries/regions
 	CreatedAt             time.Time `json:"created_at"`
 	UpdatedAt             time.Time `json:"updated_at"`
 }
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PlaceKind represents the type of a place
+type PlaceKind string
+
+const (
+	PlaceKindDogPark PlaceKind = "dog_park"
+	PlaceKindBeach   PlaceKind = "beach"
+	PlaceKindTrail   PlaceKind = "trail"
+	PlaceKindOther   PlaceKind = "other"
+)
+
+// Place is a named area such as a dog park. Dogs reporting a location inside
+// its boundary are checked in automatically.
+type Place struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name        string     `gorm:"type:varchar(100);not null" json:"name"`
+	Kind        PlaceKind  `gorm:"type:varchar(20);not null;default:'dog_park'" json:"kind"`
+	Description string     `gorm:"type:text" json:"description"`
+	Boundary    GeoPolygon `gorm:"type:geography(POLYGON);not null;index:idx_places_boundary,type:gist" json:"boundary"`
+	CreatedByID uuid.UUID  `gorm:"type:uuid;not null" json:"created_by_id"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// BeforeCreate sets the ID before creating the place
+func (p *Place) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the Place model
+func (Place) TableName() string {
+	return "places"
+}
+
+// PlaceVisit is a dog's stay at a place, from check-in when it first reports
+// a location inside the place to check-out when it reports one outside.
+// A dog has at most one open visit.
+type PlaceVisit struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PlaceID      uuid.UUID  `gorm:"type:uuid;not null;index:idx_place_visits_place_checked_in,priority:1" json:"place_id"`
+	DogID        uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_place_visits_open_dog,where:checked_out_at IS NULL" json:"dog_id"`
+	CheckedInAt  time.Time  `gorm:"not null;index:idx_place_visits_place_checked_in,priority:2" json:"checked_in_at"`
+	LastSeenAt   time.Time  `gorm:"not null" json:"last_seen_at"`
+	CheckedOutAt *time.Time `json:"checked_out_at,omitempty"`
+
+	// Relationships
+	Place Place `gorm:"foreignKey:PlaceID;constraint:OnDelete:CASCADE" json:"-"`
+	Dog   Dog   `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"dog,omitempty"`
+}
+
+// BeforeCreate sets the ID before creating the visit
+func (pv *PlaceVisit) BeforeCreate(tx *gorm.DB) error {
+	if pv.ID == uuid.Nil {
+		pv.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the PlaceVisit model
+func (PlaceVisit) TableName() string {
+	return "place_visits"
+}
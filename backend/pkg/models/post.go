@@ -7,16 +7,37 @@ import (
 	"gorm.io/gorm"
 )
 
+// PostVisibility controls who can see a post
+type PostVisibility string
+
+const (
+	PostVisibilityPublic    PostVisibility = "public"    // everyone, unless the owner's profile is private
+	PostVisibilityFollowers PostVisibility = "followers" // the owner and users whose dogs follow the dog
+	PostVisibilityPrivate   PostVisibility = "private"   // the owner only
+)
+
+// MediaType is the kind of a post media item
+type MediaType string
+
+const (
+	MediaTypePhoto MediaType = "photo"
+	MediaTypeVideo MediaType = "video"
+)
+
 // Post represents a dog's social media post
 type Post struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	DogID     uuid.UUID `gorm:"type:uuid;not null;index" json:"dog_id"`
-	Content   string    `gorm:"type:text;not null" json:"content"`
-	ImageURL  string    `gorm:"type:varchar(255)" json:"image_url"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+	ID           uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	DogID        uuid.UUID      `gorm:"type:uuid;not null;index" json:"dog_id"`
+	Content      string         `gorm:"type:text;not null" json:"content"`
+	Visibility   PostVisibility `gorm:"type:varchar(20);not null;default:'public';index" json:"visibility"`
+	LocationName *string        `gorm:"type:varchar(200)" json:"location,omitempty"`
+	PlaceID      *uuid.UUID     `gorm:"type:uuid;index" json:"place_id,omitempty"`
+	CreatedAt    time.Time      `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
 
 	// Relationships
 	Dog       Dog         `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"dog,omitempty"`
+	Media     []PostMedia `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"media"`
+	Place     *Place      `gorm:"foreignKey:PlaceID;constraint:OnDelete:SET NULL" json:"place,omitempty"`
 	Likes     []Like      `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"likes,omitempty"`
 	Comments  []Comment   `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"comments,omitempty"`
 	Hashtags  []Hashtag   `gorm:"many2many:post_hashtags;constraint:OnDelete:CASCADE" json:"hashtags,omitempty"`
@@ -35,12 +56,38 @@ func (Post) TableName() string {
 	return "posts"
 }
 
+// PostMedia is a photo or video attached to a post. Position orders the
+// items within the post, starting at 0.
+type PostMedia struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PostID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_post_media_position" json:"post_id"`
+	Position int       `gorm:"not null;uniqueIndex:idx_post_media_position" json:"position"`
+	Type     MediaType `gorm:"type:varchar(10);not null" json:"type"`
+	URL      string    `gorm:"type:varchar(500);not null" json:"url"`
+	Width    int       `gorm:"not null;default:0" json:"width,omitempty"`
+	Height   int       `gorm:"not null;default:0" json:"height,omitempty"`
+	Blurhash string    `gorm:"type:varchar(100)" json:"blurhash,omitempty"`
+}
+
+// BeforeCreate sets the ID before creating the media item
+func (pm *PostMedia) BeforeCreate(tx *gorm.DB) error {
+	if pm.ID == uuid.Nil {
+		pm.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the PostMedia model
+func (PostMedia) TableName() string {
+	return "post_media"
+}
+
 // Like represents a like on a post
 type Like struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	PostID    uuid.UUID `gorm:"type:uuid;not null;index" json:"post_id"`
 	DogID     uuid.UUID `gorm:"type:uuid;not null;index" json:"dog_id"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
 
 	// Relationships
 	Post Post `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"post,omitempty"`
@@ -60,17 +107,30 @@ func (Like) TableName() string {
 	return "likes"
 }
 
-// Comment represents a comment on a post
+// Comment represents a comment on a post. Replies point to the comment
+// they answer through ParentID; Depth is 0 for comments on the post itself.
+// A deleted comment that still has replies keeps its place in the thread
+// with its content removed.
 type Comment struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	PostID    uuid.UUID `gorm:"type:uuid;not null;index" json:"post_id"`
-	DogID     uuid.UUID `gorm:"type:uuid;not null;index" json:"dog_id"`
-	Content   string    `gorm:"type:text;not null" json:"content"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PostID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"post_id"`
+	DogID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"dog_id"`
+	ParentID   *uuid.UUID `gorm:"type:uuid;index" json:"parent_id,omitempty"`
+	Depth      int        `gorm:"not null;default:0" json:"depth"`
+	Content    string     `gorm:"type:text;not null" json:"content"`
+	ReplyCount int        `gorm:"not null;default:0" json:"reply_count"`
+	LikeCount  int        `gorm:"not null;default:0" json:"like_count"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+	EditedAt   *time.Time `json:"edited_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+
+	// Liked reports whether one of the viewer's dogs liked the comment
+	Liked bool `gorm:"-" json:"liked"`
 
 	// Relationships
-	Post Post `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"post,omitempty"`
-	Dog  Dog  `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"dog,omitempty"`
+	Post   Post     `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"post,omitempty"`
+	Dog    Dog      `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"dog,omitempty"`
+	Parent *Comment `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
 // BeforeCreate sets the ID before creating the comment
@@ -86,10 +146,37 @@ func (Comment) TableName() string {
 	return "comments"
 }
 
-// Hashtag represents a hashtag that can be used in posts
+// CommentLike is a dog's like on a comment
+type CommentLike struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	CommentID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_comment_likes_dog" json:"comment_id"`
+	DogID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_comment_likes_dog;index" json:"dog_id"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// Relationships
+	Comment Comment `gorm:"foreignKey:CommentID;constraint:OnDelete:CASCADE" json:"-"`
+	Dog     Dog     `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// BeforeCreate sets the ID before creating the comment like
+func (cl *CommentLike) BeforeCreate(tx *gorm.DB) error {
+	if cl.ID == uuid.Nil {
+		cl.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the CommentLike model
+func (CommentLike) TableName() string {
+	return "comment_likes"
+}
+
+// Hashtag represents a hashtag that can be used in posts. Tags are stored
+// lowercase without the leading #.
 type Hashtag struct {
-	ID   uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	Tag  string    `gorm:"type:varchar(50);uniqueIndex;not null" json:"tag"`
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Tag       string    `gorm:"type:varchar(50);uniqueIndex;not null" json:"tag"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 
 	// Relationships
 	Posts []Post `gorm:"many2many:post_hashtags;constraint:OnDelete:CASCADE" json:"posts,omitempty"`
@@ -108,10 +195,13 @@ func (Hashtag) TableName() string {
 	return "hashtags"
 }
 
-// PostHashtag represents the many-to-many relationship between posts and hashtags
+// PostHashtag represents the many-to-many relationship between posts and
+// hashtags. CreatedAt is when the post started using the tag, which is
+// what trending ranks by.
 type PostHashtag struct {
 	PostID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"post_id"`
-	HashtagID uuid.UUID `gorm:"type:uuid;primaryKey" json:"hashtag_id"`
+	HashtagID uuid.UUID `gorm:"type:uuid;primaryKey;index:idx_post_hashtags_tag_time,priority:1" json:"hashtag_id"`
+	CreatedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index:idx_post_hashtags_tag_time,priority:2" json:"created_at"`
 }
 
 // TableName returns the table name for the PostHashtag model
@@ -123,6 +213,7 @@ func (PostHashtag) TableName() string {
 type Follower struct {
 	FollowerDogID uuid.UUID `gorm:"type:uuid;primaryKey" json:"follower_dog_id"`
 	FollowedDogID uuid.UUID `gorm:"type:uuid;primaryKey" json:"followed_dog_id"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 
 	// Relationships
 	FollowerDog Dog `gorm:"foreignKey:FollowerDogID;constraint:OnDelete:CASCADE" json:"follower_dog,omitempty"`
@@ -132,4 +223,31 @@ type Follower struct {
 // TableName returns the table name for the Follower model
 func (Follower) TableName() string {
 	return "followers"
-}
\ No newline at end of file
+}
+
+// FollowRequest is a pending request to follow a dog whose owner approves
+// followers. It becomes a Follower when approved and is deleted when denied
+// or cancelled.
+type FollowRequest struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	FollowerDogID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_follow_requests_pair" json:"follower_dog_id"`
+	FollowedDogID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_follow_requests_pair;index" json:"followed_dog_id"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// Relationships
+	FollowerDog Dog `gorm:"foreignKey:FollowerDogID;constraint:OnDelete:CASCADE" json:"follower_dog,omitempty"`
+	FollowedDog Dog `gorm:"foreignKey:FollowedDogID;constraint:OnDelete:CASCADE" json:"followed_dog,omitempty"`
+}
+
+// BeforeCreate sets the ID before creating the follow request
+func (fr *FollowRequest) BeforeCreate(tx *gorm.DB) error {
+	if fr.ID == uuid.Nil {
+		fr.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the FollowRequest model
+func (FollowRequest) TableName() string {
+	return "follow_requests"
+}
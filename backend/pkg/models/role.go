@@ -0,0 +1,61 @@
+package models
+
+// Role represents a user's access level
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission represents a single privileged action, named resource:action
+type Permission string
+
+const (
+	PermissionReportsReview     Permission = "reports:review"
+	PermissionUsersSuspend      Permission = "users:suspend"
+	PermissionNotificationsSend Permission = "notifications:send"
+	PermissionRolesManage       Permission = "roles:manage"
+	PermissionPlacesManage      Permission = "places:manage"
+)
+
+// rolePermissions lists what each role may do. Regular users have no
+// privileged permissions.
+var rolePermissions = map[Role][]Permission{
+	RoleModerator: {
+		PermissionReportsReview,
+		PermissionUsersSuspend,
+	},
+	RoleAdmin: {
+		PermissionReportsReview,
+		PermissionUsersSuspend,
+		PermissionNotificationsSend,
+		PermissionRolesManage,
+		PermissionPlacesManage,
+	},
+}
+
+// IsValid checks if the role is one of the known roles
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Permissions returns the permissions granted to the role
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// HasPermission checks if the role grants the permission
+func (r Role) HasPermission(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
 	"gorm.io/gorm"
 )
 
@@ -15,6 +17,15 @@ const (
 	VisibilityPrivate Visibility = "private"
 )
 
+// LocationSharing controls who can see a user's dogs in nearby results
+type LocationSharing string
+
+const (
+	LocationSharingOff      LocationSharing = "off"
+	LocationSharingFriends  LocationSharing = "friends"
+	LocationSharingEveryone LocationSharing = "everyone"
+)
+
 // User represents a user in the system
 type User struct {
 	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -22,8 +33,16 @@ type User struct {
 	Email        string     `gorm:"type:varchar(100);uniqueIndex;not null" json:"email" validate:"required,email"`
 	PasswordHash string     `gorm:"type:varchar(255);not null" json:"-"`
 	Visibility   Visibility `gorm:"type:varchar(20);default:'public'" json:"visibility"`
+	Role         Role       `gorm:"type:varchar(20);not null;default:'user'" json:"role"`
 	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 
+	// Location privacy. Dogs are never shown to others within
+	// HomeRadiusMeters of HomeLocation. Only the user's own privacy
+	// settings expose these.
+	LocationSharing  LocationSharing `gorm:"type:varchar(20);not null;default:'everyone'" json:"-"`
+	HomeLocation     *GeoPoint       `gorm:"type:geography(POINT)" json:"-"`
+	HomeRadiusMeters int             `gorm:"not null;default:0" json:"-"`
+
 	// Relationships
 	Dogs              []Dog              `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"dogs,omitempty"`
 	UserSubscriptions []UserSubscription `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"subscriptions,omitempty"`
@@ -31,11 +50,14 @@ type User struct {
 	Notifications     []Notification     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"notifications,omitempty"`
 }
 
-// BeforeCreate sets the ID before creating the user
+// BeforeCreate sets the ID and default role before creating the user
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == uuid.Nil {
 		u.ID = uuid.New()
 	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
 	return nil
 }
 
@@ -54,6 +76,33 @@ func (u *User) IsPrivate() bool {
 	return u.Visibility == VisibilityPrivate
 }
 
+// SharesLocationWith reports whether the user's dogs may appear in nearby
+// results for a viewer, given whether the viewer is a friend. Private
+// profiles share with friends at most.
+func (u *User) SharesLocationWith(friend bool) bool {
+	switch u.LocationSharing {
+	case LocationSharingEveryone:
+		return u.IsPublic() || friend
+	case LocationSharingFriends:
+		return friend
+	default:
+		return false
+	}
+}
+
+// IsInHomeZone reports whether the point is inside the user's home zone
+func (u *User) IsInHomeZone(point GeoPoint) bool {
+	if u.HomeLocation == nil || u.HomeRadiusMeters <= 0 {
+		return false
+	}
+	return geo.Distance(orb.Point(*u.HomeLocation), orb.Point(point)) <= float64(u.HomeRadiusMeters)
+}
+
+// HasPermission checks if the user's role grants the permission
+func (u *User) HasPermission(permission Permission) bool {
+	return u.Role.HasPermission(permission)
+}
+
 // ToPublicUser returns a user object without sensitive information
 func (u *User) ToPublicUser() *User {
 	return &User{
@@ -64,23 +113,73 @@ func (u *User) ToPublicUser() *User {
 	}
 }
 
-// RefreshToken represents a refresh token for JWT authentication
+// Session represents one signed-in device. Its ID is the FamilyID shared by
+// every refresh token rotated from the login that started it.
+type Session struct {
+	ID                   uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID               uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	DeviceName           string     `gorm:"type:varchar(100)" json:"device_name"`
+	IPAddress            string     `gorm:"type:varchar(45)" json:"ip_address"`
+	UserAgent            string     `gorm:"type:varchar(500)" json:"user_agent"`
+	AccessTokenID        string     `gorm:"type:varchar(36)" json:"-"`
+	AccessTokenExpiresAt *time.Time `json:"-"`
+	LastSeenAt           time.Time  `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt            time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt            *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt            time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// Current marks the session the request was made from
+	Current bool `gorm:"-" json:"current"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+}
+
+// BeforeCreate sets the ID before creating the session
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the Session model
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// IsActive checks if the session has been neither revoked nor expired
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// RefreshToken represents a refresh token for JWT authentication.
+// Token holds the SHA-256 hash of the opaque token handed to the client.
+// Every token issued by rotating another one shares its FamilyID, so a
+// replayed token can revoke the whole chain. FamilyID is also the ID of the
+// Session the token belongs to.
 type RefreshToken struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
-	Token     string    `gorm:"type:varchar(255);not null;index" json:"token"`
-	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Token        string     `gorm:"type:varchar(255);not null;uniqueIndex" json:"-"`
+	FamilyID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"family_id"`
+	ReplacedByID *uuid.UUID `gorm:"type:uuid" json:"replaced_by_id,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 
 	// Relationships
 	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
 }
 
-// BeforeCreate sets the ID before creating the refresh token
+// BeforeCreate sets the ID and family before creating the refresh token
 func (rt *RefreshToken) BeforeCreate(tx *gorm.DB) error {
 	if rt.ID == uuid.Nil {
 		rt.ID = uuid.New()
 	}
+	if rt.FamilyID == uuid.Nil {
+		rt.FamilyID = rt.ID
+	}
 	return nil
 }
 
@@ -92,4 +191,41 @@ func (RefreshToken) TableName() string {
 // IsExpired checks if the refresh token has expired
 func (rt *RefreshToken) IsExpired() bool {
 	return time.Now().After(rt.ExpiresAt)
-}
\ No newline at end of file
+}
+
+// IsRevoked checks if the refresh token has been rotated or revoked
+func (rt *RefreshToken) IsRevoked() bool {
+	return rt.RevokedAt != nil
+}
+
+// PasswordResetToken represents a single-use password reset token.
+// Only the SHA-256 hash of the emailed token is stored.
+type PasswordResetToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Token     string     `gorm:"type:varchar(255);not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+}
+
+// BeforeCreate sets the ID before creating the password reset token
+func (prt *PasswordResetToken) BeforeCreate(tx *gorm.DB) error {
+	if prt.ID == uuid.Nil {
+		prt.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the PasswordResetToken model
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// IsUsable checks if the reset token is unused and not expired
+func (prt *PasswordResetToken) IsUsable() bool {
+	return prt.UsedAt == nil && time.Now().Before(prt.ExpiresAt)
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Walk is a recorded walk of a dog. Stats and the simplified route are
+// recomputed from the walk's points whenever points are added. A dog has at
+// most one walk in progress.
+type Walk struct {
+	ID               uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	DogID            uuid.UUID      `gorm:"type:uuid;not null;index;uniqueIndex:idx_walks_active_dog,where:ended_at IS NULL" json:"dog_id"`
+	StartedAt        time.Time      `gorm:"not null" json:"started_at"`
+	EndedAt          *time.Time     `json:"ended_at,omitempty"`
+	DistanceMeters   float64        `gorm:"not null;default:0" json:"distance_meters"`
+	DurationSeconds  int            `gorm:"not null;default:0" json:"duration_seconds"`
+	PaceSecondsPerKm *float64       `json:"pace_seconds_per_km,omitempty"`
+	PointCount       int            `gorm:"not null;default:0" json:"point_count"`
+	Route            *GeoLineString `gorm:"type:geography(LINESTRING)" json:"route,omitempty"`
+	CreatedAt        time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	// Relationships
+	Dog        Dog         `gorm:"foreignKey:DogID;constraint:OnDelete:CASCADE" json:"-"`
+	Points     []WalkPoint `gorm:"foreignKey:WalkID;constraint:OnDelete:CASCADE" json:"-"`
+	Encounters []Encounter `gorm:"many2many:walk_encounters;constraint:OnDelete:CASCADE" json:"encounters,omitempty"`
+}
+
+// BeforeCreate sets the ID before creating the walk
+func (w *Walk) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the Walk model
+func (Walk) TableName() string {
+	return "walks"
+}
+
+// IsActive returns true while the walk is in progress
+func (w *Walk) IsActive() bool {
+	return w.EndedAt == nil
+}
+
+// WalkPoint is a GPS fix recorded during a walk. Each walk has at most one
+// point per timestamp, so resent batches are ignored.
+type WalkPoint struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"-"`
+	WalkID         uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_walk_points_walk_time,priority:1" json:"-"`
+	Location       GeoPoint  `gorm:"type:geography(POINT);not null" json:"location"`
+	AccuracyMeters float64   `gorm:"not null;default:0" json:"accuracy_meters"`
+	RecordedAt     time.Time `gorm:"not null;uniqueIndex:idx_walk_points_walk_time,priority:2" json:"recorded_at"`
+}
+
+// BeforeCreate sets the ID before creating the point
+func (wp *WalkPoint) BeforeCreate(tx *gorm.DB) error {
+	if wp.ID == uuid.Nil {
+		wp.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName returns the table name for the WalkPoint model
+func (WalkPoint) TableName() string {
+	return "walk_points"
+}
@@ -2,37 +2,53 @@ package services
 
 import (
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
 )
 
+// refreshTokenBytes is the entropy of opaque refresh tokens
+const refreshTokenBytes = 32
+
 type AuthService struct {
-	db        *gorm.DB
-	jwtSecret string
+	db  *gorm.DB
+	cfg config.JWTConfig
 }
 
-func NewAuthService(db *gorm.DB, jwtSecret string) *AuthService {
+func NewAuthService(db *gorm.DB, cfg config.JWTConfig) *AuthService {
 	return &AuthService{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:  db,
+		cfg: cfg,
 	}
 }
 
+// ClientInfo describes the device a session is used from.
+// IPAddress and UserAgent are filled in by the handler, not the client.
+type ClientInfo struct {
+	DeviceName string `json:"device_name" validate:"omitempty,max=100"`
+	IPAddress  string `json:"-"`
+	UserAgent  string `json:"-"`
+}
+
 // RegisterRequest represents registration request
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
+	ClientInfo
 }
 
 // LoginRequest represents login request
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
+	ClientInfo
 }
 
 // AuthResponse represents authentication response
@@ -74,14 +90,9 @@ func (s *AuthService) Register(req RegisterRequest) (*AuthResponse, error) {
 	}
 
 	// Generate tokens
-	accessToken, err := s.generateAccessToken(user.ID, user.Email)
-	if err != nil {
-		return nil, errors.New("failed to generate access token")
-	}
-
-	refreshToken, err := s.generateRefreshToken(user.ID)
+	accessToken, refreshToken, err := s.startSession(&user, req.ClientInfo)
 	if err != nil {
-		return nil, errors.New("failed to generate refresh token")
+		return nil, err
 	}
 
 	// Clear password hash from response
@@ -111,14 +122,9 @@ func (s *AuthService) Login(req LoginRequest) (*AuthResponse, error) {
 	}
 
 	// Generate tokens
-	accessToken, err := s.generateAccessToken(user.ID, user.Email)
+	accessToken, refreshToken, err := s.startSession(&user, req.ClientInfo)
 	if err != nil {
-		return nil, errors.New("failed to generate access token")
-	}
-
-	refreshToken, err := s.generateRefreshToken(user.ID)
-	if err != nil {
-		return nil, errors.New("failed to generate refresh token")
+		return nil, err
 	}
 
 	// Load user dogs
@@ -136,11 +142,105 @@ func (s *AuthService) Login(req LoginRequest) (*AuthResponse, error) {
 	}, nil
 }
 
+// RefreshToken exchanges a refresh token for a new token pair.
+// Refresh tokens are single use: the presented token is revoked and replaced
+// by a new one in the same family. Presenting an already rotated token is
+// treated as theft and revokes every token in its family.
+func (s *AuthService) RefreshToken(refreshToken string, client ClientInfo) (*AuthResponse, error) {
+	var stored models.RefreshToken
+	if err := s.db.Where("token = ?", utils.HashToken(refreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrInvalidToken
+		}
+		return nil, utils.WrapError(err, "failed to find refresh token")
+	}
+
+	if stored.IsRevoked() {
+		if stored.ReplacedByID != nil {
+			// Reuse of a rotated token: kill the whole family
+			if err := s.revokeFamily(stored.FamilyID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, utils.ErrInvalidToken
+	}
+
+	if stored.IsExpired() {
+		return nil, utils.ErrTokenExpired
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ?", stored.UserID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrInvalidToken
+		}
+		return nil, utils.WrapError(err, "failed to find user")
+	}
+
+	var newRefreshToken, accessToken string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		replacementID := uuid.New()
+
+		// Conditional update so two concurrent refreshes cannot both win
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Updates(map[string]interface{}{
+				"revoked_at":     time.Now(),
+				"replaced_by_id": replacementID,
+			})
+		if result.Error != nil {
+			return utils.WrapError(result.Error, "failed to rotate refresh token")
+		}
+		if result.RowsAffected == 0 {
+			return utils.ErrInvalidToken
+		}
+
+		token, err := s.createRefreshToken(tx, replacementID, user.ID, stored.FamilyID)
+		if err != nil {
+			return err
+		}
+		newRefreshToken = token
+
+		access, claims, err := s.generateAccessToken(&user, stored.FamilyID)
+		if err != nil {
+			return errors.New("failed to generate access token")
+		}
+		accessToken = access
+
+		return s.touchSession(tx, stored.FamilyID, claims, client)
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidToken) {
+			// Lost the race against another refresh with the same token
+			if err := s.revokeFamily(stored.FamilyID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	user.PasswordHash = ""
+
+	return &AuthResponse{
+		User:         &user,
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+	}, nil
+}
+
 // ValidateToken validates and returns user ID from token
 func (s *AuthService) ValidateToken(tokenString string) (uuid.UUID, error) {
-	// This is a simplified implementation
-	// In a real implementation, you would use JWT parsing
-	return uuid.New(), nil
+	claims, err := utils.ValidateToken(tokenString, s.cfg)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return uuid.Nil, utils.ErrInvalidToken
+	}
+
+	return userID, nil
 }
 
 // GetUserByID retrieves user by ID
@@ -185,15 +285,116 @@ func (s *AuthService) ChangePassword(userID uuid.UUID, oldPassword, newPassword
 	return nil
 }
 
-// Helper functions for token generation (simplified)
-func (s *AuthService) generateAccessToken(userID uuid.UUID, email string) (string, error) {
-	// This is a simplified implementation
-	// In a real implementation, you would use JWT
-	return "access_token_" + userID.String(), nil
+// Helper functions for token generation
+func (s *AuthService) generateAccessToken(user *models.User, sessionID uuid.UUID) (string, *utils.Claims, error) {
+	return utils.GenerateToken(user.ID.String(), user.Email, string(user.Role), sessionID.String(), s.cfg)
 }
 
-func (s *AuthService) generateRefreshToken(userID uuid.UUID) (string, error) {
-	// This is a simplified implementation
-	// In a real implementation, you would use JWT
-	return "refresh_token_" + userID.String(), nil
-}
\ No newline at end of file
+// startSession records a new session for the user and issues its first token pair.
+// The session ID doubles as the family ID of its refresh tokens.
+func (s *AuthService) startSession(user *models.User, client ClientInfo) (string, string, error) {
+	sessionID := uuid.New()
+
+	accessToken, claims, err := s.generateAccessToken(user, sessionID)
+	if err != nil {
+		return "", "", errors.New("failed to generate access token")
+	}
+
+	var refreshToken string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		accessExpiresAt := claims.ExpiresAt.Time
+
+		session := models.Session{
+			ID:                   sessionID,
+			UserID:               user.ID,
+			DeviceName:           client.DeviceName,
+			IPAddress:            client.IPAddress,
+			UserAgent:            client.UserAgent,
+			AccessTokenID:        claims.ID,
+			AccessTokenExpiresAt: &accessExpiresAt,
+			LastSeenAt:           now,
+			ExpiresAt:            now.Add(s.cfg.RefreshExpireHours),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return utils.WrapError(err, "failed to create session")
+		}
+
+		token, err := s.createRefreshToken(tx, uuid.New(), user.ID, sessionID)
+		if err != nil {
+			return err
+		}
+		refreshToken = token
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// touchSession records activity on a session after its tokens were rotated
+func (s *AuthService) touchSession(tx *gorm.DB, sessionID uuid.UUID, claims *utils.Claims, client ClientInfo) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"access_token_id":         claims.ID,
+		"access_token_expires_at": claims.ExpiresAt.Time,
+		"last_seen_at":            now,
+		"expires_at":              now.Add(s.cfg.RefreshExpireHours),
+	}
+	if client.DeviceName != "" {
+		updates["device_name"] = client.DeviceName
+	}
+	if client.IPAddress != "" {
+		updates["ip_address"] = client.IPAddress
+	}
+	if client.UserAgent != "" {
+		updates["user_agent"] = client.UserAgent
+	}
+
+	if err := tx.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(updates).Error; err != nil {
+		return utils.WrapError(err, "failed to update session")
+	}
+	return nil
+}
+
+func (s *AuthService) createRefreshToken(tx *gorm.DB, id uuid.UUID, userID uuid.UUID, familyID uuid.UUID) (string, error) {
+	token, err := utils.GenerateSecureToken(refreshTokenBytes)
+	if err != nil {
+		return "", utils.WrapError(err, "failed to generate refresh token")
+	}
+
+	record := models.RefreshToken{
+		ID:        id,
+		UserID:    userID,
+		Token:     utils.HashToken(token),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(s.cfg.RefreshExpireHours),
+	}
+
+	if err := tx.Create(&record).Error; err != nil {
+		return "", utils.WrapError(err, "failed to store refresh token")
+	}
+
+	return token, nil
+}
+
+// revokeFamily revokes every still-active token descended from the same login
+// together with the session they belong to
+func (s *AuthService) revokeFamily(familyID uuid.UUID) error {
+	now := time.Now()
+	if err := s.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return utils.WrapError(err, "failed to revoke refresh token family")
+	}
+	if err := s.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return utils.WrapError(err, "failed to revoke session")
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/doggyclub/backend/pkg/models"
 	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
@@ -16,7 +18,7 @@ func TestAuthService_Register(t *testing.T) {
 	ctx := testutils.SetupTestContext(t)
 	defer ctx.TeardownTestContext(t)
 
-	authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 
 	tests := []struct {
 		name        string
@@ -105,7 +107,7 @@ func TestAuthService_Login(t *testing.T) {
 	ctx := testutils.SetupTestContext(t)
 	defer ctx.TeardownTestContext(t)
 
-	authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 
 	// Create a test user first
 	password := "password123"
@@ -184,8 +186,61 @@ func TestAuthService_Login(t *testing.T) {
 	}
 }
 
-// Note: RefreshToken functionality is handled at the handler level
-// This test is removed since the service doesn't expose this method
+func TestAuthService_RefreshToken(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
+
+	registered, err := authService.Register(RegisterRequest{
+		Email:    "refresh@example.com",
+		Password: "password123",
+		Username: "RefreshUser",
+	})
+	require.NoError(t, err)
+
+	// Issued access tokens must pass the same validation as the middleware
+	userID, err := authService.ValidateToken(registered.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, registered.User.ID, userID)
+
+	// Only the hash of the refresh token is persisted
+	var stored models.RefreshToken
+	require.NoError(t, ctx.DB.Where("user_id = ?", userID).First(&stored).Error)
+	assert.NotEqual(t, registered.RefreshToken, stored.Token)
+
+	rotated, err := authService.RefreshToken(registered.RefreshToken, ClientInfo{})
+	require.NoError(t, err)
+	assert.NotEqual(t, registered.RefreshToken, rotated.RefreshToken)
+	assert.NotEqual(t, registered.AccessToken, rotated.AccessToken)
+
+	// Rotated token stays in the same family as the original
+	var rotatedRecord models.RefreshToken
+	require.NoError(t, ctx.DB.Where("token = ?", utils.HashToken(rotated.RefreshToken)).First(&rotatedRecord).Error)
+	assert.Equal(t, stored.FamilyID, rotatedRecord.FamilyID)
+
+	// Replaying the original token fails and revokes the whole family
+	_, err = authService.RefreshToken(registered.RefreshToken, ClientInfo{})
+	assert.ErrorIs(t, err, utils.ErrInvalidToken)
+
+	_, err = authService.RefreshToken(rotated.RefreshToken, ClientInfo{})
+	assert.ErrorIs(t, err, utils.ErrInvalidToken)
+
+	var active int64
+	ctx.DB.Model(&models.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", stored.FamilyID).Count(&active)
+	assert.Equal(t, int64(0), active)
+}
+
+func TestAuthService_RefreshTokenInvalid(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
+
+	response, err := authService.RefreshToken("not-a-real-token", ClientInfo{})
+	assert.ErrorIs(t, err, utils.ErrInvalidToken)
+	assert.Nil(t, response)
+}
 
 // Note: Logout functionality is handled at the handler level
 // This test is removed since the service doesn't expose this method
@@ -194,7 +249,7 @@ func TestAuthService_ValidateToken(t *testing.T) {
 	ctx := testutils.SetupTestContext(t)
 	defer ctx.TeardownTestContext(t)
 
-	authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 	user := testutils.CreateTestUser(t, ctx.DB)
 
 	// Generate a valid token
@@ -251,7 +306,7 @@ func TestAuthService_DuplicateEmailRegistration(t *testing.T) {
 	ctx := testutils.SetupTestContext(t)
 	defer ctx.TeardownTestContext(t)
 
-	authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 
 	// Create first user
 	req1 := RegisterRequest{
@@ -287,12 +342,12 @@ func TestAuthService_TokenExpiration(t *testing.T) {
 
 	// Set short expiration for testing
 	ctx.Config.JWT.ExpireHours = 1 * time.Millisecond
-	authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 
 	user := testutils.CreateTestUser(t, ctx.DB)
 
 	// Generate token
-	token, err := authService.generateAccessToken(user.ID, user.Email)
+	token, _, err := authService.generateAccessToken(user, uuid.New())
 	require.NoError(t, err)
 
 	// Wait for token to expire
@@ -307,7 +362,7 @@ func TestAuthService_TokenExpiration(t *testing.T) {
 // Benchmark tests
 func BenchmarkAuthService_Register(b *testing.B) {
 	testutils.RunBenchmarkWithContext(b, func(b *testing.B, ctx *testutils.TestContext) {
-		authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+		authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 
 		for i := 0; i < b.N; i++ {
 			req := RegisterRequest{
@@ -326,7 +381,7 @@ func BenchmarkAuthService_Register(b *testing.B) {
 
 func BenchmarkAuthService_Login(b *testing.B) {
 	testutils.RunBenchmarkWithContext(b, func(b *testing.B, ctx *testutils.TestContext) {
-		authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+		authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 
 		// Create test user
 		user := testutils.CreateTestUser(&testing.T{}, ctx.DB)
@@ -351,7 +406,7 @@ func BenchmarkAuthService_Login(b *testing.B) {
 
 func BenchmarkAuthService_ValidateToken(b *testing.B) {
 	testutils.RunBenchmarkWithContext(b, func(b *testing.B, ctx *testutils.TestContext) {
-		authService := NewAuthService(ctx.DB, ctx.Config.JWT.Secret)
+		authService := NewAuthService(ctx.DB, ctx.Config.JWT)
 		user := testutils.CreateTestUser(&testing.T{}, ctx.DB)
 
 		token, err := testutils.GenerateTestJWT(user.ID.String(), ctx.Config.JWT.Secret)
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// BeaconIDKey maps an issued ephemeral ID to "<dog ID>:<epoch>"
+const BeaconIDKey = "beacon:eid:%s"
+
+const (
+	// beaconIDBytes is the length of an ephemeral ID, sized for a BLE advertisement
+	beaconIDBytes = 16
+
+	// maxBeaconBatchSize caps how far ahead a client can fetch identifiers
+	maxBeaconBatchSize = 96
+
+	defaultBeaconEpoch     = 15 * time.Minute
+	defaultBeaconBatchSize = 8
+)
+
+// ErrInvalidBeacon is returned for ephemeral IDs that were never issued or
+// are outside their validity window
+var ErrInvalidBeacon = utils.NewAPIError("INVALID_BEACON", "Unknown or expired beacon identifier", nil)
+
+// BeaconID is an ephemeral identifier a dog's device broadcasts during one epoch
+type BeaconID struct {
+	ID         string    `json:"id"`
+	ValidFrom  time.Time `json:"valid_from"`
+	ValidUntil time.Time `json:"valid_until"`
+}
+
+// BeaconService issues rotating anonymous Bluetooth identifiers and resolves
+// observed identifiers back to dogs. Identifiers are HMAC-derived from a
+// per-dog key and the epoch number, so they cannot be linked to each other
+// or to the dog without the server secret.
+type BeaconService struct {
+	redis *redis.Client
+	cfg   config.BeaconConfig
+	ctx   context.Context
+}
+
+func NewBeaconService(redis *redis.Client, cfg config.Config) *BeaconService {
+	beaconCfg := cfg.Beacon
+	if beaconCfg.Epoch <= 0 {
+		beaconCfg.Epoch = defaultBeaconEpoch
+	}
+	if beaconCfg.BatchSize <= 0 {
+		beaconCfg.BatchSize = defaultBeaconBatchSize
+	}
+
+	return &BeaconService{
+		redis: redis,
+		cfg:   beaconCfg,
+		ctx:   context.Background(),
+	}
+}
+
+// IssueBatch returns the dog's identifiers for the current epoch and the
+// following ones, and registers them for resolution until they expire
+func (s *BeaconService) IssueBatch(dogID uuid.UUID, count int) ([]BeaconID, error) {
+	if count <= 0 || count > maxBeaconBatchSize {
+		count = s.cfg.BatchSize
+	}
+
+	current := s.epochAt(time.Now())
+	pipe := s.redis.Pipeline()
+	batch := make([]BeaconID, 0, count)
+	for epoch := current; epoch < current+int64(count); epoch++ {
+		beacon := BeaconID{
+			ID:         s.derive(dogID, epoch),
+			ValidFrom:  s.epochStart(epoch),
+			ValidUntil: s.epochStart(epoch + 1),
+		}
+		batch = append(batch, beacon)
+
+		// Kept while observations of it are still accepted
+		ttl := time.Until(beacon.ValidUntil) + time.Duration(s.cfg.Tolerance)*s.cfg.Epoch
+		pipe.Set(s.ctx, fmt.Sprintf(BeaconIDKey, beacon.ID), fmt.Sprintf("%s:%d", dogID, epoch), ttl)
+	}
+
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		return nil, utils.WrapError(err, "failed to register beacon identifiers")
+	}
+	return batch, nil
+}
+
+// Resolve returns the dog that broadcast the identifier. Identifiers are
+// accepted during their epoch and for Tolerance epochs after it.
+func (s *BeaconService) Resolve(beaconID string, observedAt time.Time) (uuid.UUID, error) {
+	beaconID = strings.ToLower(strings.TrimSpace(beaconID))
+	if len(beaconID) != hex.EncodedLen(beaconIDBytes) {
+		return uuid.Nil, ErrInvalidBeacon
+	}
+
+	value, err := s.redis.Get(s.ctx, fmt.Sprintf(BeaconIDKey, beaconID)).Result()
+	if err == redis.Nil {
+		return uuid.Nil, ErrInvalidBeacon
+	}
+	if err != nil {
+		return uuid.Nil, utils.WrapError(err, "failed to resolve beacon identifier")
+	}
+
+	dogPart, epochPart, found := strings.Cut(value, ":")
+	dogID, dogErr := uuid.Parse(dogPart)
+	epoch, epochErr := strconv.ParseInt(epochPart, 10, 64)
+	if !found || dogErr != nil || epochErr != nil {
+		return uuid.Nil, ErrInvalidBeacon
+	}
+
+	current := s.epochAt(observedAt)
+	if epoch > current || epoch < current-int64(s.cfg.Tolerance) {
+		return uuid.Nil, ErrInvalidBeacon
+	}
+	return dogID, nil
+}
+
+// derive computes the identifier as HMAC(HMAC(secret, dog), epoch)
+func (s *BeaconService) derive(dogID uuid.UUID, epoch int64) string {
+	keyMAC := hmac.New(sha256.New, []byte(s.cfg.Secret))
+	keyMAC.Write([]byte("beacon:" + dogID.String()))
+	dogKey := keyMAC.Sum(nil)
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(epoch))
+	mac := hmac.New(sha256.New, dogKey)
+	mac.Write(counter[:])
+	return hex.EncodeToString(mac.Sum(nil)[:beaconIDBytes])
+}
+
+func (s *BeaconService) epochAt(t time.Time) int64 {
+	return t.Unix() / int64(s.cfg.Epoch.Seconds())
+}
+
+func (s *BeaconService) epochStart(epoch int64) time.Time {
+	return time.Unix(epoch*int64(s.cfg.Epoch.Seconds()), 0).UTC()
+}
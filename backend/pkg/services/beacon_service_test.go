@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeaconService_IssueAndResolve(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	cfg := ctx.Config
+	cfg.Beacon = config.BeaconConfig{
+		Secret:    "beacon-secret",
+		Epoch:     15 * time.Minute,
+		BatchSize: 4,
+		Tolerance: 1,
+	}
+	beaconService := NewBeaconService(ctx.Redis, cfg)
+	dogID := uuid.New()
+
+	batch, err := beaconService.IssueBatch(dogID, 0)
+	require.NoError(t, err)
+	require.Len(t, batch, 4)
+
+	seen := map[string]bool{}
+	for i, beacon := range batch {
+		assert.Len(t, beacon.ID, 32)
+		assert.False(t, seen[beacon.ID], "identifiers must rotate")
+		seen[beacon.ID] = true
+		if i > 0 {
+			assert.Equal(t, batch[i-1].ValidUntil, beacon.ValidFrom)
+		}
+	}
+
+	// Identifiers are stable for an epoch so refetching does not change them
+	again, err := beaconService.IssueBatch(dogID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, batch[0].ID, again[0].ID)
+
+	resolved, err := beaconService.Resolve(batch[0].ID, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, dogID, resolved)
+
+	// Still accepted within the tolerance after its epoch
+	_, err = beaconService.Resolve(batch[0].ID, batch[0].ValidUntil.Add(time.Minute))
+	assert.NoError(t, err)
+
+	// Rejected once the tolerance has passed
+	_, err = beaconService.Resolve(batch[0].ID, batch[0].ValidUntil.Add(16*time.Minute))
+	assert.ErrorIs(t, err, ErrInvalidBeacon)
+
+	// Rejected before its epoch starts
+	_, err = beaconService.Resolve(batch[2].ID, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidBeacon)
+
+	_, err = beaconService.Resolve("00000000000000000000000000000000", time.Now())
+	assert.ErrorIs(t, err, ErrInvalidBeacon)
+}
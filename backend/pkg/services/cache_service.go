@@ -30,7 +30,6 @@ const (
 	UserProfileKey        = "user:profile:%s"
 	DogProfileKey         = "dog:profile:%s"
 	PostKey               = "post:%s"
-	TimelineKey           = "timeline:user:%s:page:%d"
 	EncounterHistoryKey   = "encounters:user:%s:page:%d"
 	GiftCatalogKey        = "gifts:catalog"
 	GiftRankingsKey       = "gifts:rankings:%s"
@@ -40,6 +39,7 @@ const (
 	SubscriptionPlansKey  = "subscription:plans"
 	UserStatsKey          = "user:stats:%s"
 	PopularPostsKey       = "posts:popular:%s" // period: daily, weekly, monthly
+	TrendingHashtagsKey   = "hashtags:trending"
 )
 
 // Cache durations
@@ -160,22 +160,6 @@ func (s *CacheService) InvalidatePost(postID string) error {
 	return s.Delete(key)
 }
 
-// Timeline caching
-func (s *CacheService) CacheTimeline(userID string, page int, timeline interface{}) error {
-	key := fmt.Sprintf(TimelineKey, userID, page)
-	return s.Set(key, timeline, ShortCacheDuration)
-}
-
-func (s *CacheService) GetTimeline(userID string, page int, dest interface{}) error {
-	key := fmt.Sprintf(TimelineKey, userID, page)
-	return s.Get(key, dest)
-}
-
-func (s *CacheService) InvalidateUserTimeline(userID string) error {
-	pattern := fmt.Sprintf("timeline:user:%s:page:*", userID)
-	return s.DeletePattern(pattern)
-}
-
 // Gift catalog caching
 func (s *CacheService) CacheGiftCatalog(catalog interface{}) error {
 	return s.Set(GiftCatalogKey, catalog, LongCacheDuration)
@@ -305,6 +289,16 @@ func (s *CacheService) InvalidatePopularPosts(period string) error {
 	return s.Delete(key)
 }
 
+// Trending hashtags caching. The list is replaced on every refresh, so it
+// is kept for as long as the caller expects a refresh to take.
+func (s *CacheService) CacheTrendingHashtags(hashtags interface{}, duration time.Duration) error {
+	return s.Set(TrendingHashtagsKey, hashtags, duration)
+}
+
+func (s *CacheService) GetTrendingHashtags(dest interface{}) error {
+	return s.Get(TrendingHashtagsKey, dest)
+}
+
 // Rate limiting using Redis
 func (s *CacheService) CheckRateLimit(key string, limit int, window time.Duration) (bool, error) {
 	pipe := s.redis.Pipeline()
@@ -2,39 +2,49 @@ package services
 
 import (
 	"errors"
+	"log"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 
+	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
 )
 
 type DogService struct {
-	db *gorm.DB
+	db       *gorm.DB
+	presence *PresenceService
 }
 
-func NewDogService(db *gorm.DB) *DogService {
+func NewDogService(db *gorm.DB, redis *redis.Client, cfg config.Config) *DogService {
 	return &DogService{
-		db: db,
+		db:       db,
+		presence: NewPresenceService(db, redis, cfg),
 	}
 }
 
+var errInvalidDogSize = utils.NewValidationError([]utils.ValidationError{{Field: "size", Message: "size must be one of small, medium, large, giant"}})
+
 // CreateDogRequest represents dog creation request
 type CreateDogRequest struct {
-	Name     string `json:"name" validate:"required,min=1,max=50"`
-	Breed    string `json:"breed" validate:"required,min=1,max=50"`
-	Age      int    `json:"age" validate:"required,min=0,max=30"`
-	PhotoURL string `json:"photo_url"`
-	Bio      string `json:"bio" validate:"max=500"`
+	Name     string         `json:"name" validate:"required,min=1,max=50"`
+	Breed    string         `json:"breed" validate:"required,min=1,max=50"`
+	Size     models.DogSize `json:"size" validate:"omitempty,oneof=small medium large giant"`
+	Age      int            `json:"age" validate:"required,min=0,max=30"`
+	PhotoURL string         `json:"photo_url"`
+	Bio      string         `json:"bio" validate:"max=500"`
 }
 
 // UpdateDogRequest represents dog update request
 type UpdateDogRequest struct {
-	Name     *string `json:"name,omitempty" validate:"omitempty,min=1,max=50"`
-	Breed    *string `json:"breed,omitempty" validate:"omitempty,min=1,max=50"`
-	Age      *int    `json:"age,omitempty" validate:"omitempty,min=0,max=30"`
-	PhotoURL *string `json:"photo_url,omitempty"`
-	Bio      *string `json:"bio,omitempty" validate:"omitempty,max=500"`
+	Name     *string         `json:"name,omitempty" validate:"omitempty,min=1,max=50"`
+	Breed    *string         `json:"breed,omitempty" validate:"omitempty,min=1,max=50"`
+	Size     *models.DogSize `json:"size,omitempty" validate:"omitempty,oneof=small medium large giant"`
+	Age      *int            `json:"age,omitempty" validate:"omitempty,min=0,max=30"`
+	PhotoURL *string         `json:"photo_url,omitempty"`
+	Bio      *string         `json:"bio,omitempty" validate:"omitempty,max=500"`
 }
 
 // CreateDog creates a new dog profile
@@ -47,12 +57,16 @@ func (s *DogService) CreateDog(userID uuid.UUID, req CreateDogRequest) (*models.
 	if userCount == 0 {
 		return nil, errors.New("user not found")
 	}
+	if req.Size != "" && !req.Size.IsValid() {
+		return nil, errInvalidDogSize
+	}
 
 	// Create dog
 	dog := models.Dog{
 		UserID:   userID,
 		Name:     req.Name,
 		Breed:    req.Breed,
+		Size:     req.Size,
 		Age:      req.Age,
 		PhotoURL: req.PhotoURL,
 		Bio:      req.Bio,
@@ -105,6 +119,10 @@ func (s *DogService) UpdateDog(dogID uuid.UUID, userID uuid.UUID, req UpdateDogR
 		return nil, errors.New("failed to find dog")
 	}
 
+	if req.Size != nil && *req.Size != "" && !req.Size.IsValid() {
+		return nil, errInvalidDogSize
+	}
+
 	// Update fields
 	updates := make(map[string]interface{})
 	if req.Name != nil {
@@ -113,6 +131,9 @@ func (s *DogService) UpdateDog(dogID uuid.UUID, userID uuid.UUID, req UpdateDogR
 	if req.Breed != nil {
 		updates["breed"] = *req.Breed
 	}
+	if req.Size != nil {
+		updates["size"] = *req.Size
+	}
 	if req.Age != nil {
 		updates["age"] = *req.Age
 	}
@@ -151,10 +172,15 @@ func (s *DogService) DeleteDog(dogID uuid.UUID, userID uuid.UUID) error {
 		return errors.New("failed to delete dog")
 	}
 
+	// A deleted dog must not turn up nearby until its presence expires
+	if err := s.presence.Remove(dog.ID); err != nil {
+		log.Printf("Failed to remove presence of dog %s: %v", dog.ID, err)
+	}
+
 	return nil
 }
 
-// SearchPublicDogs searches for public dogs
+// SearchPublicDogs searches public dogs by name or breed, closest first
 func (s *DogService) SearchPublicDogs(query string, limit int, offset int) ([]models.Dog, int64, error) {
 	var dogs []models.Dog
 	var total int64
@@ -162,7 +188,7 @@ func (s *DogService) SearchPublicDogs(query string, limit int, offset int) ([]mo
 	// Build search query
 	searchQuery := s.db.Model(&models.Dog{}).
 		Joins("JOIN users ON dogs.user_id = users.id").
-		Where("users.visibility = 'public' AND (dogs.name ILIKE ? OR dogs.breed ILIKE ?)", "%"+query+"%", "%"+query+"%")
+		Scopes(searchableUsers(""), dogsMatching(query))
 
 	// Count total results
 	if err := searchQuery.Count(&total).Error; err != nil {
@@ -170,7 +196,7 @@ func (s *DogService) SearchPublicDogs(query string, limit int, offset int) ([]mo
 	}
 
 	// Get paginated results
-	if err := searchQuery.Preload("User").Limit(limit).Offset(offset).Find(&dogs).Error; err != nil {
+	if err := searchQuery.Scopes(byDogSimilarity(query)).Preload("User").Limit(limit).Offset(offset).Find(&dogs).Error; err != nil {
 		return nil, 0, errors.New("failed to search dogs")
 	}
 
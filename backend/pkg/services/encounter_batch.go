@@ -0,0 +1,397 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+const (
+	// retroactiveMatchWindow is how far apart in time two dogs' points may be
+	// and still count as the dogs being in the same place at once
+	retroactiveMatchWindow = time.Minute
+
+	defaultRetroactiveRadius = 50.0
+	defaultBatchMaxAge       = 72 * time.Hour
+	defaultClockSkew         = 2 * time.Minute
+)
+
+// BatchLocationPoint is a location the client recorded, possibly while offline
+type BatchLocationPoint struct {
+	Latitude   float64   `json:"latitude" validate:"min=-90,max=90"`
+	Longitude  float64   `json:"longitude" validate:"min=-180,max=180"`
+	RecordedAt time.Time `json:"recorded_at" validate:"required"`
+}
+
+// LocationBatchRequest uploads a dog's recorded locations in any order.
+// BatchID is chosen by the client and identifies retries of the same upload.
+type LocationBatchRequest struct {
+	DogID        uuid.UUID            `json:"dog_id" validate:"required"`
+	BatchID      string               `json:"batch_id" validate:"required,max=64"`
+	RadiusMeters float64              `json:"radius_meters" validate:"omitempty,min=1,max=500"`
+	Points       []BatchLocationPoint `json:"points" validate:"required,min=1,max=1000,dive"`
+}
+
+// LocationBatchResult reports what was done with an upload. Duplicate is set
+// when the batch was already ingested and the first result is returned.
+type LocationBatchResult struct {
+	BatchID      string      `json:"batch_id"`
+	Accepted     int         `json:"accepted"`
+	Rejected     int         `json:"rejected"`
+	EncounterIDs []uuid.UUID `json:"encounter_ids"`
+	Duplicate    bool        `json:"duplicate"`
+}
+
+// IngestLocationBatch records a batch of client-timestamped locations for
+// one of the user's dogs and detects the encounters the dog had during the
+// uploaded span. Points older than BatchMaxAge or further in the future than
+// the clock skew allows are rejected; points slightly in the future are taken
+// as now. The dog's current location only moves forward in time.
+func (s *EncounterService) IngestLocationBatch(userID uuid.UUID, req LocationBatchRequest) (*LocationBatchResult, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+
+	var dog models.Dog
+	if err := s.db.Where("id = ? AND user_id = ?", req.DogID, userID).First(&dog).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, utils.WrapError(err, "failed to find dog")
+	}
+	if err := ensureNotSuspended(s.db, userID.String()); err != nil {
+		return nil, err
+	}
+
+	if result, err := s.findLocationBatch(req.DogID, req.BatchID); result != nil || err != nil {
+		return result, err
+	}
+
+	now := time.Now()
+	points := s.acceptedBatchPoints(req.Points, now)
+	radius := req.RadiusMeters
+	if radius == 0 {
+		radius = defaultRetroactiveRadius
+	}
+
+	batch := models.LocationBatch{
+		DogID:        req.DogID,
+		BatchID:      req.BatchID,
+		Accepted:     len(points),
+		Rejected:     len(req.Points) - len(points),
+		EncounterIDs: []uuid.UUID{},
+	}
+	var latest *LocationPoint
+	var encounters []models.Encounter
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Claims the batch ID; a concurrent retry fails here and reads our result
+		if err := tx.Omit(clause.Associations).Create(&batch).Error; err != nil {
+			return err
+		}
+		if len(points) == 0 {
+			return nil
+		}
+
+		if err := s.locationHistory.RecordBatch(tx, req.DogID, points); err != nil {
+			return err
+		}
+
+		var err error
+		latest, err = s.advanceDeviceLocation(tx, req.DogID, points[len(points)-1])
+		if err != nil {
+			return err
+		}
+		if latest != nil {
+			if err := s.places.RecordVisit(tx, req.DogID, models.NewGeoPoint(latest.Latitude, latest.Longitude), latest.RecordedAt); err != nil {
+				return err
+			}
+		}
+
+		encounters, err = s.detectRetroactive(tx, req.DogID, userID.String(), points, radius)
+		if err != nil {
+			return err
+		}
+		for _, encounter := range encounters {
+			batch.EncounterIDs = append(batch.EncounterIDs, encounter.ID)
+		}
+		return tx.Model(&batch).Select("encounter_ids").Updates(&batch).Error
+	})
+	if utils.IsUniqueViolation(err) {
+		return s.findLocationBatch(req.DogID, req.BatchID)
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to ingest location batch")
+	}
+
+	s.publishNewEncounters(encounters...)
+
+	if latest != nil {
+		if err := s.presence.Update(req.DogID, latest.Latitude, latest.Longitude, latest.RecordedAt); err != nil {
+			log.Printf("Failed to update presence for dog %s: %v", req.DogID, err)
+		}
+	}
+
+	return &LocationBatchResult{
+		BatchID:      batch.BatchID,
+		Accepted:     batch.Accepted,
+		Rejected:     batch.Rejected,
+		EncounterIDs: batch.EncounterIDs,
+	}, nil
+}
+
+// findLocationBatch returns the result of an already ingested batch, or nil
+func (s *EncounterService) findLocationBatch(dogID uuid.UUID, batchID string) (*LocationBatchResult, error) {
+	var batch models.LocationBatch
+	err := s.db.Where("dog_id = ? AND batch_id = ?", dogID, batchID).First(&batch).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find location batch")
+	}
+
+	return &LocationBatchResult{
+		BatchID:      batch.BatchID,
+		Accepted:     batch.Accepted,
+		Rejected:     batch.Rejected,
+		EncounterIDs: batch.EncounterIDs,
+		Duplicate:    true,
+	}, nil
+}
+
+// acceptedBatchPoints drops points outside the accepted time window and
+// repeated timestamps, and returns the rest in time order
+func (s *EncounterService) acceptedBatchPoints(input []BatchLocationPoint, now time.Time) []LocationPoint {
+	oldest := now.Add(-s.batchMaxAge)
+	newest := now.Add(s.clockSkew)
+
+	seen := make(map[int64]bool, len(input))
+	points := make([]LocationPoint, 0, len(input))
+	for _, point := range input {
+		recordedAt := point.RecordedAt.UTC()
+		if recordedAt.Before(oldest) || recordedAt.After(newest) {
+			continue
+		}
+		if recordedAt.After(now) {
+			recordedAt = now
+		}
+		if seen[recordedAt.UnixNano()] {
+			continue
+		}
+		seen[recordedAt.UnixNano()] = true
+
+		points = append(points, LocationPoint{
+			Latitude:   point.Latitude,
+			Longitude:  point.Longitude,
+			RecordedAt: recordedAt,
+		})
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].RecordedAt.Before(points[j].RecordedAt)
+	})
+	return points
+}
+
+// advanceDeviceLocation moves the dog's current location to the point if it
+// is newer than the stored one, and returns the point if it did
+func (s *EncounterService) advanceDeviceLocation(tx *gorm.DB, dogID uuid.UUID, point LocationPoint) (*LocationPoint, error) {
+	result := tx.Exec(`
+		UPDATE device_locations
+		SET location = ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, updated_at = ?
+		WHERE dog_id = ? AND updated_at < ?
+	`, point.Longitude, point.Latitude, point.RecordedAt, dogID, point.RecordedAt)
+	if result.Error != nil {
+		return nil, utils.WrapError(result.Error, "failed to update device location")
+	}
+	if result.RowsAffected > 0 {
+		return &point, nil
+	}
+
+	result = tx.Exec(`
+		INSERT INTO device_locations (id, dog_id, location, updated_at)
+		SELECT ?, ?, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?
+		WHERE NOT EXISTS (SELECT 1 FROM device_locations WHERE dog_id = ?)
+	`, uuid.New(), dogID, point.Longitude, point.Latitude, point.RecordedAt, dogID)
+	if result.Error != nil {
+		return nil, utils.WrapError(result.Error, "failed to create device location")
+	}
+	if result.RowsAffected > 0 {
+		return &point, nil
+	}
+	return nil, nil
+}
+
+// retroactiveMatch is another dog's closest recorded position to one of the
+// uploaded points
+type retroactiveMatch struct {
+	Idx      int
+	DogID    uuid.UUID
+	Distance float64
+}
+
+// detectRetroactive finds the dogs recorded within radiusMeters of the
+// uploaded points and records an encounter for every stretch the dogs stayed
+// together for the dwell time. Points form a stretch while the gaps between
+// them are shorter than the separation timeout. Stretches are held against
+// both owners' encounter preferences as of when they began.
+func (s *EncounterService) detectRetroactive(tx *gorm.DB, dogID uuid.UUID, ownerID string, points []LocationPoint, radiusMeters float64) ([]models.Encounter, error) {
+	type indexedPoint struct {
+		Idx int `json:"idx"`
+		LocationPoint
+	}
+	indexed := make([]indexedPoint, len(points))
+	for i, point := range points {
+		indexed[i] = indexedPoint{Idx: i, LocationPoint: point}
+	}
+	data, err := json.Marshal(indexed)
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to encode uploaded points")
+	}
+
+	window := retroactiveMatchWindow.Seconds()
+	var rows []retroactiveMatch
+	if err := tx.Raw(`
+		SELECT p.idx, h.dog_id, MIN(ST_Distance(h.location, ST_SetSRID(ST_MakePoint(p.longitude, p.latitude), 4326)::geography)) AS distance
+		FROM jsonb_to_recordset(?::jsonb) AS p(idx int, latitude float8, longitude float8, recorded_at timestamptz)
+		JOIN location_history h
+			ON h.recorded_at BETWEEN p.recorded_at - make_interval(secs => ?) AND p.recorded_at + make_interval(secs => ?)
+			AND ST_DWithin(h.location, ST_SetSRID(ST_MakePoint(p.longitude, p.latitude), 4326)::geography, ?)
+		WHERE h.dog_id <> ?
+			AND h.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN (`+blockedUserIDsSQL+`))
+		GROUP BY p.idx, h.dog_id
+	`, string(data), window, window, radiusMeters, dogID, ownerID, ownerID).Scan(&rows).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to match uploaded locations")
+	}
+
+	byDog := make(map[uuid.UUID][]retroactiveMatch)
+	for _, row := range rows {
+		byDog[row.DogID] = append(byDog[row.DogID], row)
+	}
+
+	var encounters []models.Encounter
+	for otherDogID, matches := range byDog {
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Idx < matches[j].Idx })
+
+		for start := 0; start < len(matches); {
+			end := start
+			closest := matches[start]
+			for end+1 < len(matches) &&
+				points[matches[end+1].Idx].RecordedAt.Sub(points[matches[end].Idx].RecordedAt) <= s.cfg.SeparationTimeout {
+				end++
+				if matches[end].Distance < closest.Distance {
+					closest = matches[end]
+				}
+			}
+
+			startedAt := points[matches[start].Idx].RecordedAt
+			endedAt := points[matches[end].Idx].RecordedAt
+			if endedAt.Sub(startedAt) >= s.cfg.DwellTime {
+				allowed, err := s.preferredMatches(dogID, []PresenceMatch{{DogID: otherDogID, Distance: closest.Distance}}, startedAt)
+				if err != nil {
+					return nil, err
+				}
+				if len(allowed) == 0 {
+					start = end + 1
+					continue
+				}
+
+				dog1ID, dog2ID := models.OrderedDogPair(dogID, otherDogID)
+				location := points[closest.Idx]
+				minDistance := closest.Distance
+				encounter, err := s.mergeRetroactive(tx, detection{
+					Dog1ID:            dog1ID,
+					Dog2ID:            dog2ID,
+					Method:            models.DetectionMethodGPS,
+					Location:          models.NewGeoPoint(location.Latitude, location.Longitude),
+					StartedAt:         startedAt,
+					MinDistanceMeters: &minDistance,
+				}, endedAt)
+				if err != nil {
+					return nil, err
+				}
+				encounters = append(encounters, *encounter)
+			}
+			start = end + 1
+		}
+	}
+	return encounters, nil
+}
+
+// mergeRetroactive adds a detection from the past to the pair's encounter
+// overlapping it, or records it as an encounter that already ended
+func (s *EncounterService) mergeRetroactive(tx *gorm.DB, d detection, endedAt time.Time) (*models.Encounter, error) {
+	var encounter models.Encounter
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("dog1_id = ? AND dog2_id = ?", d.Dog1ID, d.Dog2ID).
+		Where("started_at <= ? AND last_seen_at >= ?", endedAt.Add(s.cfg.SeparationTimeout), d.StartedAt.Add(-s.cfg.SeparationTimeout)).
+		Order("started_at").
+		First(&encounter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		startedAt := d.StartedAt
+		encounter = models.Encounter{
+			Dog1ID:            d.Dog1ID,
+			Dog2ID:            d.Dog2ID,
+			Location:          d.Location,
+			Timestamp:         endedAt,
+			StartedAt:         &startedAt,
+			LastSeenAt:        &endedAt,
+			EndedAt:           &endedAt,
+			DurationSeconds:   int(endedAt.Sub(startedAt).Seconds()),
+			MinDistanceMeters: d.MinDistanceMeters,
+		}
+		encounter.AddDetection(d.Method)
+		if err := s.attachPlace(tx, &encounter); err != nil {
+			return nil, err
+		}
+		if err := tx.Omit(clause.Associations).Create(&encounter).Error; err != nil {
+			return nil, err
+		}
+		if err := s.walks.LinkEncounter(tx, &encounter); err != nil {
+			return nil, err
+		}
+		return &encounter, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encounter.AddDetection(d.Method)
+	if d.StartedAt.Before(*encounter.StartedAt) {
+		encounter.StartedAt = &d.StartedAt
+	}
+	if encounter.LastSeenAt == nil || endedAt.After(*encounter.LastSeenAt) {
+		encounter.LastSeenAt = &endedAt
+		if encounter.EndedAt != nil {
+			encounter.EndedAt = &endedAt
+		}
+	}
+	if d.MinDistanceMeters != nil && (encounter.MinDistanceMeters == nil || *d.MinDistanceMeters < *encounter.MinDistanceMeters) {
+		encounter.MinDistanceMeters = d.MinDistanceMeters
+		encounter.Location = d.Location
+	}
+	encounter.DurationSeconds = int(encounter.LastSeenAt.Sub(*encounter.StartedAt).Seconds())
+	if err := s.attachPlace(tx, &encounter); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&encounter).
+		Select("detection_methods", "detection_count", "confidence", "started_at", "last_seen_at",
+			"ended_at", "duration_seconds", "min_distance_meters", "location", "place_id").
+		Updates(&encounter).Error; err != nil {
+		return nil, err
+	}
+	if err := s.walks.LinkEncounter(tx, &encounter); err != nil {
+		return nil, err
+	}
+	return &encounter, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncounterService_AcceptedBatchPoints(t *testing.T) {
+	s := &EncounterService{batchMaxAge: time.Hour, clockSkew: time.Minute}
+	now := time.Now()
+
+	points := s.acceptedBatchPoints([]BatchLocationPoint{
+		{Latitude: 1, RecordedAt: now.Add(-10 * time.Minute)},
+		{Latitude: 2, RecordedAt: now.Add(-2 * time.Hour)},
+		{Latitude: 3, RecordedAt: now.Add(30 * time.Second)},
+		{Latitude: 4, RecordedAt: now.Add(-20 * time.Minute)},
+		{Latitude: 5, RecordedAt: now.Add(-10 * time.Minute)},
+		{Latitude: 6, RecordedAt: now.Add(5 * time.Minute)},
+	}, now)
+
+	// Too old, too far ahead and repeated timestamps are dropped, the rest sorted
+	require.Len(t, points, 3)
+	assert.Equal(t, 4.0, points[0].Latitude)
+	assert.Equal(t, 1.0, points[1].Latitude)
+	assert.Equal(t, 3.0, points[2].Latitude)
+	assert.True(t, points[2].RecordedAt.Equal(now.UTC()))
+}
+
+func TestEncounterService_IngestLocationBatch(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	cfg := ctx.Config
+	cfg.Encounter = config.EncounterConfig{
+		DwellTime:         2 * time.Minute,
+		SeparationTimeout: 5 * time.Minute,
+	}
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, cfg)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+
+	// The other dog was seen near the park an hour ago, every 30 seconds for five minutes
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var nearby []LocationPoint
+	for i := 0; i <= 10; i++ {
+		nearby = append(nearby, LocationPoint{Latitude: 52.5201, Longitude: 13.4050, RecordedAt: start.Add(time.Duration(i) * 30 * time.Second)})
+	}
+	require.NoError(t, encounterService.locationHistory.RecordBatch(ctx.DB, dog2.ID, nearby))
+
+	// The device was offline and uploads out of order
+	req := LocationBatchRequest{DogID: dog1.ID, BatchID: "offline-1"}
+	for i := 10; i >= 0; i-- {
+		req.Points = append(req.Points, BatchLocationPoint{Latitude: 52.5200, Longitude: 13.4050, RecordedAt: start.Add(time.Duration(i) * 30 * time.Second)})
+	}
+	req.Points = append(req.Points, BatchLocationPoint{Latitude: 52.5200, Longitude: 13.4050, RecordedAt: start.Add(-100 * time.Hour)})
+
+	result, err := encounterService.IngestLocationBatch(user1.ID, req)
+	require.NoError(t, err)
+	assert.Equal(t, 11, result.Accepted)
+	assert.Equal(t, 1, result.Rejected)
+	assert.False(t, result.Duplicate)
+	require.Len(t, result.EncounterIDs, 1)
+
+	var encounter models.Encounter
+	require.NoError(t, ctx.DB.First(&encounter, "id = ?", result.EncounterIDs[0]).Error)
+	require.NotNil(t, encounter.EndedAt)
+	assert.Equal(t, 300, encounter.DurationSeconds)
+
+	// Retrying the upload returns the first result without recording again
+	retry, err := encounterService.IngestLocationBatch(user1.ID, req)
+	require.NoError(t, err)
+	assert.True(t, retry.Duplicate)
+	assert.Equal(t, result.EncounterIDs, retry.EncounterIDs)
+
+	var count int64
+	require.NoError(t, ctx.DB.Model(&models.LocationHistory{}).Where("dog_id = ?", dog1.ID).Count(&count).Error)
+	assert.Equal(t, int64(11), count)
+
+	// A later upload overlapping the encounter extends it
+	later := LocationBatchRequest{DogID: dog1.ID, BatchID: "offline-2", Points: []BatchLocationPoint{
+		{Latitude: 52.5200, Longitude: 13.4050, RecordedAt: start.Add(-3 * time.Minute)},
+		{Latitude: 52.5200, Longitude: 13.4050, RecordedAt: start.Add(-time.Minute)},
+		{Latitude: 52.5200, Longitude: 13.4050, RecordedAt: start},
+	}}
+	require.NoError(t, encounterService.locationHistory.RecordBatch(ctx.DB, dog2.ID, []LocationPoint{
+		{Latitude: 52.5201, Longitude: 13.4050, RecordedAt: start.Add(-3 * time.Minute)},
+		{Latitude: 52.5201, Longitude: 13.4050, RecordedAt: start.Add(-time.Minute)},
+	}))
+	result, err = encounterService.IngestLocationBatch(user1.ID, later)
+	require.NoError(t, err)
+	require.Len(t, result.EncounterIDs, 1)
+	assert.Equal(t, encounter.ID, result.EncounterIDs[0])
+
+	require.NoError(t, ctx.DB.First(&encounter, "id = ?", encounter.ID).Error)
+	assert.Equal(t, 480, encounter.DurationSeconds)
+
+	// Other users cannot upload for the dog
+	_, err = encounterService.IngestLocationBatch(user2.ID, LocationBatchRequest{DogID: dog1.ID, BatchID: "x", Points: req.Points[:1]})
+	assert.Error(t, err)
+}
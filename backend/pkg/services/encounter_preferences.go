@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// UpdateEncounterSettingsRequest changes the user's encounter preferences.
+// Omitted fields keep their value. Quiet hours are set by giving both ends
+// and removed with ClearQuietHours; empty breed and size lists match any dog.
+type UpdateEncounterSettingsRequest struct {
+	DetectionEnabled *bool             `json:"detection_enabled,omitempty"`
+	QuietHoursStart  *string           `json:"quiet_hours_start,omitempty" validate:"omitempty,datetime=15:04"`
+	QuietHoursEnd    *string           `json:"quiet_hours_end,omitempty" validate:"omitempty,datetime=15:04"`
+	ClearQuietHours  bool              `json:"clear_quiet_hours"`
+	TimeZone         *string           `json:"time_zone,omitempty" validate:"omitempty,timezone"`
+	RadiusMeters     *int              `json:"radius_meters,omitempty" validate:"omitempty,min=10,max=500"`
+	FollowedDogsOnly *bool             `json:"followed_dogs_only,omitempty"`
+	Breeds           *[]string         `json:"breeds,omitempty" validate:"omitempty,max=20,dive,min=1,max=50"`
+	Sizes            *[]models.DogSize `json:"sizes,omitempty" validate:"omitempty,max=4,dive,oneof=small medium large giant"`
+}
+
+// EncounterDetails is an encounter as shown to one of its dogs' owners,
+// with how often the pair has met
+type EncounterDetails struct {
+	models.Encounter
+	TimesMet            int64      `json:"times_met"`
+	MetBefore           bool       `json:"met_before"`
+	PreviousEncounterAt *time.Time `json:"previous_encounter_at,omitempty"`
+}
+
+// GetEncounterPreferences returns the user's encounter preferences
+func (s *EncounterService) GetEncounterPreferences(userID uuid.UUID) (*models.EncounterSettings, error) {
+	settings, err := s.encounterSettings([]uuid.UUID{userID})
+	if err != nil {
+		return nil, err
+	}
+	result := settings[userID]
+	return &result, nil
+}
+
+// UpdateEncounterPreferences saves changes to the user's encounter preferences
+func (s *EncounterService) UpdateEncounterPreferences(userID uuid.UUID, req UpdateEncounterSettingsRequest) (*models.EncounterSettings, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+	if (req.QuietHoursStart == nil) != (req.QuietHoursEnd == nil) {
+		return nil, utils.NewValidationError([]utils.ValidationError{{Field: "quiet_hours", Message: "quiet_hours_start and quiet_hours_end must be given together"}})
+	}
+
+	var settings models.EncounterSettings
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&settings).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			settings = models.DefaultEncounterSettings(userID)
+		} else if err != nil {
+			return utils.WrapError(err, "failed to get encounter settings")
+		}
+
+		if req.DetectionEnabled != nil {
+			settings.DetectionEnabled = *req.DetectionEnabled
+		}
+		if req.ClearQuietHours {
+			settings.QuietHoursStart, settings.QuietHoursEnd = nil, nil
+		}
+		if req.QuietHoursStart != nil {
+			settings.QuietHoursStart, settings.QuietHoursEnd = req.QuietHoursStart, req.QuietHoursEnd
+		}
+		if req.TimeZone != nil {
+			settings.TimeZone = *req.TimeZone
+		}
+		if req.RadiusMeters != nil {
+			settings.RadiusMeters = *req.RadiusMeters
+		}
+		if req.FollowedDogsOnly != nil {
+			settings.FollowedDogsOnly = *req.FollowedDogsOnly
+		}
+		if req.Breeds != nil {
+			settings.Breeds = *req.Breeds
+		}
+		if req.Sizes != nil {
+			settings.Sizes = *req.Sizes
+		}
+
+		// Save writes every column, so false values are not mistaken for unset
+		if err := tx.Omit(clause.Associations).Save(&settings).Error; err != nil {
+			return utils.WrapError(err, "failed to save encounter settings")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// encounterSettings returns the preferences of each user, defaults for
+// users who never set any
+func (s *EncounterService) encounterSettings(userIDs []uuid.UUID) (map[uuid.UUID]models.EncounterSettings, error) {
+	var rows []models.EncounterSettings
+	if err := s.db.Where("user_id IN ?", userIDs).Find(&rows).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get encounter settings")
+	}
+
+	settings := make(map[uuid.UUID]models.EncounterSettings, len(userIDs))
+	for _, userID := range userIDs {
+		settings[userID] = models.DefaultEncounterSettings(userID)
+	}
+	for _, row := range rows {
+		settings[row.UserID] = row
+	}
+	return settings, nil
+}
+
+// preferredMatches keeps the nearby dogs both owners' preferences allow an
+// encounter with. An encounter is shared, so the dog must pass the other
+// owner's preferences as much as the other dog must pass its owner's.
+func (s *EncounterService) preferredMatches(dogID uuid.UUID, matches []PresenceMatch, now time.Time) ([]PresenceMatch, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	dogIDs := []uuid.UUID{dogID}
+	for _, match := range matches {
+		dogIDs = append(dogIDs, match.DogID)
+	}
+
+	var dogs []models.Dog
+	if err := s.db.Select("id", "user_id", "breed", "size").Where("id IN ?", dogIDs).Find(&dogs).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get dogs")
+	}
+	dogsByID := make(map[uuid.UUID]models.Dog, len(dogs))
+	var ownerIDs []uuid.UUID
+	for _, dog := range dogs {
+		dogsByID[dog.ID] = dog
+		ownerIDs = append(ownerIDs, dog.UserID)
+	}
+	self, found := dogsByID[dogID]
+	if !found {
+		return nil, utils.ErrNotFound
+	}
+
+	settings, err := s.encounterSettings(ownerIDs)
+	if err != nil {
+		return nil, err
+	}
+	mine := settings[self.UserID]
+	if !mine.Detecting(now) {
+		return nil, nil
+	}
+
+	// Who follows whom between the dog and the nearby dogs
+	var follows []models.Follower
+	if err := s.db.Where("(follower_dog_id = ? AND followed_dog_id IN ?) OR (followed_dog_id = ? AND follower_dog_id IN ?)",
+		dogID, dogIDs[1:], dogID, dogIDs[1:]).Find(&follows).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get follows")
+	}
+	following := make(map[[2]uuid.UUID]bool, len(follows))
+	for _, follow := range follows {
+		following[[2]uuid.UUID{follow.FollowerDogID, follow.FollowedDogID}] = true
+	}
+
+	var allowed []PresenceMatch
+	for _, match := range matches {
+		other, found := dogsByID[match.DogID]
+		if !found {
+			continue
+		}
+		theirs := settings[other.UserID]
+
+		if !theirs.Detecting(now) {
+			continue
+		}
+		if match.Distance > float64(mine.RadiusMeters) || match.Distance > float64(theirs.RadiusMeters) {
+			continue
+		}
+		if !mine.Accepts(other) || !theirs.Accepts(self) {
+			continue
+		}
+		if mine.FollowedDogsOnly && !following[[2]uuid.UUID{dogID, other.ID}] {
+			continue
+		}
+		if theirs.FollowedDogsOnly && !following[[2]uuid.UUID{other.ID, dogID}] {
+			continue
+		}
+		allowed = append(allowed, match)
+	}
+	return allowed, nil
+}
+
+// GetEncounterDetails returns an encounter of one of the user's dogs with
+// both dogs, the place and how often the pair has met. Only the owners of
+// the two dogs can see it, and only while neither has blocked the other.
+func (s *EncounterService) GetEncounterDetails(userID uuid.UUID, encounterID uuid.UUID) (*EncounterDetails, error) {
+	var encounter models.Encounter
+	err := s.db.Preload("Dog1").Preload("Dog2").Preload("Place").
+		Where("id = ?", encounterID).
+		Where("dog1_id IN (SELECT id FROM dogs WHERE user_id = ?) OR dog2_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID, userID).
+		First(&encounter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find encounter")
+	}
+
+	blocked, err := isBlockedPair(s.db, encounter.Dog1.UserID.String(), encounter.Dog2.UserID.String())
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, utils.ErrNotFound
+	}
+
+	// Only the area is shown, as in the encounter history
+	encounter.Location = s.locationPrivacy.Snap(encounter.Location)
+	details := EncounterDetails{Encounter: encounter}
+
+	pair := s.db.Model(&models.Encounter{}).Where("dog1_id = ? AND dog2_id = ?", encounter.Dog1ID, encounter.Dog2ID)
+	if err := pair.Count(&details.TimesMet).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to count encounters")
+	}
+
+	var previous models.Encounter
+	err = s.db.Where("dog1_id = ? AND dog2_id = ? AND id <> ?", encounter.Dog1ID, encounter.Dog2ID, encounter.ID).
+		Where("COALESCE(started_at, timestamp) < ?", encounterStart(encounter)).
+		Order("timestamp DESC").
+		First(&previous).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.WrapError(err, "failed to find previous encounter")
+	}
+	if err == nil {
+		details.MetBefore = true
+		details.PreviousEncounterAt = &previous.Timestamp
+	}
+
+	return &details, nil
+}
+
+// encounterStart returns when the encounter began. Encounters recorded
+// before dwell tracking have only a timestamp.
+func encounterStart(encounter models.Encounter) time.Time {
+	if encounter.StartedAt != nil {
+		return *encounter.StartedAt
+	}
+	return encounter.Timestamp
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncounterSettings_QuietHoursAndFilters(t *testing.T) {
+	start, end := "22:00", "07:00"
+	settings := models.DefaultEncounterSettings(uuid.New())
+	settings.QuietHoursStart, settings.QuietHoursEnd = &start, &end
+
+	// Quiet hours wrap around midnight
+	assert.True(t, settings.InQuietHours(time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)))
+	assert.True(t, settings.InQuietHours(time.Date(2024, 5, 1, 6, 59, 0, 0, time.UTC)))
+	assert.False(t, settings.InQuietHours(time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)))
+	assert.False(t, settings.Detecting(time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)))
+
+	// and are read in the user's time zone
+	settings.TimeZone = "Europe/Berlin"
+	assert.True(t, settings.InQuietHours(time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC)))
+
+	settings.Breeds = []string{"golden retriever"}
+	settings.Sizes = []models.DogSize{models.DogSizeLarge}
+	assert.True(t, settings.Accepts(models.Dog{Breed: "Golden Retriever", Size: models.DogSizeLarge}))
+	assert.False(t, settings.Accepts(models.Dog{Breed: "Golden Retriever", Size: models.DogSizeSmall}))
+	assert.False(t, settings.Accepts(models.Dog{Breed: "Golden Retriever"}))
+	assert.False(t, settings.Accepts(models.Dog{Breed: "Poodle", Size: models.DogSizeLarge}))
+}
+
+func TestEncounterService_PreferredMatches(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, ctx.Config)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+	matches := []PresenceMatch{{DogID: dog2.ID, Distance: 30}}
+	now := time.Now()
+
+	allowed, err := encounterService.preferredMatches(dog1.ID, matches, now)
+	require.NoError(t, err)
+	assert.Len(t, allowed, 1)
+
+	// The other owner's radius applies as well
+	radius := 20
+	_, err = encounterService.UpdateEncounterPreferences(user2.ID, UpdateEncounterSettingsRequest{RadiusMeters: &radius})
+	require.NoError(t, err)
+	allowed, err = encounterService.preferredMatches(dog1.ID, matches, now)
+	require.NoError(t, err)
+	assert.Empty(t, allowed)
+
+	radius = 100
+	followedOnly := true
+	settings, err := encounterService.UpdateEncounterPreferences(user2.ID, UpdateEncounterSettingsRequest{RadiusMeters: &radius, FollowedDogsOnly: &followedOnly})
+	require.NoError(t, err)
+	assert.Equal(t, 100, settings.RadiusMeters)
+	assert.True(t, settings.DetectionEnabled)
+
+	// Only once the other dog follows this one
+	allowed, err = encounterService.preferredMatches(dog1.ID, matches, now)
+	require.NoError(t, err)
+	assert.Empty(t, allowed)
+
+	require.NoError(t, ctx.DB.Create(&models.Follower{FollowerDogID: dog2.ID, FollowedDogID: dog1.ID}).Error)
+	allowed, err = encounterService.preferredMatches(dog1.ID, matches, now)
+	require.NoError(t, err)
+	assert.Len(t, allowed, 1)
+
+	disabled := false
+	_, err = encounterService.UpdateEncounterPreferences(user1.ID, UpdateEncounterSettingsRequest{DetectionEnabled: &disabled})
+	require.NoError(t, err)
+	allowed, err = encounterService.preferredMatches(dog1.ID, matches, now)
+	require.NoError(t, err)
+	assert.Empty(t, allowed)
+
+	// Bluetooth sightings are held to the same preferences
+	beacons, err := encounterService.GetBeaconIDs(user1.ID, dog1.ID, 1)
+	require.NoError(t, err)
+	encounter, err := encounterService.CreateBluetoothEncounter(user2.ID, EncounterDetectionRequest{
+		DogID:       dog2.ID,
+		EphemeralID: beacons[0].ID,
+		Method:      models.DetectionMethodBluetooth,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, encounter)
+	var recorded int64
+	require.NoError(t, ctx.DB.Model(&models.Encounter{}).Count(&recorded).Error)
+	assert.Zero(t, recorded)
+
+	start := "22:00"
+	_, err = encounterService.UpdateEncounterPreferences(user1.ID, UpdateEncounterSettingsRequest{QuietHoursStart: &start})
+	assert.Error(t, err)
+}
+
+func TestEncounterService_GetEncounterDetails(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, ctx.Config)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	stranger := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+
+	dog1ID, dog2ID := models.OrderedDogPair(dog1.ID, dog2.ID)
+	earlier := models.Encounter{Dog1ID: dog1ID, Dog2ID: dog2ID, Location: models.NewGeoPoint(52.52, 13.405), Timestamp: time.Now().Add(-48 * time.Hour), DetectionMethod: models.DetectionMethodGPS}
+	latest := models.Encounter{Dog1ID: dog1ID, Dog2ID: dog2ID, Location: models.NewGeoPoint(52.52, 13.405), Timestamp: time.Now(), DetectionMethod: models.DetectionMethodGPS}
+	require.NoError(t, ctx.DB.Create(&earlier).Error)
+	require.NoError(t, ctx.DB.Create(&latest).Error)
+
+	details, err := encounterService.GetEncounterDetails(user2.ID, latest.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), details.TimesMet)
+	assert.True(t, details.MetBefore)
+	require.NotNil(t, details.PreviousEncounterAt)
+	assert.WithinDuration(t, earlier.Timestamp, *details.PreviousEncounterAt, time.Second)
+	assert.NotEqual(t, uuid.Nil, details.Dog1.ID)
+
+	details, err = encounterService.GetEncounterDetails(user1.ID, earlier.ID)
+	require.NoError(t, err)
+	assert.False(t, details.MetBefore)
+
+	_, err = encounterService.GetEncounterDetails(stranger.ID, latest.ID)
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+}
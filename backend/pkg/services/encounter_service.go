@@ -2,261 +2,739 @@ package services
 
 import (
 	"errors"
+	"log"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
 )
 
 type EncounterService struct {
-	db *gorm.DB
+	db              *gorm.DB
+	cfg             config.EncounterConfig
+	locationHistory *LocationHistoryService
+	beaconService   *BeaconService
+	presence        *PresenceService
+	locationPrivacy *LocationPrivacyService
+	places          *PlaceService
+	walks           *WalkService
+	realtime        *RealtimeService
+	timeline        *TimelineService
+	batchMaxAge     time.Duration
+	clockSkew       time.Duration
+	waveWindow      time.Duration
 }
 
-func NewEncounterService(db *gorm.DB) *EncounterService {
+func NewEncounterService(db *gorm.DB, redis *redis.Client, cfg config.Config) *EncounterService {
+	batchMaxAge := cfg.Location.BatchMaxAge
+	if batchMaxAge <= 0 {
+		batchMaxAge = defaultBatchMaxAge
+	}
+	clockSkew := cfg.Location.ClockSkew
+	if clockSkew <= 0 {
+		clockSkew = defaultClockSkew
+	}
+	waveWindow := cfg.Encounter.WaveWindow
+	if waveWindow <= 0 {
+		waveWindow = defaultWaveWindow
+	}
+
 	return &EncounterService{
-		db: db,
+		db:              db,
+		cfg:             cfg.Encounter,
+		locationHistory: NewLocationHistoryService(db, cfg),
+		beaconService:   NewBeaconService(redis, cfg),
+		presence:        NewPresenceService(db, redis, cfg),
+		locationPrivacy: NewLocationPrivacyService(db, cfg),
+		places:          NewPlaceService(db, cfg),
+		walks:           NewWalkService(db),
+		realtime:        NewRealtimeService(redis, cfg),
+		timeline:        NewTimelineService(db, redis, cfg),
+		batchMaxAge:     batchMaxAge,
+		clockSkew:       clockSkew,
+		waveWindow:      waveWindow,
 	}
 }
 
 // LocationUpdateRequest represents a location update request
 type LocationUpdateRequest struct {
 	DogID     uuid.UUID `json:"dog_id" validate:"required"`
-	Latitude  float64   `json:"latitude" validate:"required,min=-90,max=90"`
-	Longitude float64   `json:"longitude" validate:"required,min=-180,max=180"`
+	Latitude  float64   `json:"latitude" validate:"min=-90,max=90"`
+	Longitude float64   `json:"longitude" validate:"min=-180,max=180"`
 }
 
-// EncounterDetectionRequest represents an encounter detection request
+// EncounterDetectionRequest represents an encounter detection request.
+// EphemeralID is the beacon identifier the dog's device observed. Location
+// is optional; the dog's last known position is used without it.
 type EncounterDetectionRequest struct {
-	DogID    uuid.UUID                  `json:"dog_id" validate:"required"`
-	Location orb.Point                  `json:"location"`
-	Method   models.DetectionMethod     `json:"method" validate:"required"`
-	Metadata map[string]interface{}     `json:"metadata,omitempty"`
+	DogID       uuid.UUID              `json:"dog_id" validate:"required"`
+	EphemeralID string                 `json:"ephemeral_id" validate:"required"`
+	Location    *models.GeoPoint       `json:"location,omitempty"`
+	Method      models.DetectionMethod `json:"method" validate:"required"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 }
 
-// UpdateDeviceLocation updates or creates a device location for a dog
-func (s *EncounterService) UpdateDeviceLocation(req LocationUpdateRequest) error {
-	// Check if dog exists
+// UpdateDeviceLocation sets the current location of one of the user's dogs,
+// appends it to the dog's location history and checks the dog in to or out
+// of places
+func (s *EncounterService) UpdateDeviceLocation(userID uuid.UUID, req LocationUpdateRequest) error {
+	if err := utils.ValidateStruct(req); err != nil {
+		return err
+	}
+
 	var dog models.Dog
-	if err := s.db.Where("id = ?", req.DogID).First(&dog).Error; err != nil {
+	if err := s.db.Where("id = ? AND user_id = ?", req.DogID, userID).First(&dog).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("dog not found")
+			return utils.ErrNotFound
 		}
-		return errors.New("failed to find dog")
+		return utils.WrapError(err, "failed to find dog")
 	}
 
-	// Create point from coordinates
-	location := orb.Point{req.Longitude, req.Latitude}
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// device_locations keeps a single current row per dog
+		result := tx.Exec(`
+			UPDATE device_locations
+			SET location = ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, updated_at = ?
+			WHERE dog_id = ?
+		`, req.Longitude, req.Latitude, now, req.DogID)
+		if result.Error != nil {
+			return utils.WrapError(result.Error, "failed to update device location")
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Exec(`
+				INSERT INTO device_locations (id, dog_id, location, updated_at)
+				VALUES (?, ?, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)
+			`, uuid.New(), req.DogID, req.Longitude, req.Latitude, now).Error; err != nil {
+				return utils.WrapError(err, "failed to create device location")
+			}
+		}
 
-	// Update or create device location
-	deviceLocation := models.DeviceLocation{
-		DogID:     req.DogID,
-		Location:  location,
-		UpdatedAt: time.Now(),
+		if err := s.locationHistory.Record(tx, req.DogID, req.Latitude, req.Longitude, now); err != nil {
+			return err
+		}
+		return s.places.RecordVisit(tx, req.DogID, models.NewGeoPoint(req.Latitude, req.Longitude), now)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Use UPSERT to update or insert
-	if err := s.db.Where("dog_id = ?", req.DogID).
-		Assign(map[string]interface{}{
-			"location":   location,
-			"updated_at": time.Now(),
-		}).
-		FirstOrCreate(&deviceLocation).Error; err != nil {
-		return errors.New("failed to update device location")
+	// The durable write went through; the index catches up on the next ping
+	if err := s.presence.Update(req.DogID, req.Latitude, req.Longitude, now); err != nil {
+		log.Printf("Failed to update presence for dog %s: %v", req.DogID, err)
 	}
-
 	return nil
 }
 
-// DetectEncounters finds potential encounters within a radius
-func (s *EncounterService) DetectEncounters(dogID uuid.UUID, radiusMeters float64) ([]models.Encounter, error) {
-	// Get current dog's location
-	var currentLocation models.DeviceLocation
-	if err := s.db.Where("dog_id = ?", dogID).First(&currentLocation).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("dog location not found")
-		}
-		return nil, errors.New("failed to get dog location")
-	}
-
-	// Find nearby dogs using PostGIS ST_DWithin
-	var nearbyDogs []models.DeviceLocation
-	if err := s.db.Raw(`
-		SELECT dog_id, location, updated_at 
-		FROM device_locations 
-		WHERE dog_id != ? 
-		AND ST_DWithin(
-			location::geography, 
-			ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, 
-			?
-		)
-		AND updated_at > NOW() - INTERVAL '1 hour'
-	`, dogID, currentLocation.Location[0], currentLocation.Location[1], radiusMeters).
-		Scan(&nearbyDogs).Error; err != nil {
-		return nil, errors.New("failed to find nearby dogs")
+// DetectEncounters tracks proximity sessions between one of the user's dogs
+// and dogs within the radius and returns the encounters confirmed by this
+// call. A pair only becomes an encounter once it stays in range for the
+// dwell time, so dogs merely passing each other are not recorded.
+func (s *EncounterService) DetectEncounters(userID uuid.UUID, dogID uuid.UUID, radiusMeters float64) ([]models.Encounter, error) {
+	var count int64
+	if err := s.db.Model(&models.Dog{}).Where("id = ? AND user_id = ?", dogID, userID).Count(&count).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog")
+	}
+	if count == 0 {
+		return nil, utils.ErrNotFound
+	}
+	ownerID := userID.String()
+	if err := ensureNotSuspended(s.db, ownerID); err != nil {
+		return nil, err
+	}
+
+	// Only positions fresh enough to still count as being together
+	now := time.Now()
+	since := now.Add(-s.cfg.SeparationTimeout)
+	latitude, longitude, found, err := s.presence.Position(dogID, since)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("dog location not found")
+	}
+
+	if err := s.closeSeparatedSessions(now); err != nil {
+		return nil, err
+	}
+
+	matches, err := s.presence.Nearby(latitude, longitude, radiusMeters, since, dogID)
+	if err != nil {
+		return nil, err
+	}
+	nearbyDogs, err := s.withoutBlockedDogs(ownerID, matches)
+	if err != nil {
+		return nil, err
+	}
+	nearbyDogs, err = s.preferredMatches(dogID, nearbyDogs, now)
+	if err != nil {
+		return nil, err
 	}
 
 	var encounters []models.Encounter
-	for _, nearbyDog := range nearbyDogs {
-		// Check if encounter already exists recently (within 30 minutes)
-		var existingCount int64
-		if err := s.db.Model(&models.Encounter{}).
-			Where("((dog1_id = ? AND dog2_id = ?) OR (dog1_id = ? AND dog2_id = ?)) AND timestamp > ?",
-				dogID, nearbyDog.DogID, nearbyDog.DogID, dogID, time.Now().Add(-30*time.Minute)).
-			Count(&existingCount).Error; err != nil {
-			continue // Skip this dog if we can't check
+	for _, nearby := range nearbyDogs {
+		encounter, err := s.trackProximity(dogID, nearby, models.NewGeoPoint(latitude, longitude), now)
+		if err != nil {
+			continue // Skip this dog, its session is picked up on the next ping
 		}
+		if encounter != nil {
+			encounters = append(encounters, *encounter)
+		}
+	}
+
+	s.publishNewEncounters(encounters...)
+	return encounters, nil
+}
+
+// trackProximity extends or opens the proximity session between the dogs
+// and confirms it as an encounter once it reaches the dwell time. The
+// session only runs up to when the nearby dog last reported, so a stale
+// position of a dog that passed by cannot keep it going.
+func (s *EncounterService) trackProximity(dogID uuid.UUID, nearby PresenceMatch, location models.GeoPoint, now time.Time) (*models.Encounter, error) {
+	dog1ID, dog2ID := models.OrderedDogPair(dogID, nearby.DogID)
+	seenAt := nearby.SeenAt
+	if seenAt.After(now) {
+		seenAt = now
+	}
+
+	var confirmed *models.Encounter
+	err := retryOnConflict(func() error {
+		confirmed = nil
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			var session models.ProximitySession
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("dog1_id = ? AND dog2_id = ? AND ended_at IS NULL", dog1ID, dog2ID).
+				First(&session).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				session = models.ProximitySession{
+					Dog1ID:            dog1ID,
+					Dog2ID:            dog2ID,
+					Location:          location,
+					StartedAt:         seenAt,
+					LastSeenAt:        seenAt,
+					MinDistanceMeters: nearby.Distance,
+				}
+				return tx.Omit(clause.Associations).Create(&session).Error
+			}
+			if err != nil {
+				return err
+			}
 
-		if existingCount == 0 {
-			// Create new encounter
-			encounter := models.Encounter{
-				Dog1ID:    dogID,
-				Dog2ID:    nearbyDog.DogID,
-				Location:  currentLocation.Location,
-				Timestamp: time.Now(),
+			// Nothing new from the nearby dog since the session last moved on
+			if !seenAt.After(session.LastSeenAt) {
+				return nil
+			}
+			session.LastSeenAt = seenAt
+			if nearby.Distance < session.MinDistanceMeters {
+				session.MinDistanceMeters = nearby.Distance
+				session.Location = location
 			}
 
-			if err := s.db.Create(&encounter).Error; err != nil {
-				continue // Skip if creation fails
+			if session.EncounterID == nil && session.Duration() >= s.cfg.DwellTime {
+				// A Bluetooth detection may already have opened the encounter
+				encounter, err := s.mergeDetection(tx, detection{
+					Dog1ID:            dog1ID,
+					Dog2ID:            dog2ID,
+					Method:            models.DetectionMethodGPS,
+					Location:          session.Location,
+					StartedAt:         session.StartedAt,
+					MinDistanceMeters: &session.MinDistanceMeters,
+				}, now)
+				if err != nil {
+					return err
+				}
+				session.EncounterID = &encounter.ID
+				confirmed = encounter
+			} else if session.EncounterID != nil {
+				if err := s.extendEncounter(tx, *session.EncounterID, session.Location, &session.MinDistanceMeters, now); err != nil {
+					return err
+				}
+				if err := s.walks.LinkEncounter(tx, &models.Encounter{ID: *session.EncounterID, Dog1ID: dog1ID, Dog2ID: dog2ID, StartedAt: &session.StartedAt, LastSeenAt: &now}); err != nil {
+					return err
+				}
 			}
 
-			encounters = append(encounters, encounter)
+			return tx.Model(&models.ProximitySession{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+				"last_seen_at":        session.LastSeenAt,
+				"min_distance_meters": session.MinDistanceMeters,
+				"location":            session.Location,
+				"encounter_id":        session.EncounterID,
+			}).Error
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return confirmed, nil
+}
+
+// detection is a single observation of two dogs meeting
+type detection struct {
+	Dog1ID            uuid.UUID
+	Dog2ID            uuid.UUID
+	Method            models.DetectionMethod
+	Location          models.GeoPoint
+	StartedAt         time.Time
+	MinDistanceMeters *float64
+}
+
+// mergeDetection adds the detection to the pair's open encounter, opening one
+// if there is none. Creating a second open encounter for the pair violates
+// idx_encounters_open_pair, so concurrent callers retry and merge instead.
+func (s *EncounterService) mergeDetection(tx *gorm.DB, d detection, now time.Time) (*models.Encounter, error) {
+	var encounter models.Encounter
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("dog1_id = ? AND dog2_id = ? AND ended_at IS NULL", d.Dog1ID, d.Dog2ID).
+		First(&encounter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		startedAt := d.StartedAt
+		encounter = models.Encounter{
+			Dog1ID:            d.Dog1ID,
+			Dog2ID:            d.Dog2ID,
+			Location:          d.Location,
+			Timestamp:         now,
+			StartedAt:         &startedAt,
+			LastSeenAt:        &now,
+			DurationSeconds:   int(now.Sub(startedAt).Seconds()),
+			MinDistanceMeters: d.MinDistanceMeters,
+		}
+		encounter.AddDetection(d.Method)
+		if err := s.attachPlace(tx, &encounter); err != nil {
+			return nil, err
 		}
+		omit := []string{clause.Associations}
+		if encounter.Location == (models.GeoPoint{}) {
+			// No position is known; leave it NULL rather than store 0,0
+			omit = append(omit, "location")
+		}
+		if err := tx.Omit(omit...).Create(&encounter).Error; err != nil {
+			return nil, err
+		}
+		if err := s.walks.LinkEncounter(tx, &encounter); err != nil {
+			return nil, err
+		}
+		return &encounter, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return encounters, nil
+	encounter.AddDetection(d.Method)
+	if encounter.StartedAt == nil || d.StartedAt.Before(*encounter.StartedAt) {
+		encounter.StartedAt = &d.StartedAt
+	}
+	if d.MinDistanceMeters != nil && (encounter.MinDistanceMeters == nil || *d.MinDistanceMeters < *encounter.MinDistanceMeters) {
+		encounter.MinDistanceMeters = d.MinDistanceMeters
+		encounter.Location = d.Location
+	} else if encounter.Location == (models.GeoPoint{}) {
+		encounter.Location = d.Location
+	}
+	encounter.LastSeenAt = &now
+	encounter.DurationSeconds = int(now.Sub(*encounter.StartedAt).Seconds())
+	if err := s.attachPlace(tx, &encounter); err != nil {
+		return nil, err
+	}
+
+	// Struct updates so detection_methods goes through its JSON serializer
+	columns := []interface{}{"detection_count", "confidence", "started_at",
+		"last_seen_at", "duration_seconds", "min_distance_meters", "place_id"}
+	if encounter.Location != (models.GeoPoint{}) {
+		columns = append(columns, "location")
+	}
+	if err := tx.Model(&encounter).
+		Select("detection_methods", columns...).
+		Updates(&encounter).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.walks.LinkEncounter(tx, &encounter); err != nil {
+		return nil, err
+	}
+	return &encounter, nil
 }
 
-// CreateBluetoothEncounter creates an encounter detected via Bluetooth
-func (s *EncounterService) CreateBluetoothEncounter(req EncounterDetectionRequest) (*models.Encounter, error) {
-	// Validate that both dogs exist
-	var dog1, dog2 models.Dog
-	if err := s.db.Where("id = ?", req.DogID).First(&dog1).Error; err != nil {
-		return nil, errors.New("dog not found")
+// attachPlace sets the place the encounter happened in, if it has none yet.
+// Bluetooth detections may come without a location.
+func (s *EncounterService) attachPlace(tx *gorm.DB, encounter *models.Encounter) error {
+	if encounter.PlaceID != nil || encounter.Location == (models.GeoPoint{}) {
+		return nil
+	}
+
+	place, err := s.places.PlaceAt(tx, encounter.Location)
+	if err != nil || place == nil {
+		return err
+	}
+	encounter.PlaceID = &place.ID
+	return nil
+}
+
+// extendEncounter keeps an open encounter alive while its dogs stay together
+func (s *EncounterService) extendEncounter(tx *gorm.DB, encounterID uuid.UUID, location models.GeoPoint, minDistance *float64, now time.Time) error {
+	updates := map[string]interface{}{
+		"last_seen_at":     now,
+		"duration_seconds": gorm.Expr("EXTRACT(EPOCH FROM ?::timestamptz - started_at)::int", now),
+	}
+	if minDistance != nil {
+		updates["location"] = gorm.Expr("CASE WHEN min_distance_meters IS NULL OR min_distance_meters > ? THEN ? ELSE location END", *minDistance, location)
+		updates["min_distance_meters"] = gorm.Expr("LEAST(COALESCE(min_distance_meters, ?), ?)", *minDistance, *minDistance)
+	}
+
+	return tx.Model(&models.Encounter{}).Where("id = ? AND ended_at IS NULL", encounterID).Updates(updates).Error
+}
+
+// retryOnConflict runs fn again when it lost a race to create a unique row,
+// so the second attempt finds and merges into the winner's row
+func retryOnConflict(fn func() error) error {
+	err := fn()
+	if utils.IsUniqueViolation(err) {
+		err = fn()
+	}
+	return err
+}
+
+// closeSeparatedSessions ends proximity sessions and encounters whose dogs
+// have not been seen together for the separation timeout. Encounters end
+// when their dogs were last seen together.
+func (s *EncounterService) closeSeparatedSessions(now time.Time) error {
+	cutoff := now.Add(-s.cfg.SeparationTimeout)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Encounter{}).
+			Where("ended_at IS NULL AND last_seen_at < ?", cutoff).
+			Updates(map[string]interface{}{
+				"ended_at":         gorm.Expr("last_seen_at"),
+				"duration_seconds": gorm.Expr("EXTRACT(EPOCH FROM last_seen_at - started_at)::int"),
+			}).Error; err != nil {
+			return utils.WrapError(err, "failed to end encounters")
+		}
+
+		if err := tx.Model(&models.ProximitySession{}).
+			Where("ended_at IS NULL AND last_seen_at < ?", cutoff).
+			Update("ended_at", gorm.Expr("last_seen_at")).Error; err != nil {
+			return utils.WrapError(err, "failed to close proximity sessions")
+		}
+		return nil
+	})
+}
+
+// GetBeaconIDs returns the next rotating Bluetooth identifiers for one of
+// the user's dogs to broadcast
+func (s *EncounterService) GetBeaconIDs(userID uuid.UUID, dogID uuid.UUID, count int) ([]BeaconID, error) {
+	var total int64
+	if err := s.db.Model(&models.Dog{}).Where("id = ? AND user_id = ?", dogID, userID).Count(&total).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog")
+	}
+	if total == 0 {
+		return nil, utils.ErrNotFound
+	}
+
+	return s.beaconService.IssueBatch(dogID, count)
+}
+
+// CreateBluetoothEncounter records that one of the user's dogs observed
+// another dog's beacon, merging it into the pair's open encounter when the
+// meeting was already detected. Nothing is recorded, and nil returned, when
+// either owner's encounter preferences rule the other dog out.
+func (s *EncounterService) CreateBluetoothEncounter(userID uuid.UUID, req EncounterDetectionRequest) (*models.Encounter, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+	if req.Location != nil && (math.Abs(req.Location.Lat()) > 90 || math.Abs(req.Location.Lng()) > 180) {
+		return nil, utils.NewValidationError([]utils.ValidationError{{Field: "location", Message: "location must be a valid [longitude, latitude]"}})
 	}
 
-	// For Bluetooth, we need the other dog ID from metadata
-	otherDogIDStr, ok := req.Metadata["other_dog_id"].(string)
-	if !ok {
-		return nil, errors.New("other_dog_id required for Bluetooth encounters")
+	var dog1, dog2 models.Dog
+	if err := s.db.Where("id = ? AND user_id = ?", req.DogID, userID).First(&dog1).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, utils.WrapError(err, "failed to find dog")
 	}
 
-	otherDogID, err := uuid.Parse(otherDogIDStr)
+	otherDogID, err := s.beaconService.Resolve(req.EphemeralID, time.Now())
 	if err != nil {
-		return nil, errors.New("invalid other_dog_id format")
+		return nil, err
+	}
+	if otherDogID == req.DogID {
+		return nil, ErrInvalidBeacon
 	}
 
 	if err := s.db.Where("id = ?", otherDogID).First(&dog2).Error; err != nil {
-		return nil, errors.New("other dog not found")
+		return nil, ErrInvalidBeacon
 	}
 
-	// Check for recent encounter
-	var existingCount int64
-	if err := s.db.Model(&models.Encounter{}).
-		Where("((dog1_id = ? AND dog2_id = ?) OR (dog1_id = ? AND dog2_id = ?)) AND timestamp > ?",
-			req.DogID, otherDogID, otherDogID, req.DogID, time.Now().Add(-30*time.Minute)).
-		Count(&existingCount).Error; err != nil {
-		return nil, errors.New("failed to check existing encounters")
+	if err := ensureCanInteract(s.db, dog1.UserID.String(), dog2.UserID.String()); err != nil {
+		return nil, err
 	}
 
-	if existingCount > 0 {
-		return nil, errors.New("encounter already recorded recently")
+	now := time.Now()
+	allowed, err := s.preferredMatches(req.DogID, []PresenceMatch{{DogID: otherDogID}}, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) == 0 {
+		return nil, nil
 	}
 
-	// Create encounter
-	encounter := models.Encounter{
-		Dog1ID:    req.DogID,
-		Dog2ID:    otherDogID,
-		Location:  req.Location,
-		Timestamp: time.Now(),
+	if err := s.closeSeparatedSessions(now); err != nil {
+		return nil, err
 	}
 
-	if err := s.db.Create(&encounter).Error; err != nil {
-		return nil, errors.New("failed to create encounter")
+	location, err := s.bluetoothLocation(req, now)
+	if err != nil {
+		return nil, err
 	}
 
-	return &encounter, nil
+	// Reports from both dogs and GPS detections of the same meeting merge
+	dog1ID, dog2ID := models.OrderedDogPair(req.DogID, otherDogID)
+	var encounter *models.Encounter
+	err = retryOnConflict(func() error {
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			encounter, err = s.mergeDetection(tx, detection{
+				Dog1ID:    dog1ID,
+				Dog2ID:    dog2ID,
+				Method:    models.DetectionMethodBluetooth,
+				Location:  location,
+				StartedAt: now,
+			}, now)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to record encounter")
+	}
+
+	s.publishNewEncounters(*encounter)
+	return encounter, nil
 }
 
-// GetDogEncounters returns encounters for a specific dog
+// bluetoothLocation returns where a Bluetooth sighting happened: the
+// reported location, else the dog's recent position. It is the zero point
+// when neither is known.
+func (s *EncounterService) bluetoothLocation(req EncounterDetectionRequest, now time.Time) (models.GeoPoint, error) {
+	if req.Location != nil {
+		return *req.Location, nil
+	}
+	latitude, longitude, found, err := s.presence.Position(req.DogID, now.Add(-s.cfg.SeparationTimeout))
+	if err != nil || !found {
+		return models.GeoPoint{}, err
+	}
+	return models.NewGeoPoint(latitude, longitude), nil
+}
+
+// publishNewEncounters tells the owners of both dogs about the encounters
+// that were opened, which are those with a single detection so far
+func (s *EncounterService) publishNewEncounters(encounters ...models.Encounter) {
+	for _, encounter := range encounters {
+		if encounter.DetectionCount != 1 {
+			continue
+		}
+
+		var dogs []models.Dog
+		if err := s.db.Select("id", "user_id").
+			Where("id IN ?", []uuid.UUID{encounter.Dog1ID, encounter.Dog2ID}).
+			Find(&dogs).Error; err != nil {
+			log.Printf("Failed to find dogs of encounter %s: %v", encounter.ID, err)
+			continue
+		}
+
+		for _, dog := range dogs {
+			otherDogID := encounter.Dog1ID
+			if dog.ID == encounter.Dog1ID {
+				otherDogID = encounter.Dog2ID
+			}
+			s.realtime.Notify([]uuid.UUID{dog.UserID}, RealtimeEventEncounter, map[string]interface{}{
+				"encounter_id":     encounter.ID,
+				"dog_id":           dog.ID,
+				"other_dog_id":     otherDogID,
+				"place_id":         encounter.PlaceID,
+				"detection_method": encounter.DetectionMethod,
+				"started_at":       encounter.StartedAt,
+			})
+		}
+	}
+}
+
+// GetDogEncounters returns encounters for a specific dog with their
+// locations snapped to the privacy grid
 func (s *EncounterService) GetDogEncounters(dogID uuid.UUID, limit int, offset int) ([]models.Encounter, int64, error) {
 	var encounters []models.Encounter
 	var total int64
 
+	ownerID, err := dogOwnerID(s.db, dogID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Encounters with dogs of blocked users are hidden
+	blockedDogs := "(SELECT id FROM dogs WHERE user_id IN (" + blockedUserIDsSQL + "))"
+	notBlocked := "dog1_id NOT IN " + blockedDogs + " AND dog2_id NOT IN " + blockedDogs
+
 	// Count total encounters
 	if err := s.db.Model(&models.Encounter{}).
 		Where("dog1_id = ? OR dog2_id = ?", dogID, dogID).
+		Where(notBlocked, ownerID, ownerID, ownerID, ownerID).
 		Count(&total).Error; err != nil {
 		return nil, 0, errors.New("failed to count encounters")
 	}
 
 	// Get paginated encounters with related dogs
-	if err := s.db.Preload("Dog1").Preload("Dog2").
+	if err := s.db.Preload("Dog1").Preload("Dog2").Preload("Place").
 		Where("dog1_id = ? OR dog2_id = ?", dogID, dogID).
+		Where(notBlocked, ownerID, ownerID, ownerID, ownerID).
 		Order("timestamp DESC").
 		Limit(limit).Offset(offset).
 		Find(&encounters).Error; err != nil {
 		return nil, 0, errors.New("failed to get encounters")
 	}
 
+	// Encounter history is visible to other users, so only the area is shown
+	for i := range encounters {
+		encounters[i].Location = s.locationPrivacy.Snap(encounters[i].Location)
+	}
+
 	return encounters, total, nil
 }
 
-// GetNearbyDogs returns dogs within a radius of a given location
-func (s *EncounterService) GetNearbyDogs(dogID uuid.UUID, latitude, longitude float64, radiusMeters float64) ([]models.Dog, error) {
-	var dogs []models.Dog
+// NearbyDog is a dog near the viewer's dog. Its location is snapped to the
+// privacy grid and its distance rounded to match.
+type NearbyDog struct {
+	Dog            models.Dog      `json:"dog"`
+	Location       models.GeoPoint `json:"location"`
+	DistanceMeters float64         `json:"distance_meters"`
+}
+
+// GetNearbyDogs returns dogs within a radius of one of the user's dogs,
+// nearest first. Dogs whose owners are in a block relationship with the
+// user, do not share their location with the user or are in their home zone
+// are left out.
+func (s *EncounterService) GetNearbyDogs(userID uuid.UUID, dogID uuid.UUID, radiusMeters float64) ([]NearbyDog, error) {
+	var count int64
+	if err := s.db.Model(&models.Dog{}).Where("id = ? AND user_id = ?", dogID, userID).Count(&count).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog")
+	}
+	if count == 0 {
+		return nil, utils.ErrNotFound
+	}
+
+	latitude, longitude, found, err := s.presence.Position(dogID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("dog location not found")
+	}
+
+	matches, err := s.presence.Nearby(latitude, longitude, radiusMeters, time.Time{}, dogID)
+	if err != nil {
+		return nil, err
+	}
+	matches, err = s.withoutBlockedDogs(userID.String(), matches)
+	if err != nil {
+		return nil, err
+	}
+	matches, err = s.locationPrivacy.Visible(userID, matches)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
 
-	// Find dogs within radius using PostGIS
-	if err := s.db.Raw(`
-		SELECT DISTINCT d.* 
-		FROM dogs d
-		JOIN device_locations dl ON d.id = dl.dog_id
-		JOIN users u ON d.user_id = u.id
-		WHERE d.id != ?
-		AND u.visibility = 'public'
-		AND ST_DWithin(
-			dl.location::geography,
-			ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography,
-			?
-		)
-		AND dl.updated_at > NOW() - INTERVAL '1 hour'
-		ORDER BY ST_Distance(
-			dl.location::geography,
-			ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography
-		)
-	`, dogID, longitude, latitude, radiusMeters, longitude, latitude).
-		Find(&dogs).Error; err != nil {
+	ids := make([]uuid.UUID, len(matches))
+	for i, match := range matches {
+		ids[i] = match.DogID
+	}
+
+	var dogs []models.Dog
+	if err := s.db.Where("id IN ?", ids).Find(&dogs).Error; err != nil {
 		return nil, errors.New("failed to find nearby dogs")
 	}
+	byID := make(map[uuid.UUID]models.Dog, len(dogs))
+	for _, dog := range dogs {
+		byID[dog.ID] = dog
+	}
+
+	origin := orb.Point{longitude, latitude}
+	nearby := make([]NearbyDog, 0, len(dogs))
+	for _, match := range matches {
+		dog, ok := byID[match.DogID]
+		if !ok {
+			continue
+		}
+		location := s.locationPrivacy.Snap(match.Location)
+		nearby = append(nearby, NearbyDog{
+			Dog:            dog,
+			Location:       location,
+			DistanceMeters: s.locationPrivacy.RoundDistance(geo.Distance(origin, orb.Point(location))),
+		})
+	}
 
-	return dogs, nil
+	// Order by the coarse distance only, so the order reveals no more than it
+	sort.Slice(nearby, func(i, j int) bool {
+		if nearby[i].DistanceMeters != nearby[j].DistanceMeters {
+			return nearby[i].DistanceMeters < nearby[j].DistanceMeters
+		}
+		return nearby[i].Dog.ID.String() < nearby[j].Dog.ID.String()
+	})
+
+	return nearby, nil
 }
 
-// GetLocationHistory returns location history for a dog
-func (s *EncounterService) GetLocationHistory(dogID uuid.UUID, hours int) ([]models.DeviceLocation, error) {
-	var locations []models.DeviceLocation
+// withoutBlockedDogs drops matches whose owners are in a block relationship with the user
+func (s *EncounterService) withoutBlockedDogs(userID string, matches []PresenceMatch) ([]PresenceMatch, error) {
+	if len(matches) == 0 {
+		return matches, nil
+	}
 
-	// Get location history (this would require storing historical locations)
-	// For now, just return current location
-	if err := s.db.Where("dog_id = ? AND updated_at > ?", 
-		dogID, time.Now().Add(-time.Duration(hours)*time.Hour)).
-		Order("updated_at DESC").
-		Find(&locations).Error; err != nil {
-		return nil, errors.New("failed to get location history")
+	ids := make([]uuid.UUID, len(matches))
+	for i, match := range matches {
+		ids[i] = match.DogID
 	}
 
-	return locations, nil
+	var blocked []uuid.UUID
+	if err := s.db.Model(&models.Dog{}).
+		Where("id IN ? AND user_id IN ("+blockedUserIDsSQL+")", ids, userID, userID).
+		Pluck("id", &blocked).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to filter blocked dogs")
+	}
+	if len(blocked) == 0 {
+		return matches, nil
+	}
+
+	isBlocked := make(map[uuid.UUID]bool, len(blocked))
+	for _, id := range blocked {
+		isBlocked[id] = true
+	}
+	allowed := make([]PresenceMatch, 0, len(matches))
+	for _, match := range matches {
+		if !isBlocked[match.DogID] {
+			allowed = append(allowed, match)
+		}
+	}
+	return allowed, nil
 }
 
-// CleanupOldLocations removes location data older than specified duration
-func (s *EncounterService) CleanupOldLocations(olderThan time.Duration) error {
-	cutoff := time.Now().Add(-olderThan)
-	
-	if err := s.db.Where("updated_at < ?", cutoff).Delete(&models.DeviceLocation{}).Error; err != nil {
-		return errors.New("failed to cleanup old locations")
+// GetLocationHistory returns the recorded locations of one of the user's
+// dogs over the last hours. Points older than the downsampling age are
+// thinned out and points past the retention period are gone.
+func (s *EncounterService) GetLocationHistory(userID uuid.UUID, dogID uuid.UUID, hours int) ([]LocationPoint, error) {
+	var count int64
+	if err := s.db.Model(&models.Dog{}).Where("id = ? AND user_id = ?", dogID, userID).Count(&count).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog")
+	}
+	if count == 0 {
+		return nil, utils.ErrNotFound
 	}
 
-	return nil
-}
\ No newline at end of file
+	return s.locationHistory.GetHistory(dogID, hours)
+}
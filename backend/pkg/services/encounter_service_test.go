@@ -0,0 +1,187 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncounterService_DwellBasedDetection(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	cfg := ctx.Config
+	cfg.Encounter = config.EncounterConfig{
+		DwellTime:         2 * time.Minute,
+		SeparationTimeout: 5 * time.Minute,
+	}
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, cfg)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+
+	require.NoError(t, encounterService.UpdateDeviceLocation(user1.ID, LocationUpdateRequest{DogID: dog1.ID, Latitude: 52.5200, Longitude: 13.4050}))
+	require.NoError(t, encounterService.UpdateDeviceLocation(user2.ID, LocationUpdateRequest{DogID: dog2.ID, Latitude: 52.5201, Longitude: 13.4050}))
+
+	// In range, but not yet for the dwell time
+	encounters, err := encounterService.DetectEncounters(user1.ID, dog1.ID, 50)
+	require.NoError(t, err)
+	assert.Empty(t, encounters)
+
+	var session models.ProximitySession
+	require.NoError(t, ctx.DB.Where("ended_at IS NULL").First(&session).Error)
+	assert.Nil(t, session.EncounterID)
+
+	// Only the owner can run detection for a dog
+	_, err = encounterService.DetectEncounters(user2.ID, dog1.ID, 50)
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+
+	// The dogs have been in range for three minutes, last seen together a
+	// minute ago
+	require.NoError(t, ctx.DB.Model(&session).Updates(map[string]interface{}{
+		"started_at":   time.Now().Add(-3 * time.Minute),
+		"last_seen_at": time.Now().Add(-time.Minute),
+	}).Error)
+
+	// A position dog1 reported before then does not extend the session
+	require.NoError(t, encounterService.presence.Update(dog1.ID, 52.5200, 13.4050, time.Now().Add(-2*time.Minute)))
+	encounters, err = encounterService.DetectEncounters(user2.ID, dog2.ID, 50)
+	require.NoError(t, err)
+	assert.Empty(t, encounters)
+
+	require.NoError(t, encounterService.UpdateDeviceLocation(user1.ID, LocationUpdateRequest{DogID: dog1.ID, Latitude: 52.5200, Longitude: 13.4050}))
+	encounters, err = encounterService.DetectEncounters(user2.ID, dog2.ID, 50)
+	require.NoError(t, err)
+	require.Len(t, encounters, 1)
+	assert.Equal(t, models.DetectionMethodGPS, encounters[0].DetectionMethod)
+	assert.GreaterOrEqual(t, encounters[0].DurationSeconds, 180)
+	require.NotNil(t, encounters[0].MinDistanceMeters)
+	assert.InDelta(t, 11, *encounters[0].MinDistanceMeters, 2)
+
+	assert.Equal(t, []models.DetectionMethod{models.DetectionMethodGPS}, encounters[0].DetectionMethods)
+	gpsConfidence := encounters[0].Confidence
+
+	// Further pings extend the same encounter
+	encounters, err = encounterService.DetectEncounters(user1.ID, dog1.ID, 50)
+	require.NoError(t, err)
+	assert.Empty(t, encounters)
+
+	dog1Beacons, err := encounterService.GetBeaconIDs(user1.ID, dog1.ID, 1)
+	require.NoError(t, err)
+	dog2Beacons, err := encounterService.GetBeaconIDs(user2.ID, dog2.ID, 1)
+	require.NoError(t, err)
+
+	// Bluetooth reports from both dogs merge into the GPS encounter
+	bluetooth, err := encounterService.CreateBluetoothEncounter(user2.ID, EncounterDetectionRequest{
+		DogID:       dog2.ID,
+		EphemeralID: dog1Beacons[0].ID,
+		Method:      models.DetectionMethodBluetooth,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ctx.DB.First(&session, "id = ?", session.ID).Error)
+	assert.Equal(t, *session.EncounterID, bluetooth.ID)
+	assert.Equal(t, models.DetectionMethodGPS, bluetooth.DetectionMethod)
+	assert.ElementsMatch(t, []models.DetectionMethod{models.DetectionMethodGPS, models.DetectionMethodBluetooth}, bluetooth.DetectionMethods)
+	assert.Greater(t, bluetooth.Confidence, gpsConfidence)
+
+	bluetooth, err = encounterService.CreateBluetoothEncounter(user1.ID, EncounterDetectionRequest{
+		DogID:       dog1.ID,
+		EphemeralID: dog2Beacons[0].ID,
+		Method:      models.DetectionMethodBluetooth,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, *session.EncounterID, bluetooth.ID)
+	assert.Equal(t, 3, bluetooth.DetectionCount)
+
+	// The dogs separated six minutes ago
+	separatedAt := time.Now().Add(-6 * time.Minute)
+	require.NoError(t, ctx.DB.Model(&models.ProximitySession{}).Where("id = ?", session.ID).Update("last_seen_at", separatedAt).Error)
+	require.NoError(t, encounterService.presence.Update(dog2.ID, 52.5201, 13.4050, separatedAt))
+
+	encounters, err = encounterService.DetectEncounters(user1.ID, dog1.ID, 50)
+	require.NoError(t, err)
+	assert.Empty(t, encounters)
+
+	require.NoError(t, ctx.DB.First(&session, "id = ?", session.ID).Error)
+	require.NotNil(t, session.EndedAt)
+
+	var encounter models.Encounter
+	require.NoError(t, ctx.DB.First(&encounter, "id = ?", *session.EncounterID).Error)
+	require.NotNil(t, encounter.EndedAt)
+	assert.WithinDuration(t, separatedAt, *encounter.EndedAt, time.Second)
+
+	var total int64
+	ctx.DB.Model(&models.Encounter{}).Count(&total)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestEncounterService_BluetoothEncounterLocation(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, ctx.Config)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+	beacons, err := encounterService.GetBeaconIDs(user1.ID, dog1.ID, 1)
+	require.NoError(t, err)
+	sighting := EncounterDetectionRequest{
+		DogID:       dog2.ID,
+		EphemeralID: beacons[0].ID,
+		Method:      models.DetectionMethodBluetooth,
+	}
+
+	// Without a reported or known position no location is stored
+	encounter, err := encounterService.CreateBluetoothEncounter(user2.ID, sighting)
+	require.NoError(t, err)
+	var missing bool
+	require.NoError(t, ctx.DB.Raw("SELECT location IS NULL FROM encounters WHERE id = ?", encounter.ID).Scan(&missing).Error)
+	assert.True(t, missing)
+
+	// A later sighting falls back to where the dog was last seen
+	require.NoError(t, encounterService.UpdateDeviceLocation(user2.ID, LocationUpdateRequest{DogID: dog2.ID, Latitude: 52.5200, Longitude: 13.4050}))
+	encounter, err = encounterService.CreateBluetoothEncounter(user2.ID, sighting)
+	require.NoError(t, err)
+	var stored models.Encounter
+	require.NoError(t, ctx.DB.First(&stored, "id = ?", encounter.ID).Error)
+	assert.InDelta(t, 52.5200, stored.Location.Lat(), 0.0001)
+	assert.InDelta(t, 13.4050, stored.Location.Lng(), 0.0001)
+
+	// Malformed sightings are client errors
+	_, err = encounterService.CreateBluetoothEncounter(user2.ID, EncounterDetectionRequest{DogID: dog2.ID, Method: models.DetectionMethodBluetooth})
+	status, _ := utils.HTTPError(err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	offMap := models.NewGeoPoint(95, 13.4050)
+	sighting.Location = &offMap
+	_, err = encounterService.CreateBluetoothEncounter(user2.ID, sighting)
+	status, _ = utils.HTTPError(err)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestEncounter_AddDetection(t *testing.T) {
+	var encounter models.Encounter
+
+	encounter.AddDetection(models.DetectionMethodBluetooth)
+	assert.Equal(t, models.DetectionMethodBluetooth, encounter.DetectionMethod)
+	assert.Equal(t, 0.7, encounter.Confidence)
+
+	// The other dog reporting the same meeting
+	encounter.AddDetection(models.DetectionMethodBluetooth)
+	assert.Equal(t, 0.85, encounter.Confidence)
+
+	encounter.AddDetection(models.DetectionMethodGPS)
+	assert.Equal(t, []models.DetectionMethod{models.DetectionMethodBluetooth, models.DetectionMethodGPS}, encounter.DetectionMethods)
+	assert.Equal(t, 3, encounter.DetectionCount)
+	assert.Equal(t, 0.94, encounter.Confidence)
+}
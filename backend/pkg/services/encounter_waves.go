@@ -0,0 +1,217 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// defaultWaveWindow is how long a wave waits to be waved back
+const defaultWaveWindow = 48 * time.Hour
+
+// WaveResult is the user's wave and whether it connected the dogs
+type WaveResult struct {
+	Wave    models.EncounterWave `json:"wave"`
+	Matched bool                 `json:"matched"`
+}
+
+// WaveAtEncounter waves from the user's dog at the other dog of the
+// encounter. If the other owner already waved and their wave has not
+// expired, the dogs follow each other. Waving again while a wave is pending
+// or matched returns it unchanged; an expired wave is renewed.
+func (s *EncounterService) WaveAtEncounter(userID uuid.UUID, encounterID uuid.UUID) (*WaveResult, error) {
+	var encounter models.Encounter
+	err := s.db.Preload("Dog1").Preload("Dog2").Where("id = ?", encounterID).First(&encounter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find encounter")
+	}
+
+	dog, other := encounter.Dog1, encounter.Dog2
+	if other.UserID == userID {
+		dog, other = other, dog
+	}
+	if dog.UserID != userID {
+		return nil, utils.ErrNotFound
+	}
+	if other.UserID == userID {
+		return nil, utils.NewAPIError("INVALID_ACTION", "Cannot wave at your own dog", nil)
+	}
+	if err := ensureCanInteract(s.db, userID.String(), other.UserID.String()); err != nil {
+		return nil, err
+	}
+
+	var result WaveResult
+	var waved, matched bool
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Both owners waving at once must see each other's wave
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", encounter.ID).First(&models.Encounter{}).Error; err != nil {
+			return utils.WrapError(err, "failed to lock encounter")
+		}
+		if err := expireWaves(tx.Where("encounter_id = ?", encounter.ID), now); err != nil {
+			return err
+		}
+
+		wave, renewed, err := s.renewWave(tx, encounter.ID, dog, other, now)
+		if err != nil {
+			return err
+		}
+		result.Wave = *wave
+		waved = renewed
+		if wave.Status != models.WaveStatusPending {
+			result.Matched = wave.Status == models.WaveStatusMatched
+			return nil
+		}
+
+		var theirs models.EncounterWave
+		err = tx.Where("encounter_id = ? AND dog_id = ? AND status = ?", encounter.ID, other.ID, models.WaveStatusPending).First(&theirs).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return utils.WrapError(err, "failed to find wave")
+		}
+
+		if err := connectDogs(tx, dog.ID, other.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.EncounterWave{}).
+			Where("id IN ?", []uuid.UUID{wave.ID, theirs.ID}).
+			Updates(map[string]interface{}{"status": models.WaveStatusMatched, "matched_at": now}).Error; err != nil {
+			return utils.WrapError(err, "failed to match waves")
+		}
+		result.Wave.Status = models.WaveStatusMatched
+		result.Wave.MatchedAt = &now
+		result.Matched = true
+		matched = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"encounter_id": encounter.ID,
+		"dog_id":       other.ID,
+		"other_dog_id": dog.ID,
+		"matched":      result.Matched,
+	}
+	if matched {
+		// The dogs now follow each other
+		for _, follow := range []struct {
+			userID uuid.UUID
+			dogID  uuid.UUID
+		}{{userID, other.ID}, {other.UserID, dog.ID}} {
+			if err := s.timeline.Follow(follow.userID.String(), follow.dogID); err != nil {
+				log.Printf("Failed to backfill dog %s into timeline of user %s: %v", follow.dogID, follow.userID, err)
+			}
+		}
+
+		s.realtime.Notify([]uuid.UUID{other.UserID}, RealtimeEventWave, data)
+		s.realtime.Notify([]uuid.UUID{userID}, RealtimeEventWave, map[string]interface{}{
+			"encounter_id": encounter.ID,
+			"dog_id":       dog.ID,
+			"other_dog_id": other.ID,
+			"matched":      true,
+		})
+	} else if waved {
+		s.realtime.Notify([]uuid.UUID{other.UserID}, RealtimeEventWave, data)
+	}
+
+	return &result, nil
+}
+
+// renewWave returns the dog's wave at the encounter, creating it or renewing
+// it if it expired, and whether it did either
+func (s *EncounterService) renewWave(tx *gorm.DB, encounterID uuid.UUID, dog models.Dog, other models.Dog, now time.Time) (*models.EncounterWave, bool, error) {
+	var wave models.EncounterWave
+	err := tx.Where("encounter_id = ? AND dog_id = ?", encounterID, dog.ID).First(&wave).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, utils.WrapError(err, "failed to find wave")
+	}
+
+	if err == nil && wave.Status != models.WaveStatusExpired {
+		return &wave, false, nil
+	}
+	if err == nil {
+		wave.Status = models.WaveStatusPending
+		wave.ExpiresAt = now.Add(s.waveWindow)
+		wave.CreatedAt = now
+		if err := tx.Model(&wave).Select("status", "expires_at", "created_at").Updates(&wave).Error; err != nil {
+			return nil, false, utils.WrapError(err, "failed to renew wave")
+		}
+		return &wave, true, nil
+	}
+
+	wave = models.EncounterWave{
+		EncounterID: encounterID,
+		DogID:       dog.ID,
+		ToDogID:     other.ID,
+		UserID:      dog.UserID,
+		Status:      models.WaveStatusPending,
+		ExpiresAt:   now.Add(s.waveWindow),
+		CreatedAt:   now,
+	}
+	if err := tx.Omit(clause.Associations).Create(&wave).Error; err != nil {
+		return nil, false, utils.WrapError(err, "failed to create wave")
+	}
+	return &wave, true, nil
+}
+
+// connectDogs makes the dogs follow each other. Both owners waved, which is
+// the approval an owner requiring follow approval would otherwise give, so
+// no follow request is needed in either direction.
+func connectDogs(tx *gorm.DB, dogID uuid.UUID, otherDogID uuid.UUID) error {
+	follows := []models.Follower{
+		{FollowerDogID: dogID, FollowedDogID: otherDogID},
+		{FollowerDogID: otherDogID, FollowedDogID: dogID},
+	}
+	if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&follows).Error; err != nil {
+		return utils.WrapError(err, "failed to connect dogs")
+	}
+	return nil
+}
+
+// expireWaves marks the pending waves in scope whose window has passed as expired
+func expireWaves(scope *gorm.DB, now time.Time) error {
+	if err := scope.Model(&models.EncounterWave{}).
+		Where("status = ? AND expires_at <= ?", models.WaveStatusPending, now).
+		Update("status", models.WaveStatusExpired).Error; err != nil {
+		return utils.WrapError(err, "failed to expire waves")
+	}
+	return nil
+}
+
+// GetReceivedWaves returns the pending waves at the user's dogs, newest
+// first, leaving out waves from users in a block relationship with the user
+func (s *EncounterService) GetReceivedWaves(userID uuid.UUID, limit int, offset int) ([]models.EncounterWave, int64, error) {
+	query := s.db.Model(&models.EncounterWave{}).
+		Where("to_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID).
+		Where("status = ? AND expires_at > ?", models.WaveStatusPending, time.Now()).
+		Where("user_id NOT IN ("+blockedUserIDsSQL+")", userID, userID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to count waves")
+	}
+
+	var waves []models.EncounterWave
+	if err := query.Preload("Dog").
+		Order("created_at DESC").
+		Limit(limit).Offset(offset).
+		Find(&waves).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to get waves")
+	}
+
+	return waves, total, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncounterService_WaveAtEncounter(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, ctx.Config)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	stranger := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+
+	dog1ID, dog2ID := models.OrderedDogPair(dog1.ID, dog2.ID)
+	encounter := models.Encounter{Dog1ID: dog1ID, Dog2ID: dog2ID, Location: models.NewGeoPoint(52.52, 13.405), Timestamp: time.Now(), DetectionMethod: models.DetectionMethodGPS}
+	require.NoError(t, ctx.DB.Create(&encounter).Error)
+
+	_, err := encounterService.WaveAtEncounter(stranger.ID, encounter.ID)
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+
+	first, err := encounterService.WaveAtEncounter(user1.ID, encounter.ID)
+	require.NoError(t, err)
+	assert.False(t, first.Matched)
+	assert.Equal(t, models.WaveStatusPending, first.Wave.Status)
+
+	received, total, err := encounterService.GetReceivedWaves(user2.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, received, 1)
+	assert.Equal(t, dog1.ID, received[0].DogID)
+
+	// A wave back after the first one expired does not connect the dogs
+	require.NoError(t, ctx.DB.Model(&models.EncounterWave{}).Where("id = ?", first.Wave.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	second, err := encounterService.WaveAtEncounter(user2.ID, encounter.ID)
+	require.NoError(t, err)
+	assert.False(t, second.Matched)
+
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+	post, err := postService.CreatePost(user2.ID.String(), CreatePostRequest{DogID: dog2.ID.String(), Content: "nice to meet you"})
+	require.NoError(t, err)
+	posts, _, err := postService.GetTimeline(user1.ID.String(), 20, 0)
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+
+	// Until the first owner waves again
+	renewed, err := encounterService.WaveAtEncounter(user1.ID, encounter.ID)
+	require.NoError(t, err)
+	assert.True(t, renewed.Matched)
+
+	var follows int64
+	require.NoError(t, ctx.DB.Model(&models.Follower{}).
+		Where("(follower_dog_id = ? AND followed_dog_id = ?) OR (follower_dog_id = ? AND followed_dog_id = ?)", dog1.ID, dog2.ID, dog2.ID, dog1.ID).
+		Count(&follows).Error)
+	assert.Equal(t, int64(2), follows)
+
+	// and the dogs' posts are backfilled into each other's timelines
+	posts, _, err = postService.GetTimeline(user1.ID.String(), 20, 0)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, post.ID, posts[0].ID)
+
+	// Waving again keeps the match
+	again, err := encounterService.WaveAtEncounter(user2.ID, encounter.ID)
+	require.NoError(t, err)
+	assert.True(t, again.Matched)
+}
+
+func TestEncounterService_WaveAtEncounterBlocked(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, ctx.Config)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+
+	dog1ID, dog2ID := models.OrderedDogPair(dog1.ID, dog2.ID)
+	encounter := models.Encounter{Dog1ID: dog1ID, Dog2ID: dog2ID, Location: models.NewGeoPoint(52.52, 13.405), Timestamp: time.Now(), DetectionMethod: models.DetectionMethodGPS}
+	require.NoError(t, ctx.DB.Create(&encounter).Error)
+
+	require.NoError(t, ctx.DB.Create(&models.BlockedUser{BlockerID: user2.ID.String(), BlockedID: user1.ID.String()}).Error)
+
+	_, err := encounterService.WaveAtEncounter(user1.ID, encounter.ID)
+	assert.ErrorIs(t, err, utils.ErrUserBlocked)
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// FollowState is where the user's dog stands with a dog it tried to follow
+type FollowState string
+
+const (
+	FollowStateNone      FollowState = "none"
+	FollowStateRequested FollowState = "requested"
+	FollowStateFollowing FollowState = "following"
+)
+
+// FollowRequestDirection selects the requests made to or by the user's dogs
+type FollowRequestDirection string
+
+const (
+	FollowRequestsIncoming FollowRequestDirection = "incoming"
+	FollowRequestsOutgoing FollowRequestDirection = "outgoing"
+)
+
+// requiresFollowApproval reports whether following the user's dogs needs
+// the user's approval, because the profile is private or the user asked to
+// approve followers
+func requiresFollowApproval(db *gorm.DB, userID uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.Model(&models.User{}).
+		Where("id = ?", userID).
+		Where("visibility = ? OR EXISTS (SELECT 1 FROM safety_settings WHERE safety_settings.user_id = users.id AND safety_settings.require_follow_approval)", models.VisibilityPrivate).
+		Count(&count).Error; err != nil {
+		return false, utils.WrapError(err, "failed to check follow approval")
+	}
+	return count > 0, nil
+}
+
+// GetFollowRequests returns the pending requests to follow the user's dogs
+// (incoming) or made by them (outgoing), newest first
+func (s *PostService) GetFollowRequests(userID string, direction FollowRequestDirection, limit int, offset int) ([]models.FollowRequest, int64, error) {
+	query := s.db.Model(&models.FollowRequest{})
+	switch direction {
+	case FollowRequestsIncoming:
+		query = query.Where("followed_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID)
+	case FollowRequestsOutgoing:
+		query = query.Where("follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID)
+	default:
+		return nil, 0, utils.NewValidationError([]utils.ValidationError{{Field: "direction", Message: "direction must be incoming or outgoing"}})
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to count follow requests")
+	}
+
+	var requests []models.FollowRequest
+	if err := query.Preload("FollowerDog").Preload("FollowedDog").
+		Order("created_at DESC").
+		Limit(limit).Offset(offset).
+		Find(&requests).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to get follow requests")
+	}
+
+	return requests, total, nil
+}
+
+// ApproveFollowRequest turns a request to follow one of the user's dogs
+// into a follow
+func (s *PostService) ApproveFollowRequest(userID string, requestID uuid.UUID) (*models.Follower, error) {
+	request, err := s.findFollowRequest("followed_dog_id", userID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	requesterID, err := dogOwnerID(s.db, request.FollowerDogID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureCanInteract(s.db, userID, requesterID); err != nil {
+		return nil, err
+	}
+
+	follow := models.Follower{
+		FollowerDogID: request.FollowerDogID,
+		FollowedDogID: request.FollowedDogID,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(request)
+		if result.Error != nil {
+			return utils.WrapError(result.Error, "failed to delete follow request")
+		}
+		if result.RowsAffected == 0 {
+			return utils.ErrNotFound // cancelled meanwhile
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&follow).Error; err != nil {
+			return utils.WrapError(err, "failed to follow dog")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.timeline.Follow(requesterID, follow.FollowedDogID); err != nil {
+		log.Printf("Failed to backfill dog %s into timeline of user %s: %v", follow.FollowedDogID, requesterID, err)
+	}
+
+	if requesterUUID, err := uuid.Parse(requesterID); err == nil {
+		s.realtime.Notify([]uuid.UUID{requesterUUID}, RealtimeEventFollowApproved, map[string]interface{}{
+			"request_id":      request.ID,
+			"follower_dog_id": follow.FollowerDogID,
+			"followed_dog_id": follow.FollowedDogID,
+		})
+	}
+	return &follow, nil
+}
+
+// DenyFollowRequest rejects a request to follow one of the user's dogs
+func (s *PostService) DenyFollowRequest(userID string, requestID uuid.UUID) error {
+	return s.deleteFollowRequest("followed_dog_id", userID, requestID)
+}
+
+// CancelFollowRequest withdraws a request made by one of the user's dogs
+func (s *PostService) CancelFollowRequest(userID string, requestID uuid.UUID) error {
+	return s.deleteFollowRequest("follower_dog_id", userID, requestID)
+}
+
+// findFollowRequest returns the request if the user owns the dog in column
+func (s *PostService) findFollowRequest(column string, userID string, requestID uuid.UUID) (*models.FollowRequest, error) {
+	var request models.FollowRequest
+	err := s.db.Where("id = ?", requestID).
+		Where(column+" IN (SELECT id FROM dogs WHERE user_id = ?)", userID).
+		First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find follow request")
+	}
+	return &request, nil
+}
+
+// deleteFollowRequest deletes the request if the user owns the dog in column
+func (s *PostService) deleteFollowRequest(column string, userID string, requestID uuid.UUID) error {
+	result := s.db.Where("id = ?", requestID).
+		Where(column+" IN (SELECT id FROM dogs WHERE user_id = ?)", userID).
+		Delete(&models.FollowRequest{})
+	if result.Error != nil {
+		return utils.WrapError(result.Error, "failed to delete follow request")
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrNotFound
+	}
+	return nil
+}
+
+// GetFollowers returns the dogs following the dog, most recent first
+func (s *PostService) GetFollowers(viewerID string, dogID uuid.UUID, limit int, offset int) ([]models.Dog, int64, error) {
+	return s.followList(viewerID, dogID, "followed_dog_id", "follower_dog_id", limit, offset)
+}
+
+// GetFollowing returns the dogs the dog follows, most recent first
+func (s *PostService) GetFollowing(viewerID string, dogID uuid.UUID, limit int, offset int) ([]models.Dog, int64, error) {
+	return s.followList(viewerID, dogID, "follower_dog_id", "followed_dog_id", limit, offset)
+}
+
+// followList lists the dogs in column other of the dog's follows. The
+// lists of a private user's dog are only shown to the user and to users
+// whose dogs follow it, and dogs of users in a block relationship with the
+// viewer are left out.
+func (s *PostService) followList(viewerID string, dogID uuid.UUID, column string, other string, limit int, offset int) ([]models.Dog, int64, error) {
+	var owner models.User
+	err := s.db.Where("id = (SELECT user_id FROM dogs WHERE id = ?)", dogID).First(&owner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, 0, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, utils.WrapError(err, "failed to find dog owner")
+	}
+	if err := ensureNotBlocked(s.db, viewerID, owner.ID.String()); err != nil {
+		return nil, 0, utils.ErrNotFound
+	}
+
+	if owner.IsPrivate() && owner.ID.String() != viewerID {
+		var follows int64
+		if err := s.db.Model(&models.Follower{}).
+			Where("followed_dog_id = ? AND follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", dogID, viewerID).
+			Count(&follows).Error; err != nil {
+			return nil, 0, utils.WrapError(err, "failed to check follow")
+		}
+		if follows == 0 {
+			return nil, 0, utils.ErrForbidden
+		}
+	}
+
+	query := s.db.Table("followers").
+		Joins("JOIN dogs ON dogs.id = followers."+other).
+		Where("followers."+column+" = ?", dogID).
+		Where("dogs.user_id NOT IN ("+blockedUserIDsSQL+")", viewerID, viewerID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to count follows")
+	}
+
+	var dogs []models.Dog
+	if err := query.Select("dogs.*").
+		Order("followers.created_at DESC").
+		Limit(limit).Offset(offset).
+		Find(&dogs).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to get follows")
+	}
+
+	return dogs, total, nil
+}
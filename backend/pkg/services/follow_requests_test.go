@@ -0,0 +1,106 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostService_FollowRequests(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	owner := testutils.CreateTestUser(t, ctx.DB)
+	requester := testutils.CreateTestUser(t, ctx.DB)
+	stranger := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, owner.ID.String())
+	requesterDog := testutils.CreateTestDog(t, ctx.DB, requester.ID.String())
+	testutils.CreateTestDog(t, ctx.DB, stranger.ID.String())
+
+	require.NoError(t, ctx.DB.Model(owner).Update("visibility", models.VisibilityPrivate).Error)
+
+	state, err := postService.FollowDog(dog.ID.String(), requester.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, FollowStateRequested, state)
+
+	// Following again while pending cancels the request
+	state, err = postService.FollowDog(dog.ID.String(), requester.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, FollowStateNone, state)
+
+	_, err = postService.FollowDog(dog.ID.String(), requester.ID.String())
+	require.NoError(t, err)
+
+	outgoing, total, err := postService.GetFollowRequests(requester.ID.String(), FollowRequestsOutgoing, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, outgoing, 1)
+
+	incoming, _, err := postService.GetFollowRequests(owner.ID.String(), FollowRequestsIncoming, 20, 0)
+	require.NoError(t, err)
+	require.Len(t, incoming, 1)
+	assert.Equal(t, requesterDog.ID, incoming[0].FollowerDogID)
+
+	// Only the owner of the followed dog can approve, and the stranger
+	// cannot see the private dog's followers
+	_, err = postService.ApproveFollowRequest(requester.ID.String(), incoming[0].ID)
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+
+	follow, err := postService.ApproveFollowRequest(owner.ID.String(), incoming[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, requesterDog.ID, follow.FollowerDogID)
+
+	followers, total, err := postService.GetFollowers(requester.ID.String(), dog.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, followers, 1)
+	assert.Equal(t, requesterDog.ID, followers[0].ID)
+
+	_, _, err = postService.GetFollowers(stranger.ID.String(), dog.ID, 20, 0)
+	assert.ErrorIs(t, err, utils.ErrForbidden)
+
+	following, _, err := postService.GetFollowing(stranger.ID.String(), requesterDog.ID, 20, 0)
+	require.NoError(t, err)
+	require.Len(t, following, 1)
+	assert.Equal(t, dog.ID, following[0].ID)
+
+	// A public dog without follow approval is followed at once
+	state, err = postService.FollowDog(requesterDog.ID.String(), stranger.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, FollowStateFollowing, state)
+}
+
+func TestPostService_DenyFollowRequest(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	owner := testutils.CreateTestUser(t, ctx.DB)
+	requester := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, owner.ID.String())
+	testutils.CreateTestDog(t, ctx.DB, requester.ID.String())
+
+	require.NoError(t, ctx.DB.Create(&models.SafetySettings{UserID: owner.ID.String(), RequireFollowApproval: true}).Error)
+
+	state, err := postService.FollowDog(dog.ID.String(), requester.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, FollowStateRequested, state)
+
+	incoming, _, err := postService.GetFollowRequests(owner.ID.String(), FollowRequestsIncoming, 20, 0)
+	require.NoError(t, err)
+	require.Len(t, incoming, 1)
+
+	require.NoError(t, postService.DenyFollowRequest(owner.ID.String(), incoming[0].ID))
+	assert.ErrorIs(t, postService.CancelFollowRequest(requester.ID.String(), incoming[0].ID), utils.ErrNotFound)
+
+	var follows int64
+	require.NoError(t, ctx.DB.Model(&models.Follower{}).Where("followed_dog_id = ?", dog.ID).Count(&follows).Error)
+	assert.Zero(t, follows)
+}
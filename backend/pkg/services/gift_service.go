@@ -5,18 +5,22 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 
+	"github.com/doggyclub/backend/config"
 	"github.com/doggyclub/backend/pkg/models"
 )
 
 type GiftService struct {
-	db *gorm.DB
+	db       *gorm.DB
+	realtime *RealtimeService
 }
 
-func NewGiftService(db *gorm.DB) *GiftService {
+func NewGiftService(db *gorm.DB, redis *redis.Client, cfg config.Config) *GiftService {
 	return &GiftService{
-		db: db,
+		db:       db,
+		realtime: NewRealtimeService(redis, cfg),
 	}
 }
 
@@ -33,11 +37,11 @@ func (s *GiftService) GetAvailableGiftTypes() []string {
 	return []string{"bone", "ball", "treat", "toy", "heart", "star", "diamond"}
 }
 
-// SendGift sends a virtual gift between dogs
-func (s *GiftService) SendGift(req SendGiftRequest) (*models.Gift, error) {
+// SendGift sends a virtual gift from one of the user's dogs to another dog
+func (s *GiftService) SendGift(userID uuid.UUID, req SendGiftRequest) (*models.Gift, error) {
 	// Validate that both dogs exist
 	var senderDog, receiverDog models.Dog
-	if err := s.db.Where("id = ?", req.SenderDogID).First(&senderDog).Error; err != nil {
+	if err := s.db.Where("id = ? AND user_id = ?", req.SenderDogID, userID).First(&senderDog).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("sender dog not found")
 		}
@@ -56,6 +60,10 @@ func (s *GiftService) SendGift(req SendGiftRequest) (*models.Gift, error) {
 		return nil, errors.New("cannot send gift to the same dog")
 	}
 
+	if err := ensureCanInteract(s.db, userID.String(), receiverDog.UserID.String()); err != nil {
+		return nil, err
+	}
+
 	// Validate gift type
 	validGiftTypes := s.GetAvailableGiftTypes()
 	isValidGiftType := false
@@ -82,6 +90,15 @@ func (s *GiftService) SendGift(req SendGiftRequest) (*models.Gift, error) {
 		return nil, errors.New("failed to send gift")
 	}
 
+	if receiverDog.UserID != userID {
+		s.realtime.Notify([]uuid.UUID{receiverDog.UserID}, RealtimeEventGift, map[string]interface{}{
+			"gift_id":         gift.ID,
+			"gift_type":       gift.GiftType,
+			"sender_dog_id":   gift.SenderDogID,
+			"receiver_dog_id": gift.ReceiverDogID,
+		})
+	}
+
 	return &gift, nil
 }
 
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxHashtagLength matches the size of hashtags.tag
+	maxHashtagLength = 50
+
+	// maxTrendingHashtags is how many trending hashtags are computed and cached
+	maxTrendingHashtags = 50
+
+	defaultTrendingInterval = 10 * time.Minute
+	defaultTrendingWindow   = 72 * time.Hour
+	defaultTrendingHalfLife = 6 * time.Hour
+)
+
+// taggedPostsSQL matches the posts using a hashtag
+const taggedPostsSQL = "posts.id IN (SELECT post_hashtags.post_id FROM post_hashtags JOIN hashtags ON hashtags.id = post_hashtags.hashtag_id WHERE hashtags.tag = ?)"
+
+// TrendingHashtag is a hashtag ranked by how much it has been used lately.
+// Score is the number of dogs that used the tag within the trending window,
+// each weighted by how recently it last did.
+type TrendingHashtag struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+	Posts int     `json:"posts"`
+}
+
+// HashtagService serves posts by hashtag and the trending hashtags
+type HashtagService struct {
+	db    *gorm.DB
+	cache *CacheService
+	cfg   config.HashtagConfig
+}
+
+func NewHashtagService(db *gorm.DB, redis *redis.Client, cfg config.Config) *HashtagService {
+	hashtagCfg := cfg.Hashtag
+	if hashtagCfg.TrendingInterval <= 0 {
+		hashtagCfg.TrendingInterval = defaultTrendingInterval
+	}
+	if hashtagCfg.TrendingWindow <= 0 {
+		hashtagCfg.TrendingWindow = defaultTrendingWindow
+	}
+	if hashtagCfg.TrendingHalfLife <= 0 {
+		hashtagCfg.TrendingHalfLife = defaultTrendingHalfLife
+	}
+
+	return &HashtagService{
+		db:    db,
+		cache: NewCacheService(redis, cfg),
+		cfg:   hashtagCfg,
+	}
+}
+
+// GetPosts returns a page of the posts using the tag that the user may see,
+// newest first. The cursor returned with a full page fetches the next one;
+// it is empty after the last page.
+func (s *HashtagService) GetPosts(userID string, tag string, cursor string, limit int) ([]models.Post, string, error) {
+	tags := normalizeHashtags([]string{tag})
+	if len(tags) == 0 {
+		return nil, "", utils.NewValidationError([]utils.ValidationError{{Field: "tag", Message: "tag is invalid"}})
+	}
+
+	query := s.db.Scopes(withPostDetails, visiblePostsTo(userID)).Where(taggedPostsSQL, tags[0])
+	if userID != "" {
+		query = query.Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID)
+	}
+
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", utils.NewValidationError([]utils.ValidationError{{Field: "cursor", Message: "cursor is invalid"}})
+		}
+		query = query.Where("(posts.created_at, posts.id) < (?, ?)", createdAt, id)
+	}
+
+	var posts []models.Post
+	if err := query.Order("posts.created_at DESC").Order("posts.id DESC").
+		Limit(limit + 1).
+		Find(&posts).Error; err != nil {
+		return nil, "", utils.WrapError(err, "failed to get hashtag posts")
+	}
+
+	var next string
+	if len(posts) > limit {
+		posts = posts[:limit]
+		next = encodeCursor(posts[limit-1].CreatedAt, posts[limit-1].ID)
+	}
+
+	return posts, next, nil
+}
+
+// GetTrending returns up to limit trending hashtags from the cache, ranking
+// them now if the cache is empty
+func (s *HashtagService) GetTrending(limit int) ([]TrendingHashtag, error) {
+	var trending []TrendingHashtag
+	if err := s.cache.GetTrendingHashtags(&trending); err != nil {
+		if trending, err = s.RefreshTrending(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(trending) > limit {
+		trending = trending[:limit]
+	}
+	return trending, nil
+}
+
+// StartTrendingJob runs RefreshTrending now and then every TrendingInterval
+// until ctx is done
+func (s *HashtagService) StartTrendingJob(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.cfg.TrendingInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.RefreshTrending(); err != nil {
+				log.Printf("Trending hashtags refresh failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RefreshTrending ranks the hashtags used in public posts within the
+// trending window and caches the result. Each dog counts once per tag, so
+// one dog posting a tag over and over does not make it trend, and a use
+// loses half its weight every TrendingHalfLife.
+func (s *HashtagService) RefreshTrending() ([]TrendingHashtag, error) {
+	now := time.Now()
+
+	uses := s.db.Table("post_hashtags").
+		Select("post_hashtags.hashtag_id, posts.dog_id, COUNT(*) AS posts, "+
+			"MAX(POWER(0.5, EXTRACT(EPOCH FROM (?::timestamptz - post_hashtags.created_at)) / ?)) AS weight",
+			now, s.cfg.TrendingHalfLife.Seconds()).
+		Joins("JOIN posts ON posts.id = post_hashtags.post_id").
+		Scopes(visiblePostsTo("")).
+		Where("post_hashtags.created_at > ?", now.Add(-s.cfg.TrendingWindow)).
+		Group("post_hashtags.hashtag_id, posts.dog_id")
+
+	trending := make([]TrendingHashtag, 0, maxTrendingHashtags)
+	if err := s.db.Table("(?) AS uses", uses).
+		Select("hashtags.tag, SUM(uses.weight) AS score, SUM(uses.posts) AS posts").
+		Joins("JOIN hashtags ON hashtags.id = uses.hashtag_id").
+		Group("hashtags.tag").
+		Order("score DESC, hashtags.tag").
+		Limit(maxTrendingHashtags).
+		Scan(&trending).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to rank trending hashtags")
+	}
+
+	if err := s.cache.CacheTrendingHashtags(trending, 2*s.cfg.TrendingInterval); err != nil {
+		log.Printf("Failed to cache trending hashtags: %v", err)
+	}
+	return trending, nil
+}
+
+// syncPostHashtags makes the tags the post's only hashtags, adding the ones
+// that have not been used before. Tags the post already had keep the time
+// they were first used.
+func syncPostHashtags(tx *gorm.DB, postID uuid.UUID, tags []string) error {
+	var hashtagIDs []uuid.UUID
+	if len(tags) > 0 {
+		hashtags := make([]models.Hashtag, len(tags))
+		for i, tag := range tags {
+			hashtags[i] = models.Hashtag{Tag: tag}
+		}
+		if err := tx.Omit(clause.Associations).
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "tag"}}, DoNothing: true}).
+			Create(&hashtags).Error; err != nil {
+			return utils.WrapError(err, "failed to save hashtags")
+		}
+		if err := tx.Model(&models.Hashtag{}).Where("tag IN ?", tags).Pluck("id", &hashtagIDs).Error; err != nil {
+			return utils.WrapError(err, "failed to find hashtags")
+		}
+	}
+
+	unlink := tx.Where("post_id = ?", postID)
+	if len(hashtagIDs) > 0 {
+		unlink = unlink.Where("hashtag_id NOT IN ?", hashtagIDs)
+	}
+	if err := unlink.Delete(&models.PostHashtag{}).Error; err != nil {
+		return utils.WrapError(err, "failed to unlink hashtags")
+	}
+
+	if len(hashtagIDs) == 0 {
+		return nil
+	}
+	links := make([]models.PostHashtag, len(hashtagIDs))
+	for i, hashtagID := range hashtagIDs {
+		links[i] = models.PostHashtag{PostID: postID, HashtagID: hashtagID}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error; err != nil {
+		return utils.WrapError(err, "failed to link hashtags")
+	}
+	return nil
+}
+
+// normalizeHashtags returns the tags lowercase, without the leading # and
+// trailing punctuation, and without duplicates. Tags too long to store or
+// with spaces in them are dropped.
+func normalizeHashtags(tags []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		tag = strings.TrimLeft(tag, "#")
+		tag = strings.TrimRight(tag, ".,!?;:")
+		if tag == "" || utf8.RuneCountInString(tag) > maxHashtagLength || strings.ContainsAny(tag, "# \t\r\n") || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	return result
+}
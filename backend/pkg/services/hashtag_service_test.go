@@ -0,0 +1,93 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeHashtags(t *testing.T) {
+	tags := normalizeHashtags([]string{"#Dogs", "dogs!", " park ", "", "#", "two words", "a#b", strings.Repeat("a", 51)})
+	assert.Equal(t, []string{"dogs", "park"}, tags)
+
+	// The limit is in characters, not bytes
+	umlauts := strings.Repeat("ü", 50)
+	assert.Equal(t, []string{umlauts}, normalizeHashtags([]string{umlauts, umlauts + "ü"}))
+
+	assert.Equal(t, []string{"corgi", "beach"}, normalizeHashtags(extractHashtags("#Corgi day at the #beach! #corgi")))
+}
+
+func TestHashtagService_IndexAndTrending(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+	hashtagService := NewHashtagService(ctx.DB, ctx.Redis, ctx.Config)
+
+	author := testutils.CreateTestUser(t, ctx.DB)
+	other := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, author.ID.String())
+	otherDog := testutils.CreateTestDog(t, ctx.DB, other.ID.String())
+
+	post, err := postService.CreatePost(author.ID.String(), CreatePostRequest{
+		DogID:    dog.ID.String(),
+		Content:  "Morning at the #Beach",
+		Hashtags: []string{"#zoomies"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, post.Hashtags, 2)
+
+	for i := 0; i < 3; i++ {
+		_, err = postService.CreatePost(author.ID.String(), CreatePostRequest{DogID: dog.ID.String(), Content: "#zoomies again"})
+		require.NoError(t, err)
+	}
+	_, err = postService.CreatePost(other.ID.String(), CreatePostRequest{DogID: otherDog.ID.String(), Content: "#beach"})
+	require.NoError(t, err)
+	_, err = postService.CreatePost(other.ID.String(), CreatePostRequest{
+		DogID:      otherDog.ID.String(),
+		Content:    "#secret",
+		Visibility: models.PostVisibilityPrivate,
+	})
+	require.NoError(t, err)
+
+	posts, next, err := hashtagService.GetPosts("", "#BEACH", "", 1)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	require.NotEmpty(t, next)
+	posts, next, err = hashtagService.GetPosts("", "beach", next, 1)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, post.ID, posts[0].ID)
+	assert.Empty(t, next)
+
+	// Two dogs used #beach; one dog posting #zoomies four times counts
+	// once, and private posts are not counted
+	trending, err := hashtagService.RefreshTrending()
+	require.NoError(t, err)
+	require.Len(t, trending, 2)
+	assert.Equal(t, "beach", trending[0].Tag)
+	assert.Equal(t, "zoomies", trending[1].Tag)
+	assert.Equal(t, 4, trending[1].Posts)
+
+	// Editing the content drops the tags it no longer has
+	content := "Just a walk"
+	_, err = postService.UpdatePost(post.ID.String(), author.ID.String(), UpdatePostRequest{Content: &content})
+	require.NoError(t, err)
+	posts, _, err = hashtagService.GetPosts("", "beach", "", 20)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.NotEqual(t, post.ID, posts[0].ID)
+
+	require.NoError(t, postService.DeletePost(posts[0].ID.String(), other.ID.String()))
+	cached, err := hashtagService.GetTrending(10)
+	require.NoError(t, err)
+	assert.Len(t, cached, 2)
+	trending, err = hashtagService.RefreshTrending()
+	require.NoError(t, err)
+	require.Len(t, trending, 1)
+	assert.Equal(t, "zoomies", trending[0].Tag)
+}
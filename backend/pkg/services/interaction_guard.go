@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// blockedUserIDsSQL selects every user in a block relationship with the given
+// user, whichever side blocked. Bind the user ID twice.
+const blockedUserIDsSQL = "SELECT blocked_id FROM blocked_users WHERE blocker_id = ? UNION SELECT blocker_id FROM blocked_users WHERE blocked_id = ?"
+
+// findActiveSuspension returns the user's current suspension, or nil.
+// Warnings are recorded as suspensions but do not restrict the user.
+func findActiveSuspension(db *gorm.DB, userID string) (*models.UserSuspension, error) {
+	var suspension models.UserSuspension
+	err := db.Where("user_id = ? AND is_active = true AND type <> ?", userID, models.SuspensionType.Warning).
+		Order("created_at DESC").
+		First(&suspension).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to check user suspension")
+	}
+
+	// Check if suspension has expired
+	if !suspension.IsCurrentlyActive() {
+		// Deactivate expired suspension
+		db.Model(&suspension).Update("is_active", false)
+		return nil, nil
+	}
+
+	return &suspension, nil
+}
+
+// isBlockedPair checks if either user has blocked the other
+func isBlockedPair(db *gorm.DB, userID string, otherUserID string) (bool, error) {
+	var count int64
+	if err := db.Model(&models.BlockedUser{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)",
+			userID, otherUserID, otherUserID, userID).
+		Count(&count).Error; err != nil {
+		return false, utils.WrapError(err, "failed to check if user is blocked")
+	}
+	return count > 0, nil
+}
+
+// ensureNotSuspended rejects actions by suspended users
+func ensureNotSuspended(db *gorm.DB, userID string) error {
+	suspension, err := findActiveSuspension(db, userID)
+	if err != nil {
+		return err
+	}
+	if suspension != nil {
+		return utils.NewSuspendedError(suspension.Type, suspension.ExpiresAt)
+	}
+	return nil
+}
+
+// ensureNotBlocked rejects interactions between users where either blocked the other
+func ensureNotBlocked(db *gorm.DB, userID string, otherUserID string) error {
+	if userID == otherUserID {
+		return nil
+	}
+
+	blocked, err := isBlockedPair(db, userID, otherUserID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return utils.ErrUserBlocked
+	}
+	return nil
+}
+
+// ensureCanInteract rejects an action by userID towards otherUserID if the
+// actor is suspended or the pair is blocked
+func ensureCanInteract(db *gorm.DB, userID string, otherUserID string) error {
+	if err := ensureNotSuspended(db, userID); err != nil {
+		return err
+	}
+	return ensureNotBlocked(db, userID, otherUserID)
+}
+
+// dogOwnerID returns the ID of the user who owns the dog
+func dogOwnerID(db *gorm.DB, dogID interface{}) (string, error) {
+	var ownerIDs []string
+	if err := db.Model(&models.Dog{}).Where("id = ?", dogID).Pluck("user_id", &ownerIDs).Error; err != nil {
+		return "", utils.WrapError(err, "failed to find dog owner")
+	}
+	if len(ownerIDs) == 0 {
+		return "", utils.ErrNotFound
+	}
+	return ownerIDs[0], nil
+}
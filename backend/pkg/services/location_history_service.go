@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// locationPartitionPrefix names daily partitions, e.g. location_history_p20240131
+	locationPartitionPrefix = "location_history_p"
+	locationPartitionLayout = "20060102"
+
+	// locationPartitionsAhead is how many future days get a partition in advance
+	locationPartitionsAhead = 2
+
+	// maxLocationHistoryPoints caps the points returned by a single history query
+	maxLocationHistoryPoints = 5000
+)
+
+// LocationPoint is a single historical location of a dog
+type LocationPoint struct {
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// LocationHistoryService stores the append-only location history and
+// enforces its retention policy
+type LocationHistoryService struct {
+	db  *gorm.DB
+	cfg config.LocationConfig
+}
+
+func NewLocationHistoryService(db *gorm.DB, cfg config.Config) *LocationHistoryService {
+	return &LocationHistoryService{
+		db:  db,
+		cfg: cfg.Location,
+	}
+}
+
+// Record appends a location point for a dog
+func (s *LocationHistoryService) Record(tx *gorm.DB, dogID uuid.UUID, latitude, longitude float64, recordedAt time.Time) error {
+	if err := tx.Exec(`
+		INSERT INTO location_history (id, dog_id, location, recorded_at)
+		VALUES (?, ?, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)
+	`, uuid.New(), dogID, longitude, latitude, recordedAt.UTC()).Error; err != nil {
+		return utils.WrapError(err, "failed to record location history")
+	}
+	return nil
+}
+
+// RecordBatch appends several location points for a dog in one statement
+func (s *LocationHistoryService) RecordBatch(tx *gorm.DB, dogID uuid.UUID, points []LocationPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(points)
+	if err != nil {
+		return utils.WrapError(err, "failed to encode location history")
+	}
+	if err := tx.Exec(`
+		INSERT INTO location_history (id, dog_id, location, recorded_at)
+		SELECT gen_random_uuid(), ?, ST_SetSRID(ST_MakePoint(p.longitude, p.latitude), 4326)::geography, p.recorded_at
+		FROM jsonb_to_recordset(?::jsonb) AS p(latitude float8, longitude float8, recorded_at timestamptz)
+	`, dogID, string(data)).Error; err != nil {
+		return utils.WrapError(err, "failed to record location history")
+	}
+	return nil
+}
+
+// GetHistory returns the dog's locations over the last hours, newest first
+func (s *LocationHistoryService) GetHistory(dogID uuid.UUID, hours int) ([]LocationPoint, error) {
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	if s.cfg.HistoryRetention > 0 && since.Before(s.retentionCutoff()) {
+		since = s.retentionCutoff()
+	}
+
+	var points []LocationPoint
+	if err := s.db.Raw(`
+		SELECT ST_Y(location::geometry) AS latitude, ST_X(location::geometry) AS longitude, recorded_at
+		FROM location_history
+		WHERE dog_id = ? AND recorded_at > ?
+		ORDER BY recorded_at DESC
+		LIMIT ?
+	`, dogID, since, maxLocationHistoryPoints).Scan(&points).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get location history")
+	}
+
+	return points, nil
+}
+
+// StartRetentionJob runs Purge now and then every PurgeInterval until ctx is done
+func (s *LocationHistoryService) StartRetentionJob(ctx context.Context) {
+	if s.cfg.PurgeInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Purge(); err != nil {
+				log.Printf("Location history purge failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Purge prepares upcoming partitions, drops history past the retention
+// period, downsamples points older than DownsampleAfter and removes stale
+// current locations and upload records. History is kept forever when no
+// retention is set.
+func (s *LocationHistoryService) Purge() error {
+	// A day whose rows already landed in the default partition cannot get
+	// its own; its points stay in the default partition and retention goes on
+	if err := s.EnsurePartitions(time.Now()); err != nil {
+		log.Printf("Failed to prepare location history partitions: %v", err)
+	}
+	if s.cfg.HistoryRetention <= 0 {
+		return nil
+	}
+
+	cutoff := s.retentionCutoff()
+	if err := s.dropExpiredPartitions(cutoff); err != nil {
+		return err
+	}
+
+	// Catches rows in the default partition and the partially expired day
+	if err := s.db.Where("recorded_at < ?", cutoff).Delete(&models.LocationHistory{}).Error; err != nil {
+		return utils.WrapError(err, "failed to purge location history")
+	}
+
+	if err := s.db.Where("updated_at < ?", cutoff).Delete(&models.DeviceLocation{}).Error; err != nil {
+		return utils.WrapError(err, "failed to purge stale device locations")
+	}
+
+	if err := s.db.Where("created_at < ?", cutoff).Delete(&models.LocationBatch{}).Error; err != nil {
+		return utils.WrapError(err, "failed to purge location batches")
+	}
+
+	return s.downsample(cutoff, time.Now().Add(-s.cfg.DownsampleAfter))
+}
+
+// EnsurePartitions creates the daily partitions from the given day through
+// locationPartitionsAhead days later. A day that fails does not stop the
+// later ones; the first failure is returned.
+func (s *LocationHistoryService) EnsurePartitions(from time.Time) error {
+	var firstErr error
+	day := startOfDay(from)
+	for i := 0; i <= locationPartitionsAhead; i++ {
+		start := day.AddDate(0, 0, i)
+		end := start.AddDate(0, 0, 1)
+
+		statement := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s%s PARTITION OF location_history FOR VALUES FROM ('%s') TO ('%s')",
+			locationPartitionPrefix, start.Format(locationPartitionLayout),
+			start.Format(time.RFC3339), end.Format(time.RFC3339))
+		if err := s.db.Exec(statement).Error; err != nil && firstErr == nil {
+			firstErr = utils.WrapError(err, "failed to create location history partition "+start.Format(locationPartitionLayout))
+		}
+	}
+	return firstErr
+}
+
+// dropExpiredPartitions drops daily partitions that end before the cutoff
+func (s *LocationHistoryService) dropExpiredPartitions(cutoff time.Time) error {
+	var partitions []string
+	if err := s.db.Raw(`
+		SELECT child.relname
+		FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		WHERE parent.relname = 'location_history'
+	`).Scan(&partitions).Error; err != nil {
+		return utils.WrapError(err, "failed to list location history partitions")
+	}
+
+	for _, partition := range partitions {
+		if !strings.HasPrefix(partition, locationPartitionPrefix) {
+			continue
+		}
+		day, err := time.Parse(locationPartitionLayout, strings.TrimPrefix(partition, locationPartitionPrefix))
+		if err != nil {
+			continue
+		}
+		if day.AddDate(0, 0, 1).After(cutoff) {
+			continue
+		}
+
+		if err := s.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", partition)).Error; err != nil {
+			return utils.WrapError(err, "failed to drop location history partition")
+		}
+	}
+	return nil
+}
+
+// downsample keeps the first point per dog in every DownsampleInterval
+// bucket between from and to. It works a day at a time to keep each delete
+// within one partition, and is a no-op for days already downsampled.
+func (s *LocationHistoryService) downsample(from, to time.Time) error {
+	interval := int64(s.cfg.DownsampleInterval.Seconds())
+	if interval <= 0 || !from.Before(to) {
+		return nil
+	}
+
+	for start := from; start.Before(to); start = startOfDay(start).AddDate(0, 0, 1) {
+		end := startOfDay(start).AddDate(0, 0, 1)
+		if end.After(to) {
+			end = to
+		}
+
+		if err := s.db.Exec(`
+			DELETE FROM location_history
+			WHERE recorded_at >= ? AND recorded_at < ?
+			AND (id, recorded_at) IN (
+				SELECT id, recorded_at FROM (
+					SELECT id, recorded_at, ROW_NUMBER() OVER (
+						PARTITION BY dog_id, FLOOR(EXTRACT(EPOCH FROM recorded_at) / ?)
+						ORDER BY recorded_at
+					) AS position
+					FROM location_history
+					WHERE recorded_at >= ? AND recorded_at < ?
+				) ranked
+				WHERE position > 1
+			)
+		`, start, end, interval, start, end).Error; err != nil {
+			return utils.WrapError(err, "failed to downsample location history")
+		}
+	}
+	return nil
+}
+
+func (s *LocationHistoryService) retentionCutoff() time.Time {
+	return time.Now().Add(-s.cfg.HistoryRetention)
+}
+
+// startOfDay truncates t to midnight UTC, the partition boundary
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocationHistoryService_RetentionAndDownsampling(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	cfg := ctx.Config
+	cfg.Location = config.LocationConfig{
+		HistoryRetention:   7 * 24 * time.Hour,
+		DownsampleAfter:    24 * time.Hour,
+		DownsampleInterval: 5 * time.Minute,
+	}
+	service := NewLocationHistoryService(ctx.DB, cfg)
+
+	user := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, user.ID.String())
+
+	now := time.Now()
+	require.NoError(t, service.EnsurePartitions(now.Add(-10*24*time.Hour)))
+	require.NoError(t, service.EnsurePartitions(now))
+
+	// Past retention
+	require.NoError(t, service.Record(ctx.DB, dog.ID, 52.52, 13.40, now.Add(-8*24*time.Hour)))
+
+	// Three points within one downsampling bucket two days ago
+	bucket := now.Add(-48 * time.Hour).Truncate(5 * time.Minute)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, service.Record(ctx.DB, dog.ID, 52.52, 13.40, bucket.Add(time.Duration(i)*time.Minute)))
+	}
+
+	// Recent points are kept as reported
+	for i := 0; i < 3; i++ {
+		require.NoError(t, service.Record(ctx.DB, dog.ID, 52.52, 13.41, now.Add(-time.Duration(i+1)*time.Minute)))
+	}
+
+	require.NoError(t, service.Purge())
+
+	points, err := service.GetHistory(dog.ID, 10*24)
+	require.NoError(t, err)
+	require.Len(t, points, 4)
+	assert.InDelta(t, 52.52, points[0].Latitude, 0.0001)
+	assert.InDelta(t, 13.41, points[0].Longitude, 0.0001)
+	assert.WithinDuration(t, bucket, points[3].RecordedAt, time.Second)
+}
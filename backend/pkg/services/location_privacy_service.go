@@ -0,0 +1,122 @@
+package services
+
+import (
+	"math"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// friendUserIDsSQL selects the users the given user is friends with, meaning
+// one of their dogs and one of the user's dogs follow each other
+const friendUserIDsSQL = `SELECT DISTINCT theirs.user_id FROM followers f
+	JOIN followers back ON back.follower_dog_id = f.followed_dog_id AND back.followed_dog_id = f.follower_dog_id
+	JOIN dogs mine ON mine.id = f.follower_dog_id
+	JOIN dogs theirs ON theirs.id = f.followed_dog_id
+	WHERE mine.user_id = ?`
+
+const defaultSnapGridMeters = 250.0
+
+// LocationPrivacyService applies owners' location sharing settings to
+// positions before they are shown to other users
+type LocationPrivacyService struct {
+	db             *gorm.DB
+	snapGridMeters float64
+}
+
+func NewLocationPrivacyService(db *gorm.DB, cfg config.Config) *LocationPrivacyService {
+	snapGrid := cfg.Location.SnapGridMeters
+	if snapGrid <= 0 {
+		snapGrid = defaultSnapGridMeters
+	}
+
+	return &LocationPrivacyService{
+		db:             db,
+		snapGridMeters: snapGrid,
+	}
+}
+
+// Visible drops matches the viewer may not see: dogs whose owners do not
+// share their location with the viewer and dogs inside their owner's home
+// zone. The viewer's own dogs are always visible.
+func (s *LocationPrivacyService) Visible(viewerID uuid.UUID, matches []PresenceMatch) ([]PresenceMatch, error) {
+	if len(matches) == 0 {
+		return matches, nil
+	}
+
+	dogIDs := make([]uuid.UUID, len(matches))
+	for i, match := range matches {
+		dogIDs[i] = match.DogID
+	}
+
+	var dogs []models.Dog
+	if err := s.db.Select("id", "user_id").Where("id IN ?", dogIDs).Find(&dogs).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog owners")
+	}
+	ownerOf := make(map[uuid.UUID]uuid.UUID, len(dogs))
+	ownerIDs := make([]uuid.UUID, 0, len(dogs))
+	for _, dog := range dogs {
+		ownerOf[dog.ID] = dog.UserID
+		ownerIDs = append(ownerIDs, dog.UserID)
+	}
+
+	var owners []models.User
+	if err := s.db.Select("id", "visibility", "location_sharing", "home_location", "home_radius_meters").
+		Where("id IN ?", ownerIDs).Find(&owners).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog owners")
+	}
+	ownerByID := make(map[uuid.UUID]models.User, len(owners))
+	for _, owner := range owners {
+		ownerByID[owner.ID] = owner
+	}
+
+	friends, err := s.friends(viewerID, ownerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]PresenceMatch, 0, len(matches))
+	for _, match := range matches {
+		owner, ok := ownerByID[ownerOf[match.DogID]]
+		if !ok {
+			continue
+		}
+		if owner.ID != viewerID {
+			if !owner.SharesLocationWith(friends[owner.ID]) || owner.IsInHomeZone(match.Location) {
+				continue
+			}
+		}
+		visible = append(visible, match)
+	}
+	return visible, nil
+}
+
+// Snap coarsens a position to the configured grid
+func (s *LocationPrivacyService) Snap(point models.GeoPoint) models.GeoPoint {
+	return point.Snap(s.snapGridMeters)
+}
+
+// RoundDistance rounds a distance up to a whole number of grid cells so it
+// is no more precise than a snapped position
+func (s *LocationPrivacyService) RoundDistance(meters float64) float64 {
+	return math.Max(1, math.Ceil(meters/s.snapGridMeters)) * s.snapGridMeters
+}
+
+// friends returns which of the candidates are friends with the user
+func (s *LocationPrivacyService) friends(userID uuid.UUID, candidateIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	var ids []uuid.UUID
+	if err := s.db.Model(&models.User{}).
+		Where("id IN ? AND id IN ("+friendUserIDsSQL+")", candidateIDs, userID).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find friends")
+	}
+
+	friends := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		friends[id] = true
+	}
+	return friends, nil
+}
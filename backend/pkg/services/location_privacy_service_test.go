@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoPoint_Snap(t *testing.T) {
+	a := models.NewGeoPoint(52.52001, 13.40501)
+	b := models.NewGeoPoint(52.52003, 13.40502)
+
+	// Nearby points share a cell and snap to the same centre
+	assert.Equal(t, a.Snap(250), b.Snap(250))
+
+	// The snapped point stays within half a cell diagonal of the original
+	assert.Less(t, geo.Distance(orb.Point(a), orb.Point(a.Snap(250))), 250.0)
+
+	far := models.NewGeoPoint(52.53, 13.405)
+	assert.NotEqual(t, a.Snap(250), far.Snap(250))
+
+	assert.Equal(t, a, a.Snap(0))
+}
+
+func TestLocationPrivacyService_Visible(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	privacy := NewLocationPrivacyService(ctx.DB, ctx.Config)
+
+	viewer := testutils.CreateTestUser(t, ctx.DB)
+	viewerDog := testutils.CreateTestDog(t, ctx.DB, viewer.ID.String())
+
+	newDog := func(sharing models.LocationSharing) (*models.User, *models.Dog) {
+		owner := testutils.CreateTestUser(t, ctx.DB)
+		require.NoError(t, ctx.DB.Model(owner).Update("location_sharing", sharing).Error)
+		return owner, testutils.CreateTestDog(t, ctx.DB, owner.ID.String())
+	}
+	_, everyoneDog := newDog(models.LocationSharingEveryone)
+	_, offDog := newDog(models.LocationSharingOff)
+	_, strangerDog := newDog(models.LocationSharingFriends)
+	_, friendDog := newDog(models.LocationSharingFriends)
+	homeOwner, homeDog := newDog(models.LocationSharingEveryone)
+
+	require.NoError(t, ctx.DB.Create(&[]models.Follower{
+		{FollowerDogID: viewerDog.ID, FollowedDogID: friendDog.ID},
+		{FollowerDogID: friendDog.ID, FollowedDogID: viewerDog.ID},
+		// One-way follows do not make friends
+		{FollowerDogID: viewerDog.ID, FollowedDogID: strangerDog.ID},
+	}).Error)
+
+	home := models.NewGeoPoint(52.5200, 13.4050)
+	require.NoError(t, ctx.DB.Model(homeOwner).Updates(map[string]interface{}{
+		"home_location":      home,
+		"home_radius_meters": 300,
+	}).Error)
+
+	// Only the owner's privacy settings reveal the home location
+	settings, err := NewUserService(ctx.DB, ctx.Redis, ctx.Config).GetPrivacySettings(homeOwner.ID.String())
+	require.NoError(t, err)
+	require.NotNil(t, settings.HomeLatitude)
+	assert.InDelta(t, 52.5200, *settings.HomeLatitude, 0.0001)
+	assert.Equal(t, 300, settings.HomeRadiusMeters)
+
+	var owner models.User
+	require.NoError(t, ctx.DB.First(&owner, "id = ?", homeOwner.ID).Error)
+	data, err := json.Marshal(owner)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "home_")
+	assert.NotContains(t, string(data), "location_sharing")
+
+	awayFromHome := models.NewGeoPoint(52.5300, 13.4050)
+	matches := []PresenceMatch{
+		{DogID: everyoneDog.ID, Location: awayFromHome},
+		{DogID: offDog.ID, Location: awayFromHome},
+		{DogID: strangerDog.ID, Location: awayFromHome},
+		{DogID: friendDog.ID, Location: awayFromHome},
+		{DogID: homeDog.ID, Location: models.NewGeoPoint(52.5210, 13.4050)},
+		{DogID: uuid.New(), Location: awayFromHome},
+	}
+
+	visible, err := privacy.Visible(viewer.ID, matches)
+	require.NoError(t, err)
+
+	ids := make([]uuid.UUID, len(visible))
+	for i, match := range visible {
+		ids[i] = match.DogID
+	}
+	assert.Equal(t, []uuid.UUID{everyoneDog.ID, friendDog.ID}, ids)
+
+	// Outside the home zone the dog is shown again
+	visible, err = privacy.Visible(viewer.ID, []PresenceMatch{{DogID: homeDog.ID, Location: awayFromHome}})
+	require.NoError(t, err)
+	assert.Len(t, visible, 1)
+}
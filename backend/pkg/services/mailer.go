@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+)
+
+// Mail represents an outgoing email message
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing email
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// NewMailer returns the mail transport selected by cfg.Driver
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.Host == "" {
+			return nil, fmt.Errorf("smtp mailer requires SMTP_HOST")
+		}
+		return NewSMTPMailer(cfg), nil
+	case "", "log":
+		if cfg.LogPath == "" {
+			return NewLogMailer(os.Stdout), nil
+		}
+		file, err := os.OpenFile(cfg.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open mail log: %w", err)
+		}
+		return NewLogMailer(file), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// SMTPMailer sends email through an SMTP relay
+type SMTPMailer struct {
+	cfg config.MailConfig
+}
+
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+
+// Send sends the message using PLAIN auth when credentials are configured
+func (m *SMTPMailer) Send(mail Mail) error {
+	addr := m.cfg.Host + ":" + m.cfg.Port
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	msg := buildMessage(m.cfg, mail)
+	if err := smtp.SendMail(addr, auth, m.cfg.FromEmail, []string{mail.To}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes messages to a writer instead of delivering them.
+// Used for local development and tests.
+type LogMailer struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewLogMailer(out io.Writer) *LogMailer {
+	return &LogMailer{out: out}
+}
+
+// Send writes the message to the underlying writer
+func (m *LogMailer) Send(mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.out, "---- mail %s ----\nTo: %s\nSubject: %s\n\n%s\n",
+		time.Now().Format(time.RFC3339), mail.To, mail.Subject, mail.Body)
+	return err
+}
+
+// buildMessage renders an RFC 5322 plain text message
+func buildMessage(cfg config.MailConfig, mail Mail) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s <%s>\r\n", cfg.FromName, cfg.FromEmail)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	b.WriteString(mail.Body)
+	return b.String()
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"errors"
+	"log"
 	"regexp"
 	"strings"
 	"time"
@@ -14,16 +15,18 @@ import (
 )
 
 type ModerationService struct {
-	db    *gorm.DB
-	redis *redis.Client
-	cfg   config.Config
+	db       *gorm.DB
+	redis    *redis.Client
+	cfg      config.Config
+	timeline *TimelineService
 }
 
 func NewModerationService(db *gorm.DB, redis *redis.Client, cfg config.Config) *ModerationService {
 	return &ModerationService{
-		db:    db,
-		redis: redis,
-		cfg:   cfg,
+		db:       db,
+		redis:    redis,
+		cfg:      cfg,
+		timeline: NewTimelineService(db, redis, cfg),
 	}
 }
 
@@ -191,9 +194,15 @@ func (s *ModerationService) BlockUser(blockerID string, req BlockUserRequest) er
 		return utils.WrapError(err, "failed to block user")
 	}
 
-	// Remove any existing follows between the users
+	// Remove any existing follows and follow requests between the users
 	s.db.Where("(follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?) AND followed_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)) OR (follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?) AND followed_dog_id IN (SELECT id FROM dogs WHERE user_id = ?))",
 		blockerID, req.BlockedUserID, req.BlockedUserID, blockerID).Delete(&models.Follower{})
+	s.db.Where("(follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?) AND followed_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)) OR (follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?) AND followed_dog_id IN (SELECT id FROM dogs WHERE user_id = ?))",
+		blockerID, req.BlockedUserID, req.BlockedUserID, blockerID).Delete(&models.FollowRequest{})
+
+	if err := s.timeline.Block(blockerID, req.BlockedUserID); err != nil {
+		log.Printf("Failed to remove blocked posts from timelines of %s and %s: %v", blockerID, req.BlockedUserID, err)
+	}
 
 	return nil
 }
@@ -412,8 +421,12 @@ func (s *ModerationService) SuspendUser(moderatorID string, req SuspendUserReque
 		return nil, utils.WrapError(err, "failed to find user")
 	}
 
-	// Deactivate any existing active suspensions
-	s.db.Model(&models.UserSuspension{}).Where("user_id = ? AND is_active = true", req.UserID).Update("is_active", false)
+	// A new suspension replaces any existing one; warnings leave them in place
+	if req.Type != models.SuspensionType.Warning {
+		s.db.Model(&models.UserSuspension{}).
+			Where("user_id = ? AND is_active = true AND type <> ?", req.UserID, models.SuspensionType.Warning).
+			Update("is_active", false)
+	}
 
 	// Create suspension
 	suspension := models.UserSuspension{
@@ -495,36 +508,17 @@ func (s *ModerationService) CheckContentFilter(content string) (bool, string, er
 
 // Utility functions
 func (s *ModerationService) IsUserBlocked(userID string, otherUserID string) (bool, error) {
-	var count int64
-	if err := s.db.Model(&models.BlockedUser{}).
-		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)",
-			userID, otherUserID, otherUserID, userID).
-		Count(&count).Error; err != nil {
-		return false, utils.WrapError(err, "failed to check if user is blocked")
-	}
-	return count > 0, nil
+	return isBlockedPair(s.db, userID, otherUserID)
 }
 
+// IsUserSuspended reports whether the user is currently restricted.
+// Warnings do not count as suspensions.
 func (s *ModerationService) IsUserSuspended(userID string) (bool, *models.UserSuspension, error) {
-	var suspension models.UserSuspension
-	err := s.db.Where("user_id = ? AND is_active = true", userID).First(&suspension).Error
-	
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return false, nil, nil
-	}
-	
+	suspension, err := findActiveSuspension(s.db, userID)
 	if err != nil {
-		return false, nil, utils.WrapError(err, "failed to check user suspension")
+		return false, nil, err
 	}
-
-	// Check if suspension has expired
-	if !suspension.IsCurrentlyActive() {
-		// Deactivate expired suspension
-		s.db.Model(&suspension).Update("is_active", false)
-		return false, nil, nil
-	}
-
-	return true, &suspension, nil
+	return suspension != nil, suspension, nil
 }
 
 func (s *ModerationService) validateContentExists(contentType string, contentID string) error {
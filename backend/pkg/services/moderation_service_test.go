@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertAPIErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	var apiErr utils.APIError
+	require.True(t, errors.As(err, &apiErr), "expected APIError, got %v", err)
+	assert.Equal(t, code, apiErr.Code)
+}
+
+func TestModerationService_SuspendedUserCannotWrite(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	moderationService := NewModerationService(ctx.DB, ctx.Redis, ctx.Config)
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	moderator := testutils.CreateTestUser(t, ctx.DB)
+	user := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, user.ID.String())
+
+	createPost := func() error {
+		_, err := postService.CreatePost(user.ID.String(), CreatePostRequest{
+			DogID:     dog.ID.String(),
+			Content:   "Walk time",
+			MediaType: "photo",
+		})
+		return err
+	}
+
+	// Warnings do not restrict the user
+	_, err := moderationService.SuspendUser(moderator.ID.String(), SuspendUserRequest{
+		UserID: user.ID.String(),
+		Type:   models.SuspensionType.Warning,
+		Reason: "First warning",
+	})
+	require.NoError(t, err)
+	require.NoError(t, createPost())
+
+	duration := 24
+	_, err = moderationService.SuspendUser(moderator.ID.String(), SuspendUserRequest{
+		UserID:   user.ID.String(),
+		Type:     models.SuspensionType.TemporarySuspension,
+		Reason:   "Spam",
+		Duration: &duration,
+	})
+	require.NoError(t, err)
+
+	suspended, _, err := moderationService.IsUserSuspended(user.ID.String())
+	require.NoError(t, err)
+	assert.True(t, suspended)
+
+	err = createPost()
+	assertAPIErrorCode(t, err, utils.CodeAccountSuspended)
+
+	status, _ := utils.HTTPError(err)
+	assert.Equal(t, 403, status)
+}
+
+func TestModerationService_BlockedUsersCannotGift(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	moderationService := NewModerationService(ctx.DB, ctx.Redis, ctx.Config)
+	giftService := NewGiftService(ctx.DB, ctx.Redis, ctx.Config)
+
+	sender := testutils.CreateTestUser(t, ctx.DB)
+	receiver := testutils.CreateTestUser(t, ctx.DB)
+	senderDog := testutils.CreateTestDog(t, ctx.DB, sender.ID.String())
+	receiverDog := testutils.CreateTestDog(t, ctx.DB, receiver.ID.String())
+
+	req := SendGiftRequest{
+		SenderDogID:   senderDog.ID,
+		ReceiverDogID: receiverDog.ID,
+		GiftType:      "bone",
+	}
+
+	_, err := giftService.SendGift(sender.ID, req)
+	require.NoError(t, err)
+
+	// The receiver blocks the sender; the block applies in both directions
+	require.NoError(t, moderationService.BlockUser(receiver.ID.String(), BlockUserRequest{
+		BlockedUserID: sender.ID.String(),
+	}))
+
+	_, err = giftService.SendGift(sender.ID, req)
+	assertAPIErrorCode(t, err, utils.CodeUserBlocked)
+
+	_, err = giftService.SendGift(receiver.ID, SendGiftRequest{
+		SenderDogID:   receiverDog.ID,
+		ReceiverDogID: senderDog.ID,
+		GiftType:      "ball",
+	})
+	assertAPIErrorCode(t, err, utils.CodeUserBlocked)
+
+	// Dogs can only gift on behalf of their owner
+	_, err = giftService.SendGift(receiver.ID, req)
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// resetTokenBytes is the entropy of emailed password reset tokens
+const resetTokenBytes = 32
+
+// PasswordResetRateLimitKey is keyed by the lowercased email address
+const PasswordResetRateLimitKey = "ratelimit:password_reset:%s"
+
+type PasswordResetService struct {
+	db             *gorm.DB
+	cfg            config.Config
+	cacheService   *CacheService
+	sessionService *SessionService
+	mailer         Mailer
+
+	// issuing tracks reset links still being issued in the background
+	issuing sync.WaitGroup
+}
+
+func NewPasswordResetService(db *gorm.DB, redis *redis.Client, cfg config.Config) *PasswordResetService {
+	mailer, err := NewMailer(cfg.Mail)
+	if err != nil {
+		log.Printf("Mailer unavailable, falling back to log mailer: %v", err)
+		mailer = NewLogMailer(os.Stdout)
+	}
+
+	return &PasswordResetService{
+		db:             db,
+		cfg:            cfg,
+		cacheService:   NewCacheService(redis, cfg),
+		sessionService: NewSessionService(db, redis, cfg),
+		mailer:         mailer,
+	}
+}
+
+// RequestReset emails a reset link if the address belongs to a user.
+// It reports success for unknown addresses so callers cannot probe accounts.
+func (s *PasswordResetService) RequestReset(email string) error {
+	// Addresses are matched case-insensitively, as typed addresses often
+	// differ in case from the one registered
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	key := fmt.Sprintf(PasswordResetRateLimitKey, email)
+	allowed, err := s.cacheService.CheckRateLimit(key, s.cfg.Auth.PasswordResetLimit, s.cfg.Auth.PasswordResetWindow)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return utils.ErrTooManyRequests
+	}
+
+	var user models.User
+	if err := s.db.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return utils.WrapError(err, "failed to find user")
+	}
+
+	// The link is issued in the background so the response takes as long
+	// for existing accounts as for unknown addresses
+	s.issuing.Add(1)
+	go func() {
+		defer s.issuing.Done()
+		if err := s.issueReset(user); err != nil {
+			log.Printf("Failed to issue password reset for user %s: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// issueReset stores a new reset token for the user, invalidating earlier
+// ones, and emails the link
+func (s *PasswordResetService) issueReset(user models.User) error {
+	token, err := utils.GenerateSecureToken(resetTokenBytes)
+	if err != nil {
+		return utils.WrapError(err, "failed to generate reset token")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Only the most recent link stays valid
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return utils.WrapError(err, "failed to invalidate previous reset tokens")
+		}
+
+		resetToken := models.PasswordResetToken{
+			UserID:    user.ID,
+			Token:     utils.HashToken(token),
+			ExpiresAt: time.Now().Add(s.cfg.Auth.PasswordResetTTL),
+		}
+		if err := tx.Create(&resetToken).Error; err != nil {
+			return utils.WrapError(err, "failed to store reset token")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	mail := Mail{
+		To:      user.Email,
+		Subject: "Reset your DoggyClub password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. "+
+			"Use the link below within %d minutes to choose a new one:\n\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.\n",
+			user.Username, int(s.cfg.Auth.PasswordResetTTL.Minutes()), s.resetLink(token)),
+	}
+	if err := s.mailer.Send(mail); err != nil {
+		return utils.WrapError(err, "failed to send password reset email")
+	}
+	return nil
+}
+
+// ResetPassword consumes a reset token, sets the new password and revokes
+// every session of the user so existing devices must log in again.
+func (s *PasswordResetService) ResetPassword(token string, newPassword string) error {
+	if err := utils.ValidatePassword(newPassword); err != nil {
+		return utils.NewValidationError([]utils.ValidationError{{Field: "new_password", Message: err.Error()}})
+	}
+
+	var resetToken models.PasswordResetToken
+	if err := s.db.Where("token = ?", utils.HashToken(token)).First(&resetToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrInvalidToken
+		}
+		return utils.WrapError(err, "failed to find reset token")
+	}
+
+	if resetToken.UsedAt != nil {
+		return utils.ErrInvalidToken
+	}
+	if !resetToken.IsUsable() {
+		return utils.ErrTokenExpired
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Conditional update keeps the token single use under concurrency
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return utils.WrapError(result.Error, "failed to consume reset token")
+		}
+		if result.RowsAffected == 0 {
+			return utils.ErrInvalidToken
+		}
+
+		if err := tx.Model(&models.User{}).
+			Where("id = ?", resetToken.UserID).
+			Update("password_hash", string(hashedPassword)).Error; err != nil {
+			return utils.WrapError(err, "failed to update password")
+		}
+
+		if err := tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", resetToken.UserID).
+			Update("revoked_at", now).Error; err != nil {
+			return utils.WrapError(err, "failed to revoke refresh tokens")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Denylist access tokens still in flight for the user's sessions
+	return s.sessionService.RevokeAllSessions(resetToken.UserID)
+}
+
+// resetLink builds the client URL carrying the reset token
+func (s *PasswordResetService) resetLink(token string) string {
+	separator := "?"
+	if strings.Contains(s.cfg.Auth.PasswordResetURL, "?") {
+		separator = "&"
+	}
+	return s.cfg.Auth.PasswordResetURL + separator + "token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var resetTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+func TestPasswordResetService_ResetFlow(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	var outbox bytes.Buffer
+	resetService := NewPasswordResetService(ctx.DB, ctx.Redis, ctx.Config)
+	resetService.mailer = NewLogMailer(&outbox)
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
+
+	registered, err := authService.Register(RegisterRequest{
+		Email:    "reset@example.com",
+		Password: "password123",
+		Username: "ResetUser",
+	})
+	require.NoError(t, err)
+
+	// Unknown addresses succeed silently and send nothing
+	require.NoError(t, resetService.RequestReset("nobody@example.com"))
+	assert.Empty(t, outbox.String())
+
+	// Addresses match whatever their case
+	require.NoError(t, resetService.RequestReset(" Reset@Example.com"))
+	resetService.issuing.Wait()
+	match := resetTokenPattern.FindStringSubmatch(outbox.String())
+	require.Len(t, match, 2, "reset email should contain a token link")
+	token := match[1]
+
+	// Stored token is hashed
+	var stored models.PasswordResetToken
+	require.NoError(t, ctx.DB.Where("user_id = ?", registered.User.ID).First(&stored).Error)
+	assert.Equal(t, utils.HashToken(token), stored.Token)
+
+	// Weak passwords are rejected as a client error
+	err = resetService.ResetPassword(token, "short")
+	status, _ := utils.HTTPError(err)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	require.NoError(t, resetService.ResetPassword(token, "newpassword123"))
+
+	var user models.User
+	require.NoError(t, ctx.DB.Where("id = ?", registered.User.ID).First(&user).Error)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("newpassword123")))
+
+	// Tokens are single use
+	assert.ErrorIs(t, resetService.ResetPassword(token, "anotherpassword123"), utils.ErrInvalidToken)
+
+	// Existing sessions are revoked
+	_, err = authService.RefreshToken(registered.RefreshToken, ClientInfo{})
+	assert.ErrorIs(t, err, utils.ErrInvalidToken)
+}
+
+func TestPasswordResetService_RateLimit(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	resetService := NewPasswordResetService(ctx.DB, ctx.Redis, ctx.Config)
+	resetService.mailer = NewLogMailer(&bytes.Buffer{})
+
+	for i := 0; i < ctx.Config.Auth.PasswordResetLimit; i++ {
+		require.NoError(t, resetService.RequestReset("limited@example.com"))
+	}
+
+	assert.ErrorIs(t, resetService.RequestReset("LIMITED@example.com"), utils.ErrTooManyRequests)
+}
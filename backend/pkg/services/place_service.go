@@ -0,0 +1,396 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxPlaceAreaSquareMeters keeps places to park size rather than whole districts
+	maxPlaceAreaSquareMeters = 25_000_000
+
+	// placeReentryGrace is how soon after check-out a dog coming back to the
+	// same place resumes its visit, so GPS jitter at the edge is one visit
+	placeReentryGrace = 5 * time.Minute
+
+	// placeLeaderboardPeriod is the window leaderboard visits are counted over
+	placeLeaderboardPeriod = 7 * 24 * time.Hour
+	placeLeaderboardSize   = 20
+
+	maxPlaceResults = 100
+)
+
+// PlaceRequest creates or replaces a place. Boundary rings are lists of
+// [longitude, latitude] points, outer ring first; open rings are closed.
+type PlaceRequest struct {
+	Name        string            `json:"name" validate:"required,min=2,max=100"`
+	Kind        models.PlaceKind  `json:"kind" validate:"omitempty,oneof=dog_park beach trail other"`
+	Description string            `json:"description" validate:"max=1000"`
+	Boundary    models.GeoPolygon `json:"boundary" validate:"required"`
+}
+
+// PlaceLeaderboardEntry is a dog's number of visits to a place this week
+type PlaceLeaderboardEntry struct {
+	Dog    models.Dog `json:"dog"`
+	Visits int        `json:"visits"`
+}
+
+// PlaceService manages places and the visits dogs make to them
+type PlaceService struct {
+	db              *gorm.DB
+	locationPrivacy *LocationPrivacyService
+	presenceTTL     time.Duration
+}
+
+func NewPlaceService(db *gorm.DB, cfg config.Config) *PlaceService {
+	presenceTTL := cfg.Location.PresenceTTL
+	if presenceTTL <= 0 {
+		presenceTTL = defaultPresenceTTL
+	}
+
+	return &PlaceService{
+		db:              db,
+		locationPrivacy: NewLocationPrivacyService(db, cfg),
+		presenceTTL:     presenceTTL,
+	}
+}
+
+// CreatePlace adds a place
+func (s *PlaceService) CreatePlace(adminID uuid.UUID, req PlaceRequest) (*models.Place, error) {
+	boundary, err := validatePlaceRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	place := models.Place{
+		Name:        req.Name,
+		Kind:        req.Kind,
+		Description: req.Description,
+		Boundary:    boundary,
+		CreatedByID: adminID,
+	}
+	if place.Kind == "" {
+		place.Kind = models.PlaceKindDogPark
+	}
+	if err := s.db.Create(&place).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to create place")
+	}
+	return &place, nil
+}
+
+// UpdatePlace replaces a place's details and boundary. Open visits are
+// re-evaluated against the new boundary on the dogs' next location update.
+func (s *PlaceService) UpdatePlace(placeID uuid.UUID, req PlaceRequest) (*models.Place, error) {
+	boundary, err := validatePlaceRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	place, err := s.GetPlace(placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	place.Name = req.Name
+	place.Description = req.Description
+	place.Boundary = boundary
+	if req.Kind != "" {
+		place.Kind = req.Kind
+	}
+	if err := s.db.Model(place).Select("name", "kind", "description", "boundary").Updates(place).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to update place")
+	}
+	return place, nil
+}
+
+// DeletePlace removes a place and its visits. Encounters keep their
+// location but lose the place.
+func (s *PlaceService) DeletePlace(placeID uuid.UUID) error {
+	result := s.db.Delete(&models.Place{}, "id = ?", placeID)
+	if result.Error != nil {
+		return utils.WrapError(result.Error, "failed to delete place")
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrNotFound
+	}
+	return nil
+}
+
+// GetPlace returns a place by ID
+func (s *PlaceService) GetPlace(placeID uuid.UUID) (*models.Place, error) {
+	var place models.Place
+	if err := s.db.Where("id = ?", placeID).First(&place).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrNotFound
+		}
+		return nil, utils.WrapError(err, "failed to get place")
+	}
+	return &place, nil
+}
+
+// ListPlaces returns the places within radiusMeters of the position, nearest
+// first, or the newest places when no position is given
+func (s *PlaceService) ListPlaces(center *models.GeoPoint, radiusMeters float64) ([]models.Place, error) {
+	query := s.db.Limit(maxPlaceResults)
+	if center != nil {
+		query = query.
+			Where("ST_DWithin(boundary, ?::geography, ?)", *center, radiusMeters).
+			Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "ST_Distance(boundary, ?::geography)", Vars: []interface{}{*center}}})
+	} else {
+		query = query.Order("created_at DESC")
+	}
+
+	var places []models.Place
+	if err := query.Find(&places).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to list places")
+	}
+	return places, nil
+}
+
+// PlaceAt returns the smallest place containing the point, or nil
+func (s *PlaceService) PlaceAt(tx *gorm.DB, point models.GeoPoint) (*models.Place, error) {
+	var place models.Place
+	err := tx.Where("ST_Covers(boundary, ?::geography)", point).
+		Order("ST_Area(boundary)").
+		First(&place).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find place")
+	}
+	return &place, nil
+}
+
+// RecordVisit checks the dog in to the place containing the point and out
+// of the place it was at before. A visit whose dog stopped reporting is
+// checked out at the last time it was seen.
+func (s *PlaceService) RecordVisit(tx *gorm.DB, dogID uuid.UUID, point models.GeoPoint, now time.Time) error {
+	place, err := s.PlaceAt(tx, point)
+	if err != nil {
+		return err
+	}
+
+	var visit models.PlaceVisit
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("dog_id = ? AND checked_out_at IS NULL", dogID).
+		First(&visit).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.WrapError(err, "failed to find place visit")
+	}
+
+	if err == nil {
+		stale := now.Sub(visit.LastSeenAt) > s.presenceTTL
+		if place != nil && visit.PlaceID == place.ID && !stale {
+			return s.touchVisit(tx, visit.ID, now)
+		}
+
+		checkedOutAt := now
+		if stale {
+			checkedOutAt = visit.LastSeenAt
+		}
+		if err := tx.Model(&models.PlaceVisit{}).Where("id = ?", visit.ID).
+			Update("checked_out_at", checkedOutAt).Error; err != nil {
+			return utils.WrapError(err, "failed to check out of place")
+		}
+	}
+
+	if place == nil {
+		return nil
+	}
+
+	// Coming straight back resumes the last visit instead of counting a new one
+	var recent models.PlaceVisit
+	err = tx.Where("dog_id = ? AND place_id = ? AND checked_out_at > ?", dogID, place.ID, now.Add(-placeReentryGrace)).
+		Order("checked_out_at DESC").
+		First(&recent).Error
+	if err == nil {
+		if err := tx.Model(&models.PlaceVisit{}).Where("id = ?", recent.ID).
+			Updates(map[string]interface{}{"checked_out_at": nil, "last_seen_at": now}).Error; err != nil {
+			return utils.WrapError(err, "failed to resume place visit")
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.WrapError(err, "failed to find place visit")
+	}
+
+	if err := tx.Omit(clause.Associations).Create(&models.PlaceVisit{
+		PlaceID:     place.ID,
+		DogID:       dogID,
+		CheckedInAt: now,
+		LastSeenAt:  now,
+	}).Error; err != nil {
+		return utils.WrapError(err, "failed to check in to place")
+	}
+	return nil
+}
+
+func (s *PlaceService) touchVisit(tx *gorm.DB, visitID uuid.UUID, now time.Time) error {
+	if err := tx.Model(&models.PlaceVisit{}).Where("id = ?", visitID).Update("last_seen_at", now).Error; err != nil {
+		return utils.WrapError(err, "failed to update place visit")
+	}
+	return nil
+}
+
+// GetPresentDogs returns the dogs checked in at the place that reported
+// recently, earliest arrival first. Dogs the user could not see on the
+// nearby map are left out.
+func (s *PlaceService) GetPresentDogs(userID uuid.UUID, placeID uuid.UUID) ([]models.Dog, error) {
+	place, err := s.GetPlace(placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var dogs []models.Dog
+	if err := s.db.Joins("JOIN place_visits pv ON pv.dog_id = dogs.id").
+		Where("pv.place_id = ? AND pv.checked_out_at IS NULL AND pv.last_seen_at > ?", placeID, time.Now().Add(-s.presenceTTL)).
+		Where("dogs.user_id NOT IN ("+blockedUserIDsSQL+")", userID, userID).
+		Order("pv.checked_in_at").
+		Find(&dogs).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get dogs at place")
+	}
+
+	visible, err := s.visibleAt(userID, place, dogIDsOf(dogs))
+	if err != nil {
+		return nil, err
+	}
+
+	present := make([]models.Dog, 0, len(dogs))
+	for _, dog := range dogs {
+		if visible[dog.ID] {
+			present = append(present, dog)
+		}
+	}
+	return present, nil
+}
+
+// GetLeaderboard ranks dogs by their visits to the place over the last week
+func (s *PlaceService) GetLeaderboard(userID uuid.UUID, placeID uuid.UUID) ([]PlaceLeaderboardEntry, error) {
+	place, err := s.GetPlace(placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		DogID  uuid.UUID
+		Visits int
+	}
+	if err := s.db.Model(&models.PlaceVisit{}).
+		Select("place_visits.dog_id, COUNT(*) AS visits").
+		Joins("JOIN dogs ON dogs.id = place_visits.dog_id").
+		Where("place_visits.place_id = ? AND place_visits.checked_in_at > ?", placeID, time.Now().Add(-placeLeaderboardPeriod)).
+		Where("dogs.user_id NOT IN ("+blockedUserIDsSQL+")", userID, userID).
+		Group("place_visits.dog_id").
+		Order("visits DESC, MIN(place_visits.checked_in_at)").
+		Limit(maxPlaceResults).
+		Scan(&rows).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get place leaderboard")
+	}
+
+	dogIDs := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		dogIDs[i] = row.DogID
+	}
+	visible, err := s.visibleAt(userID, place, dogIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var dogs []models.Dog
+	if err := s.db.Where("id IN ?", dogIDs).Find(&dogs).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get leaderboard dogs")
+	}
+	byID := make(map[uuid.UUID]models.Dog, len(dogs))
+	for _, dog := range dogs {
+		byID[dog.ID] = dog
+	}
+
+	leaderboard := make([]PlaceLeaderboardEntry, 0, placeLeaderboardSize)
+	for _, row := range rows {
+		dog, ok := byID[row.DogID]
+		if !ok || !visible[row.DogID] {
+			continue
+		}
+		leaderboard = append(leaderboard, PlaceLeaderboardEntry{Dog: dog, Visits: row.Visits})
+		if len(leaderboard) == placeLeaderboardSize {
+			break
+		}
+	}
+	return leaderboard, nil
+}
+
+// visibleAt applies the owners' location sharing settings to dogs placed at
+// the centre of the place
+func (s *PlaceService) visibleAt(userID uuid.UUID, place *models.Place, dogIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	centroid := place.Boundary.Centroid()
+	matches := make([]PresenceMatch, len(dogIDs))
+	for i, dogID := range dogIDs {
+		matches[i] = PresenceMatch{DogID: dogID, Location: centroid}
+	}
+
+	matches, err := s.locationPrivacy.Visible(userID, matches)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[uuid.UUID]bool, len(matches))
+	for _, match := range matches {
+		visible[match.DogID] = true
+	}
+	return visible, nil
+}
+
+func dogIDsOf(dogs []models.Dog) []uuid.UUID {
+	ids := make([]uuid.UUID, len(dogs))
+	for i, dog := range dogs {
+		ids[i] = dog.ID
+	}
+	return ids
+}
+
+// validatePlaceRequest validates the request and returns its boundary with
+// every ring closed
+func validatePlaceRequest(req PlaceRequest) (models.GeoPolygon, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+
+	invalid := func(message string) (models.GeoPolygon, error) {
+		return nil, utils.NewValidationError([]utils.ValidationError{{Field: "boundary", Message: message}})
+	}
+
+	boundary := make(models.GeoPolygon, 0, len(req.Boundary))
+	for _, ring := range req.Boundary {
+		for _, point := range ring {
+			if math.Abs(point.Lat()) > 90 || math.Abs(point.Lon()) > 180 {
+				return invalid("Coordinates must be [longitude, latitude] pairs")
+			}
+		}
+		if len(ring) > 0 && !ring[0].Equal(ring[len(ring)-1]) {
+			ring = append(append(orb.Ring(nil), ring...), ring[0])
+		}
+		if len(ring) < 4 {
+			return invalid("Every ring needs at least three points")
+		}
+		boundary = append(boundary, ring)
+	}
+
+	area := geo.Area(orb.Polygon(boundary))
+	if area == 0 {
+		return invalid("Boundary must enclose an area")
+	}
+	if area > maxPlaceAreaSquareMeters {
+		return invalid("Boundary is too large for a place")
+	}
+	return boundary, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testParkBoundary is roughly 200m by 110m around 52.5200, 13.4050
+var testParkBoundary = models.GeoPolygon{orb.Ring{
+	{13.4035, 52.5195}, {13.4065, 52.5195}, {13.4065, 52.5205}, {13.4035, 52.5205},
+}}
+
+func TestValidatePlaceRequest(t *testing.T) {
+	boundary, err := validatePlaceRequest(PlaceRequest{Name: "Mauerpark", Boundary: testParkBoundary})
+	require.NoError(t, err)
+	ring := boundary[0]
+	assert.Len(t, ring, 5)
+	assert.Equal(t, ring[0], ring[len(ring)-1])
+	assert.True(t, boundary.Contains(models.NewGeoPoint(52.5200, 13.4050)))
+
+	_, err = validatePlaceRequest(PlaceRequest{Name: "Line", Boundary: models.GeoPolygon{orb.Ring{{13.40, 52.52}, {13.41, 52.52}}}})
+	assert.Error(t, err)
+
+	_, err = validatePlaceRequest(PlaceRequest{Name: "Swapped", Boundary: models.GeoPolygon{orb.Ring{{52.52, 13.40}, {52.52, 181}, {52.53, 13.41}}}})
+	assert.Error(t, err)
+
+	_, err = validatePlaceRequest(PlaceRequest{Name: "Berlin", Boundary: models.GeoPolygon{orb.Ring{{13.2, 52.4}, {13.6, 52.4}, {13.6, 52.6}, {13.2, 52.6}}}})
+	assert.Error(t, err)
+}
+
+func TestPlaceService_CheckInAndOut(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	placeService := NewPlaceService(ctx.DB, ctx.Config)
+
+	admin := testutils.CreateTestUser(t, ctx.DB)
+	place, err := placeService.CreatePlace(admin.ID, PlaceRequest{Name: "Mauerpark", Boundary: testParkBoundary})
+	require.NoError(t, err)
+	assert.Equal(t, models.PlaceKindDogPark, place.Kind)
+
+	user := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, user.ID.String())
+	viewer := testutils.CreateTestUser(t, ctx.DB)
+
+	inside := models.NewGeoPoint(52.5200, 13.4050)
+	outside := models.NewGeoPoint(52.5300, 13.4050)
+	now := time.Now()
+
+	require.NoError(t, placeService.RecordVisit(ctx.DB, dog.ID, inside, now.Add(-30*time.Minute)))
+	require.NoError(t, placeService.RecordVisit(ctx.DB, dog.ID, inside, now.Add(-20*time.Minute)))
+
+	present, err := placeService.GetPresentDogs(viewer.ID, place.ID)
+	require.NoError(t, err)
+	require.Len(t, present, 1)
+	assert.Equal(t, dog.ID, present[0].ID)
+
+	// Leaving checks the dog out
+	require.NoError(t, placeService.RecordVisit(ctx.DB, dog.ID, outside, now.Add(-19*time.Minute)))
+	present, err = placeService.GetPresentDogs(viewer.ID, place.ID)
+	require.NoError(t, err)
+	assert.Empty(t, present)
+
+	// Coming straight back resumes the visit, a later return is a new one
+	require.NoError(t, placeService.RecordVisit(ctx.DB, dog.ID, inside, now.Add(-18*time.Minute)))
+	require.NoError(t, placeService.RecordVisit(ctx.DB, dog.ID, outside, now.Add(-10*time.Minute)))
+	require.NoError(t, placeService.RecordVisit(ctx.DB, dog.ID, inside, now))
+
+	var visits []models.PlaceVisit
+	require.NoError(t, ctx.DB.Where("dog_id = ?", dog.ID).Order("checked_in_at").Find(&visits).Error)
+	require.Len(t, visits, 2)
+	require.NotNil(t, visits[0].CheckedOutAt)
+	assert.Nil(t, visits[1].CheckedOutAt)
+
+	leaderboard, err := placeService.GetLeaderboard(viewer.ID, place.ID)
+	require.NoError(t, err)
+	require.Len(t, leaderboard, 1)
+	assert.Equal(t, 2, leaderboard[0].Visits)
+
+	// Owners who stop sharing their location disappear from both lists
+	require.NoError(t, ctx.DB.Model(user).Update("location_sharing", models.LocationSharingOff).Error)
+	present, err = placeService.GetPresentDogs(viewer.ID, place.ID)
+	require.NoError(t, err)
+	assert.Empty(t, present)
+	leaderboard, err = placeService.GetLeaderboard(viewer.ID, place.ID)
+	require.NoError(t, err)
+	assert.Empty(t, leaderboard)
+}
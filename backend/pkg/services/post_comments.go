@@ -0,0 +1,277 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// maxCommentDepth is the number of levels a comment thread nests, counting
+// the comments on the post itself
+const maxCommentDepth = 3
+
+// UpdateCommentRequest represents comment update request
+type UpdateCommentRequest struct {
+	Content string `json:"content" validate:"required,max=500"`
+}
+
+// replyPlacement returns the parent and depth of a reply to the comment. A
+// reply to a comment on the deepest level becomes its sibling.
+func replyPlacement(comment *models.Comment) (*uuid.UUID, int) {
+	if comment.Depth >= maxCommentDepth-1 {
+		return comment.ParentID, comment.Depth
+	}
+	parentID := comment.ID
+	return &parentID, comment.Depth + 1
+}
+
+// findComment returns the comment on the post
+func (s *PostService) findComment(postID uuid.UUID, commentID uuid.UUID) (*models.Comment, error) {
+	var comment models.Comment
+	err := s.db.Where("id = ? AND post_id = ?", commentID, postID).First(&comment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find comment")
+	}
+	return &comment, nil
+}
+
+// visibleComment returns the comment and its post if the user can see the
+// post and the comment has not been deleted
+func (s *PostService) visibleComment(userID string, commentID uuid.UUID) (*models.Comment, *models.Post, error) {
+	var comment models.Comment
+	err := s.db.Where("id = ? AND deleted_at IS NULL", commentID).First(&comment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, utils.WrapError(err, "failed to find comment")
+	}
+
+	var post models.Post
+	err = s.db.Scopes(visiblePostsTo(userID)).Where("id = ?", comment.PostID).First(&post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, utils.WrapError(err, "failed to find post")
+	}
+	return &comment, &post, nil
+}
+
+// markLikedComments sets Liked on the comments one of the user's dogs liked
+func (s *PostService) markLikedComments(userID string, comments []models.Comment) error {
+	if userID == "" || len(comments) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(comments))
+	for i := range comments {
+		ids[i] = comments[i].ID
+	}
+
+	var liked []uuid.UUID
+	if err := s.db.Model(&models.CommentLike{}).
+		Where("comment_id IN ? AND dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", ids, userID).
+		Pluck("comment_id", &liked).Error; err != nil {
+		return utils.WrapError(err, "failed to check comment likes")
+	}
+
+	likedSet := make(map[uuid.UUID]bool, len(liked))
+	for _, id := range liked {
+		likedSet[id] = true
+	}
+	for i := range comments {
+		comments[i].Liked = likedSet[comments[i].ID]
+	}
+	return nil
+}
+
+// UpdateComment changes the content of one of the user's comments
+func (s *PostService) UpdateComment(commentID uuid.UUID, userID string, req UpdateCommentRequest) (*models.Comment, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+
+	comment, _, err := s.visibleComment(userID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	authorID, err := dogOwnerID(s.db, comment.DogID)
+	if err != nil {
+		return nil, err
+	}
+	if authorID != userID {
+		return nil, utils.ErrForbidden
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.Comment{}).
+		Where("id = ? AND deleted_at IS NULL", comment.ID).
+		Updates(map[string]interface{}{"content": req.Content, "edited_at": now})
+	if result.Error != nil {
+		return nil, utils.WrapError(result.Error, "failed to update comment")
+	}
+	if result.RowsAffected == 0 {
+		return nil, utils.ErrNotFound // deleted meanwhile
+	}
+
+	if err := s.db.Preload("Dog").Where("id = ?", comment.ID).First(comment).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to reload comment")
+	}
+	if err := s.markLikedComments(userID, []models.Comment{*comment}); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// DeleteComment deletes a comment by its author or by the post's owner. A
+// comment with replies keeps its place in the thread without its content;
+// the last reply going also removes such a deleted parent.
+func (s *PostService) DeleteComment(commentID uuid.UUID, userID string) error {
+	comment, post, err := s.visibleComment(userID, commentID)
+	if err != nil {
+		return err
+	}
+	authorID, err := dogOwnerID(s.db, comment.DogID)
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		postOwnerID, err := dogOwnerID(s.db, post.DogID)
+		if err != nil {
+			return err
+		}
+		if postOwnerID != userID {
+			return utils.ErrForbidden
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the comment so a reply added meanwhile is not deleted with it
+		var locked models.Comment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND deleted_at IS NULL", comment.ID).
+			First(&locked).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrNotFound
+		}
+		if err != nil {
+			return utils.WrapError(err, "failed to lock comment")
+		}
+
+		if locked.ReplyCount > 0 {
+			if err := tx.Model(&locked).Updates(map[string]interface{}{"content": "", "deleted_at": time.Now()}).Error; err != nil {
+				return utils.WrapError(err, "failed to delete comment")
+			}
+			return nil
+		}
+		return removeComment(tx, &locked)
+	})
+}
+
+// removeComment deletes a comment without replies and, if its parent was
+// deleted and this was its last reply, the parent too
+func removeComment(tx *gorm.DB, comment *models.Comment) error {
+	for {
+		if err := tx.Delete(&models.Comment{}, "id = ?", comment.ID).Error; err != nil {
+			return utils.WrapError(err, "failed to delete comment")
+		}
+		if comment.ParentID == nil {
+			return nil
+		}
+
+		var parent models.Comment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", *comment.ParentID).First(&parent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return utils.WrapError(err, "failed to lock parent comment")
+		}
+		if parent.DeletedAt == nil || parent.ReplyCount > 1 {
+			if err := tx.Model(&parent).UpdateColumn("reply_count", gorm.Expr("reply_count - 1")).Error; err != nil {
+				return utils.WrapError(err, "failed to count reply")
+			}
+			return nil
+		}
+		comment = &parent
+	}
+}
+
+// LikeComment likes or unlikes a comment, returning whether it is now liked
+func (s *PostService) LikeComment(commentID uuid.UUID, userID string) (bool, error) {
+	comment, _, err := s.visibleComment(userID, commentID)
+	if err != nil {
+		return false, err
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return false, errors.New("invalid user ID format")
+	}
+
+	var userDog models.Dog
+	if err := s.db.Where("user_id = ?", userUUID).First(&userDog).Error; err != nil {
+		return false, errors.New("user dog not found")
+	}
+
+	authorID, err := dogOwnerID(s.db, comment.DogID)
+	if err != nil {
+		return false, err
+	}
+
+	liked := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("comment_id = ? AND dog_id = ?", comment.ID, userDog.ID).Delete(&models.CommentLike{})
+		if result.Error != nil {
+			return utils.WrapError(result.Error, "failed to unlike comment")
+		}
+		if result.RowsAffected > 0 {
+			return countCommentLike(tx, comment.ID, -1)
+		}
+
+		if err := ensureCanInteract(tx, userID, authorID); err != nil {
+			return err
+		}
+		like := models.CommentLike{CommentID: comment.ID, DogID: userDog.ID}
+		result = tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&like)
+		if result.Error != nil {
+			return utils.WrapError(result.Error, "failed to like comment")
+		}
+		liked = true
+		if result.RowsAffected == 0 {
+			return nil // liked meanwhile
+		}
+		return countCommentLike(tx, comment.ID, 1)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if liked {
+		s.notifyOthers(userUUID, []string{authorID}, RealtimeEventLike, map[string]interface{}{
+			"post_id":    comment.PostID,
+			"comment_id": comment.ID,
+			"dog_id":     userDog.ID,
+		})
+	}
+	return liked, nil
+}
+
+// countCommentLike adds delta to the comment's like count
+func countCommentLike(tx *gorm.DB, commentID uuid.UUID, delta int) error {
+	if err := tx.Model(&models.Comment{}).Where("id = ?", commentID).
+		UpdateColumn("like_count", gorm.Expr("like_count + ?", delta)).Error; err != nil {
+		return utils.WrapError(err, "failed to count comment like")
+	}
+	return nil
+}
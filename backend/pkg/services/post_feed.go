@@ -0,0 +1,408 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// TimelineMode selects how the timeline is built
+type TimelineMode string
+
+const (
+	TimelineModeChronological TimelineMode = "chronological" // followed and own dogs, newest first
+	TimelineModeRanked        TimelineMode = "ranked"        // "For You": followed, met and popular dogs, best first
+)
+
+const (
+	// rankedFeedWindow is how old a post may be to enter the ranked feed
+	rankedFeedWindow = 7 * 24 * time.Hour
+	// rankedFeedHalfLife is how quickly a post's score fades with age
+	rankedFeedHalfLife = 24 * time.Hour
+	// rankedFeedSize caps the posts ranked for one feed
+	rankedFeedSize = 300
+	// rankedFeedTTL is how long a ranked feed can be paged through before
+	// the client has to start again from the top
+	rankedFeedTTL = 30 * time.Minute
+
+	// maxConsecutiveFeedPosts is how many posts from one dog may follow
+	// each other in the ranked feed
+	maxConsecutiveFeedPosts = 2
+
+	socialFeedCandidates  = 500
+	popularFeedCandidates = 100
+	// engagedFeedCandidates bounds the most liked and commented posts
+	// considered for the popular candidates, leaving room for those the
+	// user cannot see
+	engagedFeedCandidates = 1000
+)
+
+// RankedFeedKey holds the post IDs of a ranked feed in order
+const RankedFeedKey = "feed:ranked:%s:%s" // user ID, feed ID
+
+// Social proximity weights by how the user knows the post's dog
+const (
+	feedProximityFollowed    = 1.0
+	feedProximityOwn         = 0.8
+	feedProximityEncountered = 0.7
+	feedProximityOther       = 0.4
+)
+
+// errFeedExpired is returned for a cursor into a ranked feed that is gone
+var errFeedExpired = utils.NewAPIError("FEED_EXPIRED", "The feed has expired, load it again from the top", nil)
+
+// feedCandidate is a post considered for the ranked feed
+type feedCandidate struct {
+	ID        uuid.UUID
+	DogID     uuid.UUID
+	CreatedAt time.Time
+	Likes     int
+	Comments  int
+	Proximity float64
+	Score     float64
+}
+
+// GetRankedFeed returns a page of the user's "For You" feed: posts of
+// followed and own dogs, of dogs the user's dogs have met and popular posts,
+// scored by recency, engagement and how close the user is to the dog. The
+// feed is ranked once and kept for paging, so later pages neither skip nor
+// repeat posts; the cursor returned with a page that is not the last
+// fetches the next one.
+func (s *PostService) GetRankedFeed(userID string, cursor string, limit int) ([]models.Post, string, error) {
+	var feedID uuid.UUID
+	position := 0
+	if cursor != "" {
+		var err error
+		feedID, position, err = decodeFeedCursor(cursor)
+		if err != nil {
+			return nil, "", utils.NewValidationError([]utils.ValidationError{{Field: "cursor", Message: "cursor is invalid"}})
+		}
+	} else {
+		feedID = uuid.New()
+		ranked, err := s.rankFeed(userID, time.Now())
+		if err != nil {
+			return nil, "", err
+		}
+		if err := s.storeFeed(userID, feedID, ranked); err != nil {
+			return nil, "", err
+		}
+	}
+
+	key := fmt.Sprintf(RankedFeedKey, userID, feedID)
+	ids, err := s.redis.LRange(s.ctx, key, int64(position), int64(position+limit)).Result()
+	if err != nil {
+		return nil, "", utils.WrapError(err, "failed to read feed")
+	}
+	if cursor != "" && len(ids) == 0 {
+		return nil, "", errFeedExpired
+	}
+
+	var next string
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = encodeFeedCursor(feedID, position+limit)
+	}
+
+	posts, err := s.loadFeedPosts(userID, ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return posts, next, nil
+}
+
+// rankFeed scores the user's candidate posts and orders them for the feed
+func (s *PostService) rankFeed(userID string, now time.Time) ([]feedCandidate, error) {
+	var ownDogIDs, followedDogIDs, encounteredDogIDs []uuid.UUID
+	if err := s.db.Model(&models.Dog{}).Where("user_id = ?", userID).Pluck("id", &ownDogIDs).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get user dogs")
+	}
+	if len(ownDogIDs) > 0 {
+		if err := s.db.Model(&models.Follower{}).Where("follower_dog_id IN ?", ownDogIDs).
+			Pluck("followed_dog_id", &followedDogIDs).Error; err != nil {
+			return nil, utils.WrapError(err, "failed to get followed dogs")
+		}
+		var metAsDog1, metAsDog2 []uuid.UUID
+		if err := s.db.Model(&models.Encounter{}).Where("dog1_id IN ?", ownDogIDs).
+			Distinct().Pluck("dog2_id", &metAsDog1).Error; err != nil {
+			return nil, utils.WrapError(err, "failed to get met dogs")
+		}
+		if err := s.db.Model(&models.Encounter{}).Where("dog2_id IN ?", ownDogIDs).
+			Distinct().Pluck("dog1_id", &metAsDog2).Error; err != nil {
+			return nil, utils.WrapError(err, "failed to get met dogs")
+		}
+		encounteredDogIDs = append(metAsDog1, metAsDog2...)
+	}
+
+	proximity := make(map[uuid.UUID]float64)
+	for _, ids := range []struct {
+		dogIDs []uuid.UUID
+		weight float64
+	}{
+		{encounteredDogIDs, feedProximityEncountered},
+		{ownDogIDs, feedProximityOwn},
+		{followedDogIDs, feedProximityFollowed},
+	} {
+		for _, dogID := range ids.dogIDs {
+			if ids.weight > proximity[dogID] {
+				proximity[dogID] = ids.weight
+			}
+		}
+	}
+
+	base := func() *gorm.DB {
+		return s.db.Model(&models.Post{}).
+			Select("posts.id, posts.dog_id, posts.created_at").
+			Scopes(visiblePostsTo(userID)).
+			Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID).
+			Where("posts.created_at > ? AND posts.created_at <= ?", now.Add(-rankedFeedWindow), now)
+	}
+
+	var candidates []feedCandidate
+	if len(proximity) > 0 {
+		dogIDs := make([]uuid.UUID, 0, len(proximity))
+		for dogID := range proximity {
+			dogIDs = append(dogIDs, dogID)
+		}
+		if err := base().Where("posts.dog_id IN ?", dogIDs).
+			Order("posts.created_at DESC").
+			Limit(socialFeedCandidates).
+			Scan(&candidates).Error; err != nil {
+			return nil, utils.WrapError(err, "failed to get feed posts")
+		}
+	}
+
+	// Engagement is aggregated once over the likes and comments of the
+	// window, and only the most engaged posts are joined back; a newer post
+	// comes first among posts without engagement
+	since := now.Add(-rankedFeedWindow)
+	engaged := s.db.Raw(`
+		SELECT post_id, SUM(weight) AS engagement FROM (
+			SELECT post_id, 1 AS weight FROM likes WHERE created_at > ?
+			UNION ALL
+			SELECT post_id, 2 AS weight FROM comments WHERE created_at > ? AND deleted_at IS NULL
+		) engagement
+		GROUP BY post_id
+		ORDER BY engagement DESC
+		LIMIT ?
+	`, since, since, engagedFeedCandidates)
+
+	var popular []feedCandidate
+	if err := base().
+		Joins("LEFT JOIN (?) engaged ON engaged.post_id = posts.id", engaged).
+		Order("COALESCE(engaged.engagement, 0) DESC, posts.created_at DESC").
+		Limit(popularFeedCandidates).
+		Scan(&popular).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get popular posts")
+	}
+
+	seen := make(map[uuid.UUID]bool, len(candidates)+len(popular))
+	for _, c := range candidates {
+		seen[c.ID] = true
+	}
+	for _, c := range popular {
+		if !seen[c.ID] {
+			seen[c.ID] = true
+			candidates = append(candidates, c)
+		}
+	}
+
+	if err := s.countEngagement(candidates); err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		candidates[i].Proximity = feedProximityOther
+		if weight, ok := proximity[candidates[i].DogID]; ok {
+			candidates[i].Proximity = weight
+		}
+		candidates[i].Score = feedScore(candidates[i], now)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].CreatedAt.After(candidates[j].CreatedAt)
+	})
+
+	ranked := diversifyFeed(candidates, maxConsecutiveFeedPosts)
+	if len(ranked) > rankedFeedSize {
+		ranked = ranked[:rankedFeedSize]
+	}
+	return ranked, nil
+}
+
+// countEngagement fills in the likes and live comments of the candidates
+func (s *PostService) countEngagement(candidates []feedCandidate) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+	postIDs := make([]uuid.UUID, len(candidates))
+	for i, c := range candidates {
+		postIDs[i] = c.ID
+	}
+
+	type count struct {
+		PostID uuid.UUID
+		Count  int
+	}
+	var likes, comments []count
+	if err := s.db.Model(&models.Like{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ?", postIDs).Group("post_id").
+		Scan(&likes).Error; err != nil {
+		return utils.WrapError(err, "failed to count likes")
+	}
+	if err := s.db.Model(&models.Comment{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ? AND deleted_at IS NULL", postIDs).Group("post_id").
+		Scan(&comments).Error; err != nil {
+		return utils.WrapError(err, "failed to count comments")
+	}
+
+	likesByPost := make(map[uuid.UUID]int, len(likes))
+	for _, l := range likes {
+		likesByPost[l.PostID] = l.Count
+	}
+	commentsByPost := make(map[uuid.UUID]int, len(comments))
+	for _, c := range comments {
+		commentsByPost[c.PostID] = c.Count
+	}
+	for i := range candidates {
+		candidates[i].Likes = likesByPost[candidates[i].ID]
+		candidates[i].Comments = commentsByPost[candidates[i].ID]
+	}
+	return nil
+}
+
+// storeFeed keeps the ranked post IDs for paging
+func (s *PostService) storeFeed(userID string, feedID uuid.UUID, ranked []feedCandidate) error {
+	if len(ranked) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(ranked))
+	for i, c := range ranked {
+		ids[i] = c.ID.String()
+	}
+
+	key := fmt.Sprintf(RankedFeedKey, userID, feedID)
+	_, err := s.redis.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(s.ctx, key, ids...)
+		pipe.Expire(s.ctx, key, rankedFeedTTL)
+		return nil
+	})
+	if err != nil {
+		return utils.WrapError(err, "failed to store feed")
+	}
+	return nil
+}
+
+// loadFeedPosts returns the posts in the given order, leaving out those
+// deleted or hidden from the user since the feed was ranked
+func (s *PostService) loadFeedPosts(userID string, ids []string) ([]models.Post, error) {
+	posts := []models.Post{}
+	if len(ids) == 0 {
+		return posts, nil
+	}
+
+	var found []models.Post
+	if err := s.db.Scopes(withPostDetails, visiblePostsTo(userID)).
+		Where("posts.id IN ?", ids).
+		Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID).
+		Find(&found).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get feed posts")
+	}
+
+	byID := make(map[string]models.Post, len(found))
+	for _, post := range found {
+		byID[post.ID.String()] = post
+	}
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+// feedScore rates a post for the ranked feed. The score halves every
+// rankedFeedHalfLife, grows with the log of its likes and comments
+// (comments count double) and is scaled by the candidate's proximity.
+func feedScore(c feedCandidate, now time.Time) float64 {
+	age := now.Sub(c.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	recency := math.Pow(0.5, age.Hours()/rankedFeedHalfLife.Hours())
+	engagement := 1 + math.Log1p(float64(c.Likes)+2*float64(c.Comments))
+	return recency * engagement * c.Proximity
+}
+
+// diversifyFeed reorders ranked candidates so that no more than max posts
+// from the same dog follow each other, moving the extra posts down to the
+// first place they fit. Posts that fit nowhere go at the end.
+func diversifyFeed(ranked []feedCandidate, max int) []feedCandidate {
+	result := make([]feedCandidate, 0, len(ranked))
+	pending := append([]feedCandidate(nil), ranked...)
+
+	for len(pending) > 0 {
+		placed := false
+		for i, c := range pending {
+			if consecutiveFrom(result, c.DogID) < max {
+				result = append(result, c)
+				pending = append(pending[:i], pending[i+1:]...)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return append(result, pending...)
+		}
+	}
+	return result
+}
+
+// consecutiveFrom returns how many posts at the end of the feed are the dog's
+func consecutiveFrom(feed []feedCandidate, dogID uuid.UUID) int {
+	n := 0
+	for i := len(feed) - 1; i >= 0 && feed[i].DogID == dogID; i-- {
+		n++
+	}
+	return n
+}
+
+// encodeFeedCursor returns the cursor for the ranked feed from position on
+func encodeFeedCursor(feedID uuid.UUID, position int) string {
+	raw := feedID.String() + "|" + strconv.Itoa(position)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeFeedCursor returns the feed and position the cursor points to
+func decodeFeedCursor(cursor string) (uuid.UUID, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	id, pos, found := strings.Cut(string(raw), "|")
+	if !found {
+		return uuid.Nil, 0, errors.New("malformed cursor")
+	}
+	feedID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	position, err := strconv.Atoi(pos)
+	if err != nil || position < 0 {
+		return uuid.Nil, 0, errors.New("malformed cursor")
+	}
+	return feedID, position, nil
+}
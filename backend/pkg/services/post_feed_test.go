@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedScore(t *testing.T) {
+	now := time.Now()
+	fresh := feedCandidate{CreatedAt: now.Add(-time.Hour), Proximity: feedProximityOther}
+	old := feedCandidate{CreatedAt: now.Add(-3 * 24 * time.Hour), Proximity: feedProximityOther}
+	liked := old
+	liked.Likes = 50
+	followed := fresh
+	followed.Proximity = feedProximityFollowed
+
+	assert.Greater(t, feedScore(fresh, now), feedScore(old, now))
+	assert.Greater(t, feedScore(liked, now), feedScore(old, now))
+	assert.Greater(t, feedScore(followed, now), feedScore(fresh, now))
+	assert.InDelta(t, feedScore(fresh, now)/2, feedScore(feedCandidate{CreatedAt: fresh.CreatedAt.Add(-rankedFeedHalfLife), Proximity: feedProximityOther}, now), 1e-9)
+}
+
+func TestDiversifyFeed(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	dogs := []uuid.UUID{a, a, a, a, b, a, b}
+	ranked := make([]feedCandidate, len(dogs))
+	for i, dogID := range dogs {
+		ranked[i] = feedCandidate{ID: uuid.New(), DogID: dogID}
+	}
+
+	feed := diversifyFeed(ranked, 2)
+	require.Len(t, feed, len(ranked))
+	got := make([]uuid.UUID, len(feed))
+	for i, c := range feed {
+		got[i] = c.DogID
+	}
+	assert.Equal(t, []uuid.UUID{a, a, b, a, a, b, a}, got)
+	assert.Equal(t, ranked[4].ID, feed[2].ID)
+
+	// Posts that cannot be spread out still make it into the feed
+	assert.Len(t, diversifyFeed([]feedCandidate{{DogID: a}, {DogID: a}, {DogID: a}}, 2), 3)
+}
+
+func TestFeedCursor(t *testing.T) {
+	feedID := uuid.New()
+	gotID, position, err := decodeFeedCursor(encodeFeedCursor(feedID, 40))
+	require.NoError(t, err)
+	assert.Equal(t, feedID, gotID)
+	assert.Equal(t, 40, position)
+
+	_, _, err = decodeFeedCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+func TestPostService_GetRankedFeed(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	service := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	viewer := testutils.CreateTestUser(t, ctx.DB)
+	viewerDog := testutils.CreateTestDog(t, ctx.DB, viewer.ID.String())
+	friend := testutils.CreateTestUser(t, ctx.DB)
+	friendDog := testutils.CreateTestDog(t, ctx.DB, friend.ID.String())
+	stranger := testutils.CreateTestUser(t, ctx.DB)
+	strangerDog := testutils.CreateTestDog(t, ctx.DB, stranger.ID.String())
+
+	require.NoError(t, ctx.DB.Create(&models.Follower{FollowerDogID: viewerDog.ID, FollowedDogID: friendDog.ID}).Error)
+
+	for i := 0; i < 4; i++ {
+		_, err := service.CreatePost(friend.ID.String(), CreatePostRequest{DogID: friendDog.ID.String(), Content: "friend"})
+		require.NoError(t, err)
+	}
+	popular, err := service.CreatePost(stranger.ID.String(), CreatePostRequest{DogID: strangerDog.ID.String(), Content: "popular"})
+	require.NoError(t, err)
+	_, err = service.CreatePost(stranger.ID.String(), CreatePostRequest{
+		DogID:      strangerDog.ID.String(),
+		Content:    "hidden",
+		Visibility: models.PostVisibilityPrivate,
+	})
+	require.NoError(t, err)
+
+	// Pages follow on from each other without repeats, and the popular
+	// post breaks up the followed dog's run
+	seen := make(map[uuid.UUID]bool)
+	var dogIDs []uuid.UUID
+	cursor := ""
+	for {
+		posts, next, err := service.GetRankedFeed(viewer.ID.String(), cursor, 2)
+		require.NoError(t, err)
+		for _, post := range posts {
+			assert.False(t, seen[post.ID], "post repeated")
+			seen[post.ID] = true
+			dogIDs = append(dogIDs, post.DogID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Len(t, seen, 5)
+	assert.True(t, seen[popular.ID])
+	assert.Equal(t, strangerDog.ID, dogIDs[2])
+
+	// Someone who follows no one still gets popular posts
+	newcomer := testutils.CreateTestUser(t, ctx.DB)
+	posts, _, err := service.GetRankedFeed(newcomer.ID.String(), "", 20)
+	require.NoError(t, err)
+	assert.Len(t, posts, 5)
+
+	_, _, err = service.GetRankedFeed(viewer.ID.String(), encodeFeedCursor(uuid.New(), 0), 2)
+	assert.Error(t, err)
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"log"
 	"strings"
 
 	"github.com/doggyclub/backend/config"
@@ -10,41 +12,67 @@ import (
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PostService struct {
-	db    *gorm.DB
-	redis *redis.Client
-	cfg   config.Config
+	db       *gorm.DB
+	redis    *redis.Client
+	cfg      config.Config
+	realtime *RealtimeService
+	timeline *TimelineService
+	ctx      context.Context
 }
 
 func NewPostService(db *gorm.DB, redis *redis.Client, cfg config.Config) *PostService {
 	return &PostService{
-		db:    db,
-		redis: redis,
-		cfg:   cfg,
+		db:       db,
+		redis:    redis,
+		cfg:      cfg,
+		realtime: NewRealtimeService(redis, cfg),
+		timeline: NewTimelineService(db, redis, cfg),
+		ctx:      context.Background(),
 	}
 }
 
-// CreatePostRequest represents post creation request
+// CreatePostRequest represents post creation request. Media lists the
+// post's photos and videos in order; MediaUrls and MediaType are the older
+// form without dimensions. IsPublic is the older form of Visibility.
 type CreatePostRequest struct {
-	DogID     string   `json:"dog_id" validate:"required"`
-	Content   string   `json:"content" validate:"required,max=1000"`
-	MediaUrls []string `json:"media_urls" validate:"max=10"`
-	MediaType string   `json:"media_type" validate:"oneof=photo video mixed"`
-	Hashtags  []string `json:"hashtags" validate:"max=20"`
-	Location  *string  `json:"location" validate:"omitempty,max=200"`
-	IsPublic  bool     `json:"is_public"`
+	DogID      string                `json:"dog_id" validate:"required"`
+	Content    string                `json:"content" validate:"required,max=1000"`
+	Media      []PostMediaRequest    `json:"media" validate:"max=10,dive"`
+	MediaUrls  []string              `json:"media_urls" validate:"max=10,dive,url,max=500"`
+	MediaType  string                `json:"media_type" validate:"omitempty,oneof=photo video mixed"`
+	Hashtags   []string              `json:"hashtags" validate:"max=20"`
+	Location   *string               `json:"location" validate:"omitempty,max=200"`
+	PlaceID    *uuid.UUID            `json:"place_id,omitempty"`
+	Visibility models.PostVisibility `json:"visibility" validate:"omitempty,oneof=public followers private"`
+	IsPublic   *bool                 `json:"is_public,omitempty"`
 }
 
-// UpdatePostRequest represents post update request
+// UpdatePostRequest represents post update request. Media and MediaUrls
+// replace all of the post's media; ClearPlace removes the place.
 type UpdatePostRequest struct {
-	Content   *string  `json:"content,omitempty" validate:"omitempty,max=1000"`
-	MediaUrls *[]string `json:"media_urls,omitempty" validate:"omitempty,max=10"`
-	MediaType *string  `json:"media_type,omitempty" validate:"omitempty,oneof=photo video mixed"`
-	Hashtags  *[]string `json:"hashtags,omitempty" validate:"omitempty,max=20"`
-	Location  *string  `json:"location,omitempty" validate:"omitempty,max=200"`
-	IsPublic  *bool    `json:"is_public,omitempty"`
+	Content    *string                `json:"content,omitempty" validate:"omitempty,max=1000"`
+	Media      *[]PostMediaRequest    `json:"media,omitempty" validate:"omitempty,max=10,dive"`
+	MediaUrls  *[]string              `json:"media_urls,omitempty" validate:"omitempty,max=10,dive,url,max=500"`
+	MediaType  *string                `json:"media_type,omitempty" validate:"omitempty,oneof=photo video mixed"`
+	Hashtags   *[]string              `json:"hashtags,omitempty" validate:"omitempty,max=20"`
+	Location   *string                `json:"location,omitempty" validate:"omitempty,max=200"`
+	PlaceID    *uuid.UUID             `json:"place_id,omitempty"`
+	ClearPlace bool                   `json:"clear_place"`
+	Visibility *models.PostVisibility `json:"visibility,omitempty" validate:"omitempty,oneof=public followers private"`
+	IsPublic   *bool                  `json:"is_public,omitempty"`
+}
+
+// PostMediaRequest describes a photo or video attached to a post
+type PostMediaRequest struct {
+	URL      string           `json:"url" validate:"required,url,max=500"`
+	Type     models.MediaType `json:"type" validate:"required,oneof=photo video"`
+	Width    int              `json:"width" validate:"min=0,max=20000"`
+	Height   int              `json:"height" validate:"min=0,max=20000"`
+	Blurhash string           `json:"blurhash" validate:"max=100"`
 }
 
 // CommentRequest represents comment request
@@ -69,87 +97,80 @@ func (s *PostService) CreatePost(userID string, req CreatePostRequest) (*models.
 		return nil, utils.WrapError(err, "failed to find dog")
 	}
 
+	if err := ensureNotSuspended(s.db, userID); err != nil {
+		return nil, err
+	}
+
 	// Extract hashtags from content
-	hashtags := append(req.Hashtags, extractHashtags(req.Content)...)
-	hashtags = removeDuplicates(hashtags)
+	hashtags := normalizeHashtags(append(req.Hashtags, extractHashtags(req.Content)...))
 
 	dogUUID, err := uuid.Parse(req.DogID)
 	if err != nil {
 		return nil, errors.New("invalid dog ID format")
 	}
 
-	// Use the first media URL as the image URL for simplified model
-	var imageURL string
-	if len(req.MediaUrls) > 0 {
-		imageURL = req.MediaUrls[0]
+	if err := s.ensurePlaceExists(req.PlaceID); err != nil {
+		return nil, err
 	}
 
 	post := models.Post{
-		DogID:    dogUUID,
-		Content:  req.Content,
-		ImageURL: imageURL,
+		DogID:        dogUUID,
+		Content:      req.Content,
+		Visibility:   postVisibility(req.Visibility, req.IsPublic),
+		LocationName: req.Location,
+		PlaceID:      req.PlaceID,
+		Media:        postMedia(req.Media, req.MediaUrls, req.MediaType),
 	}
 
-	if err := s.db.Create(&post).Error; err != nil {
-		return nil, utils.WrapError(err, "failed to create post")
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Dog", "Place", "Hashtags").Create(&post).Error; err != nil {
+			return utils.WrapError(err, "failed to create post")
+		}
+		return syncPostHashtags(tx, post.ID, hashtags)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.timeline.AddPost(userID, &post); err != nil {
+		log.Printf("Failed to add post %s to timelines: %v", post.ID, err)
 	}
 
 	// Load post with dog information
-	if err := s.db.Preload("Dog").Where("id = ?", post.ID).First(&post).Error; err != nil {
+	if err := s.db.Scopes(withPostDetails).Where("id = ?", post.ID).First(&post).Error; err != nil {
 		return nil, utils.WrapError(err, "failed to reload post")
 	}
 
 	return &post, nil
 }
 
-// GetTimeline returns posts for user's timeline
+// GetTimeline returns posts for user's timeline, newest first, from the
+// timeline kept for the user in Redis
 func (s *PostService) GetTimeline(userID string, limit int, offset int) ([]models.Post, int64, error) {
-	var posts []models.Post
-	var total int64
-
-	// Get followed dogs
-	var followedDogIDs []string
-	s.db.Model(&models.Follower{}).Where("follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID).Pluck("followed_dog_id", &followedDogIDs)
-
-	// Get user's own dogs
-	var userDogIDs []string
-	s.db.Model(&models.Dog{}).Where("user_id = ?", userID).Pluck("id", &userDogIDs)
-
-	// Combine all dog IDs
-	allDogIDs := append(followedDogIDs, userDogIDs...)
-	if len(allDogIDs) == 0 {
-		return posts, 0, nil
+	postIDs, total, err := s.timeline.Page(userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Count total posts
-	if err := s.db.Model(&models.Post{}).Where("dog_id IN ? AND is_public = true", allDogIDs).Count(&total).Error; err != nil {
-		return nil, 0, utils.WrapError(err, "failed to count posts")
+	ids := make([]string, len(postIDs))
+	for i, postID := range postIDs {
+		ids[i] = postID.String()
 	}
-
-	// Get posts with pagination
-	if err := s.db.Preload("Dog").
-		Where("dog_id IN ? AND is_public = true", allDogIDs).
-		Order("created_at DESC").
-		Limit(limit).Offset(offset).
-		Find(&posts).Error; err != nil {
-		return nil, 0, utils.WrapError(err, "failed to get timeline posts")
+	posts, err := s.loadFeedPosts(userID, ids)
+	if err != nil {
+		return nil, 0, err
 	}
-
 	return posts, total, nil
 }
 
 // GetPost returns a single post by ID
 func (s *PostService) GetPost(postID string, userID string) (*models.Post, error) {
 	var post models.Post
-	query := s.db.Preload("Dog")
+	query := s.db.Scopes(withPostDetails, visiblePostsTo(userID))
 
-	// Check if user can access this post
+	// Posts by blocked users are hidden
 	if userID != "" {
-		// Check if it's a public post or user owns the dog
-		query = query.Where("(is_public = true OR dog_id IN (SELECT id FROM dogs WHERE user_id = ?))", userID)
-	} else {
-		// Public access only
-		query = query.Where("is_public = true")
+		query = query.Where("dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID)
 	}
 
 	if err := query.Where("id = ?", postID).First(&post).Error; err != nil {
@@ -180,42 +201,110 @@ func (s *PostService) UpdatePost(postID string, userID string, req UpdatePostReq
 		return nil, utils.WrapError(err, "failed to find post")
 	}
 
+	if err := s.ensurePlaceExists(req.PlaceID); err != nil {
+		return nil, err
+	}
+
 	// Update fields
 	updates := make(map[string]interface{})
+	content := post.Content
 	if req.Content != nil {
 		updates["content"] = *req.Content
-		// Extract hashtags from new content
+		content = *req.Content
+	}
+
+	// Hashtags are the given ones plus those in the content
+	var hashtags []string
+	updateHashtags := req.Content != nil || req.Hashtags != nil
+	if updateHashtags {
+		var tags []string
 		if req.Hashtags != nil {
-			hashtags := append(*req.Hashtags, extractHashtags(*req.Content)...)
-			updates["hashtags"] = removeDuplicates(hashtags)
-		} else {
-			updates["hashtags"] = extractHashtags(*req.Content)
+			tags = *req.Hashtags
 		}
+		hashtags = normalizeHashtags(append(tags, extractHashtags(content)...))
 	}
-	if req.MediaUrls != nil {
-		updates["media_urls"] = *req.MediaUrls
+	if req.Location != nil {
+		if *req.Location == "" {
+			updates["location_name"] = nil
+		} else {
+			updates["location_name"] = *req.Location
+		}
 	}
-	if req.MediaType != nil {
-		updates["media_type"] = *req.MediaType
+	if req.ClearPlace {
+		updates["place_id"] = nil
 	}
-	if req.Hashtags != nil && req.Content == nil {
-		updates["hashtags"] = *req.Hashtags
+	if req.PlaceID != nil {
+		updates["place_id"] = *req.PlaceID
 	}
-	if req.Location != nil {
-		updates["location"] = *req.Location
+	if req.Visibility != nil || req.IsPublic != nil {
+		var visibility models.PostVisibility
+		if req.Visibility != nil {
+			visibility = *req.Visibility
+		}
+		updates["visibility"] = postVisibility(visibility, req.IsPublic)
 	}
-	if req.IsPublic != nil {
-		updates["is_public"] = *req.IsPublic
+
+	previousVisibility := post.Visibility
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&post).Updates(updates).Error; err != nil {
+				return utils.WrapError(err, "failed to update post")
+			}
+		}
+
+		if updateHashtags {
+			if err := syncPostHashtags(tx, post.ID, hashtags); err != nil {
+				return err
+			}
+		}
+
+		if req.Media == nil && req.MediaUrls == nil {
+			return nil
+		}
+		var media []PostMediaRequest
+		if req.Media != nil {
+			media = *req.Media
+		}
+		var urls []string
+		if req.MediaUrls != nil {
+			urls = *req.MediaUrls
+		}
+		mediaType := ""
+		if req.MediaType != nil {
+			mediaType = *req.MediaType
+		}
+
+		if err := tx.Where("post_id = ?", post.ID).Delete(&models.PostMedia{}).Error; err != nil {
+			return utils.WrapError(err, "failed to replace post media")
+		}
+		items := postMedia(media, urls, mediaType)
+		for i := range items {
+			items[i].PostID = post.ID
+		}
+		if len(items) > 0 {
+			if err := tx.Create(&items).Error; err != nil {
+				return utils.WrapError(err, "failed to replace post media")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if len(updates) > 0 {
-		if err := s.db.Model(&post).Updates(updates).Error; err != nil {
-			return nil, utils.WrapError(err, "failed to update post")
+	// Rewrite the timelines for the new audience
+	if visibility, ok := updates["visibility"].(models.PostVisibility); ok && visibility != previousVisibility {
+		if err := s.timeline.RemovePost(userID, &post); err != nil {
+			log.Printf("Failed to remove post %s from timelines: %v", post.ID, err)
+		}
+		post.Visibility = visibility
+		if err := s.timeline.AddPost(userID, &post); err != nil {
+			log.Printf("Failed to add post %s to timelines: %v", post.ID, err)
 		}
 	}
 
 	// Reload post with dog information
-	if err := s.db.Preload("Dog").Where("id = ?", postID).First(&post).Error; err != nil {
+	if err := s.db.Scopes(withPostDetails).Where("id = ?", postID).First(&post).Error; err != nil {
 		return nil, utils.WrapError(err, "failed to reload post")
 	}
 
@@ -235,10 +324,22 @@ func (s *PostService) DeletePost(postID string, userID string) error {
 		return utils.WrapError(err, "failed to find post")
 	}
 
-	if err := s.db.Delete(&post).Error; err != nil {
-		return utils.WrapError(err, "failed to delete post")
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("post_id = ?", post.ID).Delete(&models.PostHashtag{}).Error; err != nil {
+			return utils.WrapError(err, "failed to unlink hashtags")
+		}
+		if err := tx.Delete(&post).Error; err != nil {
+			return utils.WrapError(err, "failed to delete post")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	if err := s.timeline.RemovePost(userID, &post); err != nil {
+		log.Printf("Failed to remove post %s from timelines: %v", post.ID, err)
+	}
 	return nil
 }
 
@@ -246,7 +347,7 @@ func (s *PostService) DeletePost(postID string, userID string) error {
 func (s *PostService) LikePost(postID string, userID string) (bool, error) {
 	// Check if post exists and is accessible
 	var post models.Post
-	if err := s.db.Where("id = ? AND is_public = true", postID).First(&post).Error; err != nil {
+	if err := s.db.Scopes(visiblePostsTo(userID)).Where("id = ?", postID).First(&post).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return false, utils.ErrNotFound
 		}
@@ -273,6 +374,14 @@ func (s *PostService) LikePost(postID string, userID string) (bool, error) {
 	}
 
 	// Like the post
+	ownerID, err := dogOwnerID(s.db, post.DogID)
+	if err != nil {
+		return false, err
+	}
+	if err := ensureCanInteract(s.db, userID, ownerID); err != nil {
+		return false, err
+	}
+
 	postUUID, err := uuid.Parse(postID)
 	if err != nil {
 		return false, errors.New("invalid post ID format")
@@ -299,10 +408,17 @@ func (s *PostService) LikePost(postID string, userID string) (bool, error) {
 
 	// Increment likes count
 	s.db.Model(&post).UpdateColumn("likes_count", gorm.Expr("likes_count + 1"))
+
+	s.notifyOthers(userUUID, []string{ownerID}, RealtimeEventLike, map[string]interface{}{
+		"post_id": post.ID,
+		"dog_id":  userDog.ID,
+	})
 	return true, nil
 }
 
-// AddComment adds a comment to a post
+// AddComment adds a comment to a post, or a reply to one of its comments.
+// Replies to a comment at the deepest allowed level join that comment's
+// thread instead of nesting further.
 func (s *PostService) AddComment(postID string, userID string, req CommentRequest) (*models.Comment, error) {
 	// Validate request
 	if err := utils.ValidateStruct(req); err != nil {
@@ -311,25 +427,21 @@ func (s *PostService) AddComment(postID string, userID string, req CommentReques
 
 	// Check if post exists and is accessible
 	var post models.Post
-	if err := s.db.Where("id = ? AND is_public = true", postID).First(&post).Error; err != nil {
+	if err := s.db.Scopes(visiblePostsTo(userID)).Where("id = ?", postID).First(&post).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, utils.ErrNotFound
 		}
 		return nil, utils.WrapError(err, "failed to find post")
 	}
 
-	// If it's a reply, check if parent comment exists
-	if req.ParentID != nil {
-		var parentComment models.Comment
-		if err := s.db.Where("id = ? AND post_id = ?", *req.ParentID, postID).First(&parentComment).Error; err != nil {
-			return nil, utils.ErrNotFound
-		}
-	}
-
-	postUUID, err := uuid.Parse(postID)
+	ownerID, err := dogOwnerID(s.db, post.DogID)
 	if err != nil {
-		return nil, errors.New("invalid post ID format")
+		return nil, err
 	}
+	if err := ensureCanInteract(s.db, userID, ownerID); err != nil {
+		return nil, err
+	}
+
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
 		return nil, errors.New("invalid user ID format")
@@ -342,125 +454,277 @@ func (s *PostService) AddComment(postID string, userID string, req CommentReques
 	}
 
 	comment := models.Comment{
-		PostID:  postUUID,
+		PostID:  post.ID,
 		DogID:   userDog.ID,
 		Content: req.Content,
 	}
 
-	if err := s.db.Create(&comment).Error; err != nil {
-		return nil, utils.WrapError(err, "failed to create comment")
+	// The post's owner and, for replies, the parent comment's author
+	recipients := []string{ownerID}
+
+	// If it's a reply, check if parent comment exists
+	var parentComment *models.Comment
+	if req.ParentID != nil {
+		parentUUID, err := uuid.Parse(*req.ParentID)
+		if err != nil {
+			return nil, utils.NewValidationError([]utils.ValidationError{{Field: "parent_id", Message: "parent_id must be a valid UUID"}})
+		}
+		parentComment, err = s.findComment(post.ID, parentUUID)
+		if err != nil {
+			return nil, err
+		}
+		if parentComment.DeletedAt != nil {
+			return nil, utils.ErrNotFound
+		}
+
+		parentAuthorID, err := dogOwnerID(s.db, parentComment.DogID)
+		if err != nil {
+			return nil, err
+		}
+		if err := ensureNotBlocked(s.db, userID, parentAuthorID); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, parentAuthorID)
+
+		comment.ParentID, comment.Depth = replyPlacement(parentComment)
 	}
 
-	// Increment comments count
-	s.db.Model(&post).UpdateColumn("comments_count", gorm.Expr("comments_count + 1"))
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the parent so it cannot be deleted before the reply is counted
+		if comment.ParentID != nil {
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+				Where("id = ? AND deleted_at IS NULL", *comment.ParentID).
+				First(&models.Comment{}).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return utils.ErrNotFound
+			}
+			if err != nil {
+				return utils.WrapError(err, "failed to lock parent comment")
+			}
+		}
+		if err := tx.Omit(clause.Associations).Create(&comment).Error; err != nil {
+			return utils.WrapError(err, "failed to create comment")
+		}
+		if comment.ParentID != nil {
+			if err := tx.Model(&models.Comment{}).Where("id = ?", *comment.ParentID).
+				UpdateColumn("reply_count", gorm.Expr("reply_count + 1")).Error; err != nil {
+				return utils.WrapError(err, "failed to count reply")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Load comment with user information
-	if err := s.db.Preload("User").Where("id = ?", comment.ID).First(&comment).Error; err != nil {
+	// Load comment with dog information
+	if err := s.db.Preload("Dog").Where("id = ?", comment.ID).First(&comment).Error; err != nil {
 		return nil, utils.WrapError(err, "failed to reload comment")
 	}
 
+	data := map[string]interface{}{
+		"post_id":    post.ID,
+		"comment_id": comment.ID,
+		"dog_id":     userDog.ID,
+	}
+	if parentComment != nil {
+		data["parent_id"] = parentComment.ID
+	}
+	s.notifyOthers(userUUID, recipients, RealtimeEventComment, data)
+
 	return &comment, nil
 }
 
-// GetComments returns comments for a post
-func (s *PostService) GetComments(postID string, limit int, offset int) ([]models.Comment, int64, error) {
-	var comments []models.Comment
-	var total int64
+// GetComments returns a page of a thread of the post's comments: the
+// comments on the post itself, newest first, when parentID is nil, or the
+// replies to that comment, oldest first. The cursor returned with a full
+// page fetches the next one; it is empty after the last page.
+func (s *PostService) GetComments(postID string, userID string, parentID *uuid.UUID, cursor string, limit int) ([]models.Comment, string, error) {
+	var post models.Post
+	if err := s.db.Scopes(visiblePostsTo(userID)).Select("id").Where("id = ?", postID).First(&post).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", utils.ErrNotFound
+		}
+		return nil, "", utils.WrapError(err, "failed to find post")
+	}
 
-	// Count total comments
-	if err := s.db.Model(&models.Comment{}).Where("post_id = ? AND parent_id IS NULL", postID).Count(&total).Error; err != nil {
-		return nil, 0, utils.WrapError(err, "failed to count comments")
+	query := s.db.Model(&models.Comment{}).Where("post_id = ?", post.ID)
+	order := "DESC"
+	if parentID != nil {
+		if _, err := s.findComment(post.ID, *parentID); err != nil {
+			return nil, "", err
+		}
+		query = query.Where("parent_id = ?", *parentID)
+		order = "ASC"
+	} else {
+		query = query.Where("parent_id IS NULL")
+	}
+	if userID != "" {
+		query = query.Where("dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID)
 	}
 
-	// Get comments with replies
-	if err := s.db.Preload("User").Preload("Replies.User").
-		Where("post_id = ? AND parent_id IS NULL", postID).
-		Order("created_at DESC").
-		Limit(limit).Offset(offset).
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", utils.NewValidationError([]utils.ValidationError{{Field: "cursor", Message: "cursor is invalid"}})
+		}
+		comparison := "<"
+		if order == "ASC" {
+			comparison = ">"
+		}
+		query = query.Where("(created_at, id) "+comparison+" (?, ?)", createdAt, id)
+	}
+
+	var comments []models.Comment
+	if err := query.Preload("Dog").
+		Order("created_at " + order).Order("id " + order).
+		Limit(limit + 1).
 		Find(&comments).Error; err != nil {
-		return nil, 0, utils.WrapError(err, "failed to get comments")
+		return nil, "", utils.WrapError(err, "failed to get comments")
+	}
+
+	var next string
+	if len(comments) > limit {
+		comments = comments[:limit]
+		next = encodeCursor(comments[limit-1].CreatedAt, comments[limit-1].ID)
+	}
+
+	if err := s.markLikedComments(userID, comments); err != nil {
+		return nil, "", err
+	}
+	for i := range comments {
+		if comments[i].DeletedAt != nil {
+			comments[i].Dog = models.Dog{}
+		}
 	}
 
-	return comments, total, nil
+	return comments, next, nil
 }
 
-// FollowDog follows or unfollows a dog
-func (s *PostService) FollowDog(dogID string, userID string) (bool, error) {
-	// Check if dog exists and is public
+// FollowDog follows or unfollows a dog. Dogs of private users and of users
+// who approve their followers get a follow request instead, and following
+// again while the request is pending cancels it.
+func (s *PostService) FollowDog(dogID string, userID string) (FollowState, error) {
 	var dog models.Dog
-	if err := s.db.Where("id = ? AND is_public = true", dogID).First(&dog).Error; err != nil {
+	if err := s.db.Where("id = ?", dogID).First(&dog).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return false, utils.ErrNotFound
+			return FollowStateNone, utils.ErrNotFound
 		}
-		return false, utils.WrapError(err, "failed to find dog")
+		return FollowStateNone, utils.WrapError(err, "failed to find dog")
 	}
 
 	// Can't follow own dog
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
-		return false, errors.New("invalid user ID format")
+		return FollowStateNone, errors.New("invalid user ID format")
 	}
 	if dog.UserID == userUUID {
-		return false, utils.NewAPIError("INVALID_ACTION", "Cannot follow your own dog", nil)
+		return FollowStateNone, utils.NewAPIError("INVALID_ACTION", "Cannot follow your own dog", nil)
 	}
 
 	// Get follower dog
 	var followerDog models.Dog
 	if err := s.db.Where("user_id = ?", userUUID).First(&followerDog).Error; err != nil {
-		return false, errors.New("follower dog not found")
-	}
-
-	dogUUID, err := uuid.Parse(dogID)
-	if err != nil {
-		return false, errors.New("invalid dog ID format")
+		return FollowStateNone, errors.New("follower dog not found")
 	}
 
 	// Check if already following
 	var existingFollow models.Follower
-	err = s.db.Where("follower_dog_id = ? AND followed_dog_id = ?", followerDog.ID, dogUUID).First(&existingFollow).Error
+	err = s.db.Where("follower_dog_id = ? AND followed_dog_id = ?", followerDog.ID, dog.ID).First(&existingFollow).Error
 
 	if err == nil {
 		// Unfollow the dog
 		if err := s.db.Delete(&existingFollow).Error; err != nil {
-			return false, utils.WrapError(err, "failed to unfollow dog")
+			return FollowStateNone, utils.WrapError(err, "failed to unfollow dog")
 		}
-		return false, nil
+		if err := s.timeline.Unfollow(userID, dog.ID); err != nil {
+			log.Printf("Failed to remove dog %s from timeline of user %s: %v", dog.ID, userID, err)
+		}
+		return FollowStateNone, nil
 	}
 
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
-		return false, utils.WrapError(err, "failed to check existing follow")
+		return FollowStateNone, utils.WrapError(err, "failed to check existing follow")
+	}
+
+	// Cancel a pending request
+	result := s.db.Where("follower_dog_id = ? AND followed_dog_id = ?", followerDog.ID, dog.ID).Delete(&models.FollowRequest{})
+	if result.Error != nil {
+		return FollowStateNone, utils.WrapError(result.Error, "failed to cancel follow request")
+	}
+	if result.RowsAffected > 0 {
+		return FollowStateNone, nil
 	}
 
 	// Follow the dog
+	if err := ensureCanInteract(s.db, userID, dog.UserID.String()); err != nil {
+		return FollowStateNone, err
+	}
+
+	approval, err := requiresFollowApproval(s.db, dog.UserID)
+	if err != nil {
+		return FollowStateNone, err
+	}
+	if approval {
+		request := models.FollowRequest{
+			FollowerDogID: followerDog.ID,
+			FollowedDogID: dog.ID,
+		}
+		if err := s.db.Omit(clause.Associations).Create(&request).Error; err != nil {
+			return FollowStateNone, utils.WrapError(err, "failed to request follow")
+		}
+
+		s.realtime.Notify([]uuid.UUID{dog.UserID}, RealtimeEventFollowRequest, map[string]interface{}{
+			"request_id":      request.ID,
+			"follower_dog_id": followerDog.ID,
+			"followed_dog_id": dog.ID,
+		})
+		return FollowStateRequested, nil
+	}
+
 	follow := models.Follower{
 		FollowerDogID: followerDog.ID,
-		FollowedDogID: dogUUID,
+		FollowedDogID: dog.ID,
 	}
 
 	if err := s.db.Create(&follow).Error; err != nil {
-		return false, utils.WrapError(err, "failed to follow dog")
+		return FollowStateNone, utils.WrapError(err, "failed to follow dog")
 	}
 
-	return true, nil
+	if err := s.timeline.Follow(userID, dog.ID); err != nil {
+		log.Printf("Failed to backfill dog %s into timeline of user %s: %v", dog.ID, userID, err)
+	}
+
+	s.realtime.Notify([]uuid.UUID{dog.UserID}, RealtimeEventFollow, map[string]interface{}{
+		"follower_dog_id": followerDog.ID,
+		"followed_dog_id": dog.ID,
+	})
+	return FollowStateFollowing, nil
+}
+
+// notifyOthers publishes the event to the recipients other than the actor
+func (s *PostService) notifyOthers(actorID uuid.UUID, recipientIDs []string, eventType RealtimeEventType, data interface{}) {
+	var userIDs []uuid.UUID
+	for _, recipientID := range recipientIDs {
+		if userID, err := uuid.Parse(recipientID); err == nil && userID != actorID {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	s.realtime.Notify(userIDs, eventType, data)
 }
 
-// SearchPosts searches for posts by content or hashtags
+// SearchPosts searches public posts by content or hashtag, best match first
 func (s *PostService) SearchPosts(query string, limit int, offset int) ([]models.Post, int64, error) {
 	var posts []models.Post
 	var total int64
 
-	searchQuery := "%" + query + "%"
-
 	// Count total results
-	countQuery := s.db.Model(&models.Post{}).Where("is_public = true AND (content ILIKE ? OR ? = ANY(hashtags))", searchQuery, query)
-	if err := countQuery.Count(&total).Error; err != nil {
+	if err := s.db.Model(&models.Post{}).Scopes(searchablePosts(""), postsMatching(query)).Count(&total).Error; err != nil {
 		return nil, 0, utils.WrapError(err, "failed to count posts")
 	}
 
 	// Get posts
-	if err := s.db.Preload("Dog").
-		Where("is_public = true AND (content ILIKE ? OR ? = ANY(hashtags))", searchQuery, query).
-		Order("created_at DESC").
+	if err := s.db.Scopes(withPostDetails, searchablePosts(""), postsMatching(query), byPostRank(query)).
 		Limit(limit).Offset(offset).
 		Find(&posts).Error; err != nil {
 		return nil, 0, utils.WrapError(err, "failed to search posts")
@@ -488,17 +752,92 @@ func extractHashtags(content string) []string {
 	return hashtags
 }
 
-// removeDuplicates removes duplicate strings from slice
-func removeDuplicates(slice []string) []string {
-	seen := make(map[string]bool)
-	result := []string{}
-	
-	for _, item := range slice {
-		if !seen[item] {
-			seen[item] = true
-			result = append(result, item)
+// visiblePostsTo limits a posts query to the posts the user may see: public
+// posts of public profiles, followers-only posts (and public posts of
+// private profiles) of dogs one of the user's dogs follows, and the user's
+// own posts. An empty user ID sees public posts of public profiles only.
+func visiblePostsTo(userID string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		public := "posts.visibility = 'public' AND posts.dog_id NOT IN (SELECT dogs.id FROM dogs JOIN users ON users.id = dogs.user_id WHERE users.visibility = 'private')"
+		if userID == "" {
+			return db.Where(public)
 		}
+		return db.Where("(("+public+") OR posts.dog_id IN (SELECT id FROM dogs WHERE user_id = ?) OR "+
+			"(posts.visibility IN ('public', 'followers') AND posts.dog_id IN (SELECT followed_dog_id FROM followers WHERE follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?))))",
+			userID, userID)
 	}
-	
-	return result
-}
\ No newline at end of file
+}
+
+// withPostDetails loads a post's dog, place, hashtags and media in order
+func withPostDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Dog").Preload("Place").Preload("Hashtags").Preload("Media", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	})
+}
+
+// postVisibility returns the requested visibility, falling back to the
+// older is_public flag and then to public
+func postVisibility(visibility models.PostVisibility, isPublic *bool) models.PostVisibility {
+	switch {
+	case visibility != "":
+		return visibility
+	case isPublic != nil && !*isPublic:
+		return models.PostVisibilityPrivate
+	default:
+		return models.PostVisibilityPublic
+	}
+}
+
+// postMedia builds a post's media items from the request. Bare URLs take
+// their type from mediaType, or from the file extension for mixed media.
+func postMedia(media []PostMediaRequest, urls []string, mediaType string) []models.PostMedia {
+	items := make([]models.PostMedia, 0, len(media)+len(urls))
+	for _, m := range media {
+		items = append(items, models.PostMedia{
+			Position: len(items),
+			Type:     m.Type,
+			URL:      m.URL,
+			Width:    m.Width,
+			Height:   m.Height,
+			Blurhash: m.Blurhash,
+		})
+	}
+	for _, url := range urls {
+		itemType := models.MediaTypePhoto
+		if mediaType == string(models.MediaTypeVideo) || (mediaType == "mixed" && isVideoURL(url)) {
+			itemType = models.MediaTypeVideo
+		}
+		items = append(items, models.PostMedia{
+			Position: len(items),
+			Type:     itemType,
+			URL:      url,
+		})
+	}
+	return items
+}
+
+// isVideoURL reports whether the URL points to a video file
+func isVideoURL(url string) bool {
+	path := strings.ToLower(strings.SplitN(url, "?", 2)[0])
+	for _, ext := range []string{".mp4", ".mov", ".m4v", ".webm"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePlaceExists rejects posts tagged with an unknown place
+func (s *PostService) ensurePlaceExists(placeID *uuid.UUID) error {
+	if placeID == nil {
+		return nil
+	}
+	var count int64
+	if err := s.db.Model(&models.Place{}).Where("id = ?", *placeID).Count(&count).Error; err != nil {
+		return utils.WrapError(err, "failed to find place")
+	}
+	if count == 0 {
+		return utils.NewValidationError([]utils.ValidationError{{Field: "place_id", Message: "place not found"}})
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostMediaAndVisibility(t *testing.T) {
+	items := postMedia(
+		[]PostMediaRequest{{URL: "https://cdn.example.com/a.jpg", Type: models.MediaTypePhoto, Width: 1080, Height: 1350, Blurhash: "LEHV6nWB2yk8"}},
+		[]string{"https://cdn.example.com/b.MP4?sig=1", "https://cdn.example.com/c.png"},
+		"mixed",
+	)
+	require.Len(t, items, 3)
+	assert.Equal(t, 0, items[0].Position)
+	assert.Equal(t, 1080, items[0].Width)
+	assert.Equal(t, models.MediaTypeVideo, items[1].Type)
+	assert.Equal(t, models.MediaTypePhoto, items[2].Type)
+	assert.Equal(t, 2, items[2].Position)
+
+	private := false
+	assert.Equal(t, models.PostVisibilityPublic, postVisibility("", nil))
+	assert.Equal(t, models.PostVisibilityPrivate, postVisibility("", &private))
+	assert.Equal(t, models.PostVisibilityFollowers, postVisibility(models.PostVisibilityFollowers, &private))
+}
+
+func TestPostService_Visibility(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	author := testutils.CreateTestUser(t, ctx.DB)
+	follower := testutils.CreateTestUser(t, ctx.DB)
+	stranger := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, author.ID.String())
+	followerDog := testutils.CreateTestDog(t, ctx.DB, follower.ID.String())
+	testutils.CreateTestDog(t, ctx.DB, stranger.ID.String())
+	require.NoError(t, ctx.DB.Create(&models.Follower{FollowerDogID: followerDog.ID, FollowedDogID: dog.ID}).Error)
+
+	post, err := postService.CreatePost(author.ID.String(), CreatePostRequest{
+		DogID:      dog.ID.String(),
+		Content:    "Park day",
+		Media:      []PostMediaRequest{{URL: "https://cdn.example.com/1.jpg", Type: models.MediaTypePhoto}},
+		MediaUrls:  []string{"https://cdn.example.com/2.jpg"},
+		Visibility: models.PostVisibilityFollowers,
+	})
+	require.NoError(t, err)
+	require.Len(t, post.Media, 2)
+	assert.Equal(t, "https://cdn.example.com/2.jpg", post.Media[1].URL)
+
+	_, err = postService.GetPost(post.ID.String(), follower.ID.String())
+	require.NoError(t, err)
+	_, err = postService.GetPost(post.ID.String(), stranger.ID.String())
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+	_, err = postService.GetPost(post.ID.String(), "")
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+
+	timeline, total, err := postService.GetTimeline(follower.ID.String(), 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, timeline, 1)
+
+	// Making it private hides it from followers too
+	visibility := models.PostVisibilityPrivate
+	mediaType := "video"
+	updated, err := postService.UpdatePost(post.ID.String(), author.ID.String(), UpdatePostRequest{
+		Visibility: &visibility,
+		MediaUrls:  &[]string{"https://cdn.example.com/3.mov"},
+		MediaType:  &mediaType,
+	})
+	require.NoError(t, err)
+	require.Len(t, updated.Media, 1)
+	assert.Equal(t, models.MediaTypeVideo, updated.Media[0].Type)
+
+	_, err = postService.GetPost(post.ID.String(), follower.ID.String())
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+	_, err = postService.GetPost(post.ID.String(), author.ID.String())
+	require.NoError(t, err)
+}
+
+func TestCommentCursorAndPlacement(t *testing.T) {
+	comment := models.Comment{ID: uuid.New(), CreatedAt: time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.UTC)}
+	createdAt, id, err := decodeCursor(encodeCursor(comment.CreatedAt, comment.ID))
+	require.NoError(t, err)
+	assert.True(t, createdAt.Equal(comment.CreatedAt))
+	assert.Equal(t, comment.ID, id)
+
+	_, _, err = decodeCursor("not a cursor")
+	assert.Error(t, err)
+
+	parentID, depth := replyPlacement(&comment)
+	require.NotNil(t, parentID)
+	assert.Equal(t, comment.ID, *parentID)
+	assert.Equal(t, 1, depth)
+
+	// Replies to the deepest level stay on it
+	deepest := models.Comment{ID: uuid.New(), ParentID: &comment.ID, Depth: maxCommentDepth - 1}
+	parentID, depth = replyPlacement(&deepest)
+	assert.Equal(t, &comment.ID, parentID)
+	assert.Equal(t, maxCommentDepth-1, depth)
+}
+
+func TestPostService_CommentThreads(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	author := testutils.CreateTestUser(t, ctx.DB)
+	commenter := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, author.ID.String())
+	testutils.CreateTestDog(t, ctx.DB, commenter.ID.String())
+
+	post, err := postService.CreatePost(author.ID.String(), CreatePostRequest{DogID: dog.ID.String(), Content: "Park day"})
+	require.NoError(t, err)
+
+	var top []*models.Comment
+	for i := 0; i < 3; i++ {
+		comment, err := postService.AddComment(post.ID.String(), commenter.ID.String(), CommentRequest{Content: "Nice"})
+		require.NoError(t, err)
+		top = append(top, comment)
+	}
+
+	parentID := top[0].ID.String()
+	reply, err := postService.AddComment(post.ID.String(), author.ID.String(), CommentRequest{Content: "Thanks", ParentID: &parentID})
+	require.NoError(t, err)
+	assert.Equal(t, 1, reply.Depth)
+
+	// Two top-level comments per page, newest first
+	page, cursor, err := postService.GetComments(post.ID.String(), author.ID.String(), nil, "", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, top[2].ID, page[0].ID)
+	require.NotEmpty(t, cursor)
+
+	page, cursor, err = postService.GetComments(post.ID.String(), author.ID.String(), nil, cursor, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, top[0].ID, page[0].ID)
+	assert.Equal(t, 1, page[0].ReplyCount)
+	assert.Empty(t, cursor)
+
+	replies, _, err := postService.GetComments(post.ID.String(), author.ID.String(), &top[0].ID, "", 20)
+	require.NoError(t, err)
+	require.Len(t, replies, 1)
+	assert.Equal(t, reply.ID, replies[0].ID)
+
+	// Only the author edits; the author and the post owner delete
+	_, err = postService.UpdateComment(top[1].ID, author.ID.String(), UpdateCommentRequest{Content: "Edited"})
+	assert.ErrorIs(t, err, utils.ErrForbidden)
+	edited, err := postService.UpdateComment(top[1].ID, commenter.ID.String(), UpdateCommentRequest{Content: "Edited"})
+	require.NoError(t, err)
+	assert.NotNil(t, edited.EditedAt)
+
+	liked, err := postService.LikeComment(top[1].ID, author.ID.String())
+	require.NoError(t, err)
+	assert.True(t, liked)
+
+	require.NoError(t, postService.DeleteComment(top[1].ID, author.ID.String()))
+
+	// A deleted comment with replies stays until its last reply goes
+	require.NoError(t, postService.DeleteComment(top[0].ID, commenter.ID.String()))
+	page, _, err = postService.GetComments(post.ID.String(), author.ID.String(), nil, "", 20)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.NotNil(t, page[1].DeletedAt)
+	assert.Empty(t, page[1].Content)
+
+	require.NoError(t, postService.DeleteComment(reply.ID, author.ID.String()))
+	page, _, err = postService.GetComments(post.ID.String(), author.ID.String(), nil, "", 20)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, top[2].ID, page[0].ID)
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Presence keys. PresenceGeoKey is a GEO set of dog positions and
+// PresenceSeenKey scores the same members by their last report time, since
+// members of a GEO set cannot expire individually.
+const (
+	PresenceGeoKey  = "presence:dogs:geo"
+	PresenceSeenKey = "presence:dogs:seen"
+)
+
+// pruneStaleScript atomically drops members last seen before ARGV[1] from
+// both presence keys, so a dog reporting concurrently is never lost
+var pruneStaleScript = redis.NewScript(`
+local stale = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[1], 'LIMIT', 0, 1000)
+if #stale > 0 then
+	redis.call('ZREM', KEYS[1], unpack(stale))
+	redis.call('ZREM', KEYS[2], unpack(stale))
+end
+return #stale
+`)
+
+const (
+	defaultPresenceTTL = time.Hour
+
+	// maxPresenceResults caps the candidates returned by one nearby search
+	maxPresenceResults = 200
+)
+
+// PresenceMatch is a dog found near a position, where it is, its distance
+// in meters and when it reported that position
+type PresenceMatch struct {
+	DogID    uuid.UUID
+	Location models.GeoPoint
+	Distance float64
+	SeenAt   time.Time
+}
+
+// PresenceService is the live index of where dogs are, used for nearby and
+// encounter candidate lookups. PostGIS device_locations stays the durable
+// store the index is rebuilt from.
+type PresenceService struct {
+	db    *gorm.DB
+	redis *redis.Client
+	ttl   time.Duration
+	ctx   context.Context
+}
+
+func NewPresenceService(db *gorm.DB, redis *redis.Client, cfg config.Config) *PresenceService {
+	ttl := cfg.Location.PresenceTTL
+	if ttl <= 0 {
+		ttl = defaultPresenceTTL
+	}
+
+	return &PresenceService{
+		db:    db,
+		redis: redis,
+		ttl:   ttl,
+		ctx:   context.Background(),
+	}
+}
+
+// Update records the dog's position as of seenAt
+func (s *PresenceService) Update(dogID uuid.UUID, latitude, longitude float64, seenAt time.Time) error {
+	member := dogID.String()
+
+	pipe := s.redis.TxPipeline()
+	pipe.GeoAdd(s.ctx, PresenceGeoKey, &redis.GeoLocation{Name: member, Longitude: longitude, Latitude: latitude})
+	pipe.ZAdd(s.ctx, PresenceSeenKey, redis.Z{Score: float64(seenAt.Unix()), Member: member})
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		return utils.WrapError(err, "failed to update presence")
+	}
+	return nil
+}
+
+// Remove drops the dog from the index
+func (s *PresenceService) Remove(dogID uuid.UUID) error {
+	pipe := s.redis.TxPipeline()
+	pipe.ZRem(s.ctx, PresenceGeoKey, dogID.String())
+	pipe.ZRem(s.ctx, PresenceSeenKey, dogID.String())
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		return utils.WrapError(err, "failed to remove presence")
+	}
+	return nil
+}
+
+// Position returns the dog's last known position if it was seen since the given time
+func (s *PresenceService) Position(dogID uuid.UUID, since time.Time) (latitude, longitude float64, found bool, err error) {
+	member := dogID.String()
+
+	pipe := s.redis.Pipeline()
+	posCmd := pipe.GeoPos(s.ctx, PresenceGeoKey, member)
+	seenCmd := pipe.ZScore(s.ctx, PresenceSeenKey, member)
+	if _, err := pipe.Exec(s.ctx); err != nil && err != redis.Nil {
+		return 0, 0, false, utils.WrapError(err, "failed to get presence")
+	}
+
+	positions := posCmd.Val()
+	if len(positions) == 0 || positions[0] == nil || seenCmd.Err() != nil {
+		return 0, 0, false, nil
+	}
+	if int64(seenCmd.Val()) < since.Unix() {
+		return 0, 0, false, nil
+	}
+	return positions[0].Latitude, positions[0].Longitude, true, nil
+}
+
+// Nearby returns dogs seen since the given time within radiusMeters of the
+// position, nearest first, leaving out excludeDogID
+func (s *PresenceService) Nearby(latitude, longitude, radiusMeters float64, since time.Time, excludeDogID uuid.UUID) ([]PresenceMatch, error) {
+	if err := s.pruneStale(); err != nil {
+		return nil, err
+	}
+
+	locations, err := s.redis.GeoSearchLocation(s.ctx, PresenceGeoKey, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  longitude,
+			Latitude:   latitude,
+			Radius:     radiusMeters,
+			RadiusUnit: "m",
+			Sort:       "ASC",
+			Count:      maxPresenceResults,
+		},
+		WithCoord: true,
+		WithDist:  true,
+	}).Result()
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to search presence")
+	}
+	if len(locations) == 0 {
+		return nil, nil
+	}
+
+	members := make([]string, len(locations))
+	for i, location := range locations {
+		members[i] = location.Name
+	}
+	seen, err := s.redis.ZMScore(s.ctx, PresenceSeenKey, members...).Result()
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to get presence times")
+	}
+
+	matches := make([]PresenceMatch, 0, len(locations))
+	for i, location := range locations {
+		if int64(seen[i]) < since.Unix() {
+			continue
+		}
+		dogID, err := uuid.Parse(location.Name)
+		if err != nil || dogID == excludeDogID {
+			continue
+		}
+		matches = append(matches, PresenceMatch{
+			DogID:    dogID,
+			Location: models.NewGeoPoint(location.Latitude, location.Longitude),
+			Distance: location.Dist,
+			SeenAt:   time.Unix(int64(seen[i]), 0),
+		})
+	}
+	return matches, nil
+}
+
+// Rebuild replaces the index with the fresh positions in device_locations.
+// Run on startup so the index matches the durable store after a Redis restart.
+func (s *PresenceService) Rebuild() error {
+	var rows []struct {
+		DogID     uuid.UUID
+		Latitude  float64
+		Longitude float64
+		UpdatedAt time.Time
+	}
+	if err := s.db.Raw(`
+		SELECT dog_id, ST_Y(location::geometry) AS latitude, ST_X(location::geometry) AS longitude, updated_at
+		FROM device_locations
+		WHERE updated_at > ?
+	`, time.Now().Add(-s.ttl)).Scan(&rows).Error; err != nil {
+		return utils.WrapError(err, "failed to load device locations")
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.Del(s.ctx, PresenceGeoKey, PresenceSeenKey)
+	for _, row := range rows {
+		member := row.DogID.String()
+		pipe.GeoAdd(s.ctx, PresenceGeoKey, &redis.GeoLocation{Name: member, Longitude: row.Longitude, Latitude: row.Latitude})
+		pipe.ZAdd(s.ctx, PresenceSeenKey, redis.Z{Score: float64(row.UpdatedAt.Unix()), Member: member})
+	}
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		return utils.WrapError(err, "failed to rebuild presence")
+	}
+	return nil
+}
+
+// pruneStale removes dogs that have not reported within the TTL
+func (s *PresenceService) pruneStale() error {
+	cutoff := time.Now().Add(-s.ttl).Unix()
+	if err := pruneStaleScript.Run(s.ctx, s.redis, []string{PresenceGeoKey, PresenceSeenKey}, cutoff).Err(); err != nil {
+		return utils.WrapError(err, "failed to prune stale presence")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceService_Nearby(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	presence := NewPresenceService(ctx.DB, ctx.Redis, ctx.Config)
+	now := time.Now()
+
+	self := uuid.New()
+	near := uuid.New()
+	farther := uuid.New()
+	outside := uuid.New()
+	stale := uuid.New()
+
+	require.NoError(t, presence.Update(self, 52.5200, 13.4050, now))
+	require.NoError(t, presence.Update(farther, 52.5210, 13.4050, now))
+	require.NoError(t, presence.Update(near, 52.5201, 13.4050, now))
+	require.NoError(t, presence.Update(outside, 52.5300, 13.4050, now))
+	require.NoError(t, presence.Update(stale, 52.5200, 13.4051, now.Add(-2*time.Hour)))
+
+	matches, err := presence.Nearby(52.5200, 13.4050, 500, now.Add(-5*time.Minute), self)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Equal(t, near, matches[0].DogID)
+	assert.Equal(t, farther, matches[1].DogID)
+	assert.InDelta(t, 11, matches[0].Distance, 2)
+
+	// Dogs past the TTL are pruned from the index
+	_, _, found, err := presence.Position(stale, time.Time{})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Freshness is also applied per query
+	matches, err = presence.Nearby(52.5200, 13.4050, 500, now.Add(time.Minute), self)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	require.NoError(t, presence.Remove(near))
+	latitude, _, found, err := presence.Position(farther, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.InDelta(t, 52.5210, latitude, 0.0001)
+}
+
+func TestPresenceService_Rebuild(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, ctx.Config)
+	presence := encounterService.presence
+
+	user := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, user.ID.String())
+	require.NoError(t, encounterService.UpdateDeviceLocation(user.ID, LocationUpdateRequest{DogID: dog.ID, Latitude: 48.8566, Longitude: 2.3522}))
+
+	// Simulate a Redis restart losing the index
+	require.NoError(t, ctx.Redis.FlushDB(context.Background()).Err())
+	_, _, found, err := presence.Position(dog.ID, time.Time{})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, presence.Rebuild())
+	latitude, longitude, found, err := presence.Position(dog.ID, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.InDelta(t, 48.8566, latitude, 0.0001)
+	assert.InDelta(t, 2.3522, longitude, 0.0001)
+
+	// Deleted dogs leave the index right away
+	require.NoError(t, NewDogService(ctx.DB, ctx.Redis, ctx.Config).DeleteDog(dog.ID, user.ID))
+	_, _, found, err = presence.Position(dog.ID, time.Time{})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
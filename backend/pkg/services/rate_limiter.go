@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitKey is keyed by policy name and client (user or IP)
+const RateLimitKey = "ratelimit:%s:%s"
+
+// slidingWindowScript records the request in a sorted set scored by time
+// once older entries have left the window. It returns whether the request
+// was allowed, the remaining requests and the milliseconds until the oldest
+// entry expires.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, limit - count, reset}
+`)
+
+// RateLimitResult describes the state of a client's window after a request
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is when the oldest request leaves the window and a slot frees up
+	ResetAfter time.Duration
+}
+
+// RateLimiter enforces sliding-window request limits in Redis
+type RateLimiter struct {
+	redis *redis.Client
+	ctx   context.Context
+}
+
+func NewRateLimiter(redis *redis.Client) *RateLimiter {
+	return &RateLimiter{
+		redis: redis,
+		ctx:   context.Background(),
+	}
+}
+
+// Allow counts a request by client against the policy. Rejected requests
+// are not recorded, so clients that back off regain capacity on schedule.
+func (l *RateLimiter) Allow(policy config.RateLimitPolicy, client string) (*RateLimitResult, error) {
+	key := fmt.Sprintf(RateLimitKey, policy.Name, client)
+	now := time.Now().UnixMilli()
+
+	values, err := slidingWindowScript.Run(l.ctx, l.redis, []string{key},
+		now, policy.Window.Milliseconds(), policy.Limit, fmt.Sprintf("%d-%s", now, uuid.NewString())).Int64Slice()
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to check rate limit")
+	}
+
+	return &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      policy.Limit,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	limiter := NewRateLimiter(ctx.Redis)
+	policy := config.RateLimitPolicy{Name: "test", Limit: 3, Window: time.Minute}
+
+	for i := 0; i < policy.Limit; i++ {
+		result, err := limiter.Allow(policy, "user:1")
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, policy.Limit-i-1, result.Remaining)
+	}
+
+	result, err := limiter.Allow(policy, "user:1")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.True(t, result.ResetAfter > 0 && result.ResetAfter <= policy.Window)
+
+	// Clients are limited independently
+	result, err = limiter.Allow(policy, "user:2")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestRateLimiter_SlidingWindow(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	limiter := NewRateLimiter(ctx.Redis)
+	policy := config.RateLimitPolicy{Name: "test", Limit: 2, Window: 500 * time.Millisecond}
+
+	for i := 0; i < policy.Limit; i++ {
+		_, err := limiter.Allow(policy, "ip:127.0.0.1")
+		require.NoError(t, err)
+	}
+
+	result, err := limiter.Allow(policy, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	time.Sleep(policy.Window + 50*time.Millisecond)
+
+	result, err = limiter.Allow(policy, "ip:127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Realtime keys. RealtimeEventsKey is a stream of the user's recent events
+// that reconnecting clients resume from, and RealtimeChannel is the pub/sub
+// channel that fans new events out to every API instance.
+const (
+	RealtimeEventsKey = "realtime:events:%s"
+	RealtimeChannel   = "realtime:user:%s"
+)
+
+// publishEventScript appends the event to the user's stream and publishes it
+// with the stream ID it was given, so live and replayed events share IDs
+var publishEventScript = redis.NewScript(`
+local id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', 'event', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PUBLISH', ARGV[4], id .. ' ' .. ARGV[2])
+return id
+`)
+
+const (
+	defaultRealtimeReplayLimit = 200
+	defaultRealtimeReplayTTL   = 24 * time.Hour
+
+	// realtimeBufferSize is how many events a slow connection may fall
+	// behind before it is dropped and left to resume
+	realtimeBufferSize = 64
+)
+
+// RealtimeEventType is the kind of thing a realtime event reports
+type RealtimeEventType string
+
+const (
+	RealtimeEventEncounter      RealtimeEventType = "encounter"
+	RealtimeEventGift           RealtimeEventType = "gift"
+	RealtimeEventLike           RealtimeEventType = "like"
+	RealtimeEventComment        RealtimeEventType = "comment"
+	RealtimeEventFollow         RealtimeEventType = "follow"
+	RealtimeEventFollowRequest  RealtimeEventType = "follow_request"
+	RealtimeEventFollowApproved RealtimeEventType = "follow_approved"
+	RealtimeEventWave           RealtimeEventType = "wave"
+)
+
+// RealtimeEventTypes are the event types clients can subscribe to
+var RealtimeEventTypes = []RealtimeEventType{
+	RealtimeEventEncounter,
+	RealtimeEventGift,
+	RealtimeEventLike,
+	RealtimeEventComment,
+	RealtimeEventFollow,
+	RealtimeEventFollowRequest,
+	RealtimeEventFollowApproved,
+	RealtimeEventWave,
+}
+
+// RealtimeEvent is an event delivered to a user's connections. IDs are
+// Redis stream IDs and increase with each event a user receives.
+type RealtimeEvent struct {
+	ID        string            `json:"id,omitempty"`
+	Type      RealtimeEventType `json:"type"`
+	Data      json.RawMessage   `json:"data"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// RealtimeService publishes events to users and replays the ones they missed
+type RealtimeService struct {
+	redis       *redis.Client
+	replayLimit int
+	replayTTL   time.Duration
+	ctx         context.Context
+}
+
+func NewRealtimeService(redis *redis.Client, cfg config.Config) *RealtimeService {
+	replayLimit := cfg.Realtime.ReplayLimit
+	if replayLimit <= 0 {
+		replayLimit = defaultRealtimeReplayLimit
+	}
+	replayTTL := cfg.Realtime.ReplayTTL
+	if replayTTL <= 0 {
+		replayTTL = defaultRealtimeReplayTTL
+	}
+
+	return &RealtimeService{
+		redis:       redis,
+		replayLimit: replayLimit,
+		replayTTL:   replayTTL,
+		ctx:         context.Background(),
+	}
+}
+
+// Publish sends an event to all of the user's connections, on any instance
+func (s *RealtimeService) Publish(userID uuid.UUID, eventType RealtimeEventType, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return utils.WrapError(err, "failed to encode realtime event")
+	}
+	event, err := json.Marshal(RealtimeEvent{Type: eventType, Data: payload, CreatedAt: time.Now()})
+	if err != nil {
+		return utils.WrapError(err, "failed to encode realtime event")
+	}
+
+	keys := []string{fmt.Sprintf(RealtimeEventsKey, userID)}
+	if err := publishEventScript.Run(s.ctx, s.redis, keys,
+		s.replayLimit, string(event), s.replayTTL.Milliseconds(), fmt.Sprintf(RealtimeChannel, userID),
+	).Err(); err != nil {
+		return utils.WrapError(err, "failed to publish realtime event")
+	}
+	return nil
+}
+
+// Notify publishes the event to each user, logging failures. Delivery is
+// best effort: the action that caused the event has already happened.
+func (s *RealtimeService) Notify(userIDs []uuid.UUID, eventType RealtimeEventType, data interface{}) {
+	seen := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		if err := s.Publish(userID, eventType, data); err != nil {
+			log.Printf("Failed to publish %s event to user %s: %v", eventType, userID, err)
+		}
+	}
+}
+
+// Replay returns the user's retained events after the given event ID, oldest first
+func (s *RealtimeService) Replay(userID uuid.UUID, afterID string) ([]RealtimeEvent, error) {
+	entries, err := s.redis.XRangeN(s.ctx, fmt.Sprintf(RealtimeEventsKey, userID), "("+afterID, "+", int64(s.replayLimit)).Result()
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to replay realtime events")
+	}
+
+	events := make([]RealtimeEvent, 0, len(entries))
+	for _, entry := range entries {
+		raw, _ := entry.Values["event"].(string)
+		event, err := decodeRealtimeEvent(entry.ID, raw)
+		if err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// decodeRealtimeEvent parses an event stored under the given stream ID
+func decodeRealtimeEvent(id, raw string) (RealtimeEvent, error) {
+	var event RealtimeEvent
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		return event, err
+	}
+	event.ID = id
+	return event, nil
+}
+
+// ParseRealtimeEventID splits a stream ID into its millisecond time and sequence
+func ParseRealtimeEventID(id string) (ms, seq uint64, err error) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid event ID %q", id)
+	}
+	if ms, err = strconv.ParseUint(msPart, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid event ID %q", id)
+	}
+	if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid event ID %q", id)
+	}
+	return ms, seq, nil
+}
+
+// realtimeEventAfter reports whether event ID a comes after b. Unparsable
+// IDs never come after anything.
+func realtimeEventAfter(a, b string) bool {
+	aMs, aSeq, err := ParseRealtimeEventID(a)
+	if err != nil {
+		return false
+	}
+	bMs, bSeq, err := ParseRealtimeEventID(b)
+	if err != nil {
+		return true
+	}
+	return aMs > bMs || (aMs == bMs && aSeq > bSeq)
+}
+
+// ParseRealtimeEventTypes parses a comma separated list of event types. An
+// empty list means all types.
+func ParseRealtimeEventTypes(list string) ([]RealtimeEventType, error) {
+	var types []RealtimeEventType
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isRealtimeEventType(RealtimeEventType(name)) {
+			return nil, utils.NewValidationError([]utils.ValidationError{{Field: "types", Message: "unknown event type " + name}})
+		}
+		types = append(types, RealtimeEventType(name))
+	}
+	return types, nil
+}
+
+func isRealtimeEventType(eventType RealtimeEventType) bool {
+	for _, known := range RealtimeEventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// RealtimeHub delivers published events to this instance's connections. It
+// holds one Redis pub/sub connection, subscribed to the channels of the
+// users connected here.
+type RealtimeHub struct {
+	realtime *RealtimeService
+	pubsub   *redis.PubSub
+	ctx      context.Context
+
+	mu          sync.Mutex
+	subscribers map[string]map[*RealtimeStream]bool
+}
+
+func NewRealtimeHub(redis *redis.Client, cfg config.Config) *RealtimeHub {
+	ctx := context.Background()
+	hub := &RealtimeHub{
+		realtime:    NewRealtimeService(redis, cfg),
+		pubsub:      redis.Subscribe(ctx),
+		ctx:         ctx,
+		subscribers: make(map[string]map[*RealtimeStream]bool),
+	}
+	go hub.run()
+	return hub
+}
+
+// run dispatches published events to the connections of their user
+func (h *RealtimeHub) run() {
+	for msg := range h.pubsub.Channel() {
+		id, raw, found := strings.Cut(msg.Payload, " ")
+		if !found {
+			continue
+		}
+		event, err := decodeRealtimeEvent(id, raw)
+		if err != nil {
+			continue
+		}
+
+		h.mu.Lock()
+		for stream := range h.subscribers[msg.Channel] {
+			stream.deliver(event)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Open starts a stream of the user's events limited to the given types, or
+// all types when none are given. When lastEventID is set, retained events
+// after it are delivered first.
+func (h *RealtimeHub) Open(userID uuid.UUID, lastEventID string, types []RealtimeEventType) (*RealtimeStream, error) {
+	stream, err := h.subscribe(userID, lastEventID, types)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.replay(stream, userID, lastEventID); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// subscribe registers a stream for the user's live events. A stream resuming
+// from lastEventID holds live events back until replay has run.
+func (h *RealtimeHub) subscribe(userID uuid.UUID, lastEventID string, types []RealtimeEventType) (*RealtimeStream, error) {
+	if lastEventID != "" {
+		if _, _, err := ParseRealtimeEventID(lastEventID); err != nil {
+			return nil, utils.NewValidationError([]utils.ValidationError{{Field: "last_event_id", Message: "invalid event ID"}})
+		}
+	}
+
+	stream := &RealtimeStream{
+		hub:       h,
+		channel:   fmt.Sprintf(RealtimeChannel, userID),
+		events:    make(chan RealtimeEvent, realtimeBufferSize),
+		lastID:    lastEventID,
+		types:     make(map[RealtimeEventType]bool),
+		replaying: lastEventID != "",
+	}
+	if len(types) == 0 {
+		types = RealtimeEventTypes
+	}
+	for _, eventType := range types {
+		stream.types[eventType] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subscribers[stream.channel]) == 0 {
+		if err := h.pubsub.Subscribe(h.ctx, stream.channel); err != nil {
+			return nil, utils.WrapError(err, "failed to subscribe to realtime events")
+		}
+		h.subscribers[stream.channel] = make(map[*RealtimeStream]bool)
+	}
+	h.subscribers[stream.channel][stream] = true
+	return stream, nil
+}
+
+// replay delivers the retained events after lastEventID, then the live events
+// held back while they were fetched. The stream is subscribed first so
+// nothing published in between is missed; events both replayed and held back
+// are delivered once.
+func (h *RealtimeHub) replay(stream *RealtimeStream, userID uuid.UUID, lastEventID string) error {
+	if lastEventID == "" {
+		return nil
+	}
+	missed, err := h.realtime.Replay(userID, lastEventID)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	held := stream.held
+	stream.held = nil
+	stream.replaying = false
+	for _, event := range missed {
+		stream.deliver(event)
+	}
+	for _, event := range held {
+		stream.deliver(event)
+	}
+	return nil
+}
+
+// remove unregisters the stream, dropping the channel subscription when it
+// was the user's last stream here. Callers hold h.mu.
+func (h *RealtimeHub) remove(stream *RealtimeStream) {
+	if stream.closed {
+		return
+	}
+	stream.closed = true
+	close(stream.events)
+
+	streams := h.subscribers[stream.channel]
+	delete(streams, stream)
+
+	if len(streams) == 0 {
+		delete(h.subscribers, stream.channel)
+		if err := h.pubsub.Unsubscribe(h.ctx, stream.channel); err != nil {
+			log.Printf("Failed to unsubscribe from %s: %v", stream.channel, err)
+		}
+	}
+}
+
+// RealtimeStream is one connection's view of a user's events. Events are
+// delivered once each, in order, and only for the subscribed types.
+type RealtimeStream struct {
+	hub     *RealtimeHub
+	channel string
+	events  chan RealtimeEvent
+
+	// lastID, types, closed, replaying and held are guarded by hub.mu.
+	// While replaying, live events are held until the replay is delivered.
+	lastID    string
+	types     map[RealtimeEventType]bool
+	closed    bool
+	replaying bool
+	held      []RealtimeEvent
+}
+
+// Events returns the stream's events. The channel is closed when the stream
+// is closed or falls too far behind, after which the client should resume
+// from the last event it received.
+func (s *RealtimeStream) Events() <-chan RealtimeEvent {
+	return s.events
+}
+
+// RealtimeSubscriptionRequest changes the event types a connection receives
+type RealtimeSubscriptionRequest struct {
+	Action string              `json:"action" validate:"required,oneof=subscribe unsubscribe"`
+	Types  []RealtimeEventType `json:"types" validate:"required,min=1"`
+}
+
+// Apply subscribes or unsubscribes the stream as requested
+func (s *RealtimeStream) Apply(req RealtimeSubscriptionRequest) error {
+	if err := utils.ValidateStruct(req); err != nil {
+		return utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+	for _, eventType := range req.Types {
+		if !isRealtimeEventType(eventType) {
+			return utils.NewValidationError([]utils.ValidationError{{Field: "types", Message: "unknown event type " + string(eventType)}})
+		}
+	}
+
+	if req.Action == "subscribe" {
+		s.Subscribe(req.Types...)
+	} else {
+		s.Unsubscribe(req.Types...)
+	}
+	return nil
+}
+
+// Subscribe adds event types to those the stream delivers
+func (s *RealtimeStream) Subscribe(types ...RealtimeEventType) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	for _, eventType := range types {
+		s.types[eventType] = true
+	}
+}
+
+// Unsubscribe stops the stream delivering the event types
+func (s *RealtimeStream) Unsubscribe(types ...RealtimeEventType) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	for _, eventType := range types {
+		delete(s.types, eventType)
+	}
+}
+
+// Types returns the event types the stream delivers
+func (s *RealtimeStream) Types() []RealtimeEventType {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	types := make([]RealtimeEventType, 0, len(s.types))
+	for _, eventType := range RealtimeEventTypes {
+		if s.types[eventType] {
+			types = append(types, eventType)
+		}
+	}
+	return types
+}
+
+// Close stops the stream
+func (s *RealtimeStream) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// deliver queues the event unless it was already delivered or is filtered
+// out. A stream whose buffer is full is dropped. Callers hold hub.mu.
+func (s *RealtimeStream) deliver(event RealtimeEvent) {
+	if s.closed {
+		return
+	}
+	if s.replaying {
+		if len(s.held) >= realtimeBufferSize {
+			s.hub.remove(s)
+			return
+		}
+		s.held = append(s.held, event)
+		return
+	}
+	if s.lastID != "" && !realtimeEventAfter(event.ID, s.lastID) {
+		return
+	}
+	s.lastID = event.ID
+	if !s.types[event.Type] {
+		return
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.hub.remove(s)
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealtimeEventIDs(t *testing.T) {
+	assert.True(t, realtimeEventAfter("1700000000001-0", "1700000000000-5"))
+	assert.True(t, realtimeEventAfter("1700000000000-6", "1700000000000-5"))
+	assert.False(t, realtimeEventAfter("1700000000000-5", "1700000000000-5"))
+	assert.False(t, realtimeEventAfter("bogus", "1700000000000-5"))
+
+	_, _, err := ParseRealtimeEventID("12-x")
+	assert.Error(t, err)
+
+	types, err := ParseRealtimeEventTypes("gift, like")
+	require.NoError(t, err)
+	assert.Equal(t, []RealtimeEventType{RealtimeEventGift, RealtimeEventLike}, types)
+
+	_, err = ParseRealtimeEventTypes("gift,weather")
+	assert.Error(t, err)
+}
+
+func TestRealtimeHub_DeliverAndResume(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	realtime := NewRealtimeService(ctx.Redis, ctx.Config)
+	hub := NewRealtimeHub(ctx.Redis, ctx.Config)
+	userID := uuid.New()
+
+	stream, err := hub.Open(userID, "", []RealtimeEventType{RealtimeEventGift})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	// Let the hub's subscription reach Redis
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, realtime.Publish(userID, RealtimeEventLike, map[string]string{"post_id": "p1"}))
+	require.NoError(t, realtime.Publish(userID, RealtimeEventGift, map[string]string{"gift_id": "g1"}))
+
+	// Only the subscribed type arrives
+	var first RealtimeEvent
+	select {
+	case first = <-stream.Events():
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event delivered")
+	}
+	assert.Equal(t, RealtimeEventGift, first.Type)
+	assert.JSONEq(t, `{"gift_id":"g1"}`, string(first.Data))
+
+	// A reconnecting client resumes after the last event it saw
+	require.NoError(t, realtime.Publish(userID, RealtimeEventComment, map[string]string{"comment_id": "c1"}))
+	missed, err := realtime.Replay(userID, first.ID)
+	require.NoError(t, err)
+	require.Len(t, missed, 1)
+	assert.Equal(t, RealtimeEventComment, missed[0].Type)
+
+	resumed, err := hub.Open(userID, first.ID, nil)
+	require.NoError(t, err)
+	defer resumed.Close()
+	select {
+	case event := <-resumed.Events():
+		assert.Equal(t, missed[0].ID, event.ID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("missed event not replayed")
+	}
+
+	_, err = hub.Open(userID, "not-an-id", nil)
+	assert.Error(t, err)
+}
+
+func TestRealtimeHub_ResumeWithLiveEvent(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	realtime := NewRealtimeService(ctx.Redis, ctx.Config)
+	hub := NewRealtimeHub(ctx.Redis, ctx.Config)
+	userID := uuid.New()
+
+	require.NoError(t, realtime.Publish(userID, RealtimeEventGift, map[string]string{"gift_id": "g1"}))
+	require.NoError(t, realtime.Publish(userID, RealtimeEventLike, map[string]string{"post_id": "p1"}))
+	retained, err := realtime.Replay(userID, "0-0")
+	require.NoError(t, err)
+	require.Len(t, retained, 2)
+	seen, missed := retained[0], retained[1]
+
+	stream, err := hub.subscribe(userID, seen.ID, nil)
+	require.NoError(t, err)
+	defer stream.Close()
+
+	// An event published after subscribing reaches the hub before the replay
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, realtime.Publish(userID, RealtimeEventComment, map[string]string{"comment_id": "c1"}))
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, hub.replay(stream, userID, seen.ID))
+
+	// The missed event comes first, then the live one, each once
+	for _, want := range []RealtimeEventType{missed.Type, RealtimeEventComment} {
+		select {
+		case event := <-stream.Events():
+			assert.Equal(t, want, event.Type)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s event not delivered", want)
+		}
+	}
+	select {
+	case event := <-stream.Events():
+		t.Fatalf("unexpected %s event", event.Type)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
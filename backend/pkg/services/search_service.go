@@ -0,0 +1,284 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+)
+
+// SearchType is a kind of result a search returns
+type SearchType string
+
+const (
+	SearchTypePosts    SearchType = "posts"
+	SearchTypeDogs     SearchType = "dogs"
+	SearchTypeUsers    SearchType = "users"
+	SearchTypeHashtags SearchType = "hashtags" // autocomplete only
+)
+
+const (
+	maxSearchQueryLength = 100
+
+	// postSearchConfig is the text search configuration post content is
+	// indexed with; queries must use the same one to hit the index
+	postSearchConfig = "english"
+)
+
+// searchHiddenUserIDsSQL selects the users who asked to be left out of search
+const searchHiddenUserIDsSQL = "SELECT user_id FROM safety_settings WHERE hide_from_search = true"
+
+// SearchResults holds the results of each type that was searched
+type SearchResults struct {
+	Posts []models.Post `json:"posts,omitempty"`
+	Dogs  []models.Dog  `json:"dogs,omitempty"`
+	Users []SearchUser  `json:"users,omitempty"`
+}
+
+// SearchUser is a user as shown in search results
+type SearchUser struct {
+	ID         uuid.UUID         `json:"id"`
+	Username   string            `json:"username"`
+	Visibility models.Visibility `json:"visibility"`
+}
+
+// SearchSuggestion completes a partly typed query. ID is set for dogs and
+// users.
+type SearchSuggestion struct {
+	Type SearchType `json:"type"`
+	Text string     `json:"text"`
+	ID   *uuid.UUID `json:"id,omitempty"`
+}
+
+// SearchService searches posts, dogs and users
+type SearchService struct {
+	db *gorm.DB
+}
+
+func NewSearchService(db *gorm.DB) *SearchService {
+	return &SearchService{
+		db: db,
+	}
+}
+
+// Search returns up to limit results of each requested type, best match
+// first, skipping offset results of each. Post content is matched as a web
+// search query and by hashtag; dog names, breeds and usernames are matched
+// fuzzily. No types means all of posts, dogs and users.
+func (s *SearchService) Search(userID string, query string, types []SearchType, limit int, offset int) (*SearchResults, error) {
+	query, err := validateSearch(query, types, SearchTypePosts, SearchTypeDogs, SearchTypeUsers)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		types = []SearchType{SearchTypePosts, SearchTypeDogs, SearchTypeUsers}
+	}
+
+	results := &SearchResults{}
+	for _, searchType := range types {
+		switch searchType {
+		case SearchTypePosts:
+			results.Posts = []models.Post{}
+			if err := s.db.Scopes(withPostDetails, searchablePosts(userID), postsMatching(query), byPostRank(query)).
+				Limit(limit).Offset(offset).
+				Find(&results.Posts).Error; err != nil {
+				return nil, utils.WrapError(err, "failed to search posts")
+			}
+		case SearchTypeDogs:
+			results.Dogs = []models.Dog{}
+			if err := s.db.Joins("JOIN users ON users.id = dogs.user_id").
+				Scopes(searchableUsers(userID), dogsMatching(query), byDogSimilarity(query)).
+				Limit(limit).Offset(offset).
+				Find(&results.Dogs).Error; err != nil {
+				return nil, utils.WrapError(err, "failed to search dogs")
+			}
+		case SearchTypeUsers:
+			results.Users = []SearchUser{}
+			if err := s.db.Model(&models.User{}).
+				Select("users.id, users.username, users.visibility").
+				Scopes(searchableUsers(userID)).
+				Where("? <% users.username", query).
+				Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "word_similarity(?, users.username) DESC, users.username", Vars: []interface{}{query}}}).
+				Limit(limit).Offset(offset).
+				Scan(&results.Users).Error; err != nil {
+				return nil, utils.WrapError(err, "failed to search users")
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Autocomplete suggests up to limit hashtags, dog names and usernames of
+// each requested type for a partly typed query. Names starting with the
+// prefix come first, then close misspellings. No types means all of them.
+func (s *SearchService) Autocomplete(userID string, prefix string, types []SearchType, limit int) ([]SearchSuggestion, error) {
+	prefix, err := validateSearch(prefix, types, SearchTypeHashtags, SearchTypeDogs, SearchTypeUsers)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		types = []SearchType{SearchTypeHashtags, SearchTypeDogs, SearchTypeUsers}
+	}
+
+	pattern := likePrefix(prefix)
+	suggestions := []SearchSuggestion{}
+	for _, searchType := range types {
+		switch searchType {
+		case SearchTypeHashtags:
+			tags := normalizeHashtags([]string{prefix})
+			if len(tags) == 0 {
+				continue
+			}
+			var names []string
+			if err := s.db.Model(&models.Hashtag{}).
+				Where("tag LIKE ?", likePrefix(tags[0])).
+				Order("(SELECT COUNT(*) FROM post_hashtags WHERE post_hashtags.hashtag_id = hashtags.id) DESC, tag").
+				Limit(limit).
+				Pluck("tag", &names).Error; err != nil {
+				return nil, utils.WrapError(err, "failed to complete hashtags")
+			}
+			for _, name := range names {
+				suggestions = append(suggestions, SearchSuggestion{Type: SearchTypeHashtags, Text: name})
+			}
+		case SearchTypeDogs:
+			var dogs []models.Dog
+			if err := s.db.Select("dogs.id, dogs.name").
+				Joins("JOIN users ON users.id = dogs.user_id").
+				Scopes(searchableUsers(userID)).
+				Where("(dogs.name ILIKE ? OR ? <% dogs.name)", pattern, prefix).
+				Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "dogs.name ILIKE ? DESC, word_similarity(?, dogs.name) DESC, dogs.name", Vars: []interface{}{pattern, prefix}}}).
+				Limit(limit).
+				Find(&dogs).Error; err != nil {
+				return nil, utils.WrapError(err, "failed to complete dog names")
+			}
+			for i := range dogs {
+				suggestions = append(suggestions, SearchSuggestion{Type: SearchTypeDogs, Text: dogs[i].Name, ID: &dogs[i].ID})
+			}
+		case SearchTypeUsers:
+			var users []SearchUser
+			if err := s.db.Model(&models.User{}).
+				Select("users.id, users.username").
+				Scopes(searchableUsers(userID)).
+				Where("(users.username ILIKE ? OR ? <% users.username)", pattern, prefix).
+				Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "users.username ILIKE ? DESC, word_similarity(?, users.username) DESC, users.username", Vars: []interface{}{pattern, prefix}}}).
+				Limit(limit).
+				Scan(&users).Error; err != nil {
+				return nil, utils.WrapError(err, "failed to complete usernames")
+			}
+			for i := range users {
+				suggestions = append(suggestions, SearchSuggestion{Type: SearchTypeUsers, Text: users[i].Username, ID: &users[i].ID})
+			}
+		}
+	}
+
+	return suggestions, nil
+}
+
+// validateSearch returns the trimmed query, rejecting empty or overlong
+// queries and types other than the allowed ones
+func validateSearch(query string, types []SearchType, allowed ...SearchType) (string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" || len(query) > maxSearchQueryLength {
+		return "", utils.NewValidationError([]utils.ValidationError{{Field: "q", Message: "q must be between 1 and 100 characters"}})
+	}
+
+	for _, searchType := range types {
+		valid := false
+		for _, a := range allowed {
+			valid = valid || searchType == a
+		}
+		if !valid {
+			return "", utils.NewValidationError([]utils.ValidationError{{Field: "type", Message: "unknown search type " + string(searchType)}})
+		}
+	}
+	return query, nil
+}
+
+// searchablePosts limits a posts query to the posts the user may see, by
+// users who are not hidden from search and not in a block with the user
+func searchablePosts(userID string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Scopes(visiblePostsTo(userID)).
+			Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN (" + searchHiddenUserIDsSQL + "))")
+		if userID == "" {
+			return db
+		}
+		return db.Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID)
+	}
+}
+
+// searchableUsers limits a query on users, or joined to them, to the users
+// the user may find: not hidden from search, not in a block with the user,
+// and public or followed by one of the user's dogs. An empty user ID finds
+// public users only.
+func searchableUsers(userID string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("users.id NOT IN (" + searchHiddenUserIDsSQL + ")")
+		if userID == "" {
+			return db.Where("users.visibility = 'public'")
+		}
+		return db.Where("users.id NOT IN ("+blockedUserIDsSQL+")", userID, userID).
+			Where("(users.visibility = 'public' OR users.id = ? OR users.id IN "+
+				"(SELECT dogs.user_id FROM dogs JOIN followers ON followers.followed_dog_id = dogs.id WHERE followers.follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)))",
+				userID, userID)
+	}
+}
+
+// postsMatching limits a posts query to posts whose content matches the
+// query, or that use it as a hashtag
+func postsMatching(query string) func(db *gorm.DB) *gorm.DB {
+	tag := strings.ToLower(strings.TrimPrefix(query, "#"))
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(to_tsvector('"+postSearchConfig+"', posts.content) @@ websearch_to_tsquery('"+postSearchConfig+"', ?) OR "+taggedPostsSQL+")", query, tag)
+	}
+}
+
+// byPostRank orders posts by how well their content matches the query,
+// newest first among equal matches
+func byPostRank(query string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "ts_rank(to_tsvector('" + postSearchConfig + "', posts.content), websearch_to_tsquery('" + postSearchConfig + "', ?)) DESC, posts.created_at DESC",
+			Vars: []interface{}{query},
+		}})
+	}
+}
+
+// dogsMatching limits a dogs query to dogs whose name or breed is close to
+// the query, allowing for typos and partial words
+func dogsMatching(query string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(? <% dogs.name OR ? <% dogs.breed)", query, query)
+	}
+}
+
+// byDogSimilarity orders dogs by how close their name, or less so their
+// breed, is to the query
+func byDogSimilarity(query string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "GREATEST(word_similarity(?, dogs.name), word_similarity(?, dogs.breed) * 0.8) DESC, dogs.name",
+			Vars: []interface{}{query, query},
+		}})
+	}
+}
+
+// likePrefix returns a LIKE pattern matching strings that start with prefix
+func likePrefix(prefix string) string {
+	return likeEscape(prefix) + "%"
+}
+
+// likeContains returns a LIKE pattern matching strings that contain s
+func likeContains(s string) string {
+	return "%" + likeEscape(s) + "%"
+}
+
+// likeEscape escapes the LIKE wildcards in s so it matches literally
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
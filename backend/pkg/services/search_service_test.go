@@ -0,0 +1,102 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSearch(t *testing.T) {
+	query, err := validateSearch("  corgi ", []SearchType{SearchTypeDogs}, SearchTypeDogs, SearchTypeUsers)
+	require.NoError(t, err)
+	assert.Equal(t, "corgi", query)
+
+	_, err = validateSearch(" ", nil, SearchTypeDogs)
+	assert.Error(t, err)
+	_, err = validateSearch("corgi", []SearchType{SearchTypeHashtags}, SearchTypePosts, SearchTypeDogs)
+	assert.Error(t, err)
+
+	assert.Equal(t, `100\%\_a\\%`, likePrefix(`100%_a\`))
+}
+
+func TestSearchService_Search(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	searchService := NewSearchService(ctx.DB)
+	postService := NewPostService(ctx.DB, ctx.Redis, ctx.Config)
+
+	viewer := testutils.CreateTestUser(t, ctx.DB)
+	owner := testutils.CreateTestUser(t, ctx.DB)
+	hidden := testutils.CreateTestUser(t, ctx.DB)
+	blocked := testutils.CreateTestUser(t, ctx.DB)
+	testutils.CreateTestDog(t, ctx.DB, viewer.ID.String())
+	dog := testutils.CreateTestDog(t, ctx.DB, owner.ID.String())
+	hiddenDog := testutils.CreateTestDog(t, ctx.DB, hidden.ID.String())
+	blockedDog := testutils.CreateTestDog(t, ctx.DB, blocked.ID.String())
+	for _, d := range []*models.Dog{dog, hiddenDog, blockedDog} {
+		require.NoError(t, ctx.DB.Model(d).Updates(map[string]interface{}{"name": "Biscuit", "breed": "Shiba Inu"}).Error)
+	}
+	require.NoError(t, ctx.DB.Create(&models.SafetySettings{UserID: hidden.ID.String(), HideFromSearch: true}).Error)
+	require.NoError(t, ctx.DB.Create(&models.BlockedUser{BlockerID: blocked.ID.String(), BlockedID: viewer.ID.String()}).Error)
+
+	for _, d := range []*models.Dog{dog, hiddenDog, blockedDog} {
+		_, err := postService.CreatePost(d.UserID.String(), CreatePostRequest{DogID: d.ID.String(), Content: "Running on the beaches with friends"})
+		require.NoError(t, err)
+	}
+
+	// Typos and stemming still match; the hidden and blocked users'
+	// dogs and posts do not
+	results, err := searchService.Search(viewer.ID.String(), "Biscit", []SearchType{SearchTypeDogs}, 20, 0)
+	require.NoError(t, err)
+	require.Len(t, results.Dogs, 1)
+	assert.Equal(t, dog.ID, results.Dogs[0].ID)
+	assert.Nil(t, results.Posts)
+
+	results, err = searchService.Search(viewer.ID.String(), "run beach", nil, 20, 0)
+	require.NoError(t, err)
+	require.Len(t, results.Posts, 1)
+	assert.Equal(t, dog.ID, results.Posts[0].DogID)
+
+	// Private profiles are only found by their followers
+	require.NoError(t, ctx.DB.Model(owner).Update("visibility", models.VisibilityPrivate).Error)
+	results, err = searchService.Search(viewer.ID.String(), owner.Username, []SearchType{SearchTypeUsers, SearchTypeDogs}, 20, 0)
+	require.NoError(t, err)
+	for _, user := range results.Users {
+		assert.NotEqual(t, owner.ID, user.ID)
+	}
+	for _, d := range results.Dogs {
+		assert.NotEqual(t, dog.ID, d.ID)
+	}
+
+	// The users endpoint applies the same rules and does not match emails
+	userService := NewUserService(ctx.DB, ctx.Redis, ctx.Config)
+	users, total, err := userService.SearchUsers(viewer.ID.String(), hidden.Username, 20, 0)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, users)
+	users, _, err = userService.SearchUsers(owner.ID.String(), viewer.Email, 20, 0)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+	users, _, err = userService.SearchUsers(owner.ID.String(), viewer.Username, 20, 0)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, viewer.ID, users[0].ID)
+
+	// LIKE wildcards in the query match literally
+	for _, wildcard := range []string{"%", "_", `\`} {
+		users, total, err = userService.SearchUsers(owner.ID.String(), wildcard, 20, 0)
+		require.NoError(t, err)
+		assert.Zero(t, total)
+		assert.Empty(t, users)
+	}
+
+	suggestions, err := searchService.Autocomplete(owner.ID.String(), "bisc", []SearchType{SearchTypeDogs}, 5)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	assert.Equal(t, "Biscuit", suggestions[0].Text)
+	assert.Equal(t, dog.ID, *suggestions[0].ID)
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type SessionService struct {
+	db       *gorm.DB
+	cfg      config.Config
+	denylist *TokenDenylist
+}
+
+func NewSessionService(db *gorm.DB, redis *redis.Client, cfg config.Config) *SessionService {
+	return &SessionService{
+		db:       db,
+		cfg:      cfg,
+		denylist: NewTokenDenylist(redis),
+	}
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+// currentSessionID marks the session the request was made from.
+func (s *SessionService) ListSessions(userID uuid.UUID, currentSessionID string) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get sessions")
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.String() == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession signs the user out of one session
+func (s *SessionService) RevokeSession(userID, sessionID uuid.UUID) error {
+	var session models.Session
+	if err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.ErrNotFound
+		}
+		return utils.WrapError(err, "failed to find session")
+	}
+
+	return s.revokeSessions(userID, []models.Session{session})
+}
+
+// RevokeAllSessions signs the user out of every session, including the current one
+func (s *SessionService) RevokeAllSessions(userID uuid.UUID) error {
+	var sessions []models.Session
+	if err := s.db.Where("user_id = ? AND revoked_at IS NULL", userID).Find(&sessions).Error; err != nil {
+		return utils.WrapError(err, "failed to get sessions")
+	}
+
+	if err := s.revokeSessions(userID, sessions); err != nil {
+		return err
+	}
+
+	// Also catch refresh tokens issued before sessions were tracked
+	if err := s.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return utils.WrapError(err, "failed to revoke refresh tokens")
+	}
+
+	return nil
+}
+
+// Logout ends the session behind the presented access token. When the
+// token predates sessions, the refresh token identifies what to revoke.
+func (s *SessionService) Logout(userID uuid.UUID, claims *utils.Claims, refreshToken string) error {
+	if claims != nil && claims.ExpiresAt != nil {
+		if err := s.denylist.Deny(claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+
+	var familyID uuid.UUID
+	if claims != nil && claims.SessionID != "" {
+		sessionID, err := uuid.Parse(claims.SessionID)
+		if err != nil {
+			return utils.ErrInvalidToken
+		}
+		familyID = sessionID
+	} else if refreshToken != "" {
+		var stored models.RefreshToken
+		if err := s.db.Where("token = ? AND user_id = ?", utils.HashToken(refreshToken), userID).
+			First(&stored).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return utils.WrapError(err, "failed to find refresh token")
+		}
+		familyID = stored.FamilyID
+	} else {
+		return nil
+	}
+
+	var sessions []models.Session
+	if err := s.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", familyID, userID).
+		Find(&sessions).Error; err != nil {
+		return utils.WrapError(err, "failed to find session")
+	}
+	if err := s.revokeSessions(userID, sessions); err != nil {
+		return err
+	}
+
+	if err := s.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND user_id = ? AND revoked_at IS NULL", familyID, userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return utils.WrapError(err, "failed to revoke refresh tokens")
+	}
+
+	return nil
+}
+
+// revokeSessions marks the sessions revoked, revokes their refresh tokens and
+// denylists the sessions so all their access tokens stop working before expiry
+func (s *SessionService) revokeSessions(userID uuid.UUID, sessions []models.Session) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if err := tx.Model(&models.Session{}).
+			Where("id IN ? AND user_id = ? AND revoked_at IS NULL", ids, userID).
+			Update("revoked_at", now).Error; err != nil {
+			return utils.WrapError(err, "failed to revoke sessions")
+		}
+
+		if err := tx.Model(&models.RefreshToken{}).
+			Where("family_id IN ? AND user_id = ? AND revoked_at IS NULL", ids, userID).
+			Update("revoked_at", now).Error; err != nil {
+			return utils.WrapError(err, "failed to revoke refresh tokens")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Every access token of the sessions was issued by now, so each expires
+	// within one token lifetime
+	until := time.Now().Add(s.cfg.JWT.ExpireHours)
+	for _, session := range sessions {
+		if err := s.denylist.DenySession(session.ID.String(), until); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
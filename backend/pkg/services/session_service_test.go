@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionService_RevokeSession(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
+	sessionService := NewSessionService(ctx.DB, ctx.Redis, ctx.Config)
+	denylist := NewTokenDenylist(ctx.Redis)
+
+	registered, err := authService.Register(RegisterRequest{
+		Email:      "sessions@example.com",
+		Password:   "password123",
+		Username:   "SessionUser",
+		ClientInfo: ClientInfo{DeviceName: "Phone", IPAddress: "10.0.0.1"},
+	})
+	require.NoError(t, err)
+
+	loggedIn, err := authService.Login(LoginRequest{
+		Email:      "sessions@example.com",
+		Password:   "password123",
+		ClientInfo: ClientInfo{DeviceName: "Laptop"},
+	})
+	require.NoError(t, err)
+
+	phoneClaims, err := utils.ValidateToken(registered.AccessToken, ctx.Config.JWT)
+	require.NoError(t, err)
+	laptopClaims, err := utils.ValidateToken(loggedIn.AccessToken, ctx.Config.JWT)
+	require.NoError(t, err)
+
+	// A refresh issues a newer access token in the same phone session
+	refreshed, err := authService.RefreshToken(registered.RefreshToken, ClientInfo{})
+	require.NoError(t, err)
+	refreshedClaims, err := utils.ValidateToken(refreshed.AccessToken, ctx.Config.JWT)
+	require.NoError(t, err)
+	require.Equal(t, phoneClaims.SessionID, refreshedClaims.SessionID)
+
+	sessions, err := sessionService.ListSessions(registered.User.ID, laptopClaims.SessionID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	for _, session := range sessions {
+		assert.Equal(t, session.ID.String() == laptopClaims.SessionID, session.Current)
+	}
+
+	// Revoking the phone session kills all its tokens but not the laptop's
+	phoneSession := sessions[1]
+	if phoneSession.ID.String() != phoneClaims.SessionID {
+		phoneSession = sessions[0]
+	}
+	require.NoError(t, sessionService.RevokeSession(registered.User.ID, phoneSession.ID))
+
+	for _, claims := range []*utils.Claims{phoneClaims, refreshedClaims} {
+		denied, err := denylist.IsRevoked(claims)
+		require.NoError(t, err)
+		assert.True(t, denied)
+	}
+
+	denied, err := denylist.IsRevoked(laptopClaims)
+	require.NoError(t, err)
+	assert.False(t, denied)
+
+	_, err = authService.RefreshToken(refreshed.RefreshToken, ClientInfo{})
+	assert.ErrorIs(t, err, utils.ErrInvalidToken)
+
+	_, err = authService.RefreshToken(loggedIn.RefreshToken, ClientInfo{})
+	assert.NoError(t, err)
+
+	// Sessions of other users cannot be revoked
+	other := testutils.CreateTestUser(t, ctx.DB)
+	assert.ErrorIs(t, sessionService.RevokeSession(other.ID, phoneSession.ID), utils.ErrNotFound)
+}
+
+func TestSessionService_RevokeAllSessions(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	authService := NewAuthService(ctx.DB, ctx.Config.JWT)
+	sessionService := NewSessionService(ctx.DB, ctx.Redis, ctx.Config)
+
+	registered, err := authService.Register(RegisterRequest{
+		Email:    "everywhere@example.com",
+		Password: "password123",
+		Username: "EverywhereUser",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sessionService.RevokeAllSessions(registered.User.ID))
+
+	sessions, err := sessionService.ListSessions(registered.User.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	_, err = authService.RefreshToken(registered.RefreshToken, ClientInfo{})
+	assert.ErrorIs(t, err, utils.ErrInvalidToken)
+}
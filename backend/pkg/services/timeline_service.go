@@ -0,0 +1,560 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Timeline keys. TimelineKey is a sorted set of the posts on a user's
+// timeline, members "dogID:postID" scored by creation time in milliseconds,
+// written as posts are created. TimelinePopularDogsKey is the set of dogs
+// with too many followers to write to, whose posts are read on demand.
+const (
+	TimelineKey            = "timeline:user:%s"
+	TimelinePopularDogsKey = "timeline:popular_dogs"
+)
+
+// timelineSentinel marks a timeline as built even when it has no posts, so
+// posts are only written to timelines that hold everything before them. It
+// is scored 0 and stays below every post.
+const timelineSentinel = "-"
+
+// addTimelinePostsScript adds the posts in ARGV[2..] (score, member pairs)
+// to each timeline in KEYS that has been built, keeping the newest ARGV[1]
+var addTimelinePostsScript = redis.NewScript(`
+local max = tonumber(ARGV[1])
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 1 then
+		for i = 2, #ARGV, 2 do
+			redis.call('ZADD', key, ARGV[i], ARGV[i + 1])
+		end
+		redis.call('ZREMRANGEBYRANK', key, 1, -max - 2)
+	end
+end
+return 0
+`)
+
+const (
+	defaultTimelineMaxPosts           = 800
+	defaultTimelineFanoutMaxFollowers = 10000
+	defaultTimelineTTL                = 72 * time.Hour
+
+	// timelineFanoutBatch is how many timelines one script call writes to
+	timelineFanoutBatch = 500
+)
+
+// timelineEntry is a post on a timeline, the dog that posted it and its score
+type timelineEntry struct {
+	PostID uuid.UUID
+	DogID  uuid.UUID
+	Score  float64
+}
+
+// TimelineService keeps each user's timeline of own and followed dogs'
+// posts in Redis. Posts are written to followers' timelines when created;
+// posts of dogs with more than FanoutMaxFollowers followers are only
+// written to their owner's and are merged in from the database on read,
+// until the dog drops to FanoutMinFollowers.
+// A timeline that is missing, because it expired or was never read, is
+// rebuilt from the database.
+type TimelineService struct {
+	db    *gorm.DB
+	redis *redis.Client
+	cfg   config.TimelineConfig
+	ctx   context.Context
+
+	// demoting tracks backfills of dogs that stopped being popular
+	demoting sync.WaitGroup
+}
+
+func NewTimelineService(db *gorm.DB, redis *redis.Client, cfg config.Config) *TimelineService {
+	timelineCfg := cfg.Timeline
+	if timelineCfg.MaxPosts <= 0 {
+		timelineCfg.MaxPosts = defaultTimelineMaxPosts
+	}
+	if timelineCfg.FanoutMaxFollowers <= 0 {
+		timelineCfg.FanoutMaxFollowers = defaultTimelineFanoutMaxFollowers
+	}
+	if timelineCfg.FanoutMinFollowers <= 0 || timelineCfg.FanoutMinFollowers >= timelineCfg.FanoutMaxFollowers {
+		timelineCfg.FanoutMinFollowers = timelineCfg.FanoutMaxFollowers * 9 / 10
+	}
+	if timelineCfg.TTL <= 0 {
+		timelineCfg.TTL = defaultTimelineTTL
+	}
+
+	return &TimelineService{
+		db:    db,
+		redis: redis,
+		cfg:   timelineCfg,
+		ctx:   context.Background(),
+	}
+}
+
+// Page returns the IDs of a page of the user's timeline, newest first, and
+// how many posts it holds. Only the newest MaxPosts posts of dogs that are
+// not popular can be paged to.
+func (s *TimelineService) Page(userID string, limit int, offset int) ([]uuid.UUID, int64, error) {
+	if err := s.ensureBuilt(userID); err != nil {
+		return nil, 0, err
+	}
+
+	key := fmt.Sprintf(TimelineKey, userID)
+	pipe := s.redis.Pipeline()
+	rangeCmd := pipe.ZRevRangeByScoreWithScores(s.ctx, key, &redis.ZRangeBy{Min: "(0", Max: "+inf", Count: int64(offset + limit)})
+	countCmd := pipe.ZCount(s.ctx, key, "(0", "+inf")
+	pipe.Expire(s.ctx, key, s.cfg.TTL)
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		return nil, 0, utils.WrapError(err, "failed to read timeline")
+	}
+
+	entries := make([]timelineEntry, 0, len(rangeCmd.Val()))
+	for _, z := range rangeCmd.Val() {
+		if dogID, postID, ok := parseTimelineMember(z.Member); ok {
+			entries = append(entries, timelineEntry{PostID: postID, DogID: dogID, Score: z.Score})
+		}
+	}
+
+	popular, popularTotal, err := s.popularPosts(userID, offset+limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries = mergeTimelineEntries(entries, popular)
+
+	ids := []uuid.UUID{}
+	for i := offset; i < len(entries) && i < offset+limit; i++ {
+		ids = append(ids, entries[i].PostID)
+	}
+	return ids, countCmd.Val() + popularTotal, nil
+}
+
+// AddPost writes a new post to the timelines of its author and of the
+// owners of the dog's followers. Private posts go to the author's only, and
+// posts of popular dogs are left to be read on demand.
+func (s *TimelineService) AddPost(authorID string, post *models.Post) error {
+	userIDs := []string{authorID}
+	if post.Visibility != models.PostVisibilityPrivate {
+		popular, err := s.updatePopular(post.DogID)
+		if err != nil {
+			return err
+		}
+		if !popular {
+			owners, err := s.followerOwnerIDs(post.DogID)
+			if err != nil {
+				return err
+			}
+			userIDs = append(userIDs, owners...)
+		}
+	}
+
+	return s.add(userIDs, []timelineEntry{postTimelineEntry(post)})
+}
+
+// RemovePost takes a post off every timeline it was written to
+func (s *TimelineService) RemovePost(authorID string, post *models.Post) error {
+	userIDs := []string{authorID}
+	isPopular, err := s.redis.SIsMember(s.ctx, TimelinePopularDogsKey, post.DogID.String()).Result()
+	if err != nil {
+		return utils.WrapError(err, "failed to check popular dogs")
+	}
+	// Older posts of a dog that became popular may still be on followers'
+	// timelines; reads skip them once the post is gone
+	if !isPopular {
+		owners, err := s.followerOwnerIDs(post.DogID)
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, owners...)
+	}
+
+	member := timelineMember(post.DogID, post.ID)
+	pipe := s.redis.Pipeline()
+	for _, userID := range userIDs {
+		pipe.ZRem(s.ctx, fmt.Sprintf(TimelineKey, userID), member)
+	}
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		return utils.WrapError(err, "failed to remove post from timelines")
+	}
+	return nil
+}
+
+// Follow backfills the user's timeline with the recent posts of a dog they
+// started following, unless the new follower made the dog popular
+func (s *TimelineService) Follow(userID string, dogID uuid.UUID) error {
+	popular, err := s.updatePopular(dogID)
+	if err != nil {
+		return err
+	}
+	if popular {
+		return nil
+	}
+	return s.backfill(userID, dogID)
+}
+
+// backfill writes the recent posts of the dog the user may see to the
+// user's timeline
+func (s *TimelineService) backfill(userID string, dogID uuid.UUID) error {
+	var posts []models.Post
+	if err := s.db.Select("posts.id, posts.dog_id, posts.created_at").
+		Scopes(visiblePostsTo(userID)).
+		Where("posts.dog_id = ?", dogID).
+		Order("posts.created_at DESC").
+		Limit(s.cfg.MaxPosts).
+		Find(&posts).Error; err != nil {
+		return utils.WrapError(err, "failed to get followed dog posts")
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+
+	entries := make([]timelineEntry, len(posts))
+	for i := range posts {
+		entries[i] = postTimelineEntry(&posts[i])
+	}
+	return s.add([]string{userID}, entries)
+}
+
+// Unfollow takes a dog's posts off the user's timeline, unless another of
+// the user's dogs still follows it
+func (s *TimelineService) Unfollow(userID string, dogID uuid.UUID) error {
+	if _, err := s.updatePopular(dogID); err != nil {
+		return err
+	}
+
+	var following int64
+	if err := s.db.Model(&models.Follower{}).
+		Where("follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?) AND followed_dog_id = ?", userID, dogID).
+		Count(&following).Error; err != nil {
+		return utils.WrapError(err, "failed to check follows")
+	}
+	if following > 0 {
+		return nil
+	}
+	return s.removeDogs(userID, []uuid.UUID{dogID})
+}
+
+// Block takes each user's dogs' posts off the other's timeline
+func (s *TimelineService) Block(userID string, otherUserID string) error {
+	for _, pair := range [][2]string{{userID, otherUserID}, {otherUserID, userID}} {
+		var dogIDs []uuid.UUID
+		if err := s.db.Model(&models.Dog{}).Where("user_id = ?", pair[1]).Pluck("id", &dogIDs).Error; err != nil {
+			return utils.WrapError(err, "failed to get user dogs")
+		}
+		if err := s.removeDogs(pair[0], dogIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureBuilt rebuilds the user's timeline from the database if it is missing
+func (s *TimelineService) ensureBuilt(userID string) error {
+	key := fmt.Sprintf(TimelineKey, userID)
+	exists, err := s.redis.Exists(s.ctx, key).Result()
+	if err != nil {
+		return utils.WrapError(err, "failed to check timeline")
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	popular, err := s.popularDogIDs()
+	if err != nil {
+		return err
+	}
+	var dogIDs []uuid.UUID
+	if err := s.db.Model(&models.Dog{}).Where("user_id = ?", userID).Pluck("id", &dogIDs).Error; err != nil {
+		return utils.WrapError(err, "failed to get user dogs")
+	}
+	var followedDogIDs []uuid.UUID
+	if err := s.db.Model(&models.Follower{}).
+		Where("follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID).
+		Pluck("followed_dog_id", &followedDogIDs).Error; err != nil {
+		return utils.WrapError(err, "failed to get followed dogs")
+	}
+	for _, dogID := range followedDogIDs {
+		if !popular[dogID] {
+			dogIDs = append(dogIDs, dogID)
+		}
+	}
+
+	var posts []models.Post
+	if len(dogIDs) > 0 {
+		if err := s.db.Select("posts.id, posts.dog_id, posts.created_at").
+			Scopes(visiblePostsTo(userID)).
+			Where("posts.dog_id IN ?", dogIDs).
+			Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID).
+			Order("posts.created_at DESC").
+			Limit(s.cfg.MaxPosts).
+			Find(&posts).Error; err != nil {
+			return utils.WrapError(err, "failed to get timeline posts")
+		}
+	}
+
+	members := make([]redis.Z, 0, len(posts)+1)
+	members = append(members, redis.Z{Score: 0, Member: timelineSentinel})
+	for i := range posts {
+		entry := postTimelineEntry(&posts[i])
+		members = append(members, redis.Z{Score: entry.Score, Member: timelineMember(entry.DogID, entry.PostID)})
+	}
+	_, err = s.redis.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(s.ctx, key)
+		pipe.ZAdd(s.ctx, key, members...)
+		pipe.Expire(s.ctx, key, s.cfg.TTL)
+		return nil
+	})
+	if err != nil {
+		return utils.WrapError(err, "failed to build timeline")
+	}
+	return nil
+}
+
+// popularPosts returns up to limit of the newest posts of popular dogs the
+// user follows, and how many there are
+func (s *TimelineService) popularPosts(userID string, limit int) ([]timelineEntry, int64, error) {
+	popular, err := s.popularDogIDs()
+	if err != nil || len(popular) == 0 {
+		return nil, 0, err
+	}
+
+	var followedDogIDs []uuid.UUID
+	if err := s.db.Model(&models.Follower{}).
+		Where("follower_dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", userID).
+		Pluck("followed_dog_id", &followedDogIDs).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to get followed dogs")
+	}
+	var dogIDs []uuid.UUID
+	for _, dogID := range followedDogIDs {
+		if popular[dogID] {
+			dogIDs = append(dogIDs, dogID)
+		}
+	}
+	if len(dogIDs) == 0 {
+		return nil, 0, nil
+	}
+
+	query := func() *gorm.DB {
+		return s.db.Model(&models.Post{}).
+			Scopes(visiblePostsTo(userID)).
+			Where("posts.dog_id IN ?", dogIDs).
+			Where("posts.dog_id NOT IN (SELECT id FROM dogs WHERE user_id IN ("+blockedUserIDsSQL+"))", userID, userID)
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to count popular dog posts")
+	}
+	var posts []models.Post
+	if err := query().Select("posts.id, posts.dog_id, posts.created_at").
+		Order("posts.created_at DESC").
+		Limit(limit).
+		Find(&posts).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to get popular dog posts")
+	}
+
+	entries := make([]timelineEntry, len(posts))
+	for i := range posts {
+		entries[i] = postTimelineEntry(&posts[i])
+	}
+	return entries, total, nil
+}
+
+// updatePopular recounts the dog's followers and returns whether it is
+// popular. A dog becomes popular above FanoutMaxFollowers followers and
+// stops at FanoutMinFollowers, so one follow does not flip it back and
+// forth. The recent posts of a dog that stops being popular are backfilled
+// into its followers' timelines in the background.
+func (s *TimelineService) updatePopular(dogID uuid.UUID) (bool, error) {
+	var followers int64
+	if err := s.db.Model(&models.Follower{}).Where("followed_dog_id = ?", dogID).Count(&followers).Error; err != nil {
+		return false, utils.WrapError(err, "failed to count followers")
+	}
+
+	switch {
+	case followers > int64(s.cfg.FanoutMaxFollowers):
+		if err := s.redis.SAdd(s.ctx, TimelinePopularDogsKey, dogID.String()).Err(); err != nil {
+			return false, utils.WrapError(err, "failed to update popular dogs")
+		}
+		return true, nil
+	case followers <= int64(s.cfg.FanoutMinFollowers):
+		removed, err := s.redis.SRem(s.ctx, TimelinePopularDogsKey, dogID.String()).Result()
+		if err != nil {
+			return false, utils.WrapError(err, "failed to update popular dogs")
+		}
+		// Only the call that removed the dog backfills it
+		if removed > 0 {
+			s.demoting.Add(1)
+			go func() {
+				defer s.demoting.Done()
+				if err := s.backfillFollowers(dogID); err != nil {
+					log.Printf("Failed to backfill timelines for dog %s: %v", dogID, err)
+				}
+			}()
+		}
+		return false, nil
+	default:
+		popular, err := s.redis.SIsMember(s.ctx, TimelinePopularDogsKey, dogID.String()).Result()
+		if err != nil {
+			return false, utils.WrapError(err, "failed to check popular dogs")
+		}
+		return popular, nil
+	}
+}
+
+// backfillFollowers writes the dog's recent posts to the timelines of the
+// owners of its followers
+func (s *TimelineService) backfillFollowers(dogID uuid.UUID) error {
+	owners, err := s.followerOwnerIDs(dogID)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		if err := s.backfill(owner, dogID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// popularDogIDs returns the dogs whose posts are read on demand
+func (s *TimelineService) popularDogIDs() (map[uuid.UUID]bool, error) {
+	members, err := s.redis.SMembers(s.ctx, TimelinePopularDogsKey).Result()
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to get popular dogs")
+	}
+	popular := make(map[uuid.UUID]bool, len(members))
+	for _, member := range members {
+		if dogID, err := uuid.Parse(member); err == nil {
+			popular[dogID] = true
+		}
+	}
+	return popular, nil
+}
+
+// followerOwnerIDs returns the users whose dogs follow the dog
+func (s *TimelineService) followerOwnerIDs(dogID uuid.UUID) ([]string, error) {
+	var userIDs []string
+	if err := s.db.Model(&models.Follower{}).
+		Joins("JOIN dogs ON dogs.id = followers.follower_dog_id").
+		Where("followers.followed_dog_id = ?", dogID).
+		Distinct().
+		Pluck("dogs.user_id", &userIDs).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get followers")
+	}
+	return userIDs, nil
+}
+
+// add writes the entries to each user's timeline that has been built
+func (s *TimelineService) add(userIDs []string, entries []timelineEntry) error {
+	args := make([]interface{}, 0, 1+2*len(entries))
+	args = append(args, s.cfg.MaxPosts)
+	for _, entry := range entries {
+		args = append(args, entry.Score, timelineMember(entry.DogID, entry.PostID))
+	}
+
+	for start := 0; start < len(userIDs); start += timelineFanoutBatch {
+		end := start + timelineFanoutBatch
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		keys := make([]string, 0, end-start)
+		for _, userID := range userIDs[start:end] {
+			keys = append(keys, fmt.Sprintf(TimelineKey, userID))
+		}
+		if err := addTimelinePostsScript.Run(s.ctx, s.redis, keys, args...).Err(); err != nil && err != redis.Nil {
+			return utils.WrapError(err, "failed to write timelines")
+		}
+	}
+	return nil
+}
+
+// removeDogs takes the dogs' posts off the user's timeline
+func (s *TimelineService) removeDogs(userID string, dogIDs []uuid.UUID) error {
+	if len(dogIDs) == 0 {
+		return nil
+	}
+	key := fmt.Sprintf(TimelineKey, userID)
+	members, err := s.redis.ZRange(s.ctx, key, 0, -1).Result()
+	if err != nil {
+		return utils.WrapError(err, "failed to read timeline")
+	}
+
+	remove := make(map[uuid.UUID]bool, len(dogIDs))
+	for _, dogID := range dogIDs {
+		remove[dogID] = true
+	}
+	var stale []interface{}
+	for _, member := range members {
+		if dogID, _, ok := parseTimelineMember(member); ok && remove[dogID] {
+			stale = append(stale, member)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if err := s.redis.ZRem(s.ctx, key, stale...).Err(); err != nil {
+		return utils.WrapError(err, "failed to remove posts from timeline")
+	}
+	return nil
+}
+
+// mergeTimelineEntries merges two lists of entries newest first, dropping
+// posts that are in both
+func mergeTimelineEntries(a []timelineEntry, b []timelineEntry) []timelineEntry {
+	if len(b) == 0 {
+		return a
+	}
+	seen := make(map[uuid.UUID]bool, len(a)+len(b))
+	merged := make([]timelineEntry, 0, len(a)+len(b))
+	for _, entries := range [][]timelineEntry{a, b} {
+		for _, entry := range entries {
+			if !seen[entry.PostID] {
+				seen[entry.PostID] = true
+				merged = append(merged, entry)
+			}
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+	return merged
+}
+
+// postTimelineEntry returns the timeline entry of a post, scored by when it
+// was created
+func postTimelineEntry(post *models.Post) timelineEntry {
+	return timelineEntry{PostID: post.ID, DogID: post.DogID, Score: float64(post.CreatedAt.UnixMilli())}
+}
+
+// timelineMember is how a post is stored on a timeline
+func timelineMember(dogID uuid.UUID, postID uuid.UUID) string {
+	return dogID.String() + ":" + postID.String()
+}
+
+// parseTimelineMember returns the dog and post a timeline member stands for
+func parseTimelineMember(member string) (uuid.UUID, uuid.UUID, bool) {
+	dog, post, found := strings.Cut(member, ":")
+	if !found {
+		return uuid.Nil, uuid.Nil, false
+	}
+	dogID, err := uuid.Parse(dog)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+	postID, err := uuid.Parse(post)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+	return dogID, postID, true
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimelineMembersAndMerge(t *testing.T) {
+	dogID, postID := uuid.New(), uuid.New()
+	gotDog, gotPost, ok := parseTimelineMember(timelineMember(dogID, postID))
+	require.True(t, ok)
+	assert.Equal(t, dogID, gotDog)
+	assert.Equal(t, postID, gotPost)
+	_, _, ok = parseTimelineMember(timelineSentinel)
+	assert.False(t, ok)
+
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	merged := mergeTimelineEntries(
+		[]timelineEntry{{PostID: a, Score: 30}, {PostID: b, Score: 10}},
+		[]timelineEntry{{PostID: c, Score: 20}, {PostID: b, Score: 10}},
+	)
+	require.Len(t, merged, 3)
+	assert.Equal(t, []uuid.UUID{a, c, b}, []uuid.UUID{merged[0].PostID, merged[1].PostID, merged[2].PostID})
+}
+
+func TestPostService_MaterializedTimeline(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	cfg := ctx.Config
+	cfg.Timeline.FanoutMaxFollowers = 2
+	cfg.Timeline.FanoutMinFollowers = 1
+	postService := NewPostService(ctx.DB, ctx.Redis, cfg)
+	moderationService := NewModerationService(ctx.DB, ctx.Redis, cfg)
+
+	reader := testutils.CreateTestUser(t, ctx.DB)
+	readerDog := testutils.CreateTestDog(t, ctx.DB, reader.ID.String())
+	author := testutils.CreateTestUser(t, ctx.DB)
+	authorDog := testutils.CreateTestDog(t, ctx.DB, author.ID.String())
+	star := testutils.CreateTestUser(t, ctx.DB)
+	starDog := testutils.CreateTestDog(t, ctx.DB, star.ID.String())
+	fan := testutils.CreateTestUser(t, ctx.DB)
+	testutils.CreateTestDog(t, ctx.DB, fan.ID.String())
+	otherFan := testutils.CreateTestUser(t, ctx.DB)
+	testutils.CreateTestDog(t, ctx.DB, otherFan.ID.String())
+
+	timelineIDs := func() []uuid.UUID {
+		posts, total, err := postService.GetTimeline(reader.ID.String(), 20, 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(posts)), total)
+		ids := make([]uuid.UUID, len(posts))
+		for i, post := range posts {
+			ids[i] = post.ID
+		}
+		return ids
+	}
+	readerKey := fmt.Sprintf(TimelineKey, reader.ID)
+
+	// An earlier post is backfilled on follow, a later one fanned out
+	early, err := postService.CreatePost(author.ID.String(), CreatePostRequest{DogID: authorDog.ID.String(), Content: "early"})
+	require.NoError(t, err)
+	assert.Empty(t, timelineIDs())
+	_, err = postService.FollowDog(authorDog.ID.String(), reader.ID.String())
+	require.NoError(t, err)
+	late, err := postService.CreatePost(author.ID.String(), CreatePostRequest{DogID: authorDog.ID.String(), Content: "late"})
+	require.NoError(t, err)
+	members, err := ctx.Redis.ZCard(context.Background(), readerKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), members) // two posts and the sentinel
+	assert.Equal(t, []uuid.UUID{late.ID, early.ID}, timelineIDs())
+
+	// Deleted posts leave the timeline
+	require.NoError(t, postService.DeletePost(early.ID.String(), author.ID.String()))
+	assert.Equal(t, []uuid.UUID{late.ID}, timelineIDs())
+
+	// Posts of a dog with too many followers are read on demand
+	_, err = postService.FollowDog(starDog.ID.String(), reader.ID.String())
+	require.NoError(t, err)
+	_, err = postService.FollowDog(starDog.ID.String(), fan.ID.String())
+	require.NoError(t, err)
+	_, err = postService.FollowDog(starDog.ID.String(), otherFan.ID.String())
+	require.NoError(t, err)
+	starPost, err := postService.CreatePost(star.ID.String(), CreatePostRequest{DogID: starDog.ID.String(), Content: "hello fans"})
+	require.NoError(t, err)
+	popular, err := ctx.Redis.SIsMember(context.Background(), TimelinePopularDogsKey, starDog.ID.String()).Result()
+	require.NoError(t, err)
+	assert.True(t, popular)
+	assert.Equal(t, []uuid.UUID{starPost.ID, late.ID}, timelineIDs())
+	fanPosts, _, err := postService.GetTimeline(fan.ID.String(), 20, 0)
+	require.NoError(t, err)
+	require.Len(t, fanPosts, 1)
+	assert.Equal(t, starPost.ID, fanPosts[0].ID)
+
+	// Dropping back to the fanout limit keeps the dog popular
+	_, err = postService.FollowDog(starDog.ID.String(), otherFan.ID.String())
+	require.NoError(t, err)
+	popular, err = ctx.Redis.SIsMember(context.Background(), TimelinePopularDogsKey, starDog.ID.String()).Result()
+	require.NoError(t, err)
+	assert.True(t, popular)
+	assert.Equal(t, []uuid.UUID{starPost.ID, late.ID}, timelineIDs())
+
+	// Dropping to the lower limit makes the dog fan out again, and its
+	// posts are written to the remaining followers' timelines
+	_, err = postService.FollowDog(starDog.ID.String(), fan.ID.String())
+	require.NoError(t, err)
+	popular, err = ctx.Redis.SIsMember(context.Background(), TimelinePopularDogsKey, starDog.ID.String()).Result()
+	require.NoError(t, err)
+	assert.False(t, popular)
+	postService.timeline.demoting.Wait()
+	members, err = ctx.Redis.ZCard(context.Background(), readerKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), members)
+	assert.Equal(t, []uuid.UUID{starPost.ID, late.ID}, timelineIDs())
+
+	// Unfollowing and blocking take the dogs' posts off
+	_, err = postService.FollowDog(authorDog.ID.String(), reader.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{starPost.ID}, timelineIDs())
+
+	_, err = postService.FollowDog(authorDog.ID.String(), reader.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{starPost.ID, late.ID}, timelineIDs())
+	require.NoError(t, moderationService.BlockUser(reader.ID.String(), BlockUserRequest{BlockedUserID: author.ID.String()}))
+	assert.Equal(t, []uuid.UUID{starPost.ID}, timelineIDs())
+
+	// A lost timeline is rebuilt from the database
+	_, err = postService.CreatePost(reader.ID.String(), CreatePostRequest{DogID: readerDog.ID.String(), Content: "mine"})
+	require.NoError(t, err)
+	require.NoError(t, ctx.Redis.Del(context.Background(), readerKey).Err())
+	assert.Len(t, timelineIDs(), 2)
+}
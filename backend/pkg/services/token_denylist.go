@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// Denylist keys. AccessTokenDenylistKey is keyed by the access token ID
+// (jti) and SessionDenylistKey by the session ID (sid), which revokes every
+// access token issued for the session.
+const (
+	AccessTokenDenylistKey = "auth:denylist:%s"
+	SessionDenylistKey     = "auth:denylist:session:%s"
+)
+
+// TokenDenylist tracks revoked access tokens until they would have expired
+type TokenDenylist struct {
+	redis *redis.Client
+	ctx   context.Context
+}
+
+func NewTokenDenylist(redis *redis.Client) *TokenDenylist {
+	return &TokenDenylist{
+		redis: redis,
+		ctx:   context.Background(),
+	}
+}
+
+// Deny revokes the access token with the given ID. Entries expire with the
+// token so the denylist never outgrows the set of still-valid tokens.
+func (d *TokenDenylist) Deny(tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return nil
+	}
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := d.redis.Set(d.ctx, fmt.Sprintf(AccessTokenDenylistKey, tokenID), 1, ttl).Err(); err != nil {
+		return utils.WrapError(err, "failed to deny access token")
+	}
+	return nil
+}
+
+// DenySession revokes every access token of the session issued so far.
+// until must be no earlier than the expiry of the session's newest token.
+func (d *TokenDenylist) DenySession(sessionID string, until time.Time) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := d.redis.Set(d.ctx, fmt.Sprintf(SessionDenylistKey, sessionID), 1, ttl).Err(); err != nil {
+		return utils.WrapError(err, "failed to deny session")
+	}
+	return nil
+}
+
+// IsRevoked checks if the access token, or the session it was issued for,
+// has been revoked
+func (d *TokenDenylist) IsRevoked(claims *utils.Claims) (bool, error) {
+	keys := []string{fmt.Sprintf(AccessTokenDenylistKey, claims.ID)}
+	if claims.SessionID != "" {
+		keys = append(keys, fmt.Sprintf(SessionDenylistKey, claims.SessionID))
+	}
+
+	count, err := d.redis.Exists(d.ctx, keys...).Result()
+	if err != nil {
+		return false, utils.WrapError(err, "failed to check access token denylist")
+	}
+	return count > 0, nil
+}
@@ -30,13 +30,27 @@ type UpdateProfileRequest struct {
 	ProfileImage *string `json:"profile_image,omitempty"`
 }
 
-// UpdatePrivacySettingsRequest represents privacy settings update
+// defaultHomeRadiusMeters is used when a home location is set without a radius
+const defaultHomeRadiusMeters = 300
+
+// UpdatePrivacySettingsRequest represents privacy settings update. The home
+// location is given as latitude and longitude together, or cleared with
+// ClearHome.
 type UpdatePrivacySettingsRequest struct {
-	ShareLocation    *bool     `json:"share_location,omitempty"`
-	ShareProfile     *bool     `json:"share_profile,omitempty"`
-	AllowMessages    *bool     `json:"allow_messages,omitempty"`
-	BlockedUserIDs   *[]string `json:"blocked_user_ids,omitempty"`
-	VisibleFields    *[]string `json:"visible_fields,omitempty"`
+	LocationSharing  *models.LocationSharing `json:"location_sharing,omitempty" validate:"omitempty,oneof=off friends everyone"`
+	HomeLatitude     *float64                `json:"home_latitude,omitempty" validate:"omitempty,min=-90,max=90"`
+	HomeLongitude    *float64                `json:"home_longitude,omitempty" validate:"omitempty,min=-180,max=180"`
+	HomeRadiusMeters *int                    `json:"home_radius_meters,omitempty" validate:"omitempty,min=50,max=2000"`
+	ClearHome        bool                    `json:"clear_home,omitempty"`
+}
+
+// PrivacySettings are the user's location privacy settings. They include
+// the home location, so they are only ever returned to the user.
+type PrivacySettings struct {
+	LocationSharing  models.LocationSharing `json:"location_sharing"`
+	HomeLatitude     *float64               `json:"home_latitude,omitempty"`
+	HomeLongitude    *float64               `json:"home_longitude,omitempty"`
+	HomeRadiusMeters int                    `json:"home_radius_meters,omitempty"`
 }
 
 // User notification preferences removed from this service
@@ -96,8 +110,37 @@ func (s *UserService) UpdateProfile(userID string, req UpdateProfileRequest) (*m
 	return &user, nil
 }
 
+// GetPrivacySettings returns the user's own privacy settings
+func (s *UserService) GetPrivacySettings(userID string) (*PrivacySettings, error) {
+	var user models.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrUserNotFound
+		}
+		return nil, utils.WrapError(err, "failed to get privacy settings")
+	}
+
+	settings := &PrivacySettings{
+		LocationSharing:  user.LocationSharing,
+		HomeRadiusMeters: user.HomeRadiusMeters,
+	}
+	if user.HomeLocation != nil {
+		latitude, longitude := user.HomeLocation.Lat(), user.HomeLocation.Lng()
+		settings.HomeLatitude = &latitude
+		settings.HomeLongitude = &longitude
+	}
+	return settings, nil
+}
+
 // UpdatePrivacySettings updates privacy settings
 func (s *UserService) UpdatePrivacySettings(userID string, req UpdatePrivacySettingsRequest) error {
+	if err := utils.ValidateStruct(req); err != nil {
+		return utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+	if (req.HomeLatitude == nil) != (req.HomeLongitude == nil) {
+		return utils.NewValidationError([]utils.ValidationError{{Field: "home_latitude", Message: "home_latitude and home_longitude must be set together"}})
+	}
+
 	var user models.User
 	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -108,20 +151,20 @@ func (s *UserService) UpdatePrivacySettings(userID string, req UpdatePrivacySett
 
 	// Update privacy settings
 	updates := make(map[string]interface{})
-	if req.ShareLocation != nil {
-		updates["share_location"] = *req.ShareLocation
-	}
-	if req.ShareProfile != nil {
-		updates["share_profile"] = *req.ShareProfile
+	if req.LocationSharing != nil {
+		updates["location_sharing"] = *req.LocationSharing
 	}
-	if req.AllowMessages != nil {
-		updates["allow_messages"] = *req.AllowMessages
-	}
-	if req.BlockedUserIDs != nil {
-		updates["blocked_user_ids"] = *req.BlockedUserIDs
+	if req.ClearHome {
+		updates["home_location"] = nil
+		updates["home_radius_meters"] = 0
+	} else if req.HomeLatitude != nil && req.HomeLongitude != nil {
+		updates["home_location"] = models.NewGeoPoint(*req.HomeLatitude, *req.HomeLongitude)
+		if req.HomeRadiusMeters == nil && user.HomeRadiusMeters == 0 {
+			updates["home_radius_meters"] = defaultHomeRadiusMeters
+		}
 	}
-	if req.VisibleFields != nil {
-		updates["visible_fields"] = *req.VisibleFields
+	if req.HomeRadiusMeters != nil && !req.ClearHome {
+		updates["home_radius_meters"] = *req.HomeRadiusMeters
 	}
 
 	if len(updates) > 0 {
@@ -133,6 +176,43 @@ func (s *UserService) UpdatePrivacySettings(userID string, req UpdatePrivacySett
 	return nil
 }
 
+// UpdateRoleRequest represents a role change made by an admin
+type UpdateRoleRequest struct {
+	Role models.Role `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+// UpdateRole changes a user's role. The user's sessions are revoked so tokens
+// carrying the old role stop working and the next login picks up the new one.
+func (s *UserService) UpdateRole(userID string, role models.Role) (*models.User, error) {
+	if !role.IsValid() {
+		return nil, utils.NewValidationError([]utils.ValidationError{{Field: "role", Message: "unknown role"}})
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrUserNotFound
+		}
+		return nil, utils.WrapError(err, "failed to find user")
+	}
+
+	if user.Role == role {
+		user.PasswordHash = ""
+		return &user, nil
+	}
+
+	if err := s.db.Model(&user).Update("role", role).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to update role")
+	}
+
+	if err := NewSessionService(s.db, s.redis, s.cfg).RevokeAllSessions(user.ID); err != nil {
+		return nil, err
+	}
+
+	user.PasswordHash = ""
+	return &user, nil
+}
+
 // Notification preferences moved to notification service
 
 // DeleteAccount soft deletes user account
@@ -158,28 +238,32 @@ func (s *UserService) DeleteAccount(userID string) error {
 
 // Currency system removed for simplified schema
 
-// SearchUsers searches for users by nickname or email (admin function)
-func (s *UserService) SearchUsers(query string, limit int, offset int) ([]models.User, int64, error) {
-	var users []models.User
-	var total int64
+// SearchUsers searches the users the user may find by username. Hidden,
+// blocked and private users are left out as in the search service.
+func (s *UserService) SearchUsers(userID string, query string, limit int, offset int) ([]SearchUser, int64, error) {
+	query, err := validateSearch(query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	matching := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.User{}).
+			Scopes(searchableUsers(userID)).
+			Where("users.username ILIKE ?", likeContains(query))
+	}
 
-	// Count total results
-	countQuery := s.db.Model(&models.User{}).Where("nickname ILIKE ? OR email ILIKE ?", "%"+query+"%", "%"+query+"%")
-	if err := countQuery.Count(&total).Error; err != nil {
+	var total int64
+	if err := s.db.Scopes(matching).Count(&total).Error; err != nil {
 		return nil, 0, utils.WrapError(err, "failed to count users")
 	}
 
-	// Get paginated results
-	if err := s.db.Where("nickname ILIKE ? OR email ILIKE ?", "%"+query+"%", "%"+query+"%").
+	users := []SearchUser{}
+	if err := s.db.Scopes(matching).
+		Select("users.id, users.username, users.visibility").
+		Order("users.username").
 		Limit(limit).Offset(offset).
-		Find(&users).Error; err != nil {
+		Scan(&users).Error; err != nil {
 		return nil, 0, utils.WrapError(err, "failed to search users")
 	}
 
-	// Clear sensitive information
-	for i := range users {
-		users[i].PasswordHash = ""
-	}
-
 	return users, total, nil
 }
\ No newline at end of file
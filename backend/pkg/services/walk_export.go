@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+)
+
+// gpxDocument is a GPX 1.1 file holding a single track
+type gpxDocument struct {
+	XMLName xml.Name `xml:"gpx"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Segment gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string         `json:"type"`
+	Coordinates orb.LineString `json:"coordinates"`
+}
+
+// ExportGPX returns the walk's recorded points as a GPX track
+func (s *WalkService) ExportGPX(userID uuid.UUID, walkID uuid.UUID) ([]byte, error) {
+	walk, err := s.findWalk(s.db, userID, walkID)
+	if err != nil {
+		return nil, err
+	}
+	points, err := s.points(s.db, walk.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	document := gpxDocument{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "DoggyClub",
+		Track: gpxTrack{
+			Name:    "Walk " + walk.StartedAt.UTC().Format(time.RFC3339),
+			Segment: gpxSegment{Points: make([]gpxPoint, len(points))},
+		},
+	}
+	for i, point := range points {
+		document.Track.Segment.Points[i] = gpxPoint{
+			Lat:  point.Location.Lat(),
+			Lon:  point.Location.Lng(),
+			Time: point.RecordedAt.UTC().Format(time.RFC3339),
+		}
+	}
+
+	data, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to encode GPX")
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ExportGeoJSON returns the walk's simplified route as a GeoJSON feature
+// collection, with the walk's stats as properties
+func (s *WalkService) ExportGeoJSON(userID uuid.UUID, walkID uuid.UUID) ([]byte, error) {
+	walk, err := s.findWalk(s.db, userID, walkID)
+	if err != nil {
+		return nil, err
+	}
+
+	route := orb.LineString{}
+	if walk.Route != nil {
+		route = orb.LineString(*walk.Route)
+	}
+
+	properties := map[string]interface{}{
+		"walk_id":          walk.ID,
+		"dog_id":           walk.DogID,
+		"started_at":       walk.StartedAt.UTC().Format(time.RFC3339),
+		"distance_meters":  walk.DistanceMeters,
+		"duration_seconds": walk.DurationSeconds,
+	}
+	if walk.EndedAt != nil {
+		properties["ended_at"] = walk.EndedAt.UTC().Format(time.RFC3339)
+	}
+	if walk.PaceSecondsPerKm != nil {
+		properties["pace_seconds_per_km"] = *walk.PaceSecondsPerKm
+	}
+
+	collection := geoJSONFeatureCollection{
+		Type: "FeatureCollection",
+		Features: []geoJSONFeature{{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: route},
+			Properties: properties,
+		}},
+	}
+
+	data, err := json.Marshal(collection)
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to encode GeoJSON")
+	}
+	return data, nil
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/project"
+	"github.com/paulmach/orb/simplify"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxWalkPointAccuracy drops fixes too imprecise to draw a route with
+	maxWalkPointAccuracy = 50.0
+
+	// maxWalkSpeed drops fixes that would mean moving faster than a running
+	// dog, which are GPS jumps rather than movement
+	maxWalkSpeed = 12.0 // meters per second
+
+	// walkClockSkew is how far outside the walk's time span a point's
+	// device timestamp may be
+	walkClockSkew = 2 * time.Minute
+
+	// walkRouteTolerance is how far the simplified route may stray from the track
+	walkRouteTolerance = 5.0 // meters
+
+	// minPaceDistance is the distance below which no pace is reported
+	minPaceDistance = 10.0 // meters
+)
+
+var (
+	ErrWalkInProgress = utils.NewAPIError("WALK_IN_PROGRESS", "This dog already has a walk in progress", nil)
+	ErrWalkEnded      = utils.NewAPIError("WALK_ENDED", "This walk has already ended", nil)
+)
+
+// StartWalkRequest starts a walk for a dog
+type StartWalkRequest struct {
+	DogID uuid.UUID `json:"dog_id" validate:"required"`
+}
+
+// WalkPointInput is a GPS fix reported by the client
+type WalkPointInput struct {
+	Latitude       float64   `json:"latitude" validate:"min=-90,max=90"`
+	Longitude      float64   `json:"longitude" validate:"min=-180,max=180"`
+	AccuracyMeters float64   `json:"accuracy_meters" validate:"min=0"`
+	RecordedAt     time.Time `json:"recorded_at" validate:"required"`
+}
+
+// AddWalkPointsRequest is a batch of fixes in any order
+type AddWalkPointsRequest struct {
+	Points []WalkPointInput `json:"points" validate:"required,min=1,max=500,dive"`
+}
+
+// WalkService records walks and computes their stats
+type WalkService struct {
+	db *gorm.DB
+}
+
+func NewWalkService(db *gorm.DB) *WalkService {
+	return &WalkService{db: db}
+}
+
+// StartWalk starts a walk for one of the user's dogs
+func (s *WalkService) StartWalk(userID uuid.UUID, req StartWalkRequest) (*models.Walk, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+
+	var count int64
+	if err := s.db.Model(&models.Dog{}).Where("id = ? AND user_id = ?", req.DogID, userID).Count(&count).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to find dog")
+	}
+	if count == 0 {
+		return nil, utils.ErrNotFound
+	}
+
+	walk := models.Walk{
+		DogID:     req.DogID,
+		StartedAt: time.Now(),
+	}
+	if err := s.db.Omit(clause.Associations).Create(&walk).Error; err != nil {
+		if utils.IsUniqueViolation(err) {
+			return nil, ErrWalkInProgress
+		}
+		return nil, utils.WrapError(err, "failed to start walk")
+	}
+	return &walk, nil
+}
+
+// AddPoints adds a batch of fixes to a walk in progress and returns the
+// updated walk and how many points were kept. Imprecise fixes, fixes outside
+// the walk's time span and fixes already recorded are dropped.
+func (s *WalkService) AddPoints(userID uuid.UUID, walkID uuid.UUID, req AddWalkPointsRequest) (*models.Walk, int, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, 0, utils.NewValidationError(utils.FormatValidationErrors(err))
+	}
+
+	var walk *models.Walk
+	var accepted int
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		walk, err = s.findWalk(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, walkID)
+		if err != nil {
+			return err
+		}
+		if !walk.IsActive() {
+			return ErrWalkEnded
+		}
+
+		now := time.Now()
+		points := make([]models.WalkPoint, 0, len(req.Points))
+		for _, input := range req.Points {
+			if input.AccuracyMeters > maxWalkPointAccuracy {
+				continue
+			}
+			if input.RecordedAt.Before(walk.StartedAt.Add(-walkClockSkew)) || input.RecordedAt.After(now.Add(walkClockSkew)) {
+				continue
+			}
+			points = append(points, models.WalkPoint{
+				WalkID:         walk.ID,
+				Location:       models.NewGeoPoint(input.Latitude, input.Longitude),
+				AccuracyMeters: input.AccuracyMeters,
+				RecordedAt:     input.RecordedAt.UTC(),
+			})
+		}
+
+		if len(points) > 0 {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&points)
+			if result.Error != nil {
+				return utils.WrapError(result.Error, "failed to record walk points")
+			}
+			accepted = int(result.RowsAffected)
+		}
+
+		return s.refresh(tx, walk)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return walk, accepted, nil
+}
+
+// StopWalk ends a walk and finalizes its stats
+func (s *WalkService) StopWalk(userID uuid.UUID, walkID uuid.UUID) (*models.Walk, error) {
+	var walk *models.Walk
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		walk, err = s.findWalk(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, walkID)
+		if err != nil {
+			return err
+		}
+		if !walk.IsActive() {
+			return ErrWalkEnded
+		}
+
+		now := time.Now()
+		walk.EndedAt = &now
+		return s.refresh(tx, walk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return walk, nil
+}
+
+// GetWalk returns one of the user's walks with the encounters made on it
+func (s *WalkService) GetWalk(userID uuid.UUID, walkID uuid.UUID) (*models.Walk, error) {
+	return s.findWalk(s.db.Preload("Encounters.Dog1").Preload("Encounters.Dog2"), userID, walkID)
+}
+
+// GetDogWalks returns the walks of one of the user's dogs, newest first
+func (s *WalkService) GetDogWalks(userID uuid.UUID, dogID uuid.UUID, limit, offset int) ([]models.Walk, int64, error) {
+	var count int64
+	if err := s.db.Model(&models.Dog{}).Where("id = ? AND user_id = ?", dogID, userID).Count(&count).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to find dog")
+	}
+	if count == 0 {
+		return nil, 0, utils.ErrNotFound
+	}
+
+	var total int64
+	if err := s.db.Model(&models.Walk{}).Where("dog_id = ?", dogID).Count(&total).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to count walks")
+	}
+
+	var walks []models.Walk
+	if err := s.db.Where("dog_id = ?", dogID).
+		Order("started_at DESC").
+		Limit(limit).Offset(offset).
+		Find(&walks).Error; err != nil {
+		return nil, 0, utils.WrapError(err, "failed to get walks")
+	}
+	return walks, total, nil
+}
+
+// LinkEncounter links the encounter to the walks its dogs were on while it
+// lasted. Call it whenever the encounter is seen again or extended, as either
+// dog may have started a walk since it began.
+func (s *WalkService) LinkEncounter(tx *gorm.DB, encounter *models.Encounter) error {
+	startedAt, lastSeenAt := encounter.Timestamp, encounter.Timestamp
+	if encounter.StartedAt != nil {
+		startedAt = *encounter.StartedAt
+	}
+	if encounter.LastSeenAt != nil {
+		lastSeenAt = *encounter.LastSeenAt
+	}
+
+	if err := tx.Exec(`
+		INSERT INTO walk_encounters (walk_id, encounter_id)
+		SELECT id, ? FROM walks
+		WHERE dog_id IN (?, ?) AND started_at <= ? AND (ended_at IS NULL OR ended_at >= ?)
+		ON CONFLICT DO NOTHING
+	`, encounter.ID, encounter.Dog1ID, encounter.Dog2ID, lastSeenAt, startedAt).Error; err != nil {
+		return utils.WrapError(err, "failed to link encounter to walks")
+	}
+	return nil
+}
+
+// findWalk returns the walk if its dog belongs to the user
+func (s *WalkService) findWalk(query *gorm.DB, userID uuid.UUID, walkID uuid.UUID) (*models.Walk, error) {
+	var walk models.Walk
+	err := query.
+		Where("id = ? AND dog_id IN (SELECT id FROM dogs WHERE user_id = ?)", walkID, userID).
+		First(&walk).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.ErrNotFound
+	}
+	if err != nil {
+		return nil, utils.WrapError(err, "failed to find walk")
+	}
+	return &walk, nil
+}
+
+// points returns the walk's points in time order
+func (s *WalkService) points(tx *gorm.DB, walkID uuid.UUID) ([]models.WalkPoint, error) {
+	var points []models.WalkPoint
+	if err := tx.Where("walk_id = ?", walkID).Order("recorded_at").Find(&points).Error; err != nil {
+		return nil, utils.WrapError(err, "failed to get walk points")
+	}
+	return points, nil
+}
+
+// refresh recomputes the walk's stats and route from its points and saves them
+func (s *WalkService) refresh(tx *gorm.DB, walk *models.Walk) error {
+	points, err := s.points(tx, walk.ID)
+	if err != nil {
+		return err
+	}
+
+	track := summarizeTrack(points)
+	walk.PointCount = len(points)
+	walk.DistanceMeters = track.Distance
+	walk.Route = nil
+	if len(track.Route) >= 2 {
+		route := models.GeoLineString(track.Route)
+		walk.Route = &route
+	}
+
+	end := time.Now()
+	if walk.EndedAt != nil {
+		end = *walk.EndedAt
+	} else if len(points) > 0 {
+		end = points[len(points)-1].RecordedAt
+	}
+	walk.DurationSeconds = int(math.Max(0, end.Sub(walk.StartedAt).Seconds()))
+
+	walk.PaceSecondsPerKm = nil
+	if walk.DistanceMeters >= minPaceDistance && walk.DurationSeconds > 0 {
+		pace := float64(walk.DurationSeconds) / (walk.DistanceMeters / 1000)
+		walk.PaceSecondsPerKm = &pace
+	}
+
+	if err := tx.Model(walk).
+		Select("ended_at", "distance_meters", "duration_seconds", "pace_seconds_per_km", "point_count", "route").
+		Updates(walk).Error; err != nil {
+		return utils.WrapError(err, "failed to update walk")
+	}
+	return nil
+}
+
+// walkTrack is the movement a walk's points describe
+type walkTrack struct {
+	Distance float64
+	// Route is the track simplified to within walkRouteTolerance
+	Route orb.LineString
+}
+
+// summarizeTrack measures the distance covered by the points and simplifies
+// their route, skipping points that jump further than a dog can move
+func summarizeTrack(points []models.WalkPoint) walkTrack {
+	sorted := append([]models.WalkPoint(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].RecordedAt.Before(sorted[j].RecordedAt)
+	})
+
+	var track walkTrack
+	line := make(orb.LineString, 0, len(sorted))
+	var last *models.WalkPoint
+	for i := range sorted {
+		point := &sorted[i]
+		if last != nil {
+			distance := geo.Distance(orb.Point(last.Location), orb.Point(point.Location))
+			elapsed := point.RecordedAt.Sub(last.RecordedAt).Seconds()
+			if elapsed <= 0 || distance/elapsed > maxWalkSpeed {
+				continue
+			}
+			track.Distance += distance
+		}
+		line = append(line, orb.Point(point.Location))
+		last = point
+	}
+
+	track.Route = simplifyRoute(line, walkRouteTolerance)
+	return track
+}
+
+// simplifyRoute runs Douglas-Peucker in Web Mercator, where the tolerance in
+// meters is scaled by the latitude's stretch factor
+func simplifyRoute(line orb.LineString, toleranceMeters float64) orb.LineString {
+	if len(line) < 3 {
+		return line
+	}
+
+	stretch := 1 / math.Max(math.Cos(line[0].Lat()*math.Pi/180), 0.01)
+	projected := project.LineString(line.Clone(), project.WGS84.ToMercator)
+	simplified := simplify.DouglasPeucker(toleranceMeters * stretch).LineString(projected)
+	return project.LineString(simplified, project.Mercator.ToWGS84)
+}
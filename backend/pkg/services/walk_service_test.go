@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doggyclub/backend/config"
+	"github.com/doggyclub/backend/pkg/models"
+	"github.com/doggyclub/backend/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeTrack(t *testing.T) {
+	start := time.Now()
+
+	// Eleven points 11m apart heading north, ten seconds each, given out of order
+	var points []models.WalkPoint
+	for i := 10; i >= 0; i-- {
+		points = append(points, models.WalkPoint{
+			Location:   models.NewGeoPoint(52.5200+float64(i)*0.0001, 13.4050),
+			RecordedAt: start.Add(time.Duration(i) * 10 * time.Second),
+		})
+	}
+	// A GPS jump two kilometres away
+	points = append(points, models.WalkPoint{
+		Location:   models.NewGeoPoint(52.5380, 13.4050),
+		RecordedAt: start.Add(55 * time.Second),
+	})
+
+	track := summarizeTrack(points)
+	assert.InDelta(t, 111, track.Distance, 2)
+
+	// A straight line simplifies to its ends
+	require.Len(t, track.Route, 2)
+	assert.InDelta(t, 52.5200, track.Route[0].Lat(), 0.00001)
+	assert.InDelta(t, 52.5210, track.Route[1].Lat(), 0.00001)
+}
+
+func TestWalkService_RecordAndExport(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	walkService := NewWalkService(ctx.DB)
+
+	user := testutils.CreateTestUser(t, ctx.DB)
+	dog := testutils.CreateTestDog(t, ctx.DB, user.ID.String())
+
+	walk, err := walkService.StartWalk(user.ID, StartWalkRequest{DogID: dog.ID})
+	require.NoError(t, err)
+
+	_, err = walkService.StartWalk(user.ID, StartWalkRequest{DogID: dog.ID})
+	assert.Equal(t, ErrWalkInProgress, err)
+
+	now := time.Now()
+	batch := AddWalkPointsRequest{Points: []WalkPointInput{
+		{Latitude: 52.5200, Longitude: 13.4050, AccuracyMeters: 5, RecordedAt: now},
+		{Latitude: 52.5210, Longitude: 13.4050, AccuracyMeters: 5, RecordedAt: now.Add(time.Minute)},
+		{Latitude: 52.5300, Longitude: 13.4050, AccuracyMeters: 200, RecordedAt: now.Add(90 * time.Second)},
+	}}
+	walk, accepted, err := walkService.AddPoints(user.ID, walk.ID, batch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, accepted)
+	assert.InDelta(t, 111, walk.DistanceMeters, 2)
+
+	// Resending the batch changes nothing
+	_, accepted, err = walkService.AddPoints(user.ID, walk.ID, batch)
+	require.NoError(t, err)
+	assert.Equal(t, 0, accepted)
+
+	walk, err = walkService.StopWalk(user.ID, walk.ID)
+	require.NoError(t, err)
+	require.NotNil(t, walk.EndedAt)
+	require.NotNil(t, walk.PaceSecondsPerKm)
+	assert.Equal(t, 2, walk.PointCount)
+
+	_, _, err = walkService.AddPoints(user.ID, walk.ID, batch)
+	assert.Equal(t, ErrWalkEnded, err)
+
+	gpx, err := walkService.ExportGPX(user.ID, walk.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(gpx), "<trkpt"))
+
+	data, err := walkService.ExportGeoJSON(user.ID, walk.ID)
+	require.NoError(t, err)
+	var collection geoJSONFeatureCollection
+	require.NoError(t, json.Unmarshal(data, &collection))
+	require.Len(t, collection.Features, 1)
+	assert.Len(t, collection.Features[0].Geometry.Coordinates, 2)
+
+	// Other users cannot see the walk
+	other := testutils.CreateTestUser(t, ctx.DB)
+	_, err = walkService.GetWalk(other.ID, walk.ID)
+	assert.Error(t, err)
+}
+
+func TestEncounterService_LinksWalkStartedDuringEncounter(t *testing.T) {
+	ctx := testutils.SetupTestContext(t)
+	defer ctx.TeardownTestContext(t)
+
+	cfg := ctx.Config
+	cfg.Encounter = config.EncounterConfig{
+		DwellTime:         2 * time.Minute,
+		SeparationTimeout: 5 * time.Minute,
+	}
+	encounterService := NewEncounterService(ctx.DB, ctx.Redis, cfg)
+	walkService := NewWalkService(ctx.DB)
+
+	user1 := testutils.CreateTestUser(t, ctx.DB)
+	user2 := testutils.CreateTestUser(t, ctx.DB)
+	dog1 := testutils.CreateTestDog(t, ctx.DB, user1.ID.String())
+	dog2 := testutils.CreateTestDog(t, ctx.DB, user2.ID.String())
+
+	require.NoError(t, encounterService.UpdateDeviceLocation(user1.ID, LocationUpdateRequest{DogID: dog1.ID, Latitude: 52.5200, Longitude: 13.4050}))
+	require.NoError(t, encounterService.UpdateDeviceLocation(user2.ID, LocationUpdateRequest{DogID: dog2.ID, Latitude: 52.5201, Longitude: 13.4050}))
+	_, err := encounterService.DetectEncounters(user1.ID, dog1.ID, 50)
+	require.NoError(t, err)
+
+	var session models.ProximitySession
+	require.NoError(t, ctx.DB.Where("ended_at IS NULL").First(&session).Error)
+	require.NoError(t, ctx.DB.Model(&session).Updates(map[string]interface{}{
+		"started_at":   time.Now().Add(-5 * time.Minute),
+		"last_seen_at": time.Now().Add(-2 * time.Minute),
+	}).Error)
+	require.NoError(t, encounterService.UpdateDeviceLocation(user2.ID, LocationUpdateRequest{DogID: dog2.ID, Latitude: 52.5201, Longitude: 13.4050}))
+	encounters, err := encounterService.DetectEncounters(user1.ID, dog1.ID, 50)
+	require.NoError(t, err)
+	require.Len(t, encounters, 1)
+
+	// Dog1 sets off on a walk while the dogs are still together
+	walk, err := walkService.StartWalk(user1.ID, StartWalkRequest{DogID: dog1.ID})
+	require.NoError(t, err)
+	require.NoError(t, ctx.DB.Model(walk).Update("started_at", time.Now().Add(-time.Minute)).Error)
+	require.NoError(t, ctx.DB.Model(&session).Update("last_seen_at", time.Now().Add(-90*time.Second)).Error)
+
+	// Extending the encounter links it to the walk
+	require.NoError(t, encounterService.UpdateDeviceLocation(user2.ID, LocationUpdateRequest{DogID: dog2.ID, Latitude: 52.5201, Longitude: 13.4050}))
+	_, err = encounterService.DetectEncounters(user1.ID, dog1.ID, 50)
+	require.NoError(t, err)
+
+	var linked int64
+	require.NoError(t, ctx.DB.Table("walk_encounters").
+		Where("walk_id = ? AND encounter_id = ?", walk.ID, encounters[0].ID).
+		Count(&linked).Error)
+	assert.Equal(t, int64(1), linked)
+}
@@ -93,10 +93,10 @@ func (testDB *TestDB) TeardownTestDB(t *testing.T) {
 		"invoices", "payment_methods", "subscriptions", "subscription_features", "subscription_plans",
 		"user_devices", "notifications", "notification_preferences",
 		"transactions", "user_currencies", "gift_histories", "gifts",
-		"follows", "comments", "likes", "posts",
-		"encounters", "encounter_settings",
+		"follows", "follow_requests", "comment_likes", "comments", "likes", "post_hashtags", "hashtags", "post_media", "posts",
+		"location_history", "location_batches", "proximity_sessions", "encounter_waves", "encounters", "encounter_settings", "place_visits", "places", "walk_encounters", "walk_points", "walks",
 		"vaccination_records", "dogs",
-		"refresh_tokens", "users",
+		"password_reset_tokens", "refresh_tokens", "sessions", "users",
 		"safety_settings",
 	}
 
@@ -120,6 +120,15 @@ func SetupTestContext(t *testing.T) *TestContext {
 			ExpireHours:        24 * time.Hour,
 			RefreshExpireHours: 168 * time.Hour,
 		},
+		Auth: config.AuthConfig{
+			PasswordResetTTL:    time.Hour,
+			PasswordResetURL:    "http://localhost:3000/reset-password",
+			PasswordResetLimit:  3,
+			PasswordResetWindow: time.Hour,
+		},
+		Mail: config.MailConfig{
+			Driver: "log",
+		},
 		Server: config.ServerConfig{
 			Environment: "test",
 		},
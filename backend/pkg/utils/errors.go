@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // Common errors
@@ -20,8 +23,22 @@ var (
 	ErrInvalidCredentials  = errors.New("invalid credentials")
 	ErrTokenExpired        = errors.New("token expired")
 	ErrInvalidToken        = errors.New("invalid token")
+	ErrTooManyRequests     = errors.New("too many requests")
+)
+
+// CodeValidationError is the code of invalid request errors, which map to
+// 400 Bad Request
+const CodeValidationError = "VALIDATION_ERROR"
+
+// Codes of structured errors that map to 403 Forbidden
+const (
+	CodeAccountSuspended = "ACCOUNT_SUSPENDED"
+	CodeUserBlocked      = "USER_BLOCKED"
 )
 
+// ErrUserBlocked is returned for interactions between users where either blocked the other
+var ErrUserBlocked = NewAPIError(CodeUserBlocked, "This action is not available between these users", nil)
+
 // APIError represents an API error response
 type APIError struct {
 	Code    string      `json:"code"`
@@ -54,6 +71,8 @@ func HTTPError(err error) (int, APIError) {
 		return http.StatusForbidden, NewAPIError("FORBIDDEN", err.Error(), nil)
 	case errors.Is(err, ErrNotFound):
 		return http.StatusNotFound, NewAPIError("NOT_FOUND", err.Error(), nil)
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound, NewAPIError("USER_NOT_FOUND", "User not found", nil)
 	case errors.Is(err, ErrConflict):
 		return http.StatusConflict, NewAPIError("CONFLICT", err.Error(), nil)
 	case errors.Is(err, ErrInvalidCredentials):
@@ -62,9 +81,36 @@ func HTTPError(err error) (int, APIError) {
 		return http.StatusUnauthorized, NewAPIError("TOKEN_EXPIRED", "Token has expired", nil)
 	case errors.Is(err, ErrInvalidToken):
 		return http.StatusUnauthorized, NewAPIError("INVALID_TOKEN", "Invalid token", nil)
-	default:
-		return http.StatusInternalServerError, NewAPIError("INTERNAL_ERROR", "An internal error occurred", nil)
+	case errors.Is(err, ErrTooManyRequests):
+		return http.StatusTooManyRequests, NewAPIError("RATE_LIMITED", "Too many requests, please try again later", nil)
+	}
+
+	// Structured errors from services map by code; any other code is an
+	// internal error whose details are not shown to the client
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case CodeValidationError, "INVALID_ACTION", "INVALID_BEACON", "SELF_REPORT", "SELF_BLOCK":
+			return http.StatusBadRequest, apiErr
+		case CodeAccountSuspended, CodeUserBlocked:
+			return http.StatusForbidden, apiErr
+		case "ALREADY_BLOCKED", "DUPLICATE_REPORT", "WALK_IN_PROGRESS", "WALK_ENDED":
+			return http.StatusConflict, apiErr
+		case "FEED_EXPIRED":
+			return http.StatusGone, apiErr
+		}
 	}
+
+	return http.StatusInternalServerError, NewAPIError("INTERNAL_ERROR", "An internal error occurred", nil)
+}
+
+// NewSuspendedError reports that the acting user is suspended.
+// A nil expiresAt means the suspension is permanent.
+func NewSuspendedError(suspensionType string, expiresAt *time.Time) APIError {
+	return NewAPIError(CodeAccountSuspended, "Your account is suspended", map[string]interface{}{
+		"type":       suspensionType,
+		"expires_at": expiresAt,
+	})
 }
 
 // ValidationError represents validation errors
@@ -75,7 +121,7 @@ type ValidationError struct {
 
 // NewValidationError creates validation error response
 func NewValidationError(errors []ValidationError) APIError {
-	return NewAPIError("VALIDATION_ERROR", "Validation failed", errors)
+	return NewAPIError(CodeValidationError, "Validation failed", errors)
 }
 
 // WrapError wraps an error with context
@@ -84,4 +130,11 @@ func WrapError(err error, message string) error {
 		return nil
 	}
 	return fmt.Errorf("%s: %w", message, err)
-}
\ No newline at end of file
+}
+
+// IsUniqueViolation reports whether err is a Postgres unique constraint
+// violation, e.g. a row lost the race against another insert
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
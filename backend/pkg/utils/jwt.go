@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"time"
 
 	"github.com/doggyclub/backend/config"
@@ -9,24 +10,35 @@ import (
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a new JWT token
-func GenerateToken(userID, email string, cfg config.JWTConfig) (string, error) {
+// GenerateToken generates a new JWT token bound to a session and returns
+// its claims so callers can track the token ID (jti) and expiry
+func GenerateToken(userID, email, role, sessionID string, cfg config.JWTConfig) (string, *Claims, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        GenerateUUID(),
+			Subject:   userID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.ExpireHours)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.Secret))
+	signed, err := token.SignedString([]byte(cfg.Secret))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
 // GenerateRefreshToken generates a new refresh token
@@ -47,10 +59,13 @@ func GenerateRefreshToken(userID string, cfg config.JWTConfig) (string, error) {
 func ValidateToken(tokenString string, cfg config.JWTConfig) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(cfg.Secret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
-		return nil, err
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, ErrInvalidToken
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {